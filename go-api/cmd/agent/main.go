@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"api-monitor-go/internal/agents"
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/monitoring"
+)
+
+// Check agent. It pulls its endpoints from the central API, checks them
+// with the API's own checker and pushes the results back, tagged with
+// its region. It needs neither the database nor Redis.
+func main() {
+	log := logger.New().WithField("component", "agent")
+
+	cfg := agents.DefaultConfig()
+	cfg.ID = os.Getenv("AGENT_ID")
+	cfg.Region = os.Getenv("AGENT_REGION")
+	cfg.Secret = os.Getenv("AGENT_SECRET")
+	cfg.ServerURL = os.Getenv("AGENT_SERVER_URL")
+	cfg.Transport = agents.Transport(getEnv("AGENT_TRANSPORT", string(cfg.Transport)))
+	cfg.AssignmentInterval = getEnvDuration("AGENT_ASSIGNMENT_INTERVAL", cfg.AssignmentInterval)
+	cfg.FlushInterval = getEnvDuration("AGENT_FLUSH_INTERVAL", cfg.FlushInterval)
+	cfg.BatchSize = getEnvInt("AGENT_BATCH_SIZE", cfg.BatchSize)
+	cfg.BufferSize = getEnvInt("AGENT_BUFFER_SIZE", cfg.BufferSize)
+	cfg.MaxConcurrentChecks = getEnvInt("AGENT_MAX_CONCURRENT_CHECKS", cfg.MaxConcurrentChecks)
+
+	agent, err := agents.New(cfg, monitoring.NewEndpointChecker(log))
+	if err != nil {
+		log.Fatalf("failed to create agent: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := agent.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatalf("agent failed: %v", err)
+	}
+
+	stats := agent.Stats()
+	log.Infof("agent stopped after %d checks, %d results sent, %d dropped", stats.Checks, stats.Sent, stats.Dropped)
+}
+
+func getEnv(key, defaultVal string) string {
+	if value, exists := os.LookupEnv(key); exists {
+		return value
+	}
+	return defaultVal
+}
+
+func getEnvInt(key string, defaultVal int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultVal
+}
+
+func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultVal
+}
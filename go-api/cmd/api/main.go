@@ -2,17 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync/atomic"
 	"syscall"
 	"time"
 
+	"api-monitor-go/internal/agents"
 	"api-monitor-go/internal/container"
 	_ "api-monitor-go/internal/logger"
+	"api-monitor-go/internal/metrics"
 	"api-monitor-go/internal/middleware"
+	"api-monitor-go/internal/resilience"
 	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 )
@@ -25,6 +30,11 @@ func main() {
 		// Not critical, continue with env variables
 	}
 
+	// Schema migrations run without the rest of the container
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:]))
+	}
+
 	// Initialize container with all dependencies
 	cnt, err := container.NewContainer()
 	if err != nil {
@@ -36,26 +46,81 @@ func main() {
 
 	log := cnt.Logger()
 
-	// Setup HTTP handlers
+	// Setup HTTP handlers; API routes are registered on mux and filtered
+	// by client IP as a whole
+	root := http.NewServeMux()
 	mux := http.NewServeMux()
+	root.Handle("/", cnt.APIFilter().Middleware(mux))
 
-	// Health check endpoint (no rate limiting)
-	mux.HandleFunc("/health", handleHealth())
+	// Health check endpoint (no rate limiting or IP filtering)
+	root.HandleFunc("/health", handleHealth())
 
-	// WebSocket endpoint (with rate limiting)
-	mux.HandleFunc("/ws", middleware.RateLimitMiddleware(cnt.RateLimiter(), 10, 10)(
-		http.HandlerFunc(handleWebSocket(cnt)),
-	).ServeHTTP)
+	// WebSocket endpoint (with rate limiting and its own IP filter)
+	root.Handle("/ws", cnt.WebSocketFilter().Middleware(cnt.RateLimits().Handler(handleWebSocket(cnt))))
 
 	// Monitoring endpoint (with rate limiting)
-	mux.HandleFunc("/monitor", middleware.RateLimitMiddleware(cnt.RateLimiter(), 10, 10)(
-		http.HandlerFunc(handleMonitor(cnt)),
-	).ServeHTTP)
+	mux.HandleFunc("/monitor", cnt.RateLimits().Handler(handleMonitor(cnt)))
+
+	// Operational statistics describe every user's traffic, so only admins
+	// may read them
+	admin := middleware.RequireAdminToken(cnt.Config().AdminToken)
+
+	// Result persistence batch statistics
+	mux.Handle("/stats/batcher", admin(handleBatcherStats(cnt)))
+
+	// Result fan-out backlog and delivery counters per sink
+	mux.Handle("/stats/outbox", admin(handleOutboxStats(cnt)))
+
+	// Circuit breaker state and failure rates
+	mux.Handle("/stats/circuit-breakers", admin(handleCircuitBreakerStats(cnt)))
+
+	// Allowed and rejected requests per rate limit policy
+	mux.Handle("/stats/rate-limits", admin(handleRateLimitStats(cnt)))
+
+	// Consensus status and response times per location of the caller's
+	// endpoint. Each report aggregates raw results, so it is rate limited.
+	mux.HandleFunc("/endpoints/locations", cnt.RateLimits().Handler(handleEndpointLocations(cnt)))
+
+	// Check agents and the results they pushed
+	mux.Handle("/stats/agents", admin(handleAgentStats(cnt)))
+
+	// Rollup and pruning progress
+	mux.Handle("/stats/retention", admin(handleRetentionStats(cnt)))
+
+	// Per-collector schedule and outcomes of the embedded metrics worker
+	mux.Handle("/stats/collectors", admin(handleCollectorStats(cnt)))
+
+	// Enable or disable collectors of the embedded metrics worker at runtime
+	adminCollectors := admin(handleCollectorAdmin(cnt))
+	mux.Handle("/admin/collectors", adminCollectors)
+	mux.Handle("/admin/collectors/", adminCollectors)
+
+	// Check agents in other regions; their requests are signed, so they
+	// aren't held to the API's IP filter
+	if agentServer := cnt.Agents(); agentServer != nil {
+		root.Handle("/agents/", agentServer.Handler())
+	}
+
+	// Metrics query language, including endpoint response times. Metrics
+	// aren't scoped to a user and include revenue, so only admins may query
+	// them.
+	queryEngine := metrics.NewQueryEngine(cnt.MetricsStore(), metrics.NewPostgresResultSource(cnt.DB().Postgres))
+	queryHandler := metrics.NewQueryHandler(queryEngine)
+	mux.Handle("/metrics/query", admin(queryHandler))
+
+	// Stripe events as real-time payment metrics
+	if secret := cnt.Config().StripeWebhookSecret; secret != "" {
+		webhookConfig := metrics.DefaultStripeWebhookConfig()
+		webhookConfig.Secret = secret
+		mux.Handle("/webhooks/stripe", metrics.NewStripeWebhookHandler(cnt.Redis(), cnt.MetricsStore(), cnt.MetricsPublisher(), webhookConfig))
+	} else {
+		log.Info("STRIPE_WEBHOOK_SECRET not set, Stripe webhooks disabled")
+	}
 
 	// Create HTTP server with timeouts
 	server := &http.Server{
 		Addr:         ":" + cnt.Config().Port,
-		Handler:      mux,
+		Handler:      middleware.Authenticate(cnt.Authenticators()...)(root),
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 15 * time.Second,
 		IdleTimeout:  60 * time.Second,
@@ -132,6 +197,161 @@ func handleMonitor(cnt *container.Container) http.HandlerFunc {
 	}
 }
 
+// handleBatcherStats reports batch sizes and flush latency of result persistence
+func handleBatcherStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cnt.MonitoringService().ResultBatcherStats())
+	}
+}
+
+// handleOutboxStats reports pending and dead result deliveries per sink
+func handleOutboxStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := cnt.MonitoringService().OutboxStats(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// handleCircuitBreakerStats reports the endpoint checker's circuit breaker
+// and, when collection is embedded, those of the metrics collectors
+func handleCircuitBreakerStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		breakers := []resilience.CircuitBreakerStats{cnt.MonitoringService().CircuitBreakerStats()}
+		if worker := cnt.MetricsWorker(); worker != nil {
+			for _, state := range worker.Scheduler().States() {
+				if state.Breaker != nil {
+					breakers = append(breakers, *state.Breaker)
+				}
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(breakers)
+	}
+}
+
+// maxLocationWindow bounds the window of a location report, which
+// computes percentiles over the endpoint's raw results in the window
+const maxLocationWindow = 24 * time.Hour
+
+// handleEndpointLocations reports an endpoint's consensus status and
+// compares its response times per location, over the last hour unless a
+// window of at most maxLocationWindow is given. Callers only see their own
+// endpoints; others are reported as not found, so endpoint IDs can't be
+// probed.
+func handleEndpointLocations(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		identity := middleware.IdentityFrom(r.Context())
+		if identity.UserID == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		endpointID, err := strconv.Atoi(r.URL.Query().Get("endpoint_id"))
+		if err != nil {
+			http.Error(w, "endpoint_id is required", http.StatusBadRequest)
+			return
+		}
+		window := time.Hour
+		if value := r.URL.Query().Get("window"); value != "" {
+			if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+				http.Error(w, "invalid window", http.StatusBadRequest)
+				return
+			}
+			if window > maxLocationWindow {
+				http.Error(w, "window must not exceed "+maxLocationWindow.String(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		owned, err := cnt.Repository().EndpointOwnedBy(r.Context(), endpointID, identity.UserID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !owned {
+			http.Error(w, "endpoint not found", http.StatusNotFound)
+			return
+		}
+
+		status, locations, err := cnt.MonitoringService().LocationReport(r.Context(), endpointID, window)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"endpoint_id": endpointID,
+			"window":      window.String(),
+			"status":      status,
+			"locations":   locations,
+		})
+	}
+}
+
+// handleAgentStats reports the check agents seen and the results each
+// pushed
+func handleAgentStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats := []agents.AgentStats{}
+		if agentServer := cnt.Agents(); agentServer != nil {
+			stats = agentServer.Stats()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// handleRateLimitStats reports allowed and rejected requests per policy
+func handleRateLimitStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cnt.RateLimits().Stats())
+	}
+}
+
+// handleRetentionStats reports rollup watermarks, lag and pruned rows
+func handleRetentionStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cnt.Retention().Progress())
+	}
+}
+
+// handleCollectorStats reports metrics collector state; the list is empty
+// when collection runs in the standalone worker
+func handleCollectorStats(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		worker := cnt.MetricsWorker()
+		if worker == nil {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode([]metrics.CollectorState{})
+			return
+		}
+		worker.Scheduler().ServeHTTP(w, r)
+	}
+}
+
+// handleCollectorAdmin serves the collector admin API of the embedded
+// metrics worker
+func handleCollectorAdmin(cnt *container.Container) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		worker := cnt.MetricsWorker()
+		if worker == nil {
+			http.Error(w, "metrics collection runs in the standalone worker", http.StatusNotFound)
+			return
+		}
+		worker.Scheduler().AdminHandler("/admin/collectors").ServeHTTP(w, r)
+	}
+}
+
 // handleHealth handles the health check endpoint
 func handleHealth() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"api-monitor-go/internal/database"
+)
+
+const migrateUsage = `usage: api migrate <command>
+
+commands:
+  up            apply all pending migrations
+  down [steps]  roll back the last N migrations (default 1)
+  status        show the current and required schema version
+`
+
+// runMigrate implements the `migrate` subcommand and returns the exit code
+func runMigrate(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	db, err := database.NewDB()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to database: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	migrator, err := database.NewMigrator(db.Postgres)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load migrations: %v\n", err)
+		return 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate up failed after %d migration(s): %v\n", applied, err)
+			return 1
+		}
+		fmt.Printf("applied %d migration(s)\n", applied)
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps <= 0 {
+				fmt.Fprintf(os.Stderr, "invalid step count %q\n", args[1])
+				return 2
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "migrate down failed after %d migration(s): %v\n", rolledBack, err)
+			return 1
+		}
+		fmt.Printf("rolled back %d migration(s)\n", rolledBack)
+
+	case "status":
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read schema version: %v\n", err)
+			return 1
+		}
+		fmt.Printf("database version: %d\nrequired version: %d\n", version, database.SchemaVersion)
+		for _, m := range migrator.Migrations() {
+			state := "pending"
+			if m.Version <= version {
+				state = "applied"
+			}
+			fmt.Printf("  %04d_%s  %s\n", m.Version, m.Name, state)
+		}
+
+	default:
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	return 0
+}
@@ -2,168 +2,124 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"flag"
-	"fmt"
-	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/go-redis/redis/v8"
-	"github.com/stripe/stripe-go/v72"
-
-	"api-monitor-saas/internal/database"
-	"api-monitor-saas/internal/metrics"
+	"api-monitor-go/internal/config"
+	"api-monitor-go/internal/database"
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/metrics"
+	"api-monitor-go/internal/middleware"
+	"github.com/redis/go-redis/v9"
 )
 
+// Standalone metrics worker. It reads the same environment configuration
+// as the API; set METRICS_EMBEDDED=true to run collection inside the API
+// process instead.
 func main() {
-	// Parse flags
-	dbURL := flag.String("db", "", "Database connection URL")
-	redisURL := flag.String("redis", "", "Redis connection URL")
-	stripeKey := flag.String("stripe-key", "", "Stripe API key")
-	interval := flag.Duration("interval", 5*time.Minute, "Collection interval")
 	once := flag.Bool("once", false, "Run collection once and exit")
-
+	addr := flag.String("addr", "", "Serve collector state at /stats/collectors and the admin API at /admin/collectors on this address")
 	flag.Parse()
 
-	// Validate required flags
-	if *dbURL == "" {
-		fmt.Fprintf(os.Stderr, "Error: --db flag is required\n")
-		os.Exit(1)
+	log := logger.New().WithField("component", "collect-metrics")
+	cfg := config.Load()
+
+	db, err := database.NewDB()
+	if err != nil {
+		log.Fatalf("failed to connect to database: %v", err)
 	}
+	defer db.Close()
 
-	if *redisURL == "" {
-		fmt.Fprintf(os.Stderr, "Error: --redis flag is required\n")
-		os.Exit(1)
+	migrator, err := database.NewMigrator(db.Postgres)
+	if err != nil {
+		log.Fatalf("failed to load migrations: %v", err)
 	}
 
-	// Initialize database connection
-	db, err := database.New(*dbURL)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	err = migrator.CheckVersion(ctx)
+	cancel()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		log.Fatalf("schema check failed: %v", err)
 	}
-	defer db.Close()
 
-	// Initialize Redis connection
-	redisClient := redis.NewClient(&redis.Options{
-		Addr: *redisURL,
+	rdb := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisHost + ":" + cfg.RedisPort,
+		DB:   cfg.RedisDB,
 	})
+	defer rdb.Close()
 
-	if err := redisClient.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	err = rdb.Ping(ctx).Err()
+	cancel()
+	if err != nil {
+		log.Fatalf("failed to connect to Redis: %v", err)
 	}
-	defer redisClient.Close()
-
-	log.Println("Metrics collection worker started")
-	log.Printf("Interval: %v", *interval)
 
-	// Create aggregator
-	aggregator := metrics.NewMetricsAggregator(5)
-
-	// Register collectors
-	aggregator.AddCollector(metrics.NewSystemMetricsCollector())
-	aggregator.AddCollector(metrics.NewMonitoringMetricsCollector(db))
-
-	// Add Stripe collector if API key provided
-	if *stripeKey != "" {
-		stripe.Key = *stripeKey
-		aggregator.AddCollector(metrics.NewStripeMetricsCollector(*stripeKey))
+	worker, err := metrics.NewWorkerFromConfig(db, rdb, cfg)
+	if err != nil {
+		log.Fatalf("failed to create metrics worker: %v", err)
 	}
 
-	// Create store
-	store := metrics.NewPostgresMetricsStore(db)
-
-	// Create publisher
-	publisher := metrics.NewRedisMetricsPublisher(redisClient, "metrics:stream")
+	if *once {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.MetricsTimeout)
+		err := worker.CollectOnce(ctx)
+		cancel()
+		if err != nil {
+			log.Errorf("collection error: %v", err)
+		}
 
-	// Add logging handler
-	publisher.Subscribe(func(ctx context.Context, metrics []metrics.MetricValue) error {
-		for _, m := range metrics {
-			log.Printf("Published metric: %s = %.2f", m.Name, m.Value)
+		// Metrics are stored in batches; write what was collected before
+		// exiting
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		stopErr := worker.Stop(ctx)
+		cancel()
+		if stopErr != nil {
+			log.Errorf("failed to store collected metrics: %v", stopErr)
 		}
-		return nil
-	})
 
-	// Run once if requested
-	if *once {
-		err := collectMetrics(context.Background(), aggregator, store, publisher)
-		if err != nil {
-			log.Printf("Collection error: %v", err)
+		if err != nil || stopErr != nil {
 			os.Exit(1)
 		}
 		return
 	}
 
-	// Run continuously
-	ticker := time.NewTicker(*interval)
-	defer ticker.Stop()
+	log.Infof("metrics worker started, default interval %v", cfg.MetricsInterval)
+	worker.Start()
 
-	// Set up signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	var server *http.Server
+	if *addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/stats/collectors", middleware.RequireAdminToken(cfg.AdminToken)(worker.Scheduler()))
 
-	// Collect immediately on startup
-	err = collectMetrics(context.Background(), aggregator, store, publisher)
-	if err != nil {
-		log.Printf("Initial collection error: %v", err)
-	}
+		admin := middleware.RequireAdminToken(cfg.AdminToken)(worker.Scheduler().AdminHandler("/admin/collectors"))
+		mux.Handle("/admin/collectors", admin)
+		mux.Handle("/admin/collectors/", admin)
+		server = &http.Server{Addr: *addr, Handler: mux, ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
 
-	for {
-		select {
-		case <-ticker.C:
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			err := collectMetrics(ctx, aggregator, store, publisher)
-			cancel()
-
-			if err != nil {
-				log.Printf("Collection error: %v", err)
+		go func() {
+			if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Errorf("status server error: %v", err)
 			}
-
-		case sig := <-sigChan:
-			log.Printf("Received signal: %v", sig)
-			return
-		}
+		}()
 	}
-}
 
-// collectMetrics performs the metrics collection cycle
-func collectMetrics(
-	ctx context.Context,
-	aggregator metrics.MetricsAggregator,
-	store metrics.MetricsStore,
-	publisher metrics.MetricsPublisher,
-) error {
-	log.Println("Starting metrics collection...")
-
-	startTime := time.Now()
-
-	// Collect all metrics
-	allMetrics, err := aggregator.CollectAll(ctx)
-	if err != nil {
-		return fmt.Errorf("collection failed: %w", err)
-	}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-sigChan
+	log.Infof("received signal %v, shutting down", sig)
 
-	// Flatten metrics for storage and publishing
-	var flatMetrics []metrics.MetricValue
-	for collectorName, metricsList := range allMetrics {
-		log.Printf("Collector '%s' collected %d metrics", collectorName, len(metricsList))
-		flatMetrics = append(flatMetrics, metricsList...)
-	}
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Store metrics
-	if err := store.Store(ctx, flatMetrics); err != nil {
-		return fmt.Errorf("storage failed: %w", err)
+	if server != nil {
+		server.Shutdown(ctx)
 	}
 
-	// Publish metrics
-	if err := publisher.Publish(ctx, flatMetrics); err != nil {
-		return fmt.Errorf("publishing failed: %w", err)
+	if err := worker.Stop(ctx); err != nil {
+		log.Errorf("worker shutdown error: %v", err)
 	}
-
-	elapsed := time.Since(startTime)
-	log.Printf("Metrics collection completed: %d metrics in %v", len(flatMetrics), elapsed)
-
-	return nil
 }
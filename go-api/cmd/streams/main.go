@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"api-monitor-go/internal/config"
+	"api-monitor-go/internal/streams"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis stream admin tool. It reads the same environment configuration as
+// the API.
+//
+//	streams lag [-json] [stream ...]
+//
+// prints the length of each stream and its dead-letter stream, and the
+// consumers, pending entries and lag of each consumer group. Without
+// arguments it inspects the streams the API and metrics worker publish to.
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s lag [-json] [stream ...]\n", os.Args[0])
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 || flag.Arg(0) != "lag" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	lagFlags := flag.NewFlagSet("lag", flag.ExitOnError)
+	asJSON := lagFlags.Bool("json", false, "Print JSON instead of a table")
+	lagFlags.Parse(flag.Args()[1:])
+
+	cfg := config.Load()
+	names := lagFlags.Args()
+	if len(names) == 0 {
+		names = []string{cfg.MetricsStream, cfg.SystemMetricsStream, cfg.AlertsStream}
+	}
+
+	rdb := redis.NewClient(&redis.Options{
+		Addr: cfg.RedisHost + ":" + cfg.RedisPort,
+		DB:   cfg.RedisDB,
+	})
+	defer rdb.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var infos []streams.StreamInfo
+	for _, name := range names {
+		for _, stream := range []string{name, name + ":dead"} {
+			info, err := streams.Inspect(ctx, rdb, stream)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", stream, err)
+				os.Exit(1)
+			}
+			infos = append(infos, info)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(infos)
+		return
+	}
+
+	printLag(infos)
+}
+
+// printLag prints one row per group, or per stream without groups
+func printLag(infos []streams.StreamInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STREAM\tLENGTH\tGROUP\tCONSUMERS\tPENDING\tLAG\tOLDEST PENDING\tLAST DELIVERED")
+
+	for _, info := range infos {
+		if len(info.Groups) == 0 {
+			fmt.Fprintf(w, "%s\t%d\t-\t-\t-\t-\t-\t-\n", info.Stream, info.Length)
+			continue
+		}
+
+		for _, group := range info.Groups {
+			lag := fmt.Sprint(group.Lag)
+			if group.LagCapped {
+				lag = ">" + lag
+			}
+			oldest := "-"
+			if group.Pending > 0 {
+				oldest = group.OldestPendingAge.Round(time.Second).String()
+			}
+
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\t%s\t%s\t%s\n",
+				info.Stream, info.Length, group.Name, group.Consumers, group.Pending, lag, oldest, group.LastDeliveredID)
+		}
+	}
+
+	w.Flush()
+}
@@ -0,0 +1,594 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// Checker checks an endpoint, reporting failures in the result
+type Checker interface {
+	Check(ctx context.Context, endpoint models.Endpoint) models.MonitoringResult
+}
+
+// Transport is how an agent pushes its results
+type Transport string
+
+// Transports
+const (
+	TransportHTTP      Transport = "http"
+	TransportWebSocket Transport = "websocket"
+)
+
+// Config holds agent configuration
+type Config struct {
+	ID     string
+	Region string
+	Secret string
+
+	// ServerURL is the base URL of the central API, e.g.
+	// "https://monitor.example.com/go"
+	ServerURL string
+	Transport Transport
+
+	// AssignmentInterval is how often the assignment is refreshed
+	AssignmentInterval time.Duration
+
+	// MaxConcurrentChecks bounds the checks running at once
+	MaxConcurrentChecks int
+
+	// BatchSize is the most results pushed at once; a full batch is pushed
+	// without waiting for FlushInterval
+	BatchSize     int
+	FlushInterval time.Duration
+
+	// BufferSize is how many results are kept while the server can't be
+	// reached. The oldest are dropped beyond it.
+	BufferSize int
+
+	// RetryInterval is the first delay after a failed request; it doubles
+	// up to MaxRetryInterval
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// RequestTimeout bounds every request to the server
+	RequestTimeout time.Duration
+}
+
+// DefaultConfig returns default agent configuration
+func DefaultConfig() Config {
+	return Config{
+		Transport:           TransportHTTP,
+		AssignmentInterval:  time.Minute,
+		MaxConcurrentChecks: 10,
+		BatchSize:           100,
+		FlushInterval:       5 * time.Second,
+		BufferSize:          10000,
+		RetryInterval:       time.Second,
+		MaxRetryInterval:    time.Minute,
+		RequestTimeout:      10 * time.Second,
+	}
+}
+
+// Stats describes an agent's buffer and deliveries
+type Stats struct {
+	Endpoints int   `json:"endpoints"`
+	Checks    int64 `json:"checks"`
+	Buffered  int   `json:"buffered"`
+	Dropped   int64 `json:"dropped"`
+	Sent      int64 `json:"sent"`
+	Failures  int64 `json:"failures"`
+}
+
+// Agent checks the endpoints the central API assigns it and pushes the
+// results back, buffering them while the server can't be reached
+type Agent struct {
+	config  Config
+	checker Checker
+	client  *http.Client
+	sender  sender
+	log     *logger.Logger
+	now     func() time.Time
+
+	// assigned is signalled when a new assignment arrives
+	assigned chan struct{}
+	// full is signalled when a batch is ready to push
+	full chan struct{}
+
+	mu         sync.Mutex
+	assignment Assignment
+	buffer     []models.MonitoringResult
+	// pending is the batch being pushed
+	pending *ResultBatch
+	stats   Stats
+}
+
+// New creates an agent
+func New(config Config, checker Checker) (*Agent, error) {
+	if config.ID == "" || config.Secret == "" {
+		return nil, errors.New("agent ID and secret are required")
+	}
+	if _, err := url.Parse(config.ServerURL); err != nil || config.ServerURL == "" {
+		return nil, fmt.Errorf("invalid server URL %q", config.ServerURL)
+	}
+
+	defaults := DefaultConfig()
+	if config.Transport == "" {
+		config.Transport = defaults.Transport
+	}
+	if config.AssignmentInterval <= 0 {
+		config.AssignmentInterval = defaults.AssignmentInterval
+	}
+	if config.MaxConcurrentChecks <= 0 {
+		config.MaxConcurrentChecks = defaults.MaxConcurrentChecks
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaults.FlushInterval
+	}
+	if config.BufferSize <= 0 {
+		config.BufferSize = defaults.BufferSize
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaults.RetryInterval
+	}
+	if config.MaxRetryInterval < config.RetryInterval {
+		config.MaxRetryInterval = defaults.MaxRetryInterval
+	}
+	if config.RequestTimeout <= 0 {
+		config.RequestTimeout = defaults.RequestTimeout
+	}
+	config.ServerURL = strings.TrimSuffix(config.ServerURL, "/")
+
+	a := &Agent{
+		config:   config,
+		checker:  checker,
+		client:   &http.Client{Timeout: config.RequestTimeout},
+		log:      logger.New().WithFields(map[string]interface{}{"component": "agent", "agent_id": config.ID}),
+		now:      time.Now,
+		assigned: make(chan struct{}, 1),
+		full:     make(chan struct{}, 1),
+	}
+
+	switch config.Transport {
+	case TransportHTTP:
+		a.sender = &httpSender{agent: a}
+	case TransportWebSocket:
+		a.sender = &wsSender{agent: a}
+	default:
+		return nil, fmt.Errorf("unknown transport %q", config.Transport)
+	}
+	return a, nil
+}
+
+// Stats returns a snapshot of the agent's counters
+func (a *Agent) Stats() Stats {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	stats := a.stats
+	stats.Endpoints = len(a.assignment.Endpoints)
+	stats.Buffered = len(a.buffer)
+	if a.pending != nil {
+		stats.Buffered += len(a.pending.Results)
+	}
+	return stats
+}
+
+// Run polls for assignments, checks endpoints and pushes results until
+// ctx is done. Results still buffered then are pushed once more.
+func (a *Agent) Run(ctx context.Context) error {
+	a.log.Infof("agent starting in region %q, pushing to %s over %s", a.config.Region, a.config.ServerURL, a.config.Transport)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		a.pollAssignments(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		a.runChecks(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		a.pushResults(ctx)
+	}()
+	wg.Wait()
+
+	flushCtx, cancel := context.WithTimeout(context.Background(), a.config.RequestTimeout)
+	defer cancel()
+	a.flush(flushCtx)
+	a.sender.Close()
+
+	if buffered := a.Stats().Buffered; buffered > 0 {
+		a.log.Warnf("agent stopped with %d results not pushed", buffered)
+	}
+	return ctx.Err()
+}
+
+// pollAssignments keeps the assignment current, retrying sooner when the
+// server can't be reached
+func (a *Agent) pollAssignments(ctx context.Context) {
+	backoff := a.newBackoff()
+	for {
+		wait := a.config.AssignmentInterval
+		if err := a.refreshAssignment(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait = backoff.next()
+			a.log.Warnf("failed to fetch assignment, retrying in %v: %v", wait, err)
+		} else {
+			backoff.reset()
+		}
+
+		if !sleep(ctx, wait) {
+			return
+		}
+	}
+}
+
+// refreshAssignment fetches the agent's assignment
+func (a *Agent) refreshAssignment(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, a.config.RequestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, a.config.ServerURL+AssignmentsPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	SignRequest(req, AssignmentsPath, a.config.ID, a.config.Secret, a.now())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+	var assignment Assignment
+	if err := json.NewDecoder(resp.Body).Decode(&assignment); err != nil {
+		return fmt.Errorf("failed to decode assignment: %w", err)
+	}
+
+	a.mu.Lock()
+	a.assignment = assignment
+	a.mu.Unlock()
+	signal(a.assigned)
+	return nil
+}
+
+// runChecks checks every assigned endpoint each check interval, starting
+// as soon as the first assignment arrives
+func (a *Agent) runChecks(ctx context.Context) {
+	select {
+	case <-a.assigned:
+	case <-ctx.Done():
+		return
+	}
+
+	for {
+		a.mu.Lock()
+		assignment := a.assignment
+		a.mu.Unlock()
+
+		a.checkEndpoints(ctx, assignment.Endpoints)
+
+		interval := assignment.CheckInterval
+		if interval <= 0 {
+			interval = DefaultServerConfig().CheckInterval
+		}
+		if !sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// checkEndpoints checks endpoints concurrently and buffers the results
+func (a *Agent) checkEndpoints(ctx context.Context, endpoints []models.Endpoint) {
+	semaphore := make(chan struct{}, a.config.MaxConcurrentChecks)
+	var wg sync.WaitGroup
+	for _, endpoint := range endpoints {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+
+		wg.Add(1)
+		go func(endpoint models.Endpoint) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			result := a.checker.Check(ctx, endpoint)
+			result.Region = a.config.Region
+			result.AgentID = a.config.ID
+			a.bufferResult(result)
+		}(endpoint)
+	}
+	wg.Wait()
+}
+
+// bufferResult queues a result for pushing, dropping the oldest when the
+// buffer is full
+func (a *Agent) bufferResult(result models.MonitoringResult) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.stats.Checks++
+	if len(a.buffer) >= a.config.BufferSize {
+		dropped := len(a.buffer) - a.config.BufferSize + 1
+		a.buffer = a.buffer[dropped:]
+		a.stats.Dropped += int64(dropped)
+	}
+	a.buffer = append(a.buffer, result)
+	if len(a.buffer) >= a.config.BatchSize {
+		signal(a.full)
+	}
+}
+
+// pushResults pushes buffered results every flush interval, or as soon as
+// a batch is full, backing off while the server can't be reached
+func (a *Agent) pushResults(ctx context.Context) {
+	ticker := time.NewTicker(a.config.FlushInterval)
+	defer ticker.Stop()
+
+	backoff := a.newBackoff()
+	for {
+		select {
+		case <-ticker.C:
+		case <-a.full:
+		case <-ctx.Done():
+			return
+		}
+
+		if err := a.flush(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait := backoff.next()
+			a.log.Warnf("failed to push results, retrying in %v: %v", wait, err)
+			if !sleep(ctx, wait) {
+				return
+			}
+			continue
+		}
+		backoff.reset()
+	}
+}
+
+// flush pushes buffered results in batches until the buffer is empty. A
+// batch is resent under the same ID until it is acknowledged, so the
+// server can drop copies whose acknowledgement was lost.
+func (a *Agent) flush(ctx context.Context) error {
+	for {
+		a.mu.Lock()
+		if a.pending == nil && len(a.buffer) > 0 {
+			n := len(a.buffer)
+			if n > a.config.BatchSize {
+				n = a.config.BatchSize
+			}
+			a.pending = &ResultBatch{
+				ID:      newBatchID(),
+				AgentID: a.config.ID,
+				Region:  a.config.Region,
+				Results: append([]models.MonitoringResult(nil), a.buffer[:n]...),
+			}
+			a.buffer = a.buffer[n:]
+		}
+		pending := a.pending
+		a.mu.Unlock()
+
+		if pending == nil {
+			return nil
+		}
+
+		batch := *pending
+		batch.SentAt = a.now()
+		signed, err := SignBatch(batch, a.config.Secret)
+		if err != nil {
+			return err
+		}
+
+		sendCtx, cancel := context.WithTimeout(ctx, a.config.RequestTimeout)
+		ack, err := a.sender.Send(sendCtx, signed)
+		cancel()
+		if err == nil && ack.Error != "" {
+			err = fmt.Errorf("server rejected batch: %s", ack.Error)
+		}
+
+		a.mu.Lock()
+		if err != nil {
+			a.stats.Failures++
+			a.mu.Unlock()
+			return err
+		}
+		a.pending = nil
+		if !ack.Duplicate {
+			a.stats.Sent += int64(len(batch.Results))
+		}
+		a.mu.Unlock()
+	}
+}
+
+// backoff doubles a delay up to a limit
+type backoff struct {
+	initial, max, current time.Duration
+}
+
+func (a *Agent) newBackoff() *backoff {
+	return &backoff{initial: a.config.RetryInterval, max: a.config.MaxRetryInterval}
+}
+
+// next returns the next delay
+func (b *backoff) next() time.Duration {
+	if b.current == 0 {
+		b.current = b.initial
+	} else if b.current *= 2; b.current > b.max {
+		b.current = b.max
+	}
+	return b.current
+}
+
+// reset starts over from the initial delay
+func (b *backoff) reset() {
+	b.current = 0
+}
+
+// sleep waits for d, returning false when ctx is done first
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// signal notifies a buffered channel without blocking
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// newBatchID returns a random batch ID
+func newBatchID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// sender pushes signed batches to the server
+type sender interface {
+	Send(ctx context.Context, batch SignedBatch) (Ack, error)
+	Close() error
+}
+
+// httpSender posts each batch
+type httpSender struct {
+	agent *Agent
+}
+
+func (s *httpSender) Send(ctx context.Context, batch SignedBatch) (Ack, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return Ack{}, fmt.Errorf("failed to encode batch: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.agent.config.ServerURL+ResultsPath, bytes.NewReader(body))
+	if err != nil {
+		return Ack{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	SignRequest(req, ResultsPath, s.agent.config.ID, s.agent.config.Secret, s.agent.now())
+
+	resp, err := s.agent.client.Do(req)
+	if err != nil {
+		return Ack{}, err
+	}
+	defer resp.Body.Close()
+
+	var ack Ack
+	if err := json.NewDecoder(resp.Body).Decode(&ack); err != nil || resp.StatusCode != http.StatusOK {
+		return Ack{}, fmt.Errorf("server returned %d", resp.StatusCode)
+	}
+	return ack, nil
+}
+
+func (s *httpSender) Close() error {
+	s.agent.client.CloseIdleConnections()
+	return nil
+}
+
+// wsSender pushes batches over one WebSocket, reconnecting after errors
+type wsSender struct {
+	agent *Agent
+	conn  *websocket.Conn
+}
+
+func (s *wsSender) Send(ctx context.Context, batch SignedBatch) (Ack, error) {
+	if s.conn == nil {
+		if err := s.dial(ctx); err != nil {
+			return Ack{}, err
+		}
+	}
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(s.agent.config.RequestTimeout)
+	}
+	s.conn.SetWriteDeadline(deadline)
+	s.conn.SetReadDeadline(deadline)
+
+	if err := s.conn.WriteJSON(batch); err != nil {
+		s.Close()
+		return Ack{}, fmt.Errorf("failed to send batch: %w", err)
+	}
+	var ack Ack
+	if err := s.conn.ReadJSON(&ack); err != nil {
+		s.Close()
+		return Ack{}, fmt.Errorf("failed to read acknowledgement: %w", err)
+	}
+	return ack, nil
+}
+
+// dial opens the WebSocket, signing the upgrade request
+func (s *wsSender) dial(ctx context.Context) error {
+	u, err := url.Parse(s.agent.config.ServerURL + WebSocketPath)
+	if err != nil {
+		return fmt.Errorf("invalid server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	req := &http.Request{Method: http.MethodGet, URL: u, Header: http.Header{}}
+	SignRequest(req, WebSocketPath, s.agent.config.ID, s.agent.config.Secret, s.agent.now())
+
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, u.String(), req.Header)
+	if err != nil {
+		if resp != nil {
+			return fmt.Errorf("failed to connect: server returned %d", resp.StatusCode)
+		}
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+	s.conn = conn
+	s.agent.log.Info("connected to server")
+	return nil
+}
+
+func (s *wsSender) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,179 @@
+package agents
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// fakeChecker reports every endpoint as up
+type fakeChecker struct{}
+
+func (fakeChecker) Check(ctx context.Context, endpoint models.Endpoint) models.MonitoringResult {
+	status := http.StatusOK
+	return models.MonitoringResult{EndpointID: endpoint.ID, StatusCode: &status, ResponseTime: 5, CheckedAt: time.Now(), URL: endpoint.URL}
+}
+
+func newTestAgent(t *testing.T, serverURL, id, region string, transport Transport) *Agent {
+	t.Helper()
+	config := DefaultConfig()
+	config.ID = id
+	config.Region = region
+	config.Secret = testSecrets[id]
+	config.ServerURL = serverURL
+	config.Transport = transport
+	config.FlushInterval = 10 * time.Millisecond
+	config.RetryInterval = 5 * time.Millisecond
+	config.MaxRetryInterval = 20 * time.Millisecond
+
+	agent, err := New(config, fakeChecker{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return agent
+}
+
+// runAgent runs an agent until the test ends
+func runAgent(t *testing.T, agent *Agent) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		agent.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestAgentsPushResults(t *testing.T) {
+	sink := &resultSink{}
+	server := newTestServer(sink,
+		models.Endpoint{ID: 1, URL: "https://one.example.com"},
+		models.Endpoint{ID: 2, URL: "https://two.example.com"},
+	)
+	ts := httptest.NewServer(server.Handler())
+	defer ts.Close()
+
+	agents := map[string]*Agent{
+		"eu-1": newTestAgent(t, ts.URL, "eu-1", "eu-west", TransportHTTP),
+		"us-1": newTestAgent(t, ts.URL, "us-1", "us-east", TransportWebSocket),
+		"ap-1": newTestAgent(t, ts.URL+"/", "ap-1", "ap-south", TransportHTTP),
+	}
+	for _, agent := range agents {
+		runAgent(t, agent)
+	}
+
+	// Every agent reports every endpoint, tagged with its region
+	type key struct {
+		agent    string
+		endpoint int
+	}
+	waitFor(t, "results from every agent", func() bool {
+		seen := map[key]bool{}
+		for _, result := range sink.snapshot() {
+			if agent, ok := agents[result.AgentID]; ok && result.Region == agent.config.Region {
+				seen[key{result.AgentID, result.EndpointID}] = true
+			}
+		}
+		return len(seen) == len(agents)*2
+	})
+
+	stats := server.Stats()
+	if len(stats) != len(agents) {
+		t.Fatalf("expected %d agents in stats, got %+v", len(agents), stats)
+	}
+	for _, s := range stats {
+		if s.Results == 0 || s.Region != agents[s.AgentID].config.Region {
+			t.Errorf("unexpected stats %+v", s)
+		}
+	}
+	if !stats[2].Connected {
+		t.Errorf("expected the WebSocket agent to be connected, got %+v", stats[2])
+	}
+}
+
+func TestAgentBuffersWhileDisconnected(t *testing.T) {
+	for _, transport := range []Transport{TransportHTTP, TransportWebSocket} {
+		t.Run(string(transport), func(t *testing.T) {
+			sink := &resultSink{}
+			server := newTestServer(sink, models.Endpoint{ID: 1, URL: "https://one.example.com"})
+
+			// Results can't be pushed while down; assignments still work
+			var down int32 = 1
+			handler := server.Handler()
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.LoadInt32(&down) == 1 && !strings.HasSuffix(r.URL.Path, AssignmentsPath) {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				handler.ServeHTTP(w, r)
+			}))
+			defer ts.Close()
+
+			agent := newTestAgent(t, ts.URL, "eu-1", "eu-west", transport)
+			runAgent(t, agent)
+
+			waitFor(t, "buffered results", func() bool { return agent.Stats().Buffered >= 3 })
+			if got := len(sink.snapshot()); got != 0 {
+				t.Fatalf("expected nothing pushed while down, got %d results", got)
+			}
+			if agent.Stats().Failures == 0 {
+				t.Error("expected failed pushes to be counted")
+			}
+
+			atomic.StoreInt32(&down, 0)
+			waitFor(t, "the buffer to drain", func() bool {
+				return len(sink.snapshot()) >= 3 && agent.Stats().Sent >= 3
+			})
+		})
+	}
+}
+
+func TestAgentBufferDropsOldest(t *testing.T) {
+	agent := newTestAgent(t, "http://localhost", "eu-1", "eu-west", TransportHTTP)
+	agent.config.BufferSize = 3
+
+	for i := 1; i <= 5; i++ {
+		agent.bufferResult(models.MonitoringResult{EndpointID: i})
+	}
+
+	stats := agent.Stats()
+	if stats.Buffered != 3 || stats.Dropped != 2 || stats.Checks != 5 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	if agent.buffer[0].EndpointID != 3 {
+		t.Errorf("expected the oldest results to be dropped, buffer starts at %d", agent.buffer[0].EndpointID)
+	}
+}
+
+func TestNewAgentValidatesConfig(t *testing.T) {
+	for name, config := range map[string]Config{
+		"missing ID":        {Secret: "s", ServerURL: "http://localhost"},
+		"missing secret":    {ID: "a", ServerURL: "http://localhost"},
+		"missing URL":       {ID: "a", Secret: "s"},
+		"unknown transport": {ID: "a", Secret: "s", ServerURL: "http://localhost", Transport: "carrier-pigeon"},
+	} {
+		if _, err := New(config, fakeChecker{}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
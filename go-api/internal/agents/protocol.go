@@ -0,0 +1,125 @@
+// Package agents lets check agents in other regions run endpoint checks
+// for the central API: agents pull their assignments and push signed
+// result batches back over HTTP or a WebSocket.
+package agents
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// Paths served by the central API
+const (
+	AssignmentsPath = "/agents/assignments"
+	ResultsPath     = "/agents/results"
+	WebSocketPath   = "/agents/ws"
+)
+
+// Signature errors
+var (
+	ErrUnknownAgent     = errors.New("unknown agent")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrStaleSignature   = errors.New("signature timestamp outside the allowed skew")
+)
+
+// Assignment is the work the central API gives an agent
+type Assignment struct {
+	Endpoints []models.Endpoint `json:"endpoints"`
+
+	// CheckInterval is how often the agent checks every endpoint
+	CheckInterval time.Duration `json:"check_interval"`
+}
+
+// ResultBatch is a set of results an agent pushes at once. ID is unique
+// per batch, so a batch resent after a lost acknowledgement is only
+// stored once.
+type ResultBatch struct {
+	ID      string                    `json:"id"`
+	AgentID string                    `json:"agent_id"`
+	Region  string                    `json:"region"`
+	SentAt  time.Time                 `json:"sent_at"`
+	Results []models.MonitoringResult `json:"results"`
+}
+
+// SignedBatch carries an encoded ResultBatch and its HMAC-SHA256 signature
+// under the agent's secret
+type SignedBatch struct {
+	AgentID   string          `json:"agent_id"`
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// Ack acknowledges a batch; Error is set when it was rejected, Duplicate
+// when it had already been received
+type Ack struct {
+	BatchID   string `json:"batch_id"`
+	Accepted  int    `json:"accepted"`
+	Stale     int    `json:"stale,omitempty"`
+	Duplicate bool   `json:"duplicate,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// sign returns the hex HMAC-SHA256 of parts joined by newlines
+func sign(secret string, parts ...string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join(parts, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validSignature compares signatures in constant time
+func validSignature(secret, signature string, parts ...string) bool {
+	return hmac.Equal([]byte(sign(secret, parts...)), []byte(signature))
+}
+
+// SignBatch encodes and signs a batch
+func SignBatch(batch ResultBatch, secret string) (SignedBatch, error) {
+	payload, err := json.Marshal(batch)
+	if err != nil {
+		return SignedBatch{}, fmt.Errorf("failed to encode batch: %w", err)
+	}
+	return SignedBatch{
+		AgentID:   batch.AgentID,
+		Payload:   payload,
+		Signature: sign(secret, batch.AgentID, string(payload)),
+	}, nil
+}
+
+// authorizationScheme prefixes the Authorization header of agent requests
+const authorizationScheme = "Agent "
+
+// SignRequest authorizes a request to one of the agent paths as agentID.
+// The signature covers the method, path and time, and is good for the
+// server's allowed skew. The path is signed rather than the request's URL,
+// which may carry a prefix a reverse proxy strips.
+func SignRequest(r *http.Request, path, agentID, secret string, now time.Time) {
+	ts := strconv.FormatInt(now.Unix(), 10)
+	signature := sign(secret, agentID, ts, r.Method, path)
+	r.Header.Set("Authorization", authorizationScheme+agentID+":"+ts+":"+signature)
+}
+
+// parseAuthorization splits an agent Authorization header
+func parseAuthorization(header string) (agentID string, ts time.Time, signature string, err error) {
+	if !strings.HasPrefix(header, authorizationScheme) {
+		return "", time.Time{}, "", ErrInvalidSignature
+	}
+
+	parts := strings.Split(strings.TrimPrefix(header, authorizationScheme), ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return "", time.Time{}, "", ErrInvalidSignature
+	}
+	seconds, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return "", time.Time{}, "", ErrInvalidSignature
+	}
+	return parts[0], time.Unix(seconds, 0), parts[2], nil
+}
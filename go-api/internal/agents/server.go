@@ -0,0 +1,396 @@
+package agents
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+	"github.com/gorilla/websocket"
+)
+
+// ServerConfig holds agent server configuration
+type ServerConfig struct {
+	// Secrets maps agent IDs to their signing secrets; agents not listed
+	// are turned away
+	Secrets map[string]string
+
+	// MaxSkew is how far a signature's time may be from the server's.
+	// Batch IDs are kept for twice as long to reject replays.
+	MaxSkew time.Duration
+
+	// CheckInterval is how often agents are told to check their endpoints
+	CheckInterval time.Duration
+
+	// MaxResultAge bounds how long an agent may buffer a result. Older
+	// results are dropped rather than stored after their rollup bucket
+	// was closed, so retention's Lateness must cover it plus MaxSkew.
+	MaxResultAge time.Duration
+}
+
+// DefaultServerConfig returns default agent server configuration
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		MaxSkew:       5 * time.Minute,
+		CheckInterval: time.Minute,
+		MaxResultAge:  10 * time.Minute,
+	}
+}
+
+// EndpointSource lists the endpoints agents check
+type EndpointSource interface {
+	GetActiveEndpoints() ([]models.Endpoint, error)
+}
+
+// ResultSink stores the results of a batch before it is acknowledged, so
+// an agent may discard them once it is. It stores the batch's key with the
+// results and reports a duplicate, without storing anything, when the key
+// was already stored by any replica.
+type ResultSink func(ctx context.Context, key BatchKey, results []models.MonitoringResult) (duplicate bool, err error)
+
+// BatchKey identifies a batch. Keys stored before Expired may be deleted:
+// a batch that old fails the skew check.
+type BatchKey struct {
+	AgentID string
+	BatchID string
+	Expired time.Time
+}
+
+// AgentStats describes an agent as seen by the server
+type AgentStats struct {
+	AgentID   string    `json:"agent_id"`
+	Region    string    `json:"region,omitempty"`
+	Connected bool      `json:"connected"`
+	LastSeen  time.Time `json:"last_seen"`
+	Batches   int64     `json:"batches"`
+	Results   int64     `json:"results"`
+	Rejected  int64     `json:"rejected"`
+	Stale     int64     `json:"stale"`
+}
+
+// agentState is an agent's stats and open connections
+type agentState struct {
+	stats       AgentStats
+	connections int
+}
+
+// Server hands out endpoint assignments to agents and receives their
+// results
+type Server struct {
+	config    ServerConfig
+	endpoints EndpointSource
+	sink      ResultSink
+	upgrader  websocket.Upgrader
+	log       *logger.Logger
+	now       func() time.Time
+
+	mu     sync.Mutex
+	agents map[string]*agentState
+}
+
+// NewServer creates an agent server
+func NewServer(config ServerConfig, endpoints EndpointSource, sink ResultSink) *Server {
+	defaults := DefaultServerConfig()
+	if config.MaxSkew <= 0 {
+		config.MaxSkew = defaults.MaxSkew
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = defaults.CheckInterval
+	}
+	if config.MaxResultAge <= 0 {
+		config.MaxResultAge = defaults.MaxResultAge
+	}
+
+	return &Server{
+		config:    config,
+		endpoints: endpoints,
+		sink:      sink,
+		// Agents aren't browsers; their requests are signed instead
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		log:      logger.New().WithField("component", "agents"),
+		now:      time.Now,
+		agents:   make(map[string]*agentState),
+	}
+}
+
+// Handler returns the handler serving the agent paths
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(AssignmentsPath, s.handleAssignments)
+	mux.HandleFunc(ResultsPath, s.handleResults)
+	mux.HandleFunc(WebSocketPath, s.handleWebSocket)
+	return mux
+}
+
+// Stats returns a snapshot of every agent that has been seen, ordered by
+// ID
+func (s *Server) Stats() []AgentStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := make([]AgentStats, 0, len(s.agents))
+	for _, state := range s.agents {
+		stats = append(stats, state.stats)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].AgentID < stats[j].AgentID })
+	return stats
+}
+
+// handleAssignments returns the calling agent's assignment
+func (s *Server) handleAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	agentID, err := s.authenticate(r, AssignmentsPath)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	endpoints, err := s.endpoints.GetActiveEndpoints()
+	if err != nil {
+		s.log.WithField("agent_id", agentID).Errorf("failed to load endpoints: %v", err)
+		writeError(w, http.StatusServiceUnavailable, "failed to load endpoints")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Assignment{Endpoints: endpoints, CheckInterval: s.config.CheckInterval})
+}
+
+// handleResults receives a signed batch
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	agentID, err := s.authenticate(r, ResultsPath)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	var signed SignedBatch
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchBytes)).Decode(&signed); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid batch")
+		return
+	}
+
+	ack, err := s.receive(r.Context(), agentID, signed)
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case errors.Is(err, errSink):
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case err != nil:
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	json.NewEncoder(w).Encode(ack)
+}
+
+// handleWebSocket receives signed batches over a WebSocket, acknowledging
+// each one
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	agentID, err := s.authenticate(r, WebSocketPath)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.WithField("agent_id", agentID).Errorf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+	conn.SetReadLimit(maxBatchBytes)
+
+	s.setConnected(agentID, true)
+	defer s.setConnected(agentID, false)
+	s.log.WithField("agent_id", agentID).Info("agent connected")
+
+	for {
+		var signed SignedBatch
+		if err := conn.ReadJSON(&signed); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				s.log.WithField("agent_id", agentID).Warnf("agent connection closed: %v", err)
+			}
+			return
+		}
+
+		ack, _ := s.receive(r.Context(), agentID, signed)
+		conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		if err := conn.WriteJSON(ack); err != nil {
+			s.log.WithField("agent_id", agentID).Warnf("failed to acknowledge batch: %v", err)
+			return
+		}
+	}
+}
+
+// maxBatchBytes bounds the size of an encoded batch
+const maxBatchBytes = 8 << 20
+
+// maxBatchIDLength is the longest batch ID that can be stored
+const maxBatchIDLength = 100
+
+// errSink marks batches that were valid but could not be stored
+var errSink = errors.New("failed to store results")
+
+// authenticate verifies the Authorization header of a request to path and
+// returns the agent that signed it
+func (s *Server) authenticate(r *http.Request, path string) (string, error) {
+	agentID, ts, signature, err := parseAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+	secret, ok := s.config.Secrets[agentID]
+	if !ok {
+		return "", ErrUnknownAgent
+	}
+	if !s.withinSkew(ts) {
+		return "", ErrStaleSignature
+	}
+	if !validSignature(secret, signature, agentID, fmt.Sprint(ts.Unix()), r.Method, path) {
+		return "", ErrInvalidSignature
+	}
+
+	s.touch(agentID)
+	return agentID, nil
+}
+
+// receive verifies a batch sent by agentID and stores its results, tagged
+// with the agent, its region and location. A batch that was already
+// stored is acknowledged without storing it again.
+func (s *Server) receive(ctx context.Context, agentID string, signed SignedBatch) (Ack, error) {
+	batch, err := s.verify(agentID, signed)
+	if err != nil {
+		s.reject(agentID)
+		s.log.WithField("agent_id", agentID).Warnf("rejected batch: %v", err)
+		return Ack{BatchID: batch.ID, Error: err.Error()}, err
+	}
+
+	// An agent checks from its region; agents without one are locations
+	// of their own
+	location := batch.Region
+	if location == "" {
+		location = agentID
+	}
+	cutoff := s.now().Add(-s.config.MaxResultAge)
+	results := make([]models.MonitoringResult, 0, len(batch.Results))
+	for _, result := range batch.Results {
+		if !result.CheckedAt.IsZero() && result.CheckedAt.Before(cutoff) {
+			continue
+		}
+		result.AgentID = agentID
+		result.Region = batch.Region
+		result.Location = location
+		results = append(results, result)
+	}
+	stale := len(batch.Results) - len(results)
+	if stale > 0 {
+		s.log.WithField("agent_id", agentID).Warnf("dropped %d results older than %s", stale, s.config.MaxResultAge)
+	}
+	key := BatchKey{AgentID: agentID, BatchID: batch.ID, Expired: s.now().Add(-2 * s.config.MaxSkew)}
+	duplicate, err := s.sink(ctx, key, results)
+	if err != nil {
+		s.log.WithField("agent_id", agentID).Errorf("failed to store results: %v", err)
+		return Ack{BatchID: batch.ID, Error: errSink.Error()}, fmt.Errorf("%w: %v", errSink, err)
+	}
+	if duplicate {
+		return Ack{BatchID: batch.ID, Duplicate: true}, nil
+	}
+
+	s.mu.Lock()
+	state := s.state(agentID)
+	state.stats.Region = batch.Region
+	state.stats.Batches++
+	state.stats.Results += int64(len(results))
+	state.stats.Stale += int64(stale)
+	s.mu.Unlock()
+
+	return Ack{BatchID: batch.ID, Accepted: len(results), Stale: stale}, nil
+}
+
+// verify checks a batch's signature and time and decodes it
+func (s *Server) verify(agentID string, signed SignedBatch) (ResultBatch, error) {
+	var batch ResultBatch
+	if signed.AgentID != agentID {
+		return batch, ErrInvalidSignature
+	}
+	secret, ok := s.config.Secrets[agentID]
+	if !ok {
+		return batch, ErrUnknownAgent
+	}
+	if !validSignature(secret, signed.Signature, agentID, string(signed.Payload)) {
+		return batch, ErrInvalidSignature
+	}
+	if err := json.Unmarshal(signed.Payload, &batch); err != nil {
+		return batch, fmt.Errorf("invalid batch: %w", err)
+	}
+	if batch.AgentID != agentID || batch.ID == "" {
+		return batch, ErrInvalidSignature
+	}
+	if len(batch.ID) > maxBatchIDLength {
+		return batch, fmt.Errorf("invalid batch: ID longer than %d characters", maxBatchIDLength)
+	}
+	if !s.withinSkew(batch.SentAt) {
+		return batch, ErrStaleSignature
+	}
+	return batch, nil
+}
+
+// withinSkew reports whether t is within the allowed skew of now
+func (s *Server) withinSkew(t time.Time) bool {
+	skew := s.now().Sub(t)
+	return skew <= s.config.MaxSkew && skew >= -s.config.MaxSkew
+}
+
+// reject counts a rejected batch
+func (s *Server) reject(agentID string) {
+	s.mu.Lock()
+	s.state(agentID).stats.Rejected++
+	s.mu.Unlock()
+}
+
+// touch records that an agent was seen
+func (s *Server) touch(agentID string) {
+	s.mu.Lock()
+	s.state(agentID).stats.LastSeen = s.now()
+	s.mu.Unlock()
+}
+
+// setConnected tracks an agent's open WebSocket connections
+func (s *Server) setConnected(agentID string, connected bool) {
+	s.mu.Lock()
+	state := s.state(agentID)
+	if connected {
+		state.connections++
+	} else {
+		state.connections--
+	}
+	state.stats.Connected = state.connections > 0
+	s.mu.Unlock()
+}
+
+// state returns an agent's state, creating it. s.mu must be held.
+func (s *Server) state(agentID string) *agentState {
+	state, ok := s.agents[agentID]
+	if !ok {
+		state = &agentState{stats: AgentStats{AgentID: agentID}}
+		s.agents[agentID] = state
+	}
+	return state
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
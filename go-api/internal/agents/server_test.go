@@ -0,0 +1,289 @@
+package agents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+type staticEndpoints []models.Endpoint
+
+func (e staticEndpoints) GetActiveEndpoints() ([]models.Endpoint, error) {
+	return e, nil
+}
+
+// resultSink collects the results the server stores and, like the
+// agent_batches table, the keys of the batches they came in
+type resultSink struct {
+	mu      sync.Mutex
+	results []models.MonitoringResult
+	keys    map[BatchKey]bool
+	err     error
+}
+
+func (s *resultSink) store(ctx context.Context, key BatchKey, results []models.MonitoringResult) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false, s.err
+	}
+
+	stored := BatchKey{AgentID: key.AgentID, BatchID: key.BatchID}
+	if s.keys[stored] {
+		return true, nil
+	}
+	if s.keys == nil {
+		s.keys = make(map[BatchKey]bool)
+	}
+	s.keys[stored] = true
+	s.results = append(s.results, results...)
+	return false, nil
+}
+
+func (s *resultSink) snapshot() []models.MonitoringResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.MonitoringResult(nil), s.results...)
+}
+
+var testSecrets = map[string]string{"eu-1": "eu-secret", "us-1": "us-secret", "ap-1": "ap-secret"}
+
+func newTestServer(sink *resultSink, endpoints ...models.Endpoint) *Server {
+	config := DefaultServerConfig()
+	config.Secrets = testSecrets
+	config.CheckInterval = 20 * time.Millisecond
+	return NewServer(config, staticEndpoints(endpoints), sink.store)
+}
+
+// postBatch posts a batch signed by agentID, with the request signed by
+// requestSecret
+func postBatch(t *testing.T, handler http.Handler, agentID, requestSecret string, signed SignedBatch, now time.Time) (int, Ack) {
+	t.Helper()
+	body, _ := json.Marshal(signed)
+	req := httptest.NewRequest(http.MethodPost, ResultsPath, bytes.NewReader(body))
+	SignRequest(req, ResultsPath, agentID, requestSecret, now)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var ack Ack
+	json.NewDecoder(rec.Body).Decode(&ack)
+	return rec.Code, ack
+}
+
+func TestServerReceive(t *testing.T) {
+	sink := &resultSink{}
+	server := newTestServer(sink)
+	handler := server.Handler()
+	now := time.Now()
+
+	batch := ResultBatch{
+		ID:      "batch-1",
+		AgentID: "eu-1",
+		Region:  "eu-west",
+		SentAt:  now,
+		Results: []models.MonitoringResult{{EndpointID: 1}, {EndpointID: 2, AgentID: "spoofed"}},
+	}
+	signed, err := SignBatch(batch, "eu-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, ack := postBatch(t, handler, "eu-1", "eu-secret", signed, now)
+	if code != http.StatusOK || ack.Accepted != 2 || ack.BatchID != "batch-1" {
+		t.Fatalf("expected the batch to be accepted, got %d %+v", code, ack)
+	}
+	for _, result := range sink.snapshot() {
+		if result.AgentID != "eu-1" || result.Region != "eu-west" || result.Location != "eu-west" {
+			t.Errorf("expected results tagged eu-1/eu-west/eu-west, got %s/%s/%s", result.AgentID, result.Region, result.Location)
+		}
+	}
+
+	// A resent batch is acknowledged but stored once
+	code, ack = postBatch(t, handler, "eu-1", "eu-secret", signed, now)
+	if code != http.StatusOK || !ack.Duplicate || ack.Accepted != 0 {
+		t.Errorf("expected a duplicate acknowledgement, got %d %+v", code, ack)
+	}
+	if got := len(sink.snapshot()); got != 2 {
+		t.Errorf("expected 2 stored results, got %d", got)
+	}
+
+	stats := server.Stats()
+	if len(stats) != 1 || stats[0].Batches != 1 || stats[0].Results != 2 || stats[0].Region != "eu-west" {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestServerDeduplicatesAcrossReplicas(t *testing.T) {
+	// Replicas behind a load balancer share the database
+	sink := &resultSink{}
+	replicas := []*Server{newTestServer(sink), newTestServer(sink)}
+	now := time.Now()
+
+	signed, _ := SignBatch(ResultBatch{ID: "batch-1", AgentID: "eu-1", SentAt: now, Results: []models.MonitoringResult{{EndpointID: 1}}}, "eu-secret")
+
+	// The first acknowledgement was lost, so the agent resends the batch
+	// and it reaches the other replica
+	if code, ack := postBatch(t, replicas[0].Handler(), "eu-1", "eu-secret", signed, now); code != http.StatusOK || ack.Accepted != 1 {
+		t.Fatalf("expected the batch to be accepted, got %d %+v", code, ack)
+	}
+	if code, ack := postBatch(t, replicas[1].Handler(), "eu-1", "eu-secret", signed, now); code != http.StatusOK || !ack.Duplicate {
+		t.Fatalf("expected a duplicate acknowledgement, got %d %+v", code, ack)
+	}
+	if got := len(sink.snapshot()); got != 1 {
+		t.Errorf("expected the batch stored once, got %d results", got)
+	}
+}
+
+func TestServerRejectsLongBatchIDs(t *testing.T) {
+	sink := &resultSink{}
+	now := time.Now()
+
+	signed, _ := SignBatch(ResultBatch{ID: strings.Repeat("b", maxBatchIDLength+1), AgentID: "eu-1", SentAt: now}, "eu-secret")
+	if code, _ := postBatch(t, newTestServer(sink).Handler(), "eu-1", "eu-secret", signed, now); code != http.StatusUnauthorized {
+		t.Errorf("expected a batch ID that can't be stored to be rejected, got %d", code)
+	}
+}
+
+func TestServerDropsStaleResults(t *testing.T) {
+	sink := &resultSink{}
+	server := newTestServer(sink)
+	now := time.Now()
+
+	// A result buffered past MaxResultAge would land after its rollup
+	// bucket closed, so it is dropped instead of stored
+	batch := ResultBatch{
+		ID:      "batch-1",
+		AgentID: "eu-1",
+		SentAt:  now,
+		Results: []models.MonitoringResult{
+			{EndpointID: 1, CheckedAt: now.Add(-time.Minute)},
+			{EndpointID: 2, CheckedAt: now.Add(-time.Hour)},
+		},
+	}
+	signed, _ := SignBatch(batch, "eu-secret")
+
+	code, ack := postBatch(t, server.Handler(), "eu-1", "eu-secret", signed, now)
+	if code != http.StatusOK || ack.Accepted != 1 || ack.Stale != 1 {
+		t.Fatalf("expected one accepted and one stale result, got %d %+v", code, ack)
+	}
+	if stored := sink.snapshot(); len(stored) != 1 || stored[0].EndpointID != 1 {
+		t.Errorf("expected only the fresh result stored, got %+v", stored)
+	}
+	if stats := server.Stats(); len(stats) != 1 || stats[0].Results != 1 || stats[0].Stale != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestServerRejects(t *testing.T) {
+	now := time.Now()
+	valid := ResultBatch{ID: "b", AgentID: "eu-1", SentAt: now, Results: []models.MonitoringResult{{EndpointID: 1}}}
+
+	tests := []struct {
+		name          string
+		agentID       string
+		requestSecret string
+		batch         func() SignedBatch
+		want          int
+	}{
+		{"unknown agent", "xx-1", "eu-secret", func() SignedBatch {
+			signed, _ := SignBatch(valid, "eu-secret")
+			return signed
+		}, http.StatusUnauthorized},
+		{"wrong request secret", "eu-1", "us-secret", func() SignedBatch {
+			signed, _ := SignBatch(valid, "eu-secret")
+			return signed
+		}, http.StatusUnauthorized},
+		{"batch signed with another secret", "eu-1", "eu-secret", func() SignedBatch {
+			signed, _ := SignBatch(valid, "us-secret")
+			return signed
+		}, http.StatusUnauthorized},
+		{"tampered payload", "eu-1", "eu-secret", func() SignedBatch {
+			signed, _ := SignBatch(valid, "eu-secret")
+			signed.Payload = bytes.Replace(signed.Payload, []byte(`"endpoint_id":1`), []byte(`"endpoint_id":2`), 1)
+			return signed
+		}, http.StatusUnauthorized},
+		{"batch of another agent", "eu-1", "eu-secret", func() SignedBatch {
+			batch := valid
+			batch.AgentID = "us-1"
+			signed, _ := SignBatch(batch, "us-secret")
+			return signed
+		}, http.StatusUnauthorized},
+		{"stale batch", "eu-1", "eu-secret", func() SignedBatch {
+			batch := valid
+			batch.SentAt = now.Add(-time.Hour)
+			signed, _ := SignBatch(batch, "eu-secret")
+			return signed
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &resultSink{}
+			code, _ := postBatch(t, newTestServer(sink).Handler(), tt.agentID, tt.requestSecret, tt.batch(), now)
+			if code != tt.want {
+				t.Errorf("expected %d, got %d", tt.want, code)
+			}
+			if got := len(sink.snapshot()); got != 0 {
+				t.Errorf("expected nothing stored, got %d results", got)
+			}
+		})
+	}
+}
+
+func TestServerRequestSkew(t *testing.T) {
+	handler := newTestServer(&resultSink{}).Handler()
+
+	for name, tt := range map[string]struct {
+		signedAt time.Time
+		want     int
+	}{
+		"current":   {time.Now(), http.StatusOK},
+		"stale":     {time.Now().Add(-10 * time.Minute), http.StatusUnauthorized},
+		"in future": {time.Now().Add(10 * time.Minute), http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, AssignmentsPath, nil)
+		SignRequest(req, AssignmentsPath, "eu-1", "eu-secret", tt.signedAt)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", name, tt.want, rec.Code)
+		}
+	}
+
+	// A signature for one path doesn't authorize another
+	req := httptest.NewRequest(http.MethodGet, AssignmentsPath, nil)
+	SignRequest(req, ResultsPath, "eu-1", "eu-secret", time.Now())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected a signature for another path to be rejected, got %d", rec.Code)
+	}
+}
+
+func TestServerSinkFailure(t *testing.T) {
+	sink := &resultSink{err: errors.New("database down")}
+	handler := newTestServer(sink).Handler()
+	now := time.Now()
+
+	signed, _ := SignBatch(ResultBatch{ID: "b", AgentID: "eu-1", SentAt: now, Results: []models.MonitoringResult{{EndpointID: 1}}}, "eu-secret")
+	if code, _ := postBatch(t, handler, "eu-1", "eu-secret", signed, now); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", code)
+	}
+
+	// The batch's key wasn't stored, so it can be resent once storage is back
+	sink.mu.Lock()
+	sink.err = nil
+	sink.mu.Unlock()
+	if code, ack := postBatch(t, handler, "eu-1", "eu-secret", signed, now); code != http.StatusOK || ack.Accepted != 1 {
+		t.Errorf("expected the resent batch to be accepted, got %d %+v", code, ack)
+	}
+}
@@ -0,0 +1,181 @@
+package checks
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// BannerChecker connects to a mail server and reads its greeting. Servers
+// that turn clients away fail the check; Expect, when set, must appear in
+// the greeting, e.g. the server's host name.
+type BannerChecker struct {
+	method string
+
+	// ports are the default ports by URL scheme; tlsScheme speaks TLS
+	// from the start
+	ports     map[string]string
+	tlsScheme string
+
+	// greeting reads the server's greeting, returning its status code, if
+	// the protocol has them, and an error when it turns clients away
+	greeting func(r *bufio.Reader) (string, *int, error)
+
+	// quit is sent to leave politely
+	quit string
+}
+
+// SMTPChecker checks smtp:// and smtps:// endpoints. The greeting's reply
+// code becomes the result's status code.
+func SMTPChecker() *BannerChecker {
+	return &BannerChecker{
+		method:    "SMTP",
+		ports:     map[string]string{"smtp": "25", "smtps": "465"},
+		tlsScheme: "smtps",
+		greeting:  smtpGreeting,
+		quit:      "QUIT\r\n",
+	}
+}
+
+// IMAPChecker checks imap:// and imaps:// endpoints
+func IMAPChecker() *BannerChecker {
+	return &BannerChecker{
+		method:    "IMAP",
+		ports:     map[string]string{"imap": "143", "imaps": "993"},
+		tlsScheme: "imaps",
+		greeting:  imapGreeting,
+		quit:      "a1 LOGOUT\r\n",
+	}
+}
+
+// POP3Checker checks pop3:// and pop3s:// endpoints
+func POP3Checker() *BannerChecker {
+	return &BannerChecker{
+		method:    "POP3",
+		ports:     map[string]string{"pop3": "110", "pop3s": "995"},
+		tlsScheme: "pop3s",
+		greeting:  pop3Greeting,
+		quit:      "QUIT\r\n",
+	}
+}
+
+// Check checks an endpoint
+func (c *BannerChecker) Check(ctx context.Context, endpoint models.Endpoint) (models.MonitoringResult, error) {
+	result := newResult(endpoint, c.method)
+	opts, err := ParseOptions(endpoint.CheckOptions)
+	if err != nil {
+		return result, err
+	}
+	t, err := parseTarget(endpoint.URL, c.ports, c.tlsScheme)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout(endpoint))
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dial(ctx, t, t.tls || opts.TLS)
+	if err != nil {
+		result.ResponseTime = int(time.Since(start).Milliseconds())
+		return result, err
+	}
+	defer conn.Close()
+
+	banner, code, err := c.greeting(bufio.NewReader(conn))
+	result.ResponseTime = int(time.Since(start).Milliseconds())
+	conn.Write([]byte(c.quit))
+	if err != nil {
+		result.StatusCode = code
+		return result, err
+	}
+	if opts.Expect != "" && !strings.Contains(banner, opts.Expect) {
+		return result, fmt.Errorf("expected %q in greeting, got %q", opts.Expect, truncate(banner))
+	}
+
+	passed(&result, start)
+	if code != nil {
+		result.StatusCode = code
+	}
+	return result, nil
+}
+
+// readLine reads a CRLF terminated line
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if line == "" {
+			return "", fmt.Errorf("no greeting: %w", err)
+		}
+		return "", fmt.Errorf("incomplete greeting %q: %w", truncate(line), err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// smtpGreeting reads a possibly multi-line SMTP greeting like
+// "220 mx.example.com ESMTP". Anything but a 220 turns clients away.
+func smtpGreeting(r *bufio.Reader) (string, *int, error) {
+	var lines []string
+	for {
+		line, err := readLine(r)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(line) < 3 {
+			return "", nil, fmt.Errorf("invalid greeting %q", truncate(line))
+		}
+		code, err := strconv.Atoi(line[:3])
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid greeting %q", truncate(line))
+		}
+		lines = append(lines, line)
+
+		// "220-" continues the greeting on the next line
+		if len(line) > 3 && line[3] == '-' {
+			continue
+		}
+
+		banner := strings.Join(lines, "\n")
+		if code != 220 {
+			return banner, &code, fmt.Errorf("server refused connections: %s", truncate(line))
+		}
+		return banner, &code, nil
+	}
+}
+
+// imapGreeting reads an IMAP greeting: "* OK" or "* PREAUTH" welcome
+// clients, "* BYE" turns them away
+func imapGreeting(r *bufio.Reader) (string, *int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case strings.HasPrefix(line, "* OK"), strings.HasPrefix(line, "* PREAUTH"):
+		return line, nil, nil
+	case strings.HasPrefix(line, "* BYE"):
+		return line, nil, fmt.Errorf("server refused connections: %s", truncate(line))
+	}
+	return line, nil, fmt.Errorf("invalid greeting %q", truncate(line))
+}
+
+// pop3Greeting reads a POP3 greeting: "+OK" or "-ERR"
+func pop3Greeting(r *bufio.Reader) (string, *int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return "", nil, err
+	}
+	switch {
+	case strings.HasPrefix(line, "+OK"):
+		return line, nil, nil
+	case strings.HasPrefix(line, "-ERR"):
+		return line, nil, fmt.Errorf("server refused connections: %s", truncate(line))
+	}
+	return line, nil, errors.New("invalid greeting " + strconv.Quote(truncate(line)))
+}
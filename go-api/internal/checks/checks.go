@@ -0,0 +1,180 @@
+// Package checks checks endpoints over the protocols they speak: HTTP, raw
+// TCP and UDP exchanges, and the greetings of mail servers.
+package checks
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+)
+
+// Checker checks an endpoint once. The result is filled in whether or not
+// the check passed; the error says why it failed, so the caller can decide
+// whether another attempt might pass. Checks without status codes of
+// their own report 200 when they pass.
+type Checker interface {
+	Check(ctx context.Context, endpoint models.Endpoint) (models.MonitoringResult, error)
+}
+
+// Checkers returns the checker of every supported protocol
+func Checkers(log *logger.Logger) map[string]Checker {
+	httpChecker := NewHTTPChecker(log)
+	return map[string]Checker{
+		models.ProtocolHTTP:  httpChecker,
+		models.ProtocolHTTPS: httpChecker,
+		models.ProtocolTCP:   TCPChecker{},
+		models.ProtocolUDP:   UDPChecker{},
+		models.ProtocolSMTP:  SMTPChecker(),
+		models.ProtocolIMAP:  IMAPChecker(),
+		models.ProtocolPOP3:  POP3Checker(),
+	}
+}
+
+// Options tune a check; they are stored with the endpoint as JSON
+type Options struct {
+	// Send is written once connected; UDP checks need it
+	Send string `json:"send,omitempty"`
+
+	// Expect must appear in the response, or the server's greeting
+	Expect string `json:"expect,omitempty"`
+
+	// TLS connects with TLS; tls:// URLs and the implicit TLS ports of
+	// mail protocols do so without it
+	TLS bool `json:"tls,omitempty"`
+}
+
+// ParseOptions decodes an endpoint's check options
+func ParseOptions(raw json.RawMessage) (Options, error) {
+	var opts Options
+	if len(raw) == 0 || string(raw) == "null" {
+		return opts, nil
+	}
+	if err := json.Unmarshal(raw, &opts); err != nil {
+		return opts, fmt.Errorf("invalid check options: %w", err)
+	}
+	return opts, nil
+}
+
+// defaultTimeout applies to endpoints without a timeout of their own
+const defaultTimeout = 5 * time.Second
+
+// timeout returns an endpoint's check timeout
+func timeout(endpoint models.Endpoint) time.Duration {
+	if endpoint.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(endpoint.Timeout) * time.Millisecond
+}
+
+// newResult starts the result of checking endpoint; method names the
+// request, or the protocol for checks without one
+func newResult(endpoint models.Endpoint, method string) models.MonitoringResult {
+	return models.MonitoringResult{
+		EndpointID: endpoint.ID,
+		CheckedAt:  time.Now(),
+		Method:     method,
+		URL:        endpoint.URL,
+	}
+}
+
+// passed marks a result as passed after the time since start
+func passed(result *models.MonitoringResult, start time.Time) {
+	status := 200
+	result.StatusCode = &status
+	result.ResponseTime = int(time.Since(start).Milliseconds())
+}
+
+// target is the address a URL points at and whether it asks for TLS
+type target struct {
+	host string
+	addr string
+	tls  bool
+}
+
+// parseTarget reads host and port from a URL like tcp://db.example.com:5432.
+// Ports are looked up by scheme when missing; schemes in tlsSchemes ask
+// for TLS.
+func parseTarget(rawURL string, ports map[string]string, tlsSchemes ...string) (target, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return target{}, fmt.Errorf("invalid endpoint URL: %w", err)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if port == "" {
+		port = ports[u.Scheme]
+	}
+	if host == "" || port == "" {
+		return target{}, fmt.Errorf("endpoint URL %q needs a host and port", rawURL)
+	}
+
+	t := target{host: host, addr: net.JoinHostPort(host, port)}
+	for _, scheme := range tlsSchemes {
+		if u.Scheme == scheme {
+			t.tls = true
+		}
+	}
+	return t, nil
+}
+
+// dial connects to t over TCP, with TLS when useTLS is set. The
+// connection's deadline is ctx's.
+func dial(ctx context.Context, t target, useTLS bool) (net.Conn, error) {
+	var conn net.Conn
+	var err error
+	if useTLS {
+		dialer := &tls.Dialer{Config: &tls.Config{ServerName: t.host}}
+		conn, err = dialer.DialContext(ctx, "tcp", t.addr)
+	} else {
+		var dialer net.Dialer
+		conn, err = dialer.DialContext(ctx, "tcp", t.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	return conn, nil
+}
+
+// maxResponse bounds how much of a response is read looking for Expect
+const maxResponse = 64 << 10
+
+// readUntil reads from conn until expect appears in what was read
+func readUntil(conn net.Conn, expect string) error {
+	var received []byte
+	buf := make([]byte, 4096)
+	for len(received) < maxResponse {
+		n, err := conn.Read(buf)
+		received = append(received, buf[:n]...)
+		if strings.Contains(string(received), expect) {
+			return nil
+		}
+		if err != nil {
+			if len(received) == 0 {
+				return fmt.Errorf("no response: %w", err)
+			}
+			break
+		}
+	}
+	return fmt.Errorf("expected %q, got %q", expect, truncate(string(received)))
+}
+
+// truncate shortens s for error messages
+func truncate(s string) string {
+	const max = 100
+	if len(s) > max {
+		return s[:max] + "..."
+	}
+	return s
+}
@@ -0,0 +1,294 @@
+package checks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/resilience"
+)
+
+// serveTCP accepts connections on a local port and hands each to handle
+func serveTCP(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// greet writes a greeting and waits for the client to leave
+func greet(greeting string) func(conn net.Conn) {
+	return func(conn net.Conn) {
+		conn.Write([]byte(greeting))
+		bufio.NewReader(conn).ReadString('\n')
+	}
+}
+
+func endpoint(url string, options string) models.Endpoint {
+	e := models.Endpoint{ID: 1, URL: url, Timeout: 1000}
+	if options != "" {
+		e.CheckOptions = json.RawMessage(options)
+	}
+	return e
+}
+
+func TestTCPChecker(t *testing.T) {
+	addr := serveTCP(t, func(conn net.Conn) {
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		if line == "PING\r\n" {
+			conn.Write([]byte("+PONG\r\n"))
+		}
+	})
+
+	tests := []struct {
+		name    string
+		options string
+		wantErr bool
+	}{
+		{"connect", "", false},
+		{"send and expect", `{"send": "PING\r\n", "expect": "+PONG"}`, false},
+		{"unexpected response", `{"send": "PING\r\n", "expect": "+OK"}`, true},
+		{"no response", `{"send": "HELLO\r\n", "expect": "+PONG"}`, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := TCPChecker{}.Check(context.Background(), endpoint("tcp://"+addr, tt.options))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if result.Method != "TCP" || result.EndpointID != 1 {
+				t.Errorf("unexpected result %+v", result)
+			}
+			if !tt.wantErr && (result.StatusCode == nil || *result.StatusCode != http.StatusOK) {
+				t.Errorf("expected a passed check to report 200, got %v", result.StatusCode)
+			}
+		})
+	}
+}
+
+func TestTCPCheckerConnectionRefused(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	result, err := TCPChecker{}.Check(context.Background(), endpoint("tcp://"+addr, ""))
+	if err == nil {
+		t.Fatal("expected an error for a closed port")
+	}
+	if !result.Failed() {
+		t.Errorf("expected the result to fail, got %+v", result)
+	}
+
+	// Refused connections are transient and worth retrying
+	if resilience.DefaultClassifier().Classify(err).Decision != resilience.DecisionRetry {
+		t.Errorf("expected %v to be retried", err)
+	}
+}
+
+func TestUDPChecker(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo([]byte("echo "+string(buf[:n])), addr)
+		}
+	}()
+	url := "udp://" + conn.LocalAddr().String()
+
+	tests := []struct {
+		name    string
+		options string
+		wantErr string
+	}{
+		{"reply", `{"send": "ping"}`, ""},
+		{"expected reply", `{"send": "ping", "expect": "echo ping"}`, ""},
+		{"unexpected reply", `{"send": "ping", "expect": "pong"}`, "expected"},
+		{"no payload", "", "need a payload"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := UDPChecker{}.Check(context.Background(), endpoint(url, tt.options))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Method != "UDP" || result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+					t.Errorf("unexpected result %+v", result)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestBannerCheckers(t *testing.T) {
+	tests := []struct {
+		name     string
+		checker  *BannerChecker
+		scheme   string
+		greeting string
+		options  string
+		wantCode int
+		wantErr  bool
+	}{
+		{"SMTP", SMTPChecker(), "smtp", "220 mx.example.com ESMTP\r\n", "", 220, false},
+		{"SMTP multi-line", SMTPChecker(), "smtp", "220-mx.example.com ESMTP\r\n220-Welcome\r\n220 Ready\r\n", `{"expect": "Welcome"}`, 220, false},
+		{"SMTP refused", SMTPChecker(), "smtp", "554 No SMTP service here\r\n", "", 554, true},
+		{"SMTP garbage", SMTPChecker(), "smtp", "hello\r\n", "", 0, true},
+		{"IMAP", IMAPChecker(), "imap", "* OK IMAP4rev1 ready\r\n", "", 200, false},
+		{"IMAP preauth", IMAPChecker(), "imap", "* PREAUTH welcome back\r\n", "", 200, false},
+		{"IMAP refused", IMAPChecker(), "imap", "* BYE too many connections\r\n", "", 0, true},
+		{"POP3", POP3Checker(), "pop3", "+OK POP3 ready\r\n", `{"expect": "POP3"}`, 200, false},
+		{"POP3 unexpected greeting", POP3Checker(), "pop3", "+OK ready\r\n", `{"expect": "Dovecot"}`, 0, true},
+		{"POP3 refused", POP3Checker(), "pop3", "-ERR maintenance\r\n", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := serveTCP(t, greet(tt.greeting))
+			result, err := tt.checker.Check(context.Background(), endpoint(tt.scheme+"://"+addr, tt.options))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if result.Method != strings.ToUpper(tt.scheme) {
+				t.Errorf("expected method %s, got %s", strings.ToUpper(tt.scheme), result.Method)
+			}
+
+			var code int
+			if result.StatusCode != nil {
+				code = *result.StatusCode
+			}
+			if code != tt.wantCode {
+				t.Errorf("expected status %d, got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestBannerCheckerSilentServer(t *testing.T) {
+	addr := serveTCP(t, func(conn net.Conn) {})
+	if _, err := POP3Checker().Check(context.Background(), endpoint("pop3://"+addr, "")); err == nil {
+		t.Error("expected an error when the server closes without a greeting")
+	}
+}
+
+func TestHTTPChecker(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			if r.Header.Get("X-Token") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer ts.Close()
+	checker := NewHTTPChecker(logger.New())
+
+	e := endpoint(ts.URL, "")
+	e.Headers = json.RawMessage(`{"X-Token": "secret"}`)
+	result, err := checker.Check(context.Background(), e)
+	if err != nil || result.StatusCode == nil || *result.StatusCode != http.StatusOK || result.Method != http.MethodGet {
+		t.Errorf("expected 200 from a GET, got %+v, %v", result, err)
+	}
+
+	// Client errors are the result, not something to retry
+	result, err = checker.Check(context.Background(), endpoint(ts.URL+"/missing", ""))
+	if err != nil || result.StatusCode == nil || *result.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without an error, got %+v, %v", result, err)
+	}
+
+	var httpErr *resilience.HTTPError
+	result, err = checker.Check(context.Background(), endpoint(ts.URL+"/unavailable", ""))
+	if !errors.As(err, &httpErr) || result.StatusCode == nil || *result.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a retryable 503, got %+v, %v", result, err)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	ports := map[string]string{"smtp": "25", "smtps": "465"}
+
+	tests := []struct {
+		url     string
+		want    target
+		wantErr bool
+	}{
+		{"smtp://mx.example.com", target{host: "mx.example.com", addr: "mx.example.com:25"}, false},
+		{"smtps://mx.example.com", target{host: "mx.example.com", addr: "mx.example.com:465", tls: true}, false},
+		{"smtp://mx.example.com:2525", target{host: "mx.example.com", addr: "mx.example.com:2525"}, false},
+		{"smtp://[::1]:25", target{host: "::1", addr: "[::1]:25"}, false},
+		{"tcp://db.example.com", target{}, true},
+		{"tcp://:5432", target{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTarget(tt.url, ports, "smtps")
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.url, tt.wantErr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.url, tt.want, got)
+		}
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	for _, raw := range []string{"", "null"} {
+		if opts, err := ParseOptions(json.RawMessage(raw)); err != nil || opts != (Options{}) {
+			t.Errorf("%q: expected no options, got %+v, %v", raw, opts, err)
+		}
+	}
+
+	opts, err := ParseOptions(json.RawMessage(`{"send": "PING", "expect": "PONG", "tls": true}`))
+	if err != nil || opts != (Options{Send: "PING", Expect: "PONG", TLS: true}) {
+		t.Errorf("unexpected options %+v, %v", opts, err)
+	}
+
+	if _, err := ParseOptions(json.RawMessage(`{"send": 1}`)); err == nil {
+		t.Error("expected an error for invalid options")
+	}
+}
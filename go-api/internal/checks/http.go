@@ -0,0 +1,63 @@
+package checks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/resilience"
+)
+
+// HTTPChecker checks an endpoint with a GET request. Transient statuses
+// are returned as a *resilience.HTTPError, so they can be retried.
+type HTTPChecker struct {
+	log *logger.Logger
+}
+
+// NewHTTPChecker creates an HTTP checker
+func NewHTTPChecker(log *logger.Logger) *HTTPChecker {
+	return &HTTPChecker{log: log}
+}
+
+// Check checks an endpoint
+func (c *HTTPChecker) Check(ctx context.Context, endpoint models.Endpoint) (models.MonitoringResult, error) {
+	result := newResult(endpoint, http.MethodGet)
+	client := &http.Client{Timeout: timeout(endpoint)}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.URL, nil)
+	if err != nil {
+		return result, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Add headers if any
+	if len(endpoint.Headers) > 0 {
+		var headers map[string]string
+		if err := json.Unmarshal(endpoint.Headers, &headers); err != nil {
+			c.log.WithField("endpoint_id", endpoint.ID).Warnf("failed to parse headers: %v", err)
+		} else {
+			for key, value := range headers {
+				req.Header.Set(key, value)
+			}
+		}
+	}
+
+	resp, err := client.Do(req)
+	result.ResponseTime = int(time.Since(result.CheckedAt).Milliseconds())
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = &resp.StatusCode
+
+	// Transient statuses are retried, honoring Retry-After
+	httpErr := resilience.NewHTTPError(resp)
+	if resilience.HTTPClassifier().Classify(httpErr).Decision == resilience.DecisionRetry {
+		return result, httpErr
+	}
+	return result, nil
+}
@@ -0,0 +1,54 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// TCPChecker checks that a port accepts connections, e.g.
+// tcp://db.example.com:5432. With options it also sends a payload and
+// expects a reply, like PING and +PONG for Redis.
+type TCPChecker struct{}
+
+// Check checks an endpoint
+func (TCPChecker) Check(ctx context.Context, endpoint models.Endpoint) (models.MonitoringResult, error) {
+	result := newResult(endpoint, "TCP")
+	opts, err := ParseOptions(endpoint.CheckOptions)
+	if err != nil {
+		return result, err
+	}
+	t, err := parseTarget(endpoint.URL, nil, "tls")
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout(endpoint))
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dial(ctx, t, t.tls || opts.TLS)
+	if err != nil {
+		result.ResponseTime = int(time.Since(start).Milliseconds())
+		return result, err
+	}
+	defer conn.Close()
+
+	if opts.Send != "" {
+		if _, err := conn.Write([]byte(opts.Send)); err != nil {
+			result.ResponseTime = int(time.Since(start).Milliseconds())
+			return result, fmt.Errorf("failed to send: %w", err)
+		}
+	}
+	if opts.Expect != "" {
+		if err := readUntil(conn, opts.Expect); err != nil {
+			result.ResponseTime = int(time.Since(start).Milliseconds())
+			return result, err
+		}
+	}
+
+	passed(&result, start)
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// UDPChecker sends a datagram and waits for a reply, e.g.
+// udp://ntp.example.com:123. UDP has no connections, so the payload to
+// send is required; a reply of any content passes unless Expect is set.
+type UDPChecker struct{}
+
+// Check checks an endpoint
+func (UDPChecker) Check(ctx context.Context, endpoint models.Endpoint) (models.MonitoringResult, error) {
+	result := newResult(endpoint, "UDP")
+	opts, err := ParseOptions(endpoint.CheckOptions)
+	if err != nil {
+		return result, err
+	}
+	if opts.Send == "" {
+		return result, errors.New("UDP checks need a payload to send")
+	}
+	t, err := parseTarget(endpoint.URL, nil)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout(endpoint))
+	defer cancel()
+
+	start := time.Now()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", t.addr)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write([]byte(opts.Send)); err != nil {
+		result.ResponseTime = int(time.Since(start).Milliseconds())
+		return result, fmt.Errorf("failed to send: %w", err)
+	}
+
+	buf := make([]byte, maxResponse)
+	n, err := conn.Read(buf)
+	result.ResponseTime = int(time.Since(start).Milliseconds())
+	if err != nil {
+		return result, fmt.Errorf("no response: %w", err)
+	}
+	if reply := string(buf[:n]); opts.Expect != "" && !strings.Contains(reply, opts.Expect) {
+		return result, fmt.Errorf("expected %q, got %q", opts.Expect, truncate(reply))
+	}
+
+	passed(&result, start)
+	return result, nil
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,9 +30,95 @@ type Config struct {
 	MonitoringTimeout time.Duration
 	HTTPClientTimeout time.Duration
 
+	// Result persistence batching
+	ResultBatchSize   int
+	ResultBatchMaxAge time.Duration
+	ResultQueueSize   int
+
+	// Location the API's own checks are made from. An endpoint is down
+	// when ConsensusQuorum locations fail within ConsensusWindow.
+	MonitoringLocation string
+	ConsensusQuorum    int
+	ConsensusWindow    time.Duration
+
+	// Retention and rollups
+	RetentionEnabled    bool
+	RetentionInterval   time.Duration
+	RetentionFree       time.Duration
+	RetentionPro        time.Duration
+	RetentionEnterprise time.Duration
+	MetricsRetention    time.Duration
+	RetentionLateness   time.Duration
+
+	// Metrics collection
+	MetricsEmbedded       bool
+	MetricsInterval       time.Duration
+	MetricsTimeout        time.Duration
+	MetricsJitter         float64
+	MetricsCollectorsFile string
+	SystemMetricsStream   string
+	StripeSecretKey       string
+	StripeWebhookSecret   string
+
+	// Admin API; disabled when empty
+	AdminToken string
+
 	// Redis Streams
 	MetricsStream string
 	AlertsStream  string
+
+	// Approximate stream trimming on XADD; StreamMaxAge takes precedence
+	// over StreamMaxLen when both are set
+	StreamMaxLen int64
+	StreamMaxAge time.Duration
+
+	// Stream event envelope encoding (json or protobuf); EventLegacyFields
+	// keeps writing the pre-envelope fields while consumers migrate
+	EventEncoding     string
+	EventLegacyFields bool
+
+	// Result fan-out outbox relay
+	OutboxPollInterval time.Duration
+	OutboxMaxAttempts  int
+	OutboxRetention    time.Duration
+
+	// Rate limiting backend (redis or memory) and algorithm (token_bucket
+	// or sliding_window); the redis backend limits locally while Redis is
+	// unreachable
+	RateLimitBackend   string
+	RateLimitAlgorithm string
+
+	// Rate limit policies (JSON); the built-in ones apply when empty.
+	// Plans are looked up per user and cached for RateLimitPlanCacheTTL.
+	RateLimitPoliciesFile string
+	RateLimitPlanCacheTTL time.Duration
+
+	// Public key of the Symfony API's JWTs; callers are identified by IP
+	// only when empty
+	JWTPublicKey string
+
+	// Forwarding headers are only believed from TrustedProxies (CIDRs);
+	// nil trusts loopback and private networks. IPv6 clients are counted
+	// per ClientIPv6Prefix network.
+	TrustedProxies   []string
+	ClientIPv6Prefix int
+
+	// CIDRs allowed and denied on the API and, separately, the WebSocket
+	// endpoint; an empty allowlist allows everyone not denied
+	APIAllowCIDRs       []string
+	APIDenyCIDRs        []string
+	WebSocketAllowCIDRs []string
+	WebSocketDenyCIDRs  []string
+
+	// Check agents allowed to push results, as "id=secret" pairs; the
+	// agent endpoints are disabled when empty. Agents check their
+	// endpoints every AgentCheckInterval.
+	AgentSecrets       []string
+	AgentCheckInterval time.Duration
+
+	// AgentMaxResultAge is how long agents may buffer results; older ones
+	// are dropped. RetentionLateness is raised to cover it.
+	AgentMaxResultAge time.Duration
 }
 
 // Load loads configuration from environment variables with defaults
@@ -46,8 +133,51 @@ func Load() *Config {
 		FrontendURL:           getEnv("FRONTEND_URL", "http://localhost"),
 		MonitoringTimeout:     getEnvDuration("MONITORING_TIMEOUT", 30*time.Second),
 		HTTPClientTimeout:     getEnvDuration("HTTP_CLIENT_TIMEOUT", 30*time.Second),
+		ResultBatchSize:       getEnvInt("RESULT_BATCH_SIZE", 500),
+		ResultBatchMaxAge:     getEnvDuration("RESULT_BATCH_MAX_AGE", time.Second),
+		ResultQueueSize:       getEnvInt("RESULT_QUEUE_SIZE", 5000),
+		MonitoringLocation:    getEnv("MONITORING_LOCATION", "central"),
+		ConsensusQuorum:       getEnvInt("CONSENSUS_QUORUM", 2),
+		ConsensusWindow:       getEnvDuration("CONSENSUS_WINDOW", 5*time.Minute),
+		RetentionEnabled:      getEnvBool("RETENTION_ENABLED", true),
+		RetentionInterval:     getEnvDuration("RETENTION_INTERVAL", time.Minute),
+		RetentionFree:         getEnvDuration("RETENTION_FREE", 7*24*time.Hour),
+		RetentionPro:          getEnvDuration("RETENTION_PRO", 30*24*time.Hour),
+		RetentionEnterprise:   getEnvDuration("RETENTION_ENTERPRISE", 90*24*time.Hour),
+		MetricsRetention:      getEnvDuration("METRICS_RETENTION", 7*24*time.Hour),
+		RetentionLateness:     getEnvDuration("RETENTION_LATENESS", 20*time.Minute),
+		MetricsEmbedded:       getEnvBool("METRICS_EMBEDDED", false),
+		MetricsInterval:       getEnvDuration("METRICS_INTERVAL", 5*time.Minute),
+		MetricsTimeout:        getEnvDuration("METRICS_TIMEOUT", 30*time.Second),
+		MetricsJitter:         getEnvFloat("METRICS_JITTER", 0.1),
+		MetricsCollectorsFile: getEnv("METRICS_COLLECTORS_FILE", ""),
+		SystemMetricsStream:   getEnv("SYSTEM_METRICS_STREAM", "metrics:stream"),
+		StripeSecretKey:       getEnv("STRIPE_SECRET_KEY", ""),
+		StripeWebhookSecret:   getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		AdminToken:            getEnv("ADMIN_TOKEN", ""),
 		MetricsStream:         "api-metrics",
 		AlertsStream:          "alerts-fired",
+		StreamMaxLen:          int64(getEnvInt("STREAM_MAXLEN", 100000)),
+		StreamMaxAge:          getEnvDuration("STREAM_MAX_AGE", 0),
+		EventEncoding:         getEnv("EVENT_ENCODING", "json"),
+		EventLegacyFields:     getEnvBool("EVENT_LEGACY_FIELDS", true),
+		OutboxPollInterval:    getEnvDuration("OUTBOX_POLL_INTERVAL", time.Second),
+		OutboxMaxAttempts:     getEnvInt("OUTBOX_MAX_ATTEMPTS", 10),
+		OutboxRetention:       getEnvDuration("OUTBOX_RETENTION", 24*time.Hour),
+		RateLimitBackend:      getEnv("RATE_LIMIT_BACKEND", "redis"),
+		RateLimitAlgorithm:    getEnv("RATE_LIMIT_ALGORITHM", "token_bucket"),
+		RateLimitPoliciesFile: getEnv("RATE_LIMIT_POLICIES_FILE", ""),
+		RateLimitPlanCacheTTL: getEnvDuration("RATE_LIMIT_PLAN_CACHE_TTL", time.Minute),
+		JWTPublicKey:          getEnv("JWT_PUBLIC_KEY", ""),
+		TrustedProxies:        getEnvList("TRUSTED_PROXIES", nil),
+		ClientIPv6Prefix:      getEnvInt("CLIENT_IPV6_PREFIX", 64),
+		APIAllowCIDRs:         getEnvList("API_ALLOW_CIDRS", nil),
+		APIDenyCIDRs:          getEnvList("API_DENY_CIDRS", nil),
+		WebSocketAllowCIDRs:   getEnvList("WS_ALLOW_CIDRS", nil),
+		WebSocketDenyCIDRs:    getEnvList("WS_DENY_CIDRS", nil),
+		AgentSecrets:          getEnvList("AGENT_SECRETS", nil),
+		AgentCheckInterval:    getEnvDuration("AGENT_CHECK_INTERVAL", time.Minute),
+		AgentMaxResultAge:     getEnvDuration("AGENT_MAX_RESULT_AGE", 10*time.Minute),
 	}
 }
 
@@ -68,6 +198,15 @@ func getEnvInt(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func getEnvFloat(key string, defaultVal float64) float64 {
+	if value, exists := os.LookupEnv(key); exists {
+		if floatVal, err := strconv.ParseFloat(value, 64); err == nil {
+			return floatVal
+		}
+	}
+	return defaultVal
+}
+
 func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
 	if value, exists := os.LookupEnv(key); exists {
 		if duration, err := time.ParseDuration(value); err == nil {
@@ -76,3 +215,29 @@ func getEnvDuration(key string, defaultVal time.Duration) time.Duration {
 	}
 	return defaultVal
 }
+
+func getEnvBool(key string, defaultVal bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolVal, err := strconv.ParseBool(value); err == nil {
+			return boolVal
+		}
+	}
+	return defaultVal
+}
+
+// getEnvList splits a comma-separated variable; a set but empty variable
+// is an empty, non-nil list
+func getEnvList(key string, defaultVal []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultVal
+	}
+
+	list := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,77 @@
+// Package consensus decides an endpoint's status from the checks of
+// several locations, so one flaky vantage point can't take it down.
+package consensus
+
+import (
+	"sort"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// Config holds consensus configuration
+type Config struct {
+	// Quorum is how many locations must fail for an endpoint to be down.
+	// With fewer locations reporting, all of them must fail.
+	Quorum int
+
+	// Window is how recent a location's latest check must be to count
+	Window time.Duration
+}
+
+// DefaultConfig returns default consensus configuration
+func DefaultConfig() Config {
+	return Config{
+		Quorum: 2,
+		Window: 5 * time.Minute,
+	}
+}
+
+// Evaluate decides an endpoint's status from the latest check of each
+// location. The endpoint is down when a quorum of the locations that
+// reported within the window fail, degraded when fewer fail, and unknown
+// when none reported.
+func Evaluate(config Config, endpointID int, checks []models.LocationCheck, now time.Time) models.EndpointStatus {
+	status := models.EndpointStatus{
+		EndpointID:       endpointID,
+		Status:           models.StatusUnknown,
+		FailingLocations: []string{},
+		EvaluatedAt:      now,
+	}
+
+	for _, check := range checks {
+		if now.Sub(check.CheckedAt) > config.Window {
+			continue
+		}
+		status.Locations++
+		if check.Failed {
+			status.Failing++
+			status.FailingLocations = append(status.FailingLocations, check.Location)
+		}
+	}
+	sort.Strings(status.FailingLocations)
+
+	quorum := config.Quorum
+	if quorum > status.Locations {
+		quorum = status.Locations
+	}
+	switch {
+	case status.Locations == 0:
+	case status.Failing >= quorum:
+		status.Status = models.StatusDown
+	case status.Failing > 0:
+		status.Status = models.StatusDegraded
+	default:
+		status.Status = models.StatusUp
+	}
+	return status
+}
+
+// Notify reports whether a change of status is worth telling anyone
+// about. An endpoint seen for the first time coming up is not.
+func Notify(previous, current string) bool {
+	if previous == current {
+		return false
+	}
+	return !(previous == models.StatusUnknown && current == models.StatusUp)
+}
@@ -0,0 +1,76 @@
+package consensus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	check := func(location string, failed bool, age time.Duration) models.LocationCheck {
+		return models.LocationCheck{Location: location, Failed: failed, CheckedAt: now.Add(-age)}
+	}
+	config := Config{Quorum: 2, Window: 5 * time.Minute}
+
+	tests := []struct {
+		name    string
+		checks  []models.LocationCheck
+		status  string
+		failing []string
+		total   int
+	}{
+		{"no checks", nil, models.StatusUnknown, []string{}, 0},
+		{"all up", []models.LocationCheck{check("eu", false, 0), check("us", false, 0), check("ap", false, 0)},
+			models.StatusUp, []string{}, 3},
+		{"one flaky location", []models.LocationCheck{check("eu", true, 0), check("us", false, 0), check("ap", false, 0)},
+			models.StatusDegraded, []string{"eu"}, 3},
+		{"quorum fails", []models.LocationCheck{check("us", true, 0), check("eu", true, time.Minute), check("ap", false, 0)},
+			models.StatusDown, []string{"eu", "us"}, 3},
+		{"stale failures don't count", []models.LocationCheck{check("eu", true, 10*time.Minute), check("us", true, 0), check("ap", false, 0)},
+			models.StatusDegraded, []string{"us"}, 2},
+		{"single location fails", []models.LocationCheck{check("central", true, 0)},
+			models.StatusDown, []string{"central"}, 1},
+		{"only stale checks", []models.LocationCheck{check("eu", true, time.Hour)},
+			models.StatusUnknown, []string{}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status := Evaluate(config, 7, tt.checks, now)
+			if status.Status != tt.status {
+				t.Errorf("expected %s, got %s", tt.status, status.Status)
+			}
+			if !reflect.DeepEqual(status.FailingLocations, tt.failing) {
+				t.Errorf("expected failing locations %v, got %v", tt.failing, status.FailingLocations)
+			}
+			if status.Locations != tt.total || status.Failing != len(tt.failing) {
+				t.Errorf("expected %d of %d failing, got %d of %d", len(tt.failing), tt.total, status.Failing, status.Locations)
+			}
+			if status.EndpointID != 7 || !status.EvaluatedAt.Equal(now) {
+				t.Errorf("unexpected status %+v", status)
+			}
+		})
+	}
+}
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		previous, current string
+		want              bool
+	}{
+		{models.StatusUp, models.StatusUp, false},
+		{models.StatusUnknown, models.StatusUp, false},
+		{models.StatusUnknown, models.StatusDown, true},
+		{models.StatusUp, models.StatusDegraded, true},
+		{models.StatusDegraded, models.StatusDown, true},
+		{models.StatusDown, models.StatusUp, true},
+	}
+	for _, tt := range tests {
+		if got := Notify(tt.previous, tt.current); got != tt.want {
+			t.Errorf("%s -> %s: expected %v, got %v", tt.previous, tt.current, tt.want, got)
+		}
+	}
+}
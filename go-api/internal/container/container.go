@@ -3,28 +3,51 @@ package container
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"api-monitor-go/internal/agents"
 	"api-monitor-go/internal/config"
+	"api-monitor-go/internal/consensus"
 	"api-monitor-go/internal/database"
+	"api-monitor-go/internal/events"
 	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/metrics"
 	"api-monitor-go/internal/middleware"
+	"api-monitor-go/internal/models"
 	"api-monitor-go/internal/monitoring"
+	"api-monitor-go/internal/outbox"
+	"api-monitor-go/internal/resilience"
+	"api-monitor-go/internal/retention"
+	"api-monitor-go/internal/streams"
 	"api-monitor-go/internal/websocket"
 	"github.com/redis/go-redis/v9"
 )
 
+// resultWriteSlack covers batched result writes retrying through a short
+// database outage before they are committed
+const resultWriteSlack = 5 * time.Minute
+
 // Container holds all application dependencies
 type Container struct {
-	config      *config.Config
-	db          *database.DB
-	redis       *redis.Client
-	repo        *database.Repository
-	wsHub       *websocket.Hub
-	monitorSvc  *monitoring.Service
-	rateLimiter *middleware.RateLimiter
-	logger      *logger.Logger
-	shutdownFns []func(context.Context) error
+	config           *config.Config
+	db               *database.DB
+	redis            *redis.Client
+	repo             *database.Repository
+	wsHub            *websocket.Hub
+	monitorSvc       *monitoring.Service
+	retention        *retention.Service
+	metricsStore     metrics.MetricsStore
+	metricsPublisher metrics.MetricsPublisher
+	metricsWorker    *metrics.Worker
+	rateLimits       *middleware.RateLimits
+	authenticators   []middleware.Authenticator
+	clientIP         *middleware.ClientIPResolver
+	apiFilter        *middleware.IPFilter
+	wsFilter         *middleware.IPFilter
+	agents           *agents.Server
+	logger           *logger.Logger
+	shutdownFns      []func(context.Context) error
 }
 
 // NewContainer initializes all dependencies in correct order
@@ -62,11 +85,29 @@ func NewContainer() (*Container, error) {
 		return nil, fmt.Errorf("monitoring service initialization failed: %w", err)
 	}
 
+	// Initialize retention
+	c.initRetention()
+
+	// Initialize the check agent endpoints
+	if err := c.initAgents(); err != nil {
+		return nil, fmt.Errorf("agents initialization failed: %w", err)
+	}
+
+	// Initialize client IP resolution and filtering
+	if err := c.initClientIP(); err != nil {
+		return nil, fmt.Errorf("client IP initialization failed: %w", err)
+	}
+
 	// Initialize rate limiter
 	if err := c.initRateLimiter(); err != nil {
 		return nil, fmt.Errorf("rate limiter initialization failed: %w", err)
 	}
 
+	// Initialize metrics collection
+	if err := c.initMetrics(); err != nil {
+		return nil, fmt.Errorf("metrics initialization failed: %w", err)
+	}
+
 	c.logger.Info("container initialization completed successfully")
 	return c, nil
 }
@@ -98,6 +139,21 @@ func (c *Container) initDatabase() error {
 	c.db = db
 	c.logger.Info("database connected successfully")
 
+	// Refuse to start against a schema this build doesn't understand
+	migrator, err := database.NewMigrator(c.db.Postgres)
+	if err != nil {
+		c.db.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := migrator.CheckVersion(ctx); err != nil {
+		c.db.Close()
+		return err
+	}
+
 	c.shutdownFns = append(c.shutdownFns, func(ctx context.Context) error {
 		return c.db.Close()
 	})
@@ -145,28 +201,251 @@ func (c *Container) initWebSocketHub() {
 
 // initMonitoringService initializes the monitoring service
 func (c *Container) initMonitoringService() error {
+	eventConfig, err := events.StreamConfigFromConfig(c.config)
+	if err != nil {
+		return err
+	}
+
+	relayConfig := outbox.DefaultRelayConfig()
+	relayConfig.PollInterval = c.config.OutboxPollInterval
+	relayConfig.MaxAttempts = c.config.OutboxMaxAttempts
+	relayConfig.Retention = c.config.OutboxRetention
+
 	svc := monitoring.NewService(
 		c.repo,
 		c.wsHub,
 		c.redis,
 		c.config.SymfonyAPIURL,
 		c.config.HTTPClientTimeout,
+		database.BatcherConfig{
+			Name:         "monitoring_results",
+			MaxBatchSize: c.config.ResultBatchSize,
+			MaxBatchAge:  c.config.ResultBatchMaxAge,
+			QueueSize:    c.config.ResultQueueSize,
+		},
+		streams.TrimFromConfig(c.config),
+		eventConfig,
+		outbox.NewPostgresStore(c.db.Postgres),
+		relayConfig,
+		c.config.MonitoringLocation,
+		consensus.Config{Quorum: c.config.ConsensusQuorum, Window: c.config.ConsensusWindow},
 	)
 
 	c.monitorSvc = svc
-	c.logger.Info("monitoring service initialized")
+	c.logger.Infof("monitoring service initialized, streams trimmed by %s, events encoded as %s (legacy fields: %v)",
+		streams.TrimFromConfig(c.config), eventConfig.Encoding, eventConfig.LegacyFields)
+
+	// Flush queued results and stop the outbox relay before the database
+	// connection is closed
+	c.shutdownFns = append(c.shutdownFns, func(ctx context.Context) error {
+		return c.monitorSvc.Close(ctx)
+	})
 
 	return nil
 }
 
-// initRateLimiter initializes the rate limiter middleware
+// initRetention initializes background rollups and pruning
+func (c *Container) initRetention() {
+	cfg := retention.DefaultConfig()
+	cfg.Interval = c.config.RetentionInterval
+	cfg.PlanRetention = map[string]time.Duration{
+		"free":       c.config.RetentionFree,
+		"pro":        c.config.RetentionPro,
+		"enterprise": c.config.RetentionEnterprise,
+	}
+	cfg.MetricsRetention = c.config.MetricsRetention
+	cfg.Lateness = c.config.RetentionLateness
+
+	// Buckets must stay open for as long as agent results can arrive late
+	if len(c.config.AgentSecrets) > 0 {
+		required := c.config.AgentMaxResultAge + agents.DefaultServerConfig().MaxSkew + resultWriteSlack
+		if cfg.Lateness < required {
+			c.logger.Warnf("RETENTION_LATENESS %s is shorter than the %s agent results can be late, using %s",
+				cfg.Lateness, required, required)
+			cfg.Lateness = required
+		}
+	}
+
+	c.retention = retention.NewService(c.db.Postgres, cfg)
+	if !c.config.RetentionEnabled {
+		c.logger.Info("retention disabled")
+		return
+	}
+
+	c.retention.Start()
+	c.logger.Info("retention service started")
+
+	c.shutdownFns = append(c.shutdownFns, func(ctx context.Context) error {
+		return c.retention.Stop(ctx)
+	})
+}
+
+// initMetrics initializes the metrics store and publisher and, when
+// running embedded, the collection worker
+func (c *Container) initMetrics() error {
+	store := metrics.NewPostgresMetricsStore(c.db.Postgres)
+	c.metricsStore = store
+
+	// Flush queued metrics before the database connection is closed
+	c.shutdownFns = append(c.shutdownFns, func(ctx context.Context) error {
+		return store.Close(ctx)
+	})
+
+	publisherConfig, err := metrics.PublisherConfigFromConfig(c.config, "go-api")
+	if err != nil {
+		return err
+	}
+	c.metricsPublisher = metrics.NewRedisMetricsPublisherWithConfig(c.redis, publisherConfig)
+
+	if !c.config.MetricsEmbedded {
+		c.logger.Info("metrics collection runs in the standalone worker")
+		return nil
+	}
+
+	worker, err := metrics.NewWorkerFromConfig(c.db, c.redis, c.config)
+	if err != nil {
+		return err
+	}
+
+	// Report the endpoint checker's circuit breaker alongside the
+	// collectors' own
+	breakers := metrics.NewCircuitBreakerCollector(func() []resilience.CircuitBreakerStats {
+		return []resilience.CircuitBreakerStats{c.monitorSvc.CircuitBreakerStats()}
+	})
+	if err := worker.AddCollector(breakers); err != nil {
+		return err
+	}
+	if err := worker.AddCollector(metrics.NewRateLimitCollector(c.rateLimits.Stats)); err != nil {
+		return err
+	}
+
+	c.metricsWorker = worker
+	c.metricsWorker.Start()
+	c.logger.Info("embedded metrics worker started")
+
+	c.shutdownFns = append(c.shutdownFns, func(ctx context.Context) error {
+		return c.metricsWorker.Stop(ctx)
+	})
+
+	return nil
+}
+
+// initClientIP initializes client IP resolution and the API and WebSocket
+// IP filters
+func (c *Container) initClientIP() error {
+	cfg := middleware.DefaultClientIPConfig()
+	if c.config.TrustedProxies != nil {
+		cfg.TrustedProxies = c.config.TrustedProxies
+	}
+	cfg.IPv6Prefix = c.config.ClientIPv6Prefix
+
+	resolver, err := middleware.NewClientIPResolver(cfg)
+	if err != nil {
+		return err
+	}
+
+	apiFilter, err := middleware.NewIPFilter(middleware.IPFilterConfig{
+		Allow: c.config.APIAllowCIDRs,
+		Deny:  c.config.APIDenyCIDRs,
+	}, resolver)
+	if err != nil {
+		return fmt.Errorf("API filter: %w", err)
+	}
+
+	wsFilter, err := middleware.NewIPFilter(middleware.IPFilterConfig{
+		Allow: c.config.WebSocketAllowCIDRs,
+		Deny:  c.config.WebSocketDenyCIDRs,
+	}, resolver)
+	if err != nil {
+		return fmt.Errorf("WebSocket filter: %w", err)
+	}
+
+	c.clientIP = resolver
+	c.apiFilter = apiFilter
+	c.wsFilter = wsFilter
+	c.logger.Infof("client IP resolution initialized, trusting proxies in %v", cfg.TrustedProxies)
+	return nil
+}
+
+// initAgents initializes the server check agents pull assignments from
+// and push results to; results join the API's own in the monitoring
+// service
+func (c *Container) initAgents() error {
+	if len(c.config.AgentSecrets) == 0 {
+		c.logger.Info("AGENT_SECRETS not set, check agents disabled")
+		return nil
+	}
+
+	cfg := agents.DefaultServerConfig()
+	cfg.Secrets = make(map[string]string, len(c.config.AgentSecrets))
+	for _, pair := range c.config.AgentSecrets {
+		id, secret, ok := strings.Cut(pair, "=")
+		if !ok || id == "" || secret == "" {
+			return fmt.Errorf("invalid agent secret %q, expected id=secret", pair)
+		}
+		cfg.Secrets[id] = secret
+	}
+	cfg.CheckInterval = c.config.AgentCheckInterval
+	cfg.MaxResultAge = c.config.AgentMaxResultAge
+
+	c.agents = agents.NewServer(cfg, c.repo, func(ctx context.Context, key agents.BatchKey, results []models.MonitoringResult) (bool, error) {
+		return c.monitorSvc.IngestBatch(ctx, key.AgentID, key.BatchID, key.Expired, results)
+	})
+	c.logger.Infof("check agents initialized, %d agents allowed", len(cfg.Secrets))
+	return nil
+}
+
+// initRateLimiter initializes caller identification and the rate limit
+// policies
 func (c *Container) initRateLimiter() error {
-	limiter := middleware.NewRateLimiter(10, 10, 5*time.Minute)
-	c.rateLimiter = limiter
-	c.logger.Info("rate limiter initialized")
+	if c.config.JWTPublicKey != "" {
+		jwt, err := middleware.LoadJWTAuthenticator(c.config.JWTPublicKey, "username")
+		if err != nil {
+			return err
+		}
+		c.authenticators = append(c.authenticators, jwt)
+	} else {
+		c.logger.Info("JWT_PUBLIC_KEY not set, rate limiting by IP only")
+	}
+
+	policies := middleware.DefaultRateLimitPolicies()
+	if c.config.RateLimitPoliciesFile != "" {
+		var err error
+		if policies, err = middleware.LoadRateLimitPolicies(c.config.RateLimitPoliciesFile); err != nil {
+			return err
+		}
+	}
+	for i := range policies {
+		if policies[i].Algorithm == "" {
+			policies[i].Algorithm = middleware.Algorithm(c.config.RateLimitAlgorithm)
+		}
+	}
+
+	var newLimiter middleware.LimiterFactory
+	switch c.config.RateLimitBackend {
+	case "memory":
+		newLimiter = middleware.MemoryLimiters
+	case "redis":
+		// Each limiter falls back to limiting locally while Redis is
+		// unreachable
+		newLimiter = middleware.RedisLimiters(c.redis, middleware.DefaultRedisLimiterConfig())
+	default:
+		return fmt.Errorf("unknown rate limit backend %q", c.config.RateLimitBackend)
+	}
+
+	plans := middleware.NewCachedPlanResolver(middleware.NewPostgresPlanStore(c.db.Postgres), c.config.RateLimitPlanCacheTTL)
+	rateLimits, err := middleware.NewRateLimits(policies, plans, newLimiter)
+	if err != nil {
+		return err
+	}
+
+	rateLimits.ClientIP = c.clientIP.Key
+
+	c.rateLimits = rateLimits
+	c.logger.Infof("rate limiter initialized, %d policies on the %s backend", len(policies), c.config.RateLimitBackend)
 
 	c.shutdownFns = append(c.shutdownFns, func(ctx context.Context) error {
-		c.rateLimiter.Close()
+		c.rateLimits.Close()
 		return nil
 	})
 
@@ -199,8 +478,51 @@ func (c *Container) MonitoringService() *monitoring.Service {
 	return c.monitorSvc
 }
 
-func (c *Container) RateLimiter() *middleware.RateLimiter {
-	return c.rateLimiter
+func (c *Container) Retention() *retention.Service {
+	return c.retention
+}
+
+func (c *Container) MetricsStore() metrics.MetricsStore {
+	return c.metricsStore
+}
+
+func (c *Container) MetricsPublisher() metrics.MetricsPublisher {
+	return c.metricsPublisher
+}
+
+// MetricsWorker returns the embedded metrics worker, or nil when metrics
+// are collected by the standalone worker
+func (c *Container) MetricsWorker() *metrics.Worker {
+	return c.metricsWorker
+}
+
+func (c *Container) RateLimits() *middleware.RateLimits {
+	return c.rateLimits
+}
+
+// ClientIP returns the resolver of client addresses behind trusted proxies
+func (c *Container) ClientIP() *middleware.ClientIPResolver {
+	return c.clientIP
+}
+
+// APIFilter returns the IP filter of the API endpoints
+func (c *Container) APIFilter() *middleware.IPFilter {
+	return c.apiFilter
+}
+
+// WebSocketFilter returns the IP filter of the WebSocket endpoint
+func (c *Container) WebSocketFilter() *middleware.IPFilter {
+	return c.wsFilter
+}
+
+// Agents returns the check agent server, or nil when agents are disabled
+func (c *Container) Agents() *agents.Server {
+	return c.agents
+}
+
+// Authenticators return the ways callers are identified, in order
+func (c *Container) Authenticators() []middleware.Authenticator {
+	return c.authenticators
 }
 
 func (c *Container) Logger() *logger.Logger {
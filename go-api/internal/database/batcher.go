@@ -0,0 +1,265 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBatcherClosed is returned by Add once the batcher has been closed
+var ErrBatcherClosed = errors.New("batcher is closed")
+
+// BatcherConfig holds write-behind batcher configuration
+type BatcherConfig struct {
+	Name         string
+	MaxBatchSize int           // flush as soon as this many items are pending
+	MaxBatchAge  time.Duration // flush when the oldest pending item is this old
+	QueueSize    int           // items buffered before Add blocks the caller
+	FlushTimeout time.Duration // upper bound for a single flush
+	MaxRetries   int           // retries of a failed flush before its items are dropped; negative disables
+	RetryBackoff time.Duration // wait before the first retry, doubled for each further one
+}
+
+// maxRetryBackoff caps the wait between flush retries
+const maxRetryBackoff = 30 * time.Second
+
+// DefaultBatcherConfig returns default batcher configuration
+func DefaultBatcherConfig() BatcherConfig {
+	return BatcherConfig{
+		MaxBatchSize: 500,
+		MaxBatchAge:  time.Second,
+		QueueSize:    5000,
+		FlushTimeout: 30 * time.Second,
+		MaxRetries:   5,
+		RetryBackoff: 500 * time.Millisecond,
+	}
+}
+
+// BatcherStats is a snapshot of batcher activity
+type BatcherStats struct {
+	Name               string        `json:"name"`
+	Queued             int           `json:"queued"`
+	Batches            int64         `json:"batches"`
+	Items              int64         `json:"items"`
+	FailedBatches      int64         `json:"failed_batches"`
+	FlushRetries       int64         `json:"flush_retries"`
+	DroppedItems       int64         `json:"dropped_items"`
+	LastBatchSize      int           `json:"last_batch_size"`
+	MaxBatchSize       int           `json:"max_batch_size"`
+	LastFlushDuration  time.Duration `json:"last_flush_duration"`
+	MaxFlushDuration   time.Duration `json:"max_flush_duration"`
+	TotalFlushDuration time.Duration `json:"total_flush_duration"`
+	LastFlushAt        time.Time     `json:"last_flush_at"`
+	LastError          string        `json:"last_error,omitempty"`
+}
+
+// Batcher buffers items and hands them to a flush function in batches.
+// A single goroutine flushes, so when the flush function is slow the queue
+// fills up and Add blocks, pushing back on producers. A failed flush is
+// retried with backoff, so a short database outage only delays items; they
+// are dropped once the retries run out.
+type Batcher[T any] struct {
+	config BatcherConfig
+	flush  func(context.Context, []T) error
+	items  chan T
+
+	mu     sync.RWMutex
+	closed bool
+	quit   chan struct{}
+	done   chan struct{}
+
+	statsMu sync.Mutex
+	stats   BatcherStats
+}
+
+// NewBatcher creates a batcher and starts its flush loop
+func NewBatcher[T any](config BatcherConfig, flush func(context.Context, []T) error) *Batcher[T] {
+	defaults := DefaultBatcherConfig()
+	if config.MaxBatchSize <= 0 {
+		config.MaxBatchSize = defaults.MaxBatchSize
+	}
+	if config.MaxBatchAge <= 0 {
+		config.MaxBatchAge = defaults.MaxBatchAge
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = config.MaxBatchSize * 10
+	}
+	if config.FlushTimeout <= 0 {
+		config.FlushTimeout = defaults.FlushTimeout
+	}
+	if config.MaxRetries == 0 {
+		config.MaxRetries = defaults.MaxRetries
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = defaults.RetryBackoff
+	}
+
+	b := &Batcher[T]{
+		config: config,
+		flush:  flush,
+		items:  make(chan T, config.QueueSize),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+		stats:  BatcherStats{Name: config.Name},
+	}
+
+	go b.run()
+
+	return b
+}
+
+// Add queues an item for the next batch. It blocks while the queue is full
+// and returns early if ctx is cancelled or the batcher is closed.
+func (b *Batcher[T]) Add(ctx context.Context, item T) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if b.closed {
+		return ErrBatcherClosed
+	}
+
+	select {
+	case b.items <- item:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting items and flushes everything still queued. It
+// waits for the final flush until ctx is done.
+func (b *Batcher[T]) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.quit)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns a snapshot of the batcher statistics
+func (b *Batcher[T]) Stats() BatcherStats {
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	stats := b.stats
+	stats.Queued = len(b.items)
+	return stats
+}
+
+// run collects items into batches and flushes them on size or age
+func (b *Batcher[T]) run() {
+	defer close(b.done)
+
+	batch := make([]T, 0, b.config.MaxBatchSize)
+	var timer *time.Timer
+	var timerC <-chan time.Time
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer, timerC = nil, nil
+		}
+		if len(batch) == 0 {
+			return
+		}
+		b.flushBatch(batch)
+		batch = make([]T, 0, b.config.MaxBatchSize)
+	}
+
+	for {
+		select {
+		case item := <-b.items:
+			if len(batch) == 0 {
+				timer = time.NewTimer(b.config.MaxBatchAge)
+				timerC = timer.C
+			}
+			batch = append(batch, item)
+			if len(batch) >= b.config.MaxBatchSize {
+				flush()
+			}
+
+		case <-timerC:
+			timer, timerC = nil, nil
+			flush()
+
+		case <-b.quit:
+			// Drain whatever producers managed to queue before Close
+			for {
+				select {
+				case item := <-b.items:
+					batch = append(batch, item)
+					if len(batch) >= b.config.MaxBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flushBatch hands one batch to the flush function, retrying failures
+// with backoff, and records stats
+func (b *Batcher[T]) flushBatch(batch []T) {
+	start := time.Now()
+	err := b.flushOnce(batch)
+
+	backoff := b.config.RetryBackoff
+	for retry := 1; err != nil && retry <= b.config.MaxRetries; retry++ {
+		b.statsMu.Lock()
+		b.stats.FlushRetries++
+		b.stats.LastError = err.Error()
+		b.statsMu.Unlock()
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+		err = b.flushOnce(batch)
+	}
+	elapsed := time.Since(start)
+
+	b.statsMu.Lock()
+	defer b.statsMu.Unlock()
+
+	b.stats.Batches++
+	b.stats.LastBatchSize = len(batch)
+	if len(batch) > b.stats.MaxBatchSize {
+		b.stats.MaxBatchSize = len(batch)
+	}
+	b.stats.LastFlushDuration = elapsed
+	b.stats.TotalFlushDuration += elapsed
+	if elapsed > b.stats.MaxFlushDuration {
+		b.stats.MaxFlushDuration = elapsed
+	}
+	b.stats.LastFlushAt = start
+
+	if err != nil {
+		b.stats.FailedBatches++
+		b.stats.DroppedItems += int64(len(batch))
+		b.stats.LastError = err.Error()
+		return
+	}
+
+	b.stats.Items += int64(len(batch))
+	b.stats.LastError = ""
+}
+
+// flushOnce runs the flush function once, bounded by FlushTimeout
+func (b *Batcher[T]) flushOnce(batch []T) error {
+	ctx, cancel := context.WithTimeout(context.Background(), b.config.FlushTimeout)
+	defer cancel()
+
+	return b.flush(ctx, batch)
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingFlusher collects flushed batches for assertions
+type recordingFlusher struct {
+	mu      sync.Mutex
+	batches [][]int
+	block   chan struct{}
+	err     error
+	// failures fail that many flushes before succeeding
+	failures int
+}
+
+func (f *recordingFlusher) flush(ctx context.Context, batch []int) error {
+	if f.block != nil {
+		<-f.block
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.failures > 0 {
+		f.failures--
+		return errors.New("db unavailable")
+	}
+
+	copied := append([]int(nil), batch...)
+	f.batches = append(f.batches, copied)
+	return f.err
+}
+
+func (f *recordingFlusher) total() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	n := 0
+	for _, b := range f.batches {
+		n += len(b)
+	}
+	return n
+}
+
+func TestBatcherFlushesOnSize(t *testing.T) {
+	f := &recordingFlusher{}
+	b := NewBatcher(BatcherConfig{MaxBatchSize: 3, MaxBatchAge: time.Hour}, f.flush)
+	defer b.Close(context.Background())
+
+	for i := 0; i < 6; i++ {
+		if err := b.Add(context.Background(), i); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	waitFor(t, func() bool { return f.total() == 6 })
+
+	stats := b.Stats()
+	if stats.Batches != 2 || stats.MaxBatchSize != 3 || stats.Items != 6 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestBatcherFlushesOnAge(t *testing.T) {
+	f := &recordingFlusher{}
+	b := NewBatcher(BatcherConfig{MaxBatchSize: 100, MaxBatchAge: 20 * time.Millisecond}, f.flush)
+	defer b.Close(context.Background())
+
+	if err := b.Add(context.Background(), 1); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	waitFor(t, func() bool { return f.total() == 1 })
+}
+
+func TestBatcherCloseFlushesPending(t *testing.T) {
+	f := &recordingFlusher{}
+	b := NewBatcher(BatcherConfig{MaxBatchSize: 100, MaxBatchAge: time.Hour}, f.flush)
+
+	for i := 0; i < 5; i++ {
+		if err := b.Add(context.Background(), i); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if got := f.total(); got != 5 {
+		t.Fatalf("flushed %d items on close, want 5", got)
+	}
+
+	if err := b.Add(context.Background(), 6); !errors.Is(err, ErrBatcherClosed) {
+		t.Fatalf("Add() after Close error = %v, want ErrBatcherClosed", err)
+	}
+}
+
+func TestBatcherAppliesBackpressure(t *testing.T) {
+	f := &recordingFlusher{block: make(chan struct{})}
+	b := NewBatcher(BatcherConfig{MaxBatchSize: 1, QueueSize: 1, MaxBatchAge: time.Hour}, f.flush)
+
+	// First item is picked up and blocks in flush, second fills the queue
+	for i := 0; i < 2; i++ {
+		if err := b.Add(context.Background(), i); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+	waitFor(t, func() bool { return b.Stats().Queued == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if err := b.Add(ctx, 2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Add() on full queue error = %v, want DeadlineExceeded", err)
+	}
+
+	close(f.block)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if got := f.total(); got != 2 {
+		t.Fatalf("flushed %d items, want 2", got)
+	}
+}
+
+func TestBatcherRecordsFailedFlush(t *testing.T) {
+	f := &recordingFlusher{err: errors.New("db down")}
+	b := NewBatcher(BatcherConfig{MaxBatchSize: 2, MaxBatchAge: time.Hour, MaxRetries: 2, RetryBackoff: time.Millisecond}, f.flush)
+
+	b.Add(context.Background(), 1)
+	b.Add(context.Background(), 2)
+	b.Close(context.Background())
+
+	stats := b.Stats()
+	if stats.FailedBatches != 1 || stats.DroppedItems != 2 || stats.LastError != "db down" {
+		t.Errorf("unexpected stats after failed flush: %+v", stats)
+	}
+	if stats.FlushRetries != 2 {
+		t.Errorf("FlushRetries = %d, want 2", stats.FlushRetries)
+	}
+}
+
+func TestBatcherRetriesFailedFlush(t *testing.T) {
+	f := &recordingFlusher{failures: 2}
+	b := NewBatcher(BatcherConfig{MaxBatchSize: 2, MaxBatchAge: time.Hour, MaxRetries: 3, RetryBackoff: time.Millisecond}, f.flush)
+
+	b.Add(context.Background(), 1)
+	b.Add(context.Background(), 2)
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// A short outage delays the batch instead of losing it
+	if got := f.total(); got != 2 {
+		t.Fatalf("flushed %d items, want 2", got)
+	}
+	stats := b.Stats()
+	if stats.FailedBatches != 0 || stats.DroppedItems != 0 || stats.FlushRetries != 2 || stats.Items != 2 || stats.LastError != "" {
+		t.Errorf("unexpected stats after recovered flush: %+v", stats)
+	}
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("condition not met before timeout")
+}
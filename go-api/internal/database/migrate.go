@@ -0,0 +1,296 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// SchemaVersion is the schema version this build of the service requires.
+// It must match the highest migration version under migrations/.
+const SchemaVersion = 10
+
+// migrationsTable records which Go-owned migrations have been applied.
+// It is kept separate from Doctrine's table so both runners can coexist.
+const migrationsTable = "go_schema_migrations"
+
+// migrationLockID is the advisory lock key held while migrating so that
+// several replicas starting at once don't race each other.
+const migrationLockID = 7715240301
+
+// ErrSchemaIncompatible is returned when the database schema version does
+// not match SchemaVersion.
+var ErrSchemaIncompatible = errors.New("incompatible database schema")
+
+// Migration is a single versioned schema change with its rollback script
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Migrator applies the embedded migrations to a PostgreSQL database
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a migrator for the migrations embedded in the binary
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFS, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns the known migrations ordered by version
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Version returns the currently applied schema version, or 0 if no
+// migration has been applied yet.
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var exists bool
+	err := m.db.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, migrationsTable).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up %s: %w", migrationsTable, err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	return queryVersion(ctx, m.db)
+}
+
+// CheckVersion returns ErrSchemaIncompatible unless the database is exactly
+// at SchemaVersion.
+func (m *Migrator) CheckVersion(ctx context.Context) error {
+	version, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+
+	if version < SchemaVersion {
+		return fmt.Errorf("%w: database is at version %d, service requires %d (run `api migrate up`)",
+			ErrSchemaIncompatible, version, SchemaVersion)
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w: database is at version %d, newer than the %d supported by this build",
+			ErrSchemaIncompatible, version, SchemaVersion)
+	}
+
+	return nil
+}
+
+// Up applies all pending migrations and returns how many were applied
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, migration := range m.migrations {
+		ok, err := m.apply(ctx, migration, true)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+	}
+
+	return applied, nil
+}
+
+// Down rolls back up to steps migrations, newest first, and returns how
+// many were rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if steps <= 0 {
+		return 0, nil
+	}
+
+	if err := m.ensureMigrationsTable(ctx); err != nil {
+		return 0, err
+	}
+
+	rolledBack := 0
+	for i := len(m.migrations) - 1; i >= 0 && rolledBack < steps; i-- {
+		ok, err := m.apply(ctx, m.migrations[i], false)
+		if err != nil {
+			return rolledBack, err
+		}
+		if ok {
+			rolledBack++
+		}
+	}
+
+	return rolledBack, nil
+}
+
+// ensureMigrationsTable creates the version table if it doesn't exist
+func (m *Migrator) ensureMigrationsTable(ctx context.Context) error {
+	query := `CREATE TABLE IF NOT EXISTS ` + migrationsTable + ` (
+		version INTEGER PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`
+
+	if _, err := m.db.ExecContext(ctx, query); err != nil {
+		return fmt.Errorf("failed to create %s: %w", migrationsTable, err)
+	}
+
+	return nil
+}
+
+// apply runs one migration in its own transaction. It returns false when
+// the migration was skipped because it was already in the requested state.
+func (m *Migrator) apply(ctx context.Context, migration Migration, up bool) (bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin migration %d: %w", migration.Version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return false, fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+
+	// Re-read the version under the lock in case another instance migrated
+	current, err := queryVersion(ctx, tx)
+	if err != nil {
+		return false, err
+	}
+
+	if up {
+		if migration.Version <= current {
+			return false, nil
+		}
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return false, fmt.Errorf("migration %04d_%s up failed: %w", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO `+migrationsTable+` (version, name) VALUES ($1, $2)`,
+			migration.Version, migration.Name)
+	} else {
+		if migration.Version != current {
+			return false, nil
+		}
+		if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+			return false, fmt.Errorf("migration %04d_%s down failed: %w", migration.Version, migration.Name, err)
+		}
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM `+migrationsTable+` WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %d: %w", migration.Version, err)
+	}
+
+	return true, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func queryVersion(ctx context.Context, q queryer) (int, error) {
+	var version int
+	err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM `+migrationsTable).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// loadMigrations reads NNNN_name.up.sql / NNNN_name.down.sql pairs from dir
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		var direction string
+		switch {
+		case strings.HasSuffix(base, ".up"):
+			direction = "up"
+		case strings.HasSuffix(base, ".down"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("migration %s must end in .up.sql or .down.sql", entry.Name())
+		}
+		base = strings.TrimSuffix(base, "."+direction)
+
+		versionStr, name, found := strings.Cut(base, "_")
+		if !found || name == "" {
+			return nil, fmt.Errorf("migration %s must be named NNNN_name", entry.Name())
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s has invalid version %q", entry.Name(), versionStr)
+		}
+
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		migration, exists := byVersion[version]
+		if !exists {
+			migration = &Migration{Version: version, Name: name}
+			byVersion[version] = migration
+		} else if migration.Name != name {
+			return nil, fmt.Errorf("migration version %d used by both %q and %q", version, migration.Name, name)
+		}
+
+		if direction == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %04d_%s needs both up and down scripts", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for i, migration := range migrations {
+		if migration.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1, found %d at position %d", migration.Version, i+1)
+		}
+	}
+
+	return migrations, nil
+}
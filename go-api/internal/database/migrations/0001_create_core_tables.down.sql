@@ -0,0 +1,2 @@
+-- Symfony owns these tables and usually created them before this
+-- migration ran, so rolling back leaves them and their data in place.
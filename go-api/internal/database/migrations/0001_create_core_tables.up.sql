@@ -0,0 +1,41 @@
+-- Tables read and written by the Go service. Symfony may already have
+-- created them, so every statement is idempotent.
+CREATE TABLE IF NOT EXISTS api_endpoints (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    url VARCHAR(2048) NOT NULL,
+    check_interval INTEGER NOT NULL DEFAULT 60,
+    timeout INTEGER NOT NULL DEFAULT 5000,
+    headers JSONB,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    endpoint_id INTEGER NOT NULL,
+    alert_type VARCHAR(50) NOT NULL,
+    threshold JSONB NOT NULL DEFAULT '{}'::jsonb,
+    is_active BOOLEAN NOT NULL DEFAULT TRUE,
+    last_triggered_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS monitoring_results (
+    id BIGSERIAL PRIMARY KEY,
+    endpoint_id INTEGER NOT NULL,
+    response_time INTEGER,
+    status_code INTEGER,
+    error_message TEXT,
+    checked_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_monitoring_results_endpoint_checked
+    ON monitoring_results (endpoint_id, checked_at DESC);
+
+CREATE INDEX IF NOT EXISTS idx_monitoring_results_created_at
+    ON monitoring_results (created_at);
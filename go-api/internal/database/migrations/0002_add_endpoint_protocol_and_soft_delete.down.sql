@@ -0,0 +1,3 @@
+-- alerts.deleted_at is Symfony's own soft-delete column, so it stays.
+ALTER TABLE api_endpoints DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE api_endpoints DROP COLUMN IF EXISTS protocol;
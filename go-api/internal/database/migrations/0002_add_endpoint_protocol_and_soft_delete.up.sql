@@ -0,0 +1,5 @@
+-- MonitoringMetricsCollector groups endpoints by protocol and skips
+-- soft-deleted endpoints and alerts.
+ALTER TABLE api_endpoints ADD COLUMN IF NOT EXISTS protocol VARCHAR(20) NOT NULL DEFAULT 'http';
+ALTER TABLE api_endpoints ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+ALTER TABLE alerts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS system_metrics (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    value DECIMAL(20, 4) NOT NULL,
+    timestamp TIMESTAMP NOT NULL,
+    tags JSONB DEFAULT '{}'::jsonb,
+    description TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_metrics_name_timestamp
+    ON system_metrics (name, timestamp DESC);
+
+CREATE INDEX IF NOT EXISTS idx_system_metrics_timestamp
+    ON system_metrics (timestamp DESC);
+
+CREATE INDEX IF NOT EXISTS idx_system_metrics_tags
+    ON system_metrics USING GIN (tags);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_monitoring_results_checked_at;
+DROP TABLE IF EXISTS retention_watermarks;
+DROP TABLE IF EXISTS system_metric_rollups;
+DROP TABLE IF EXISTS monitoring_result_rollups;
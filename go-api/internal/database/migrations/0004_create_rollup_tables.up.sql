@@ -0,0 +1,44 @@
+-- Pre-aggregated monitoring results and metrics at 1m, 1h and 1d
+-- resolution. Sketches are mergeable quantile sketches (see internal/sketch).
+CREATE TABLE IF NOT EXISTS monitoring_result_rollups (
+    endpoint_id INTEGER NOT NULL,
+    resolution VARCHAR(8) NOT NULL,
+    bucket_start TIMESTAMP NOT NULL,
+    count BIGINT NOT NULL,
+    error_count BIGINT NOT NULL,
+    min_response_time DOUBLE PRECISION,
+    max_response_time DOUBLE PRECISION,
+    sum_response_time DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sketch JSONB,
+    PRIMARY KEY (endpoint_id, resolution, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_monitoring_result_rollups_resolution_bucket
+    ON monitoring_result_rollups (resolution, bucket_start);
+
+CREATE TABLE IF NOT EXISTS system_metric_rollups (
+    name VARCHAR(255) NOT NULL,
+    tags JSONB NOT NULL DEFAULT '{}'::jsonb,
+    resolution VARCHAR(8) NOT NULL,
+    bucket_start TIMESTAMP NOT NULL,
+    count BIGINT NOT NULL,
+    min_value DOUBLE PRECISION,
+    max_value DOUBLE PRECISION,
+    sum_value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    sketch JSONB,
+    PRIMARY KEY (name, tags, resolution, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_metric_rollups_resolution_bucket
+    ON system_metric_rollups (resolution, bucket_start);
+
+-- How far each rollup job has progressed; everything before the watermark
+-- has been aggregated and may be pruned from the source.
+CREATE TABLE IF NOT EXISTS retention_watermarks (
+    job VARCHAR(100) PRIMARY KEY,
+    watermark TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_monitoring_results_checked_at
+    ON monitoring_results (checked_at);
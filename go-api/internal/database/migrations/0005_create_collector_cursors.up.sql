@@ -0,0 +1,9 @@
+-- Per-collector state kept between runs, e.g. the last Stripe charge seen.
+-- The value is opaque to everything but the collector that wrote it.
+CREATE TABLE IF NOT EXISTS collector_cursors (
+    collector VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    value JSONB NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (collector, name)
+);
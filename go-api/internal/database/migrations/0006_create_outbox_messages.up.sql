@@ -0,0 +1,21 @@
+-- Transactional outbox: deliveries written in the same transaction as the
+-- results they describe, one row per sink, delivered by the outbox relay.
+CREATE TABLE IF NOT EXISTS outbox_messages (
+    id BIGSERIAL PRIMARY KEY,
+    sink VARCHAR(50) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    delivered_at TIMESTAMP,
+    UNIQUE (sink, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_due
+    ON outbox_messages (next_attempt_at, id) WHERE status = 'pending';
+
+CREATE INDEX IF NOT EXISTS idx_outbox_messages_delivered
+    ON outbox_messages (delivered_at) WHERE status = 'delivered';
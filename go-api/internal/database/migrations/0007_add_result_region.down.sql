@@ -0,0 +1,2 @@
+ALTER TABLE monitoring_results DROP COLUMN IF EXISTS agent_id;
+ALTER TABLE monitoring_results DROP COLUMN IF EXISTS region;
@@ -0,0 +1,4 @@
+-- Results measured by check agents name the agent and its region; both
+-- are empty for checks run by the API itself.
+ALTER TABLE monitoring_results ADD COLUMN IF NOT EXISTS region VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE monitoring_results ADD COLUMN IF NOT EXISTS agent_id VARCHAR(100) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS endpoint_status;
+DROP INDEX IF EXISTS idx_monitoring_results_endpoint_location_checked;
+ALTER TABLE monitoring_results DROP COLUMN IF EXISTS location;
@@ -0,0 +1,19 @@
+-- Results name the location they were checked from, so an endpoint's
+-- status can be decided by a quorum of locations.
+ALTER TABLE monitoring_results ADD COLUMN IF NOT EXISTS location VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS idx_monitoring_results_endpoint_location_checked
+    ON monitoring_results (endpoint_id, location, checked_at DESC);
+
+-- The consensus status of each endpoint. Rows are locked while a batch of
+-- results is evaluated, so replicas storing results for the same endpoint
+-- take turns and report each change once.
+CREATE TABLE IF NOT EXISTS endpoint_status (
+    endpoint_id INTEGER PRIMARY KEY,
+    status VARCHAR(20) NOT NULL DEFAULT 'unknown',
+    failing INTEGER NOT NULL DEFAULT 0,
+    locations INTEGER NOT NULL DEFAULT 0,
+    failing_locations TEXT[] NOT NULL DEFAULT '{}',
+    changed_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    evaluated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
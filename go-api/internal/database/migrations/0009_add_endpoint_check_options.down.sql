@@ -0,0 +1 @@
+ALTER TABLE api_endpoints DROP COLUMN IF EXISTS check_options;
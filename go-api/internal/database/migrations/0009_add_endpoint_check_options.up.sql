@@ -0,0 +1,3 @@
+-- Options of non-HTTP checks, e.g. {"send": "PING\r\n", "expect": "+PONG"}
+-- for TCP; NULL for endpoints checked with defaults.
+ALTER TABLE api_endpoints ADD COLUMN IF NOT EXISTS check_options JSONB;
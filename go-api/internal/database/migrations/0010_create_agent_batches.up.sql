@@ -0,0 +1,9 @@
+-- Batches received from check agents. A batch's row is written in the
+-- transaction that stores its results, so a batch resent to any replica
+-- is stored once. Rows are kept as long as a batch could be resent.
+CREATE TABLE IF NOT EXISTS agent_batches (
+    agent_id VARCHAR(100) NOT NULL,
+    batch_id VARCHAR(100) NOT NULL,
+    received_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (agent_id, batch_id)
+);
@@ -1,11 +1,15 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"api-monitor-go/internal/models"
+	"github.com/lib/pq"
 )
 
 type Repository struct {
@@ -17,8 +21,8 @@ func NewRepository(db *sql.DB) *Repository {
 }
 
 func (r *Repository) GetActiveEndpoints() ([]models.Endpoint, error) {
-	query := `SELECT id, user_id, url, check_interval, timeout, headers, is_active
-	          FROM api_endpoints WHERE is_active = true`
+	query := `SELECT id, user_id, url, check_interval, timeout, headers, is_active, protocol, check_options
+	          FROM api_endpoints WHERE is_active = true AND deleted_at IS NULL`
 
 	rows, err := r.db.Query(query)
 	if err != nil {
@@ -29,7 +33,7 @@ func (r *Repository) GetActiveEndpoints() ([]models.Endpoint, error) {
 	var endpoints []models.Endpoint
 	for rows.Next() {
 		var e models.Endpoint
-		err := rows.Scan(&e.ID, &e.UserID, &e.URL, &e.CheckInterval, &e.Timeout, &e.Headers, &e.IsActive)
+		err := rows.Scan(&e.ID, &e.UserID, &e.URL, &e.CheckInterval, &e.Timeout, &e.Headers, &e.IsActive, &e.Protocol, &e.CheckOptions)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan endpoint: %w", err)
 		}
@@ -63,8 +67,8 @@ func (r *Repository) GetAlertsForEndpoint(endpointID int) ([]models.Alert, error
 }
 
 func (r *Repository) SaveResult(result models.MonitoringResult) error {
-	query := `INSERT INTO monitoring_results (endpoint_id, response_time, status_code, error_message, checked_at, created_at)
-	          VALUES ($1, $2, $3, $4, $5, $6)`
+	query := `INSERT INTO monitoring_results (endpoint_id, response_time, status_code, error_message, checked_at, created_at, region, agent_id, location)
+	          VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 
 	_, err := r.db.Exec(query,
 		result.EndpointID,
@@ -73,6 +77,9 @@ func (r *Repository) SaveResult(result models.MonitoringResult) error {
 		result.ErrorMessage,
 		result.CheckedAt,
 		time.Now(),
+		result.Region,
+		result.AgentID,
+		result.Location,
 	)
 
 	if err != nil {
@@ -81,3 +88,275 @@ func (r *Repository) SaveResult(result models.MonitoringResult) error {
 
 	return nil
 }
+
+// SaveResults persists a batch of results in one transaction using COPY
+func (r *Repository) SaveResults(ctx context.Context, results []models.MonitoringResult) error {
+	return r.SaveResultsWith(ctx, results, nil)
+}
+
+// SaveResultsWith persists a batch of results like SaveResults and runs
+// inTx in the same transaction, so whatever it writes is committed only
+// together with the results
+func (r *Repository) SaveResultsWith(ctx context.Context, results []models.MonitoringResult, inTx func(tx *sql.Tx) error) error {
+	if len(results) == 0 {
+		return nil
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := copyResults(ctx, tx, results, inTx); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit results: %w", err)
+	}
+
+	return nil
+}
+
+// ErrDuplicateBatch is returned by SaveAgentBatch for a batch that was
+// already stored
+var ErrDuplicateBatch = errors.New("batch was already stored")
+
+// SaveAgentBatch persists a batch of results an agent sent like
+// SaveResultsWith and records the batch in the same transaction. A batch
+// that was already recorded, by any replica, isn't stored again and
+// ErrDuplicateBatch is returned. The agent's batches received before
+// expired are forgotten.
+func (r *Repository) SaveAgentBatch(ctx context.Context, agentID, batchID string, expired time.Time, results []models.MonitoringResult, inTx func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A concurrent insert of the same batch waits for this transaction
+	// and then finds the row
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO agent_batches (agent_id, batch_id, received_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (agent_id, batch_id) DO NOTHING
+	`, agentID, batchID, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record batch: %w", err)
+	}
+	recorded, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to record batch: %w", err)
+	}
+	if recorded == 0 {
+		return ErrDuplicateBatch
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`DELETE FROM agent_batches WHERE agent_id = $1 AND received_at < $2`, agentID, expired,
+	); err != nil {
+		return fmt.Errorf("failed to forget expired batches: %w", err)
+	}
+
+	if len(results) > 0 {
+		if err := copyResults(ctx, tx, results, inTx); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+
+	return nil
+}
+
+// copyResults copies results into monitoring_results within tx and then
+// runs inTx, if set
+func copyResults(ctx context.Context, tx *sql.Tx, results []models.MonitoringResult, inTx func(tx *sql.Tx) error) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("monitoring_results",
+		"endpoint_id", "response_time", "status_code", "error_message", "checked_at", "created_at",
+		"region", "agent_id", "location"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare copy: %w", err)
+	}
+
+	now := time.Now()
+	for _, result := range results {
+		_, err := stmt.ExecContext(ctx,
+			result.EndpointID,
+			result.ResponseTime,
+			result.StatusCode,
+			result.ErrorMessage,
+			result.CheckedAt,
+			now,
+			result.Region,
+			result.AgentID,
+			result.Location,
+		)
+		if err != nil {
+			stmt.Close()
+			return fmt.Errorf("failed to copy result: %w", err)
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return fmt.Errorf("failed to flush copy: %w", err)
+	}
+
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to close copy: %w", err)
+	}
+
+	if inTx != nil {
+		return inTx(tx)
+	}
+	return nil
+}
+
+// LockEndpointStatuses returns the consensus status of endpoints, creating
+// missing ones as unknown, and locks them until tx ends. Rows are locked
+// in ID order, so concurrent batches don't deadlock.
+func (r *Repository) LockEndpointStatuses(ctx context.Context, tx *sql.Tx, endpointIDs []int) (map[int]models.EndpointStatus, error) {
+	ids := append([]int(nil), endpointIDs...)
+	sort.Ints(ids)
+
+	_, err := tx.ExecContext(ctx, `INSERT INTO endpoint_status (endpoint_id)
+	          SELECT unnest($1::int[]) ON CONFLICT (endpoint_id) DO NOTHING`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create endpoint statuses: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT endpoint_id, status, failing, locations, failing_locations, changed_at, evaluated_at
+	          FROM endpoint_status WHERE endpoint_id = ANY($1) ORDER BY endpoint_id FOR UPDATE`, pq.Array(ids))
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock endpoint statuses: %w", err)
+	}
+	defer rows.Close()
+
+	statuses := make(map[int]models.EndpointStatus, len(ids))
+	for rows.Next() {
+		status, err := scanEndpointStatus(rows)
+		if err != nil {
+			return nil, err
+		}
+		statuses[status.EndpointID] = status
+	}
+	return statuses, rows.Err()
+}
+
+// SaveEndpointStatuses stores the consensus status of endpoints
+func (r *Repository) SaveEndpointStatuses(ctx context.Context, tx *sql.Tx, statuses []models.EndpointStatus) error {
+	for _, status := range statuses {
+		_, err := tx.ExecContext(ctx, `UPDATE endpoint_status
+		          SET status = $2, failing = $3, locations = $4, failing_locations = $5, changed_at = $6, evaluated_at = $7
+		          WHERE endpoint_id = $1`,
+			status.EndpointID, status.Status, status.Failing, status.Locations,
+			pq.Array(status.FailingLocations), status.ChangedAt, status.EvaluatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to save endpoint status: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetEndpointStatus returns an endpoint's consensus status; it is unknown
+// until results for it are stored
+func (r *Repository) GetEndpointStatus(ctx context.Context, endpointID int) (models.EndpointStatus, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT endpoint_id, status, failing, locations, failing_locations, changed_at, evaluated_at
+	          FROM endpoint_status WHERE endpoint_id = $1`, endpointID)
+	status, err := scanEndpointStatus(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.EndpointStatus{EndpointID: endpointID, Status: models.StatusUnknown, FailingLocations: []string{}}, nil
+	}
+	return status, err
+}
+
+// scanEndpointStatus scans an endpoint_status row
+func scanEndpointStatus(row interface{ Scan(...interface{}) error }) (models.EndpointStatus, error) {
+	var status models.EndpointStatus
+	err := row.Scan(&status.EndpointID, &status.Status, &status.Failing, &status.Locations,
+		pq.Array(&status.FailingLocations), &status.ChangedAt, &status.EvaluatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return status, err
+		}
+		return status, fmt.Errorf("failed to scan endpoint status: %w", err)
+	}
+	if status.FailingLocations == nil {
+		status.FailingLocations = []string{}
+	}
+	return status, nil
+}
+
+// EndpointOwnedBy reports whether an endpoint that isn't deleted belongs
+// to the user with an email, which is how Symfony's tokens identify users
+func (r *Repository) EndpointOwnedBy(ctx context.Context, endpointID int, email string) (bool, error) {
+	var owned bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (
+	              SELECT 1 FROM api_endpoints e
+	              JOIN users u ON u.id::text = e.user_id::text
+	              WHERE e.id = $1 AND e.deleted_at IS NULL AND u.email = $2)`, endpointID, email).Scan(&owned)
+	if err != nil {
+		return false, fmt.Errorf("failed to look up endpoint owner: %w", err)
+	}
+	return owned, nil
+}
+
+// LatestLocationChecks returns the latest check of each endpoint from each
+// location since a time, as seen by tx
+func (r *Repository) LatestLocationChecks(ctx context.Context, tx *sql.Tx, endpointIDs []int, since time.Time) (map[int][]models.LocationCheck, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT ON (endpoint_id, location)
+	              endpoint_id, location,
+	              (status_code IS NULL OR status_code >= 400 OR error_message IS NOT NULL),
+	              COALESCE(response_time, 0), checked_at
+	          FROM monitoring_results
+	          WHERE endpoint_id = ANY($1) AND checked_at >= $2
+	          ORDER BY endpoint_id, location, checked_at DESC`, pq.Array(endpointIDs), since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query location checks: %w", err)
+	}
+	defer rows.Close()
+
+	checks := make(map[int][]models.LocationCheck)
+	for rows.Next() {
+		var endpointID int
+		var check models.LocationCheck
+		if err := rows.Scan(&endpointID, &check.Location, &check.Failed, &check.ResponseTime, &check.CheckedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan location check: %w", err)
+		}
+		checks[endpointID] = append(checks[endpointID], check)
+	}
+	return checks, rows.Err()
+}
+
+// LocationLatency compares an endpoint's response times per location since
+// a time, ordered by location
+func (r *Repository) LocationLatency(ctx context.Context, endpointID int, since time.Time) ([]models.LocationLatency, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT location, COUNT(*),
+	              COUNT(*) FILTER (WHERE status_code IS NULL OR status_code >= 400 OR error_message IS NOT NULL),
+	              COALESCE(AVG(response_time), 0),
+	              COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY response_time), 0),
+	              COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY response_time), 0),
+	              MAX(checked_at)
+	          FROM monitoring_results
+	          WHERE endpoint_id = $1 AND checked_at >= $2
+	          GROUP BY location ORDER BY location`, endpointID, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query location latency: %w", err)
+	}
+	defer rows.Close()
+
+	latencies := []models.LocationLatency{}
+	for rows.Next() {
+		var l models.LocationLatency
+		if err := rows.Scan(&l.Location, &l.Checks, &l.Failures, &l.AvgResponseTime,
+			&l.P50ResponseTime, &l.P95ResponseTime, &l.LastCheckedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan location latency: %w", err)
+		}
+		latencies = append(latencies, l)
+	}
+	return latencies, rows.Err()
+}
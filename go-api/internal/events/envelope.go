@@ -0,0 +1,129 @@
+// Package events defines the versioned envelope published to Redis streams
+// and its JSON and Protobuf encodings. The schemas both services validate
+// against are in schemas/events at the repository root.
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"api-monitor-go/internal/logger"
+)
+
+// Event types and the payload version this service publishes for each
+const (
+	TypeAPICheck = "api.check.completed"
+	TypeMetric   = "metric.recorded"
+
+	APICheckVersion = 1
+	MetricVersion   = 1
+)
+
+// Envelope wraps every published event with the metadata consumers need to
+// route and deduplicate it
+type Envelope struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version"`
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Source    string    `json:"source"`
+	TraceID   string    `json:"trace_id,omitempty"`
+	Payload   Payload   `json:"payload"`
+}
+
+// Payload is the type-specific body of an event
+type Payload interface {
+	// EventType and EventVersion identify the payload schema
+	EventType() string
+	EventVersion() int
+
+	// LegacyValues returns the flat stream fields consumers read before
+	// the envelope existed
+	LegacyValues() map[string]interface{}
+}
+
+// New wraps payload in an envelope from source, taking the trace ID from
+// ctx's request ID
+func New(ctx context.Context, source string, payload Payload) *Envelope {
+	return &Envelope{
+		Type:      payload.EventType(),
+		Version:   payload.EventVersion(),
+		ID:        NewID(),
+		Timestamp: time.Now().UTC(),
+		Source:    source,
+		TraceID:   logger.GetRequestID(ctx),
+		Payload:   payload,
+	}
+}
+
+// NewID returns a random (version 4) UUID
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// newPayload returns an empty payload for an event type
+func newPayload(eventType string) (Payload, error) {
+	switch eventType {
+	case TypeAPICheck:
+		return &APICheck{}, nil
+	case TypeMetric:
+		return &Metric{}, nil
+	}
+	return nil, fmt.Errorf("unknown event type '%s'", eventType)
+}
+
+// Validate checks the fields every envelope must have
+func (e *Envelope) Validate() error {
+	switch {
+	case e.Type == "":
+		return fmt.Errorf("event type is required")
+	case e.Version < 1:
+		return fmt.Errorf("event version must be at least 1")
+	case e.ID == "":
+		return fmt.Errorf("event id is required")
+	case e.Timestamp.IsZero():
+		return fmt.Errorf("event timestamp is required")
+	case e.Source == "":
+		return fmt.Errorf("event source is required")
+	case e.Payload == nil:
+		return fmt.Errorf("event payload is required")
+	case e.Payload.EventType() != e.Type:
+		return fmt.Errorf("payload of type '%s' in '%s' event", e.Payload.EventType(), e.Type)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes the payload according to the envelope's type
+func (e *Envelope) UnmarshalJSON(data []byte) error {
+	type header Envelope
+	var raw struct {
+		header
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	payload, err := newPayload(raw.Type)
+	if err != nil {
+		return err
+	}
+	if len(raw.Payload) > 0 {
+		if err := json.Unmarshal(raw.Payload, payload); err != nil {
+			return fmt.Errorf("failed to decode %s payload: %w", raw.Type, err)
+		}
+	}
+
+	*e = Envelope(raw.header)
+	e.Payload = payload
+	return nil
+}
@@ -0,0 +1,236 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func testResult(statusCode int) models.MonitoringResult {
+	return models.MonitoringResult{
+		EndpointID:   42,
+		ResponseTime: 153,
+		StatusCode:   &statusCode,
+		CheckedAt:    time.Date(2024, 3, 5, 10, 0, 0, 123000000, time.UTC),
+		Method:       "GET",
+		URL:          "https://api.example.com/v1/health?full=1",
+	}
+}
+
+func testEnvelopes() []*Envelope {
+	ctx := logger.WithRequestID(context.Background(), "req-1")
+
+	failed := testResult(0)
+	failed.StatusCode = nil
+	message := "connection refused"
+	failed.ErrorMessage = &message
+
+	return []*Envelope{
+		New(ctx, "go-api", NewAPICheck(testResult(200))),
+		New(ctx, "go-api", NewAPICheck(testResult(0))),
+		New(context.Background(), "go-api", NewAPICheck(failed)),
+		New(ctx, "go-metrics-worker", &Metric{
+			Name:        "stripe_payments",
+			Type:        "counter",
+			Value:       12.5,
+			Timestamp:   time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC),
+			Tags:        map[string]string{"source": "charge", "status": "succeeded"},
+			Description: "Payments",
+		}),
+	}
+}
+
+func TestNewAPICheck(t *testing.T) {
+	check := NewAPICheck(testResult(200))
+	if check.EndpointID != 42 || check.Method != "GET" || check.Path != "/v1/health" || check.ResponseTime != 153 || *check.StatusCode != 200 {
+		t.Errorf("unexpected payload: %+v", check)
+	}
+
+	envelope := New(logger.WithRequestID(context.Background(), "req-1"), "go-api", check)
+	if envelope.Type != TypeAPICheck || envelope.Version != APICheckVersion || envelope.TraceID != "req-1" || len(envelope.ID) != 36 {
+		t.Errorf("unexpected envelope: %+v", envelope)
+	}
+	if err := envelope.Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	for _, encoding := range []Encoding{EncodingJSON, EncodingProtobuf} {
+		for _, envelope := range testEnvelopes() {
+			data, err := Marshal(envelope, encoding)
+			if err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+
+			decoded, err := Unmarshal(data, encoding)
+			if err != nil {
+				t.Fatalf("%s: %v", encoding, err)
+			}
+
+			envelope.Timestamp = envelope.Timestamp.Round(0)
+			if !reflect.DeepEqual(decoded, envelope) {
+				t.Errorf("%s round trip mismatch:\n got %+v\nwant %+v", encoding, decoded, envelope)
+			}
+		}
+	}
+}
+
+func TestUnmarshalRejectsInvalidEvents(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"unknown type", `{"type":"user.deleted","version":1,"id":"x","timestamp":"2024-03-05T10:00:00Z","source":"go-api","payload":{}}`},
+		{"missing id", `{"type":"metric.recorded","version":1,"timestamp":"2024-03-05T10:00:00Z","source":"go-api","payload":{}}`},
+		{"missing version", `{"type":"metric.recorded","id":"x","timestamp":"2024-03-05T10:00:00Z","source":"go-api","payload":{}}`},
+		{"malformed", `{"type":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Unmarshal([]byte(tt.data), EncodingJSON); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if _, err := Unmarshal([]byte{0xff, 0xff}, EncodingProtobuf); err == nil {
+		t.Error("expected an error for malformed protobuf")
+	}
+}
+
+// The hand-written encoding must match generated code for the well-known
+// Timestamp type
+func TestProtoTimestampMatchesWellKnownType(t *testing.T) {
+	ts := time.Date(2024, 3, 5, 10, 0, 0, 123456789, time.UTC)
+
+	b := appendTimestamp(nil, 4, ts)
+	_, _, n := protowire.ConsumeTag(b)
+	ours, _ := protowire.ConsumeBytes(b[n:])
+
+	want, err := proto.Marshal(timestamppb.New(ts))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ours, want) {
+		t.Errorf("got %x, want %x", ours, want)
+	}
+}
+
+func TestStreamValues(t *testing.T) {
+	envelope := testEnvelopes()[0]
+
+	values, err := StreamConfig{Encoding: EncodingJSON, LegacyFields: true}.StreamValues(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for field, want := range map[string]interface{}{
+		FieldContentType:  "application/json",
+		FieldEventType:    TypeAPICheck,
+		FieldEventVersion: "1",
+		"endpoint_id":     "42",
+		"response_time":   "153",
+		"status_code":     "200",
+		"timestamp":       "2024-03-05T10:00:00Z",
+		"method":          "GET",
+		"path":            "/v1/health",
+	} {
+		if values[field] != want {
+			t.Errorf("field %s: got %v, want %v", field, values[field], want)
+		}
+	}
+
+	// Redis returns fields as strings
+	values[FieldEvent] = string(values[FieldEvent].([]byte))
+	decoded, err := FromStreamValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != envelope.ID {
+		t.Errorf("expected event %s, got %s", envelope.ID, decoded.ID)
+	}
+
+	values, err = StreamConfig{Encoding: EncodingProtobuf}.StreamValues(testEnvelopes()[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := values["name"]; ok {
+		t.Error("legacy fields written when disabled")
+	}
+	if values[FieldContentType] != "application/x-protobuf" {
+		t.Errorf("unexpected content type %v", values[FieldContentType])
+	}
+	decoded, err = FromStreamValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metric := decoded.Payload.(*Metric); metric.Name != "stripe_payments" || metric.Tags["status"] != "succeeded" {
+		t.Errorf("unexpected payload: %+v", metric)
+	}
+}
+
+// JSON events must carry what the published schema requires
+func TestJSONMatchesSchema(t *testing.T) {
+	data, err := os.ReadFile("../../../schemas/events/v1/envelope.schema.json")
+	if err != nil {
+		t.Skipf("schema not available: %v", err)
+	}
+
+	var schema struct {
+		Required   []string `json:"required"`
+		Properties struct {
+			Type struct {
+				Enum []string `json:"enum"`
+			} `json:"type"`
+		} `json:"properties"`
+		Defs map[string]struct {
+			Required []string `json:"required"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	defs := map[string]string{TypeAPICheck: "ApiCheckV1", TypeMetric: "MetricV1"}
+	for _, envelope := range testEnvelopes() {
+		encoded, err := Marshal(envelope, EncodingJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var event map[string]interface{}
+		json.Unmarshal(encoded, &event)
+
+		for _, field := range schema.Required {
+			if _, ok := event[field]; !ok {
+				t.Errorf("%s event is missing '%s'", envelope.Type, field)
+			}
+		}
+
+		payload := event["payload"].(map[string]interface{})
+		for _, field := range schema.Defs[defs[envelope.Type]].Required {
+			if _, ok := payload[field]; !ok {
+				t.Errorf("%s payload is missing '%s'", envelope.Type, field)
+			}
+		}
+	}
+
+	for eventType := range defs {
+		found := false
+		for _, listed := range schema.Properties.Type.Enum {
+			found = found || listed == eventType
+		}
+		if !found {
+			t.Errorf("schema doesn't list event type %s", eventType)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package events
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"api-monitor-go/internal/models"
+)
+
+// APICheck is the payload of an api.check.completed event, published to
+// the api-metrics stream for every endpoint check
+type APICheck struct {
+	EndpointID int64  `json:"endpoint_id"`
+	Method     string `json:"method"`
+	URL        string `json:"url,omitempty"`
+	Path       string `json:"path,omitempty"`
+
+	// ResponseTime is in milliseconds
+	ResponseTime float64   `json:"response_time"`
+	StatusCode   *int      `json:"status_code,omitempty"`
+	ErrorMessage string    `json:"error_message,omitempty"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// NewAPICheck returns the event payload for a monitoring result
+func NewAPICheck(result models.MonitoringResult) *APICheck {
+	check := &APICheck{
+		EndpointID:   int64(result.EndpointID),
+		Method:       result.Method,
+		URL:          result.URL,
+		ResponseTime: float64(result.ResponseTime),
+		StatusCode:   result.StatusCode,
+		CheckedAt:    result.CheckedAt.UTC(),
+	}
+	if check.Method == "" {
+		check.Method = "GET"
+	}
+	if u, err := url.Parse(result.URL); err == nil && result.URL != "" {
+		check.Path = u.EscapedPath()
+		if check.Path == "" {
+			check.Path = "/"
+		}
+	}
+	if result.ErrorMessage != nil {
+		check.ErrorMessage = *result.ErrorMessage
+	}
+	return check
+}
+
+func (c *APICheck) EventType() string { return TypeAPICheck }
+func (c *APICheck) EventVersion() int { return APICheckVersion }
+
+// LegacyValues returns the fields the api-metrics stream carried before the
+// envelope, plus the method and path the analytics service expects
+func (c *APICheck) LegacyValues() map[string]interface{} {
+	values := map[string]interface{}{
+		"endpoint_id":   strconv.FormatInt(c.EndpointID, 10),
+		"response_time": strconv.FormatFloat(c.ResponseTime, 'f', -1, 64),
+		"timestamp":     c.CheckedAt.Format(time.RFC3339),
+		"method":        c.Method,
+	}
+	if c.Path != "" {
+		values["path"] = c.Path
+	}
+	if c.StatusCode != nil {
+		values["status_code"] = strconv.Itoa(*c.StatusCode)
+	}
+	if c.ErrorMessage != "" {
+		values["error_message"] = c.ErrorMessage
+	}
+	return values
+}
+
+// Metric is the payload of a metric.recorded event, published to the
+// metrics stream for every collected metric value
+type Metric struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Value       float64           `json:"value"`
+	Timestamp   time.Time         `json:"timestamp"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Description string            `json:"description,omitempty"`
+}
+
+func (m *Metric) EventType() string { return TypeMetric }
+func (m *Metric) EventVersion() int { return MetricVersion }
+
+// LegacyValues returns the fields the metrics stream carried before the
+// envelope, with each tag as a tag_ field
+func (m *Metric) LegacyValues() map[string]interface{} {
+	values := map[string]interface{}{
+		"name":        m.Name,
+		"type":        m.Type,
+		"value":       m.Value,
+		"timestamp":   m.Timestamp.Unix(),
+		"description": m.Description,
+	}
+	for k, v := range m.Tags {
+		values["tag_"+k] = v
+	}
+	return values
+}
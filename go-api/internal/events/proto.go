@@ -0,0 +1,310 @@
+package events
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The Protobuf encoding follows schemas/events/v1/envelope.proto. Messages
+// are encoded by hand so the build doesn't depend on protoc; field numbers
+// here must match the schema.
+
+// Envelope fields
+const (
+	envelopeType      protowire.Number = 1
+	envelopeVersion   protowire.Number = 2
+	envelopeID        protowire.Number = 3
+	envelopeTimestamp protowire.Number = 4
+	envelopeSource    protowire.Number = 5
+	envelopeTraceID   protowire.Number = 6
+	envelopeAPICheck  protowire.Number = 10
+	envelopeMetric    protowire.Number = 11
+)
+
+// ApiCheck fields
+const (
+	apiCheckEndpointID   protowire.Number = 1
+	apiCheckMethod       protowire.Number = 2
+	apiCheckURL          protowire.Number = 3
+	apiCheckPath         protowire.Number = 4
+	apiCheckResponseTime protowire.Number = 5
+	apiCheckStatusCode   protowire.Number = 6
+	apiCheckErrorMessage protowire.Number = 7
+	apiCheckCheckedAt    protowire.Number = 8
+)
+
+// Metric fields
+const (
+	metricName        protowire.Number = 1
+	metricType        protowire.Number = 2
+	metricValue       protowire.Number = 3
+	metricTimestamp   protowire.Number = 4
+	metricTags        protowire.Number = 5
+	metricDescription protowire.Number = 6
+)
+
+// MarshalProto encodes the envelope as an events.v1.Envelope message
+func (e *Envelope) MarshalProto() ([]byte, error) {
+	var b []byte
+	b = appendString(b, envelopeType, e.Type)
+	b = appendVarint(b, envelopeVersion, uint64(e.Version))
+	b = appendString(b, envelopeID, e.ID)
+	b = appendTimestamp(b, envelopeTimestamp, e.Timestamp)
+	b = appendString(b, envelopeSource, e.Source)
+	b = appendString(b, envelopeTraceID, e.TraceID)
+
+	switch p := e.Payload.(type) {
+	case *APICheck:
+		b = appendMessage(b, envelopeAPICheck, p.marshalProto())
+	case *Metric:
+		b = appendMessage(b, envelopeMetric, p.marshalProto())
+	case nil:
+	default:
+		return nil, fmt.Errorf("no protobuf encoding for %s payload", p.EventType())
+	}
+	return b, nil
+}
+
+// UnmarshalProto decodes an events.v1.Envelope message
+func (e *Envelope) UnmarshalProto(b []byte) error {
+	*e = Envelope{}
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == envelopeType && typ == protowire.BytesType:
+			return consumeString(b, &e.Type)
+		case num == envelopeVersion && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			e.Version = int(v)
+			return n, nil
+		case num == envelopeID && typ == protowire.BytesType:
+			return consumeString(b, &e.ID)
+		case num == envelopeTimestamp && typ == protowire.BytesType:
+			return consumeTimestamp(b, &e.Timestamp)
+		case num == envelopeSource && typ == protowire.BytesType:
+			return consumeString(b, &e.Source)
+		case num == envelopeTraceID && typ == protowire.BytesType:
+			return consumeString(b, &e.TraceID)
+		case num == envelopeAPICheck && typ == protowire.BytesType:
+			check := &APICheck{}
+			e.Payload = check
+			return consumeMessage(b, check.unmarshalProto)
+		case num == envelopeMetric && typ == protowire.BytesType:
+			metric := &Metric{}
+			e.Payload = metric
+			return consumeMessage(b, metric.unmarshalProto)
+		}
+		return -1, nil
+	})
+}
+
+func (c *APICheck) marshalProto() []byte {
+	var b []byte
+	b = appendVarint(b, apiCheckEndpointID, uint64(c.EndpointID))
+	b = appendString(b, apiCheckMethod, c.Method)
+	b = appendString(b, apiCheckURL, c.URL)
+	b = appendString(b, apiCheckPath, c.Path)
+	b = appendDouble(b, apiCheckResponseTime, c.ResponseTime)
+	if c.StatusCode != nil {
+		// proto3 optional: presence is encoded even for zero
+		b = protowire.AppendTag(b, apiCheckStatusCode, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(*c.StatusCode)))
+	}
+	b = appendString(b, apiCheckErrorMessage, c.ErrorMessage)
+	b = appendTimestamp(b, apiCheckCheckedAt, c.CheckedAt)
+	return b
+}
+
+func (c *APICheck) unmarshalProto(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == apiCheckEndpointID && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			c.EndpointID = int64(v)
+			return n, nil
+		case num == apiCheckMethod && typ == protowire.BytesType:
+			return consumeString(b, &c.Method)
+		case num == apiCheckURL && typ == protowire.BytesType:
+			return consumeString(b, &c.URL)
+		case num == apiCheckPath && typ == protowire.BytesType:
+			return consumeString(b, &c.Path)
+		case num == apiCheckResponseTime && typ == protowire.Fixed64Type:
+			return consumeDouble(b, &c.ResponseTime)
+		case num == apiCheckStatusCode && typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			code := int(int32(v))
+			c.StatusCode = &code
+			return n, nil
+		case num == apiCheckErrorMessage && typ == protowire.BytesType:
+			return consumeString(b, &c.ErrorMessage)
+		case num == apiCheckCheckedAt && typ == protowire.BytesType:
+			return consumeTimestamp(b, &c.CheckedAt)
+		}
+		return -1, nil
+	})
+}
+
+func (m *Metric) marshalProto() []byte {
+	var b []byte
+	b = appendString(b, metricName, m.Name)
+	b = appendString(b, metricType, m.Type)
+	b = appendDouble(b, metricValue, m.Value)
+	b = appendTimestamp(b, metricTimestamp, m.Timestamp)
+	for k, v := range m.Tags {
+		// map<string, string> entries are key = 1, value = 2 messages
+		var entry []byte
+		entry = appendString(entry, 1, k)
+		entry = appendString(entry, 2, v)
+		b = protowire.AppendTag(b, metricTags, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	b = appendString(b, metricDescription, m.Description)
+	return b
+}
+
+func (m *Metric) unmarshalProto(b []byte) error {
+	return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+		switch {
+		case num == metricName && typ == protowire.BytesType:
+			return consumeString(b, &m.Name)
+		case num == metricType && typ == protowire.BytesType:
+			return consumeString(b, &m.Type)
+		case num == metricValue && typ == protowire.Fixed64Type:
+			return consumeDouble(b, &m.Value)
+		case num == metricTimestamp && typ == protowire.BytesType:
+			return consumeTimestamp(b, &m.Timestamp)
+		case num == metricTags && typ == protowire.BytesType:
+			var key, value string
+			n, err := consumeMessage(b, func(b []byte) error {
+				return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+					switch {
+					case num == 1 && typ == protowire.BytesType:
+						return consumeString(b, &key)
+					case num == 2 && typ == protowire.BytesType:
+						return consumeString(b, &value)
+					}
+					return -1, nil
+				})
+			})
+			if m.Tags == nil {
+				m.Tags = make(map[string]string)
+			}
+			m.Tags[key] = value
+			return n, err
+		case num == metricDescription && typ == protowire.BytesType:
+			return consumeString(b, &m.Description)
+		}
+		return -1, nil
+	})
+}
+
+// Encoding helpers. Like generated code, zero scalars are omitted.
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendMessage(b []byte, num protowire.Number, msg []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, msg)
+}
+
+// appendTimestamp encodes t as a google.protobuf.Timestamp
+func appendTimestamp(b []byte, num protowire.Number, t time.Time) []byte {
+	if t.IsZero() {
+		return b
+	}
+	var msg []byte
+	msg = appendVarint(msg, 1, uint64(t.Unix()))
+	msg = appendVarint(msg, 2, uint64(t.Nanosecond()))
+	return appendMessage(b, num, msg)
+}
+
+// Decoding helpers
+
+// consumeFields calls field for each field in b. field returns how many
+// bytes of the value it consumed, or -1 to skip an unknown field.
+func consumeFields(b []byte, field func(num protowire.Number, typ protowire.Type, b []byte) (int, error)) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf tag: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+
+		n, err := field(num, typ, b)
+		if err != nil {
+			return err
+		}
+		if n == -1 {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return fmt.Errorf("invalid protobuf field %d: %w", num, protowire.ParseError(n))
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func consumeString(b []byte, v *string) (int, error) {
+	s, n := protowire.ConsumeString(b)
+	*v = s
+	return n, nil
+}
+
+func consumeDouble(b []byte, v *float64) (int, error) {
+	bits, n := protowire.ConsumeFixed64(b)
+	*v = math.Float64frombits(bits)
+	return n, nil
+}
+
+func consumeMessage(b []byte, decode func([]byte) error) (int, error) {
+	msg, n := protowire.ConsumeBytes(b)
+	if n < 0 {
+		return n, nil
+	}
+	return n, decode(msg)
+}
+
+func consumeTimestamp(b []byte, t *time.Time) (int, error) {
+	var seconds, nanos int64
+	n, err := consumeMessage(b, func(b []byte) error {
+		return consumeFields(b, func(num protowire.Number, typ protowire.Type, b []byte) (int, error) {
+			if typ != protowire.VarintType || (num != 1 && num != 2) {
+				return -1, nil
+			}
+			v, n := protowire.ConsumeVarint(b)
+			if num == 1 {
+				seconds = int64(v)
+			} else {
+				nanos = int64(int32(v))
+			}
+			return n, nil
+		})
+	})
+	*t = time.Unix(seconds, nanos).UTC()
+	return n, err
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"api-monitor-go/internal/config"
+)
+
+// Encoding is how an envelope is serialized into a stream entry
+type Encoding string
+
+const (
+	EncodingJSON     Encoding = "json"
+	EncodingProtobuf Encoding = "protobuf"
+)
+
+// ContentType returns the MIME type written next to encoded events
+func (e Encoding) ContentType() string {
+	if e == EncodingProtobuf {
+		return "application/x-protobuf"
+	}
+	return "application/json"
+}
+
+// ParseEncoding parses an encoding name
+func ParseEncoding(name string) (Encoding, error) {
+	switch Encoding(name) {
+	case EncodingJSON, "":
+		return EncodingJSON, nil
+	case EncodingProtobuf, "proto":
+		return EncodingProtobuf, nil
+	}
+	return "", fmt.Errorf("unknown event encoding '%s'", name)
+}
+
+// Stream entry fields. The envelope header is repeated outside the encoded
+// event so consumers can route entries without decoding them; the names
+// don't collide with the legacy fields.
+const (
+	FieldEvent        = "event"
+	FieldContentType  = "content_type"
+	FieldEventType    = "event_type"
+	FieldEventVersion = "event_version"
+)
+
+// StreamConfig controls how events are written to streams
+type StreamConfig struct {
+	Encoding Encoding
+
+	// LegacyFields also writes each payload's pre-envelope flat fields so
+	// existing consumers keep working during the migration
+	LegacyFields bool
+}
+
+// DefaultStreamConfig returns default stream configuration
+func DefaultStreamConfig() StreamConfig {
+	return StreamConfig{Encoding: EncodingJSON, LegacyFields: true}
+}
+
+// StreamConfigFromConfig returns the stream configuration set by
+// EVENT_ENCODING and EVENT_LEGACY_FIELDS
+func StreamConfigFromConfig(cfg *config.Config) (StreamConfig, error) {
+	encoding, err := ParseEncoding(cfg.EventEncoding)
+	if err != nil {
+		return StreamConfig{}, err
+	}
+	return StreamConfig{Encoding: encoding, LegacyFields: cfg.EventLegacyFields}, nil
+}
+
+// Marshal encodes an envelope
+func Marshal(e *Envelope, encoding Encoding) ([]byte, error) {
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	if encoding == EncodingProtobuf {
+		return e.MarshalProto()
+	}
+	return json.Marshal(e)
+}
+
+// Unmarshal decodes an envelope
+func Unmarshal(data []byte, encoding Encoding) (*Envelope, error) {
+	e := &Envelope{}
+	var err error
+	if encoding == EncodingProtobuf {
+		err = e.UnmarshalProto(data)
+	} else {
+		err = json.Unmarshal(data, e)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event: %w", err)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// StreamValues returns the fields of the stream entry for an envelope
+func (c StreamConfig) StreamValues(e *Envelope) (map[string]interface{}, error) {
+	data, err := Marshal(e, c.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	if c.LegacyFields {
+		for k, v := range e.Payload.LegacyValues() {
+			values[k] = v
+		}
+	}
+	values[FieldEvent] = data
+	values[FieldContentType] = c.Encoding.ContentType()
+	values[FieldEventType] = e.Type
+	values[FieldEventVersion] = strconv.Itoa(e.Version)
+	return values, nil
+}
+
+// FromStreamValues decodes the envelope in a stream entry's fields
+func FromStreamValues(values map[string]interface{}) (*Envelope, error) {
+	var data []byte
+	switch v := values[FieldEvent].(type) {
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return nil, fmt.Errorf("stream entry has no '%s' field", FieldEvent)
+	}
+
+	encoding := EncodingJSON
+	if values[FieldContentType] == EncodingProtobuf.ContentType() {
+		encoding = EncodingProtobuf
+	}
+	return Unmarshal(data, encoding)
+}
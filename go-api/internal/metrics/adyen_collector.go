@@ -0,0 +1,194 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("adyen", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		apiKey, err := requireOption(options, "api_key")
+		if err != nil {
+			return nil, err
+		}
+		merchantAccount, err := requireOption(options, "merchant_account")
+		if err != nil {
+			return nil, err
+		}
+		baseURL, err := requireOption(options, "base_url")
+		if err != nil {
+			return nil, err
+		}
+		window, err := windowOption(options)
+		if err != nil {
+			return nil, err
+		}
+
+		source := NewAdyenSource(AdyenConfig{
+			APIKey:          apiKey,
+			MerchantAccount: merchantAccount,
+			HTTP:            GatewayHTTPConfig{BaseURL: baseURL},
+		})
+		return NewGatewayMetricsCollector(name, source, window), nil
+	})
+}
+
+// AdyenConfig holds Adyen collector configuration
+type AdyenConfig struct {
+	APIKey          string
+	MerchantAccount string
+	HTTP            GatewayHTTPConfig
+}
+
+// AdyenSource reads Adyen-style notification items from a paginated REST
+// endpoint (GET /v1/events), such as a report service fed by Adyen's
+// webhooks. Items carry Adyen's eventCode, success and reason fields and
+// amounts in minor units.
+type AdyenSource struct {
+	config AdyenConfig
+	http   GatewayHTTPConfig
+}
+
+// NewAdyenSource creates a new Adyen gateway source
+func NewAdyenSource(config AdyenConfig) *AdyenSource {
+	return &AdyenSource{
+		config: config,
+		http:   config.HTTP.withDefaults(),
+	}
+}
+
+// Gateway returns "adyen"
+func (a *AdyenSource) Gateway() string {
+	return "adyen"
+}
+
+// Account returns the merchant account
+func (a *AdyenSource) Account() string {
+	return a.config.MerchantAccount
+}
+
+type adyenEventsPage struct {
+	Data []struct {
+		PSPReference string `json:"pspReference"`
+		EventCode    string `json:"eventCode"`
+		Success      string `json:"success"`
+		Reason       string `json:"reason"`
+		Amount       struct {
+			Currency string `json:"currency"`
+			Value    int64  `json:"value"`
+		} `json:"amount"`
+	} `json:"data"`
+	Links struct {
+		Next *struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"_links"`
+}
+
+// Fetch returns the transactions and disputes created in [from, to)
+func (a *AdyenSource) Fetch(ctx context.Context, from, to time.Time) (GatewayReport, error) {
+	var report GatewayReport
+
+	query := url.Values{
+		"merchantAccount": {a.config.MerchantAccount},
+		"createdSince":    {from.UTC().Format(time.RFC3339)},
+		"createdUntil":    {to.UTC().Format(time.RFC3339)},
+		"pageSize":        {"100"},
+	}
+	next := a.http.BaseURL + "/v1/events?" + query.Encode()
+
+	for page := 0; next != ""; page++ {
+		if page >= a.http.MaxPages {
+			return report, fmt.Errorf("failed to list events: more than %d pages", a.http.MaxPages)
+		}
+
+		var result adyenEventsPage
+		err := a.http.doJSON(ctx, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, next, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("X-API-Key", a.config.APIKey)
+			req.Header.Set("Accept", "application/json")
+			return req, nil
+		}, &result)
+		if err != nil {
+			return report, fmt.Errorf("failed to list events: %w", err)
+		}
+
+		for _, item := range result.Data {
+			success, _ := strconv.ParseBool(item.Success)
+			amount := float64(item.Amount.Value) / minorUnits(item.Amount.Currency)
+
+			if status, ok := adyenDisputeStatus(item.EventCode); ok {
+				report.Disputes = append(report.Disputes, GatewayDispute{
+					ID:       item.PSPReference,
+					Status:   status,
+					Reason:   NormalizeDeclineReason(item.Reason),
+					Amount:   amount,
+					Currency: item.Amount.Currency,
+				})
+				continue
+			}
+
+			txn := GatewayTransaction{
+				ID:       item.PSPReference,
+				Amount:   amount,
+				Currency: item.Amount.Currency,
+				Status:   GatewayStatusSucceeded,
+			}
+			switch item.EventCode {
+			case "AUTHORISATION":
+				txn.Type = GatewayTransactionPayment
+				if !success {
+					txn.Status = GatewayStatusDeclined
+					txn.DeclineReason = adyenRefusalReason(item.Reason)
+				}
+			case "REFUND", "REFUND_FAILED":
+				txn.Type = GatewayTransactionRefund
+				if !success || item.EventCode == "REFUND_FAILED" {
+					txn.Status = GatewayStatusFailed
+				}
+			default:
+				continue
+			}
+			report.Transactions = append(report.Transactions, txn)
+		}
+
+		next = ""
+		if result.Links.Next != nil {
+			if next, err = a.http.nextPage(result.Links.Next.Href); err != nil {
+				return report, fmt.Errorf("failed to list events: %w", err)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// adyenDisputeStatus maps chargeback event codes to a dispute status
+func adyenDisputeStatus(eventCode string) (string, bool) {
+	switch eventCode {
+	case "NOTIFICATION_OF_CHARGEBACK", "REQUEST_FOR_INFORMATION":
+		return "open", true
+	case "CHARGEBACK", "SECOND_CHARGEBACK":
+		return "lost", true
+	case "CHARGEBACK_REVERSED":
+		return "won", true
+	}
+	return "", false
+}
+
+// adyenRefusalReason drops the raw acquirer response Adyen sometimes
+// appends after a colon, e.g. "Refused:05 Do not honor"
+func adyenRefusalReason(reason string) string {
+	if i := strings.Index(reason, ":"); i > 0 {
+		reason = reason[:i]
+	}
+	return reason
+}
@@ -2,26 +2,158 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"api-monitor-go/internal/resilience"
+)
+
+// Health metrics recorded for every collection, tagged with the collector name
+const (
+	MetricCollectorUp       = "collector_up"
+	MetricCollectorDuration = "collector_duration_seconds"
 )
 
+// CollectorResult is the outcome of collecting from one collector
+type CollectorResult struct {
+	Collector string
+	Metrics   []MetricValue
+	Err       error
+	Duration  time.Duration
+	Attempts  int
+	Circuit   resilience.CircuitBreakerState
+	Breaker   resilience.CircuitBreakerStats
+}
+
+// HealthMetrics returns collector_up and collector_duration_seconds for
+// this result
+func (r CollectorResult) HealthMetrics(at time.Time) []MetricValue {
+	up := 1.0
+	if r.Err != nil {
+		up = 0
+	}
+	tags := map[string]string{"collector": r.Collector}
+
+	return []MetricValue{
+		{
+			Name:        MetricCollectorUp,
+			Type:        MetricTypeGauge,
+			Value:       up,
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Whether the last collection succeeded",
+		},
+		{
+			Name:        MetricCollectorDuration,
+			Type:        MetricTypeGauge,
+			Value:       r.Duration.Seconds(),
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Duration of the last collection including retries",
+		},
+	}
+}
+
+// CollectionResults holds one result per collector, keyed by name
+type CollectionResults map[string]CollectorResult
+
+// Metrics returns the metrics of every collector plus their health metrics
+func (c CollectionResults) Metrics(at time.Time) []MetricValue {
+	var all []MetricValue
+	for _, name := range c.names() {
+		result := c[name]
+		all = append(all, result.Metrics...)
+		all = append(all, result.HealthMetrics(at)...)
+	}
+	return all
+}
+
+// Err combines the errors of all failed collectors, or returns nil
+func (c CollectionResults) Err() error {
+	var failed []string
+	for _, name := range c.names() {
+		if err := c[name].Err; err != nil {
+			failed = append(failed, fmt.Sprintf("collector '%s' failed: %v", name, err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(failed, "; "))
+}
+
+func (c CollectionResults) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AggregatorConfig holds metrics aggregator configuration
+type AggregatorConfig struct {
+	MaxWorkers     int
+	Retry          resilience.RetryConfig
+	CircuitBreaker resilience.CircuitBreakerConfig // Name is set per collector
+}
+
+// DefaultAggregatorConfig returns default aggregator configuration
+func DefaultAggregatorConfig() AggregatorConfig {
+	return AggregatorConfig{
+		MaxWorkers: 5,
+		Retry: resilience.RetryConfig{
+			MaxAttempts:  3,
+			InitialDelay: 200 * time.Millisecond,
+			MaxDelay:     5 * time.Second,
+			Multiplier:   2.0,
+			Jitter:       true,
+		},
+		CircuitBreaker: resilience.CircuitBreakerConfig{
+			MaxFailures:   3,
+			ResetInterval: 5 * time.Minute,
+		},
+	}
+}
+
+// registeredCollector is a collector with its own circuit breaker
+type registeredCollector struct {
+	collector MetricsCollector
+	breaker   *resilience.CircuitBreaker
+}
+
 // DefaultMetricsAggregator is the default implementation of MetricsAggregator
 type DefaultMetricsAggregator struct {
-	collectors map[string]MetricsCollector
+	collectors map[string]*registeredCollector
 	mu         sync.RWMutex
 	maxWorkers int
+	config     AggregatorConfig
+	retrier    *resilience.Retrier
 }
 
 // NewMetricsAggregator creates a new metrics aggregator
 func NewMetricsAggregator(maxWorkers int) *DefaultMetricsAggregator {
-	if maxWorkers <= 0 {
-		maxWorkers = 5
+	config := DefaultAggregatorConfig()
+	config.MaxWorkers = maxWorkers
+	return NewMetricsAggregatorWithConfig(config)
+}
+
+// NewMetricsAggregatorWithConfig creates a metrics aggregator with custom
+// retry and circuit breaker settings
+func NewMetricsAggregatorWithConfig(config AggregatorConfig) *DefaultMetricsAggregator {
+	if config.MaxWorkers <= 0 {
+		config.MaxWorkers = 5
 	}
 
 	return &DefaultMetricsAggregator{
-		collectors: make(map[string]MetricsCollector),
-		maxWorkers: maxWorkers,
+		collectors: make(map[string]*registeredCollector),
+		maxWorkers: config.MaxWorkers,
+		config:     config,
+		retrier:    resilience.NewRetrier(config.Retry),
 	}
 }
 
@@ -43,7 +175,13 @@ func (a *DefaultMetricsAggregator) AddCollector(collector MetricsCollector) erro
 		return fmt.Errorf("collector with name '%s' already exists", name)
 	}
 
-	a.collectors[name] = collector
+	breakerConfig := a.config.CircuitBreaker
+	breakerConfig.Name = "collector:" + name
+
+	a.collectors[name] = &registeredCollector{
+		collector: collector,
+		breaker:   resilience.NewCircuitBreaker(breakerConfig),
+	}
 	return nil
 }
 
@@ -60,80 +198,94 @@ func (a *DefaultMetricsAggregator) RemoveCollector(name string) error {
 	return nil
 }
 
-// CollectAll gathers metrics from all registered collectors
-func (a *DefaultMetricsAggregator) CollectAll(ctx context.Context) (map[string][]MetricValue, error) {
+// CollectAll gathers metrics from all enabled collectors. Each collector is
+// retried and circuit-broken on its own, so one failing collector never
+// hides the metrics of the others.
+func (a *DefaultMetricsAggregator) CollectAll(ctx context.Context) CollectionResults {
 	a.mu.RLock()
-	collectorList := make([]MetricsCollector, 0, len(a.collectors))
-	collectorNames := make([]string, 0, len(a.collectors))
-
-	for name, collector := range a.collectors {
-		if collector.IsEnabled() {
-			collectorList = append(collectorList, collector)
-			collectorNames = append(collectorNames, name)
+	collectorList := make([]*registeredCollector, 0, len(a.collectors))
+	for _, rc := range a.collectors {
+		if rc.collector.IsEnabled() {
+			collectorList = append(collectorList, rc)
 		}
 	}
 	a.mu.RUnlock()
 
-	result := make(map[string][]MetricValue)
+	results := make(CollectionResults, len(collectorList))
 	resultMu := sync.Mutex{}
 
 	// Use worker pool pattern for concurrent collection
 	semaphore := make(chan struct{}, a.maxWorkers)
 	var wg sync.WaitGroup
-	errChan := make(chan error, len(collectorList))
 
-	for i, collector := range collectorList {
+	for _, rc := range collectorList {
 		wg.Add(1)
-		go func(idx int, c MetricsCollector) {
+		go func(rc *registeredCollector) {
 			defer wg.Done()
 
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			metrics, err := c.Collect(ctx)
-			if err != nil {
-				errChan <- fmt.Errorf("collector '%s' failed: %w", collectorNames[idx], err)
-				return
-			}
+			result := a.collect(ctx, rc)
 
 			resultMu.Lock()
-			result[collectorNames[idx]] = metrics
+			results[result.Collector] = result
 			resultMu.Unlock()
-		}(i, collector)
+		}(rc)
 	}
 
 	wg.Wait()
-	close(errChan)
-
-	// Collect first error if any
-	var firstErr error
-	for err := range errChan {
-		if firstErr == nil {
-			firstErr = err
-		}
-	}
-
-	if firstErr != nil {
-		return result, firstErr
-	}
-
-	return result, nil
+	return results
 }
 
 // CollectFrom gathers metrics from a specific collector
-func (a *DefaultMetricsAggregator) CollectFrom(ctx context.Context, collectorName string) ([]MetricValue, error) {
+func (a *DefaultMetricsAggregator) CollectFrom(ctx context.Context, collectorName string) (CollectorResult, error) {
 	a.mu.RLock()
-	collector, exists := a.collectors[collectorName]
+	rc, exists := a.collectors[collectorName]
 	a.mu.RUnlock()
 
 	if !exists {
-		return nil, fmt.Errorf("collector with name '%s' not found", collectorName)
+		return CollectorResult{Collector: collectorName}, fmt.Errorf("collector with name '%s' not found", collectorName)
 	}
 
-	if !collector.IsEnabled() {
-		return []MetricValue{}, nil
+	if !rc.collector.IsEnabled() {
+		breaker := rc.breaker.Stats()
+		return CollectorResult{Collector: collectorName, Metrics: []MetricValue{}, Circuit: breaker.State, Breaker: breaker}, nil
 	}
 
+	return a.collect(ctx, rc), nil
+}
+
+// collect runs one collector through its circuit breaker and the retrier
+func (a *DefaultMetricsAggregator) collect(ctx context.Context, rc *registeredCollector) CollectorResult {
+	result := CollectorResult{Collector: rc.collector.Name()}
+	start := time.Now()
+
+	result.Err = rc.breaker.ExecuteWithContext(ctx, func(ctx context.Context) error {
+		return a.retrier.DoWithContext(ctx, func(ctx context.Context) error {
+			result.Attempts++
+			metrics, err := safeCollect(ctx, rc.collector)
+			if err != nil {
+				return err
+			}
+			result.Metrics = metrics
+			return nil
+		})
+	})
+
+	result.Duration = time.Since(start)
+	result.Breaker = rc.breaker.Stats()
+	result.Circuit = result.Breaker.State
+	return result
+}
+
+// safeCollect turns a panicking collector into an error
+func safeCollect(ctx context.Context, collector MetricsCollector) (metrics []MetricValue, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("collector panicked: %v", r)
+		}
+	}()
 	return collector.Collect(ctx)
 }
 
@@ -2,10 +2,14 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
 	"time"
+
+	"api-monitor-go/internal/resilience"
 )
 
 // MockCollector implements MetricsCollector for testing
@@ -46,6 +50,24 @@ func (m *MockCollector) GetCallCount() int {
 	return m.callCount
 }
 
+// panicCollector panics in Collect
+type panicCollector struct {
+	MockCollector
+}
+
+func (p *panicCollector) Collect(ctx context.Context) ([]MetricValue, error) {
+	panic("boom")
+}
+
+// newTestAggregator retries three times without waiting and opens the
+// circuit after two failed collections
+func newTestAggregator() *DefaultMetricsAggregator {
+	config := DefaultAggregatorConfig()
+	config.Retry = resilience.RetryConfig{MaxAttempts: 3, InitialDelay: time.Microsecond, MaxDelay: time.Microsecond}
+	config.CircuitBreaker.MaxFailures = 2
+	return NewMetricsAggregatorWithConfig(config)
+}
+
 func TestNewMetricsAggregator(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -160,8 +182,8 @@ func TestCollectAllSuccess(t *testing.T) {
 	agg.AddCollector(collector1)
 	agg.AddCollector(collector2)
 
-	result, err := agg.CollectAll(context.Background())
-	if err != nil {
+	result := agg.CollectAll(context.Background())
+	if err := result.Err(); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -169,13 +191,17 @@ func TestCollectAllSuccess(t *testing.T) {
 		t.Fatalf("expected 2 collectors, got %d", len(result))
 	}
 
-	if len(result["cpu"]) != 1 || result["cpu"][0].Value != 45.5 {
+	if len(result["cpu"].Metrics) != 1 || result["cpu"].Metrics[0].Value != 45.5 {
 		t.Fatalf("unexpected cpu metrics")
 	}
 
-	if len(result["memory"]) != 1 || result["memory"][0].Value != 60.0 {
+	if len(result["memory"].Metrics) != 1 || result["memory"].Metrics[0].Value != 60.0 {
 		t.Fatalf("unexpected memory metrics")
 	}
+
+	if result["cpu"].Attempts != 1 {
+		t.Fatalf("expected 1 attempt, got %d", result["cpu"].Attempts)
+	}
 }
 
 func TestCollectAllDisabledCollector(t *testing.T) {
@@ -196,8 +222,8 @@ func TestCollectAllDisabledCollector(t *testing.T) {
 	agg.AddCollector(collector1)
 	agg.AddCollector(collector2)
 
-	result, err := agg.CollectAll(context.Background())
-	if err != nil {
+	result := agg.CollectAll(context.Background())
+	if err := result.Err(); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -214,7 +240,7 @@ func TestCollectAllDisabledCollector(t *testing.T) {
 }
 
 func TestCollectAllCollectorError(t *testing.T) {
-	agg := NewMetricsAggregator(5)
+	agg := newTestAggregator()
 
 	collector1 := &MockCollector{
 		name:    "cpu",
@@ -231,13 +257,68 @@ func TestCollectAllCollectorError(t *testing.T) {
 	agg.AddCollector(collector1)
 	agg.AddCollector(collector2)
 
-	result, err := agg.CollectAll(context.Background())
-	if err == nil {
-		t.Fatalf("expected error from collector")
+	result := agg.CollectAll(context.Background())
+	if err := result.Err(); err == nil || !strings.Contains(err.Error(), "memory") {
+		t.Fatalf("expected error from memory collector, got %v", err)
 	}
 
-	if len(result) != 1 {
-		t.Fatalf("expected partial results, got %d collectors", len(result))
+	if len(result) != 2 {
+		t.Fatalf("expected a result per collector, got %d", len(result))
+	}
+
+	// The failing collector is isolated and retried; the other still reports
+	if result["cpu"].Err != nil || len(result["cpu"].Metrics) != 1 {
+		t.Fatalf("unexpected cpu result %+v", result["cpu"])
+	}
+	if result["memory"].Err == nil || result["memory"].Attempts != 3 || collector2.GetCallCount() != 3 {
+		t.Fatalf("unexpected memory result %+v", result["memory"])
+	}
+
+	// Two metrics per collector plus the one cpu metric
+	metrics := result.Metrics(time.Now())
+	if len(metrics) != 5 {
+		t.Fatalf("expected 5 metrics, got %d", len(metrics))
+	}
+	for _, m := range metrics {
+		if m.Name == MetricCollectorUp && m.Tags["collector"] == "memory" && m.Value != 0 {
+			t.Fatalf("expected memory collector to be down")
+		}
+	}
+}
+
+func TestCollectAllCircuitBreaker(t *testing.T) {
+	agg := newTestAggregator()
+
+	failing := &MockCollector{name: "stripe", enabled: true, err: fmt.Errorf("rate limited")}
+	agg.AddCollector(failing)
+
+	for i := 0; i < 2; i++ {
+		if result := agg.CollectAll(context.Background()); result["stripe"].Err == nil {
+			t.Fatalf("expected error on run %d", i)
+		}
+	}
+
+	// The breaker opened after two failed runs; the collector isn't called
+	calls := failing.GetCallCount()
+	result := agg.CollectAll(context.Background())["stripe"]
+
+	var breakerErr *resilience.CircuitBreakerError
+	if !errors.As(result.Err, &breakerErr) || result.Attempts != 0 || result.Circuit != resilience.StateOpen {
+		t.Fatalf("expected open circuit, got %+v", result)
+	}
+	if failing.GetCallCount() != calls {
+		t.Fatalf("collector called while circuit open")
+	}
+}
+
+func TestCollectAllRecoversPanics(t *testing.T) {
+	agg := newTestAggregator()
+	agg.AddCollector(&panicCollector{MockCollector{name: "panics", enabled: true}})
+	agg.AddCollector(&MockCollector{name: "cpu", enabled: true, metrics: []MetricValue{{Name: "cpu_usage"}}})
+
+	result := agg.CollectAll(context.Background())
+	if result["panics"].Err == nil || result["cpu"].Err != nil {
+		t.Fatalf("unexpected results %+v", result)
 	}
 }
 
@@ -256,8 +337,8 @@ func TestCollectAllConcurrency(t *testing.T) {
 		agg.AddCollector(collector)
 	}
 
-	result, err := agg.CollectAll(context.Background())
-	if err != nil {
+	result := agg.CollectAll(context.Background())
+	if err := result.Err(); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -284,7 +365,7 @@ func TestCollectFromSuccess(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if len(result) != 1 || result[0].Value != 45.5 {
+	if result.Err != nil || len(result.Metrics) != 1 || result.Metrics[0].Value != 45.5 {
 		t.Fatalf("unexpected result")
 	}
 }
@@ -297,8 +378,8 @@ func TestCollectFromNotFound(t *testing.T) {
 		t.Fatalf("expected error for nonexistent collector")
 	}
 
-	if result != nil {
-		t.Fatalf("expected nil result")
+	if result.Metrics != nil {
+		t.Fatalf("expected no metrics")
 	}
 }
 
@@ -318,7 +399,7 @@ func TestCollectFromDisabled(t *testing.T) {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
-	if len(result) != 0 {
+	if len(result.Metrics) != 0 || result.Attempts != 0 {
 		t.Fatalf("expected empty result for disabled collector")
 	}
 }
@@ -354,8 +435,8 @@ func TestGetCollectors(t *testing.T) {
 func TestCollectAllEmptyCollectors(t *testing.T) {
 	agg := NewMetricsAggregator(5)
 
-	result, err := agg.CollectAll(context.Background())
-	if err != nil {
+	result := agg.CollectAll(context.Background())
+	if err := result.Err(); err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
 
@@ -378,8 +459,8 @@ func TestCollectAllContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	cancel()
 
-	result, err := agg.CollectAll(ctx)
-	if len(result) == 0 && err != nil {
+	result := agg.CollectAll(ctx)
+	if err := result.Err(); err != nil {
 		t.Logf("Context cancellation handled: %v", err)
 	}
 }
@@ -424,14 +505,14 @@ func TestCollectorEnableDisable(t *testing.T) {
 
 	agg.AddCollector(collector)
 
-	result1, _ := agg.CollectAll(context.Background())
+	result1 := agg.CollectAll(context.Background())
 	if len(result1) != 1 {
 		t.Fatalf("expected 1 result when enabled")
 	}
 
 	collector.SetEnabled(false)
 
-	result2, _ := agg.CollectAll(context.Background())
+	result2 := agg.CollectAll(context.Background())
 	if len(result2) != 0 {
 		t.Fatalf("expected 0 results when disabled")
 	}
@@ -453,7 +534,7 @@ func TestCollectorNameConsistency(t *testing.T) {
 		t.Fatalf("failed to collect from collector")
 	}
 
-	if result != nil {
-		t.Logf("collected from collector: %v", result)
+	if result.Collector != "test_collector" {
+		t.Fatalf("result collector name mismatch")
 	}
 }
@@ -0,0 +1,510 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register("checkout", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		if deps.DB == nil {
+			return nil, fmt.Errorf("a database connection is required")
+		}
+
+		config := DefaultCheckoutConfig()
+		if window, err := windowOption(options); err != nil {
+			return nil, err
+		} else if window > 0 {
+			config.Window = window
+		}
+		if value := options["spike_factor"]; value != "" {
+			factor, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid spike_factor: %w", err)
+			}
+			config.SpikeFactor = factor
+		}
+
+		collector := NewCheckoutMetricsCollector(deps.DB, config)
+		collector.name = name
+		return collector, nil
+	})
+}
+
+// CheckoutConfig holds checkout collector configuration
+type CheckoutConfig struct {
+	// Window is how far back funnel and abandonment metrics look
+	Window time.Duration
+
+	// Traffic over SpikeWindow is compared with the average rate over the
+	// BaselineWindow before it; a spike is at least SpikeFactor times the
+	// baseline and at least MinSpikeRPM requests per minute
+	SpikeWindow    time.Duration
+	BaselineWindow time.Duration
+	SpikeFactor    float64
+	MinSpikeRPM    float64
+}
+
+// DefaultCheckoutConfig returns default checkout collector configuration
+func DefaultCheckoutConfig() CheckoutConfig {
+	return CheckoutConfig{
+		Window:         time.Hour,
+		SpikeWindow:    5 * time.Minute,
+		BaselineWindow: 24 * time.Hour,
+		SpikeFactor:    3,
+		MinSpikeRPM:    10,
+	}
+}
+
+// CheckoutMetricsCollector computes checkout funnel, abandonment and
+// traffic metrics from the Ecommerce module's tables
+type CheckoutMetricsCollector struct {
+	name               string
+	enabled            atomic.Bool
+	lastUpdateTime     time.Time
+	collectionInterval time.Duration
+	config             CheckoutConfig
+	db                 *sql.DB
+}
+
+// NewCheckoutMetricsCollector creates a new checkout metrics collector
+func NewCheckoutMetricsCollector(db *sql.DB, config CheckoutConfig) *CheckoutMetricsCollector {
+	defaults := DefaultCheckoutConfig()
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.SpikeWindow <= 0 {
+		config.SpikeWindow = defaults.SpikeWindow
+	}
+	if config.BaselineWindow <= 0 {
+		config.BaselineWindow = defaults.BaselineWindow
+	}
+	if config.SpikeFactor <= 0 {
+		config.SpikeFactor = defaults.SpikeFactor
+	}
+	if config.MinSpikeRPM <= 0 {
+		config.MinSpikeRPM = defaults.MinSpikeRPM
+	}
+
+	collector := &CheckoutMetricsCollector{
+		name:               "checkout",
+		collectionInterval: time.Minute,
+		config:             config,
+		db:                 db,
+	}
+	collector.enabled.Store(true)
+	return collector
+}
+
+// Name returns the collector name
+func (c *CheckoutMetricsCollector) Name() string {
+	return c.name
+}
+
+// IsEnabled returns if the collector is enabled
+func (c *CheckoutMetricsCollector) IsEnabled() bool {
+	return c.enabled.Load()
+}
+
+// SetEnabled sets the enabled state
+func (c *CheckoutMetricsCollector) SetEnabled(enabled bool) {
+	c.enabled.Store(enabled)
+}
+
+// GetLastUpdateTime returns when metrics were last collected
+func (c *CheckoutMetricsCollector) GetLastUpdateTime() time.Time {
+	return c.lastUpdateTime
+}
+
+// GetCollectionInterval returns the collection interval
+func (c *CheckoutMetricsCollector) GetCollectionInterval() time.Duration {
+	return c.collectionInterval
+}
+
+// Collect gathers checkout metrics
+func (c *CheckoutMetricsCollector) Collect(ctx context.Context) ([]MetricValue, error) {
+	if !c.enabled.Load() {
+		return []MetricValue{}, nil
+	}
+
+	now := time.Now()
+	var metrics []MetricValue
+
+	funnelMetrics, err := c.collectFunnelMetrics(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect funnel metrics: %w", err)
+	}
+	metrics = append(metrics, funnelMetrics...)
+
+	abandonmentMetrics, err := c.collectAbandonmentMetrics(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect abandonment metrics: %w", err)
+	}
+	metrics = append(metrics, abandonmentMetrics...)
+
+	trafficMetrics, err := c.collectTrafficMetrics(ctx, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect traffic metrics: %w", err)
+	}
+	metrics = append(metrics, trafficMetrics...)
+
+	c.lastUpdateTime = now
+	return metrics, nil
+}
+
+// CheckoutStep is an enabled step of a store's checkout funnel
+type CheckoutStep struct {
+	StoreID string
+	Number  int
+	Name    string
+}
+
+// CheckoutVisit is when a session first reached a step
+type CheckoutVisit struct {
+	StoreID   string
+	SessionID string
+	Step      int
+	ReachedAt time.Time
+}
+
+// collectFunnelMetrics reads the enabled steps and the sessions that
+// reached them within the window
+func (c *CheckoutMetricsCollector) collectFunnelMetrics(ctx context.Context, now time.Time) ([]MetricValue, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT store_id::text, step_number, step_name
+		FROM ecommerce_checkout_steps
+		WHERE enabled = true
+		ORDER BY store_id, step_number
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkout steps: %w", err)
+	}
+	defer rows.Close()
+
+	var steps []CheckoutStep
+	for rows.Next() {
+		var step CheckoutStep
+		if err := rows.Scan(&step.StoreID, &step.Number, &step.Name); err != nil {
+			return nil, fmt.Errorf("failed to scan checkout step: %w", err)
+		}
+		steps = append(steps, step)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating checkout steps: %w", err)
+	}
+
+	visitRows, err := c.db.QueryContext(ctx, `
+		SELECT m.store_id::text, m.session_id, s.step_number, MIN(m."timestamp")
+		FROM ecommerce_checkout_metrics m
+		JOIN ecommerce_checkout_steps s ON s.id = m.step_id
+		WHERE m."timestamp" >= $1
+		  AND m.session_id IS NOT NULL
+		  AND s.enabled = true
+		GROUP BY m.store_id, m.session_id, s.step_number
+	`, now.Add(-c.config.Window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkout visits: %w", err)
+	}
+	defer visitRows.Close()
+
+	var visits []CheckoutVisit
+	for visitRows.Next() {
+		var visit CheckoutVisit
+		if err := visitRows.Scan(&visit.StoreID, &visit.SessionID, &visit.Step, &visit.ReachedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan checkout visit: %w", err)
+		}
+		visits = append(visits, visit)
+	}
+	if err := visitRows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating checkout visits: %w", err)
+	}
+
+	return CheckoutFunnelMetrics(steps, visits, now, c.name), nil
+}
+
+// CheckoutFunnelMetrics computes, per store and step, the sessions that
+// reached the step, conversion from the first step, the share of sessions
+// that dropped off after the step and the median time from the previous
+// step
+func CheckoutFunnelMetrics(steps []CheckoutStep, visits []CheckoutVisit, at time.Time, collector string) []MetricValue {
+	type stepKey struct {
+		store string
+		step  int
+	}
+
+	// When each session reached each step, by store
+	sessions := make(map[string]map[string]map[int]time.Time)
+	for _, visit := range visits {
+		if sessions[visit.StoreID] == nil {
+			sessions[visit.StoreID] = make(map[string]map[int]time.Time)
+		}
+		if sessions[visit.StoreID][visit.SessionID] == nil {
+			sessions[visit.StoreID][visit.SessionID] = make(map[int]time.Time)
+		}
+		sessions[visit.StoreID][visit.SessionID][visit.Step] = visit.ReachedAt
+	}
+
+	stepsByStore := make(map[string][]CheckoutStep)
+	for _, step := range steps {
+		stepsByStore[step.StoreID] = append(stepsByStore[step.StoreID], step)
+	}
+
+	var metrics []MetricValue
+	for storeID, storeSteps := range stepsByStore {
+		sort.Slice(storeSteps, func(i, j int) bool { return storeSteps[i].Number < storeSteps[j].Number })
+
+		reached := make(map[stepKey]int)
+		continued := make(map[stepKey]int)
+		durations := make(map[stepKey][]float64)
+
+		for _, visited := range sessions[storeID] {
+			for i, step := range storeSteps {
+				reachedAt, ok := visited[step.Number]
+				if !ok {
+					continue
+				}
+				key := stepKey{storeID, step.Number}
+				reached[key]++
+
+				for _, later := range storeSteps[i+1:] {
+					if _, ok := visited[later.Number]; ok {
+						continued[key]++
+						break
+					}
+				}
+
+				if i > 0 {
+					if previousAt, ok := visited[storeSteps[i-1].Number]; ok && !reachedAt.Before(previousAt) {
+						durations[key] = append(durations[key], reachedAt.Sub(previousAt).Seconds())
+					}
+				}
+			}
+		}
+
+		entered := reached[stepKey{storeID, storeSteps[0].Number}]
+		for i, step := range storeSteps {
+			key := stepKey{storeID, step.Number}
+			tags := map[string]string{
+				"collector": collector,
+				"store_id":  storeID,
+				"step":      step.Name,
+			}
+
+			metrics = append(metrics, MetricValue{
+				Name:        "checkout_funnel_sessions",
+				Type:        MetricTypeGauge,
+				Value:       float64(reached[key]),
+				Timestamp:   at,
+				Tags:        tags,
+				Description: "Sessions that reached the checkout step",
+			})
+
+			if entered > 0 {
+				metrics = append(metrics, MetricValue{
+					Name:        "checkout_funnel_conversion",
+					Type:        MetricTypeGauge,
+					Value:       float64(reached[key]) / float64(entered),
+					Timestamp:   at,
+					Tags:        tags,
+					Description: "Share of sessions entering checkout that reached the step",
+				})
+			}
+
+			if i < len(storeSteps)-1 && reached[key] > 0 {
+				metrics = append(metrics, MetricValue{
+					Name:        "checkout_step_dropoff",
+					Type:        MetricTypeGauge,
+					Value:       1 - float64(continued[key])/float64(reached[key]),
+					Timestamp:   at,
+					Tags:        tags,
+					Description: "Share of sessions at the step that reached no later step",
+				})
+			}
+
+			if len(durations[key]) > 0 {
+				metrics = append(metrics, MetricValue{
+					Name:        "checkout_step_duration_median_seconds",
+					Type:        MetricTypeGauge,
+					Value:       quantile(0.5, durations[key]),
+					Timestamp:   at,
+					Tags:        tags,
+					Description: "Median time from the previous step to this step",
+				})
+			}
+		}
+	}
+
+	return metrics
+}
+
+// collectAbandonmentMetrics counts abandoned sessions per store and step.
+// Carts aren't recorded, so their value is estimated from the store's
+// latest average order value.
+func (c *CheckoutMetricsCollector) collectAbandonmentMetrics(ctx context.Context, now time.Time) ([]MetricValue, error) {
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT
+			a.store_id::text,
+			COALESCE(s.step_name, 'unknown'),
+			st.currency,
+			COUNT(*),
+			COALESCE((
+				SELECT sm.avg_order_value
+				FROM ecommerce_sales_metrics sm
+				WHERE sm.store_id = a.store_id AND sm.avg_order_value IS NOT NULL
+				ORDER BY sm."timestamp" DESC
+				LIMIT 1
+			), 0)
+		FROM ecommerce_abandonment a
+		JOIN ecommerce_stores st ON st.id = a.store_id
+		LEFT JOIN ecommerce_checkout_steps s ON s.id = a.abandoned_at_step_id
+		WHERE a.last_seen >= $1
+		GROUP BY a.store_id, s.step_name, st.currency
+	`, now.Add(-c.config.Window))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query abandonment: %w", err)
+	}
+	defer rows.Close()
+
+	var metrics []MetricValue
+	for rows.Next() {
+		var storeID, step, currency string
+		var count int
+		var avgOrderValue float64
+
+		if err := rows.Scan(&storeID, &step, &currency, &count, &avgOrderValue); err != nil {
+			return nil, fmt.Errorf("failed to scan abandonment: %w", err)
+		}
+
+		tags := map[string]string{
+			"collector": c.name,
+			"store_id":  storeID,
+			"step":      step,
+		}
+		valueTags := map[string]string{
+			"collector": c.name,
+			"store_id":  storeID,
+			"step":      step,
+			"currency":  currency,
+		}
+
+		metrics = append(metrics,
+			MetricValue{
+				Name:        "checkout_abandonments",
+				Type:        MetricTypeGauge,
+				Value:       float64(count),
+				Timestamp:   now,
+				Tags:        tags,
+				Description: "Sessions abandoned at the step within the window",
+			},
+			MetricValue{
+				Name:        "checkout_abandonment_value",
+				Type:        MetricTypeGauge,
+				Value:       float64(count) * avgOrderValue,
+				Timestamp:   now,
+				Tags:        valueTags,
+				Description: "Estimated value of abandoned checkouts at the store's average order value",
+			},
+		)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating abandonment rows: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// collectTrafficMetrics compares each store's recent checkout request rate
+// with its rolling baseline
+func (c *CheckoutMetricsCollector) collectTrafficMetrics(ctx context.Context, now time.Time) ([]MetricValue, error) {
+	recentSince := now.Add(-c.config.SpikeWindow)
+	baselineSince := recentSince.Add(-c.config.BaselineWindow)
+
+	rows, err := c.db.QueryContext(ctx, `
+		SELECT
+			store_id::text,
+			COUNT(*) FILTER (WHERE "timestamp" >= $2),
+			COUNT(*) FILTER (WHERE "timestamp" < $2)
+		FROM ecommerce_checkout_metrics
+		WHERE "timestamp" >= $1
+		GROUP BY store_id
+	`, baselineSince, recentSince)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query checkout traffic: %w", err)
+	}
+	defer rows.Close()
+
+	var metrics []MetricValue
+	for rows.Next() {
+		var storeID string
+		var recent, baseline int
+
+		if err := rows.Scan(&storeID, &recent, &baseline); err != nil {
+			return nil, fmt.Errorf("failed to scan checkout traffic: %w", err)
+		}
+
+		currentRPM := float64(recent) / c.config.SpikeWindow.Minutes()
+		baselineRPM := float64(baseline) / c.config.BaselineWindow.Minutes()
+		metrics = append(metrics, TrafficSpikeMetrics(storeID, currentRPM, baselineRPM, c.config, now, c.name)...)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating traffic rows: %w", err)
+	}
+
+	return metrics, nil
+}
+
+// IsTrafficSpike reports whether currentRPM is a spike over baselineRPM
+func IsTrafficSpike(currentRPM, baselineRPM float64, config CheckoutConfig) bool {
+	if currentRPM < config.MinSpikeRPM {
+		return false
+	}
+	return currentRPM >= baselineRPM*config.SpikeFactor
+}
+
+// TrafficSpikeMetrics returns a store's current and baseline request rates
+// and whether the current rate is a spike
+func TrafficSpikeMetrics(storeID string, currentRPM, baselineRPM float64, config CheckoutConfig, at time.Time, collector string) []MetricValue {
+	tags := map[string]string{
+		"collector": collector,
+		"store_id":  storeID,
+	}
+
+	spike := 0.0
+	if IsTrafficSpike(currentRPM, baselineRPM, config) {
+		spike = 1
+	}
+
+	return []MetricValue{
+		{
+			Name:        "checkout_traffic_rpm",
+			Type:        MetricTypeGauge,
+			Value:       currentRPM,
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Checkout requests per minute",
+		},
+		{
+			Name:        "checkout_traffic_baseline_rpm",
+			Type:        MetricTypeGauge,
+			Value:       baselineRPM,
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Average checkout requests per minute over the baseline window",
+		},
+		{
+			Name:        "checkout_traffic_spike",
+			Type:        MetricTypeGauge,
+			Value:       spike,
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "1 while checkout traffic is a spike over the baseline",
+		},
+	}
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func findMetric(metrics []MetricValue, name string, tags map[string]string) (MetricValue, bool) {
+	for _, m := range metrics {
+		if m.Name != name {
+			continue
+		}
+		matches := true
+		for k, v := range tags {
+			if m.Tags[k] != v {
+				matches = false
+			}
+		}
+		if matches {
+			return m, true
+		}
+	}
+	return MetricValue{}, false
+}
+
+func TestCheckoutFunnelMetrics(t *testing.T) {
+	start := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	at := start.Add(time.Hour)
+
+	steps := []CheckoutStep{
+		{StoreID: "store-a", Number: 3, Name: "payment"},
+		{StoreID: "store-a", Number: 1, Name: "cart"},
+		{StoreID: "store-a", Number: 2, Name: "shipping"},
+		{StoreID: "store-b", Number: 1, Name: "cart"},
+	}
+
+	visit := func(session string, step int, after time.Duration) CheckoutVisit {
+		return CheckoutVisit{StoreID: "store-a", SessionID: session, Step: step, ReachedAt: start.Add(after)}
+	}
+	visits := []CheckoutVisit{
+		// Completes the funnel
+		visit("s1", 1, 0), visit("s1", 2, 30*time.Second), visit("s1", 3, 90*time.Second),
+		visit("s2", 1, 0), visit("s2", 2, 60*time.Second), visit("s2", 3, 100*time.Second),
+		// Drops off after shipping
+		visit("s3", 1, 0), visit("s3", 2, 90*time.Second),
+		// Drops off at the cart
+		visit("s4", 1, 0),
+		// Shipping wasn't tracked; counts as continuing past the cart
+		visit("s5", 1, 0), visit("s5", 3, 200*time.Second),
+	}
+
+	metrics := CheckoutFunnelMetrics(steps, visits, at, "checkout")
+
+	tests := []struct {
+		name  string
+		step  string
+		value float64
+	}{
+		{"checkout_funnel_sessions", "cart", 5},
+		{"checkout_funnel_sessions", "shipping", 3},
+		{"checkout_funnel_sessions", "payment", 3},
+		{"checkout_funnel_conversion", "cart", 1},
+		{"checkout_funnel_conversion", "shipping", 0.6},
+		{"checkout_funnel_conversion", "payment", 0.6},
+		{"checkout_step_dropoff", "cart", 0.2},
+		{"checkout_step_dropoff", "shipping", 1.0 / 3.0},
+		{"checkout_step_duration_median_seconds", "shipping", 60},
+		{"checkout_step_duration_median_seconds", "payment", 50},
+	}
+
+	for _, tt := range tests {
+		m, ok := findMetric(metrics, tt.name, map[string]string{"store_id": "store-a", "step": tt.step})
+		if !ok {
+			t.Errorf("%s{step=%s} not found", tt.name, tt.step)
+			continue
+		}
+		if math.Abs(m.Value-tt.value) > 1e-9 {
+			t.Errorf("%s{step=%s}: expected %v, got %v", tt.name, tt.step, tt.value, m.Value)
+		}
+		if !m.Timestamp.Equal(at) || m.Tags["collector"] != "checkout" {
+			t.Errorf("%s{step=%s}: unexpected timestamp or tags: %v %v", tt.name, tt.step, m.Timestamp, m.Tags)
+		}
+	}
+
+	// The last step has no drop-off
+	if _, ok := findMetric(metrics, "checkout_step_dropoff", map[string]string{"store_id": "store-a", "step": "payment"}); ok {
+		t.Error("expected no drop-off for the last step")
+	}
+
+	// A store without sessions still reports its steps, but no rates
+	if m, ok := findMetric(metrics, "checkout_funnel_sessions", map[string]string{"store_id": "store-b"}); !ok || m.Value != 0 {
+		t.Errorf("expected zero sessions for store-b, got %v (found %v)", m.Value, ok)
+	}
+	if _, ok := findMetric(metrics, "checkout_funnel_conversion", map[string]string{"store_id": "store-b"}); ok {
+		t.Error("expected no conversion for store-b")
+	}
+}
+
+func TestIsTrafficSpike(t *testing.T) {
+	config := DefaultCheckoutConfig()
+
+	tests := []struct {
+		name     string
+		current  float64
+		baseline float64
+		expected bool
+	}{
+		{"normal traffic", 40, 20, false},
+		{"three times baseline", 60, 20, true},
+		{"below minimum rate", 9, 1, false},
+		{"new store without baseline", 15, 0, true},
+		{"idle store", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsTrafficSpike(tt.current, tt.baseline, config); got != tt.expected {
+				t.Errorf("IsTrafficSpike(%v, %v) = %v, want %v", tt.current, tt.baseline, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTrafficSpikeMetrics(t *testing.T) {
+	at := time.Now()
+	metrics := TrafficSpikeMetrics("store-a", 90, 20, DefaultCheckoutConfig(), at, "checkout")
+
+	expected := map[string]float64{
+		"checkout_traffic_rpm":          90,
+		"checkout_traffic_baseline_rpm": 20,
+		"checkout_traffic_spike":        1,
+	}
+	for name, value := range expected {
+		m, ok := findMetric(metrics, name, map[string]string{"store_id": "store-a"})
+		if !ok {
+			t.Errorf("%s not found", name)
+			continue
+		}
+		if m.Value != value {
+			t.Errorf("%s: expected %v, got %v", name, value, m.Value)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"api-monitor-go/internal/resilience"
+)
+
+// Circuit breaker metrics, tagged with the breaker name
+const (
+	MetricCircuitBreakerState        = "circuit_breaker_state"
+	MetricCircuitBreakerFailureRate  = "circuit_breaker_failure_rate"
+	MetricCircuitBreakerSlowCallRate = "circuit_breaker_slow_call_rate"
+	MetricCircuitBreakerRejected     = "circuit_breaker_rejected_total"
+)
+
+// circuitStateValues encode breaker states as gauge values
+var circuitStateValues = map[resilience.CircuitBreakerState]float64{
+	resilience.StateClosed:   0,
+	resilience.StateHalfOpen: 1,
+	resilience.StateOpen:     2,
+}
+
+// CircuitBreakerMetrics returns the state, rates and rejections of a
+// circuit breaker
+func CircuitBreakerMetrics(stats resilience.CircuitBreakerStats, at time.Time) []MetricValue {
+	tags := map[string]string{"breaker": stats.Name}
+
+	return []MetricValue{
+		{
+			Name:        MetricCircuitBreakerState,
+			Type:        MetricTypeGauge,
+			Value:       circuitStateValues[stats.State],
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Circuit breaker state: 0 closed, 1 half-open, 2 open",
+		},
+		{
+			Name:        MetricCircuitBreakerFailureRate,
+			Type:        MetricTypeGauge,
+			Value:       stats.FailureRate,
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Share of failed calls in the circuit breaker's window",
+		},
+		{
+			Name:        MetricCircuitBreakerSlowCallRate,
+			Type:        MetricTypeGauge,
+			Value:       stats.SlowCallRate,
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Share of slow calls in the circuit breaker's window",
+		},
+		{
+			Name:        MetricCircuitBreakerRejected,
+			Type:        MetricTypeCounter,
+			Value:       float64(stats.Rejected),
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Calls rejected by the circuit breaker",
+		},
+	}
+}
+
+// CircuitBreakerCollector reports circuit breakers that live outside the
+// aggregator, such as the endpoint checker's. Collector breakers are
+// reported with each collector's health metrics.
+type CircuitBreakerCollector struct {
+	name     string
+	enabled  atomic.Bool
+	breakers func() []resilience.CircuitBreakerStats
+}
+
+// NewCircuitBreakerCollector creates a collector for the breakers returned
+// by breakers
+func NewCircuitBreakerCollector(breakers func() []resilience.CircuitBreakerStats) *CircuitBreakerCollector {
+	collector := &CircuitBreakerCollector{
+		name:     "circuit_breakers",
+		breakers: breakers,
+	}
+	collector.enabled.Store(true)
+	return collector
+}
+
+// Name returns the collector name
+func (c *CircuitBreakerCollector) Name() string {
+	return c.name
+}
+
+// IsEnabled returns if the collector is enabled
+func (c *CircuitBreakerCollector) IsEnabled() bool {
+	return c.enabled.Load()
+}
+
+// SetEnabled sets the enabled state
+func (c *CircuitBreakerCollector) SetEnabled(enabled bool) {
+	c.enabled.Store(enabled)
+}
+
+// Collect returns the metrics of every breaker
+func (c *CircuitBreakerCollector) Collect(ctx context.Context) ([]MetricValue, error) {
+	if !c.enabled.Load() {
+		return []MetricValue{}, nil
+	}
+
+	now := time.Now()
+	var metrics []MetricValue
+	for _, stats := range c.breakers() {
+		metrics = append(metrics, CircuitBreakerMetrics(stats, now)...)
+	}
+	return metrics, nil
+}
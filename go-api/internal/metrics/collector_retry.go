@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"api-monitor-go/internal/resilience"
+)
+
+// newRateLimitRetrier returns the retrier payment API calls go through.
+// Responses with one of statusCodes, described by a resilience.HTTPError,
+// are retried up to maxRetries times with exponential backoff between
+// initialBackoff and maxBackoff, or after the Retry-After the API asked
+// for, capped at maxBackoff. A retry that can't start before the
+// collection's deadline would only turn the rate limit into a timeout, so
+// it isn't attempted.
+func newRateLimitRetrier(maxRetries int, initialBackoff, maxBackoff time.Duration, statusCodes ...int) *resilience.Retrier {
+	return resilience.NewRetrier(resilience.RetryConfig{
+		MaxAttempts:  maxRetries + 1,
+		InitialDelay: initialBackoff,
+		MaxDelay:     maxBackoff,
+		Multiplier:   2,
+		Jitter:       true,
+		Classifier:   resilience.HTTPClassifier(statusCodes...),
+	})
+}
+
+// retryRateLimited runs fn through retrier. A rate limit it couldn't wait
+// out is reported as such.
+func retryRateLimited(ctx context.Context, retrier *resilience.Retrier, fn func(ctx context.Context) error) error {
+	err := retrier.DoWithContext(ctx, fn)
+
+	var httpErr *resilience.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("rate limited: %w", err)
+	}
+	return err
+}
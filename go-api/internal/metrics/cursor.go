@@ -0,0 +1,104 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// CursorStore persists collector state between runs, such as how far an
+// incremental collector has read
+type CursorStore interface {
+	// Load decodes the saved value into v and reports whether one existed
+	Load(ctx context.Context, collector, name string, v interface{}) (bool, error)
+
+	// Save replaces the saved value with v
+	Save(ctx context.Context, collector, name string, v interface{}) error
+}
+
+// PostgresCursorStore keeps cursors in the collector_cursors table
+type PostgresCursorStore struct {
+	db *sql.DB
+}
+
+// NewPostgresCursorStore creates a new PostgreSQL cursor store
+func NewPostgresCursorStore(db *sql.DB) *PostgresCursorStore {
+	return &PostgresCursorStore{db: db}
+}
+
+// Load decodes the saved cursor into v
+func (p *PostgresCursorStore) Load(ctx context.Context, collector, name string, v interface{}) (bool, error) {
+	var data []byte
+	err := p.db.QueryRowContext(ctx,
+		`SELECT value FROM collector_cursors WHERE collector = $1 AND name = $2`,
+		collector, name,
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load cursor: %w", err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to decode cursor: %w", err)
+	}
+	return true, nil
+}
+
+// Save upserts the cursor
+func (p *PostgresCursorStore) Save(ctx context.Context, collector, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	_, err = p.db.ExecContext(ctx, `
+		INSERT INTO collector_cursors (collector, name, value, updated_at)
+		VALUES ($1, $2, $3, CURRENT_TIMESTAMP)
+		ON CONFLICT (collector, name) DO UPDATE
+		SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at
+	`, collector, name, data)
+	if err != nil {
+		return fmt.Errorf("failed to save cursor: %w", err)
+	}
+	return nil
+}
+
+// MemoryCursorStore keeps cursors in memory; they are lost on restart
+type MemoryCursorStore struct {
+	mu     sync.Mutex
+	values map[string][]byte
+}
+
+// NewMemoryCursorStore creates a new in-memory cursor store
+func NewMemoryCursorStore() *MemoryCursorStore {
+	return &MemoryCursorStore{values: make(map[string][]byte)}
+}
+
+// Load decodes the saved cursor into v
+func (m *MemoryCursorStore) Load(ctx context.Context, collector, name string, v interface{}) (bool, error) {
+	m.mu.Lock()
+	data, exists := m.values[collector+"\x00"+name]
+	m.mu.Unlock()
+
+	if !exists {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// Save replaces the saved cursor
+func (m *MemoryCursorStore) Save(ctx context.Context, collector, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode cursor: %w", err)
+	}
+
+	m.mu.Lock()
+	m.values[collector+"\x00"+name] = data
+	m.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,461 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"api-monitor-go/internal/resilience"
+)
+
+// Payment gateway metrics share one schema so dashboards can compare
+// gateways. Every series is tagged gateway, account and collector; values
+// cover the collector's lookback window.
+const (
+	// MetricGatewayTransactions counts transactions by type and status
+	MetricGatewayTransactions = "gateway_transactions"
+
+	// MetricGatewayAuthSuccessRate is the share of payment attempts that
+	// were authorized, between 0 and 1
+	MetricGatewayAuthSuccessRate = "gateway_auth_success_rate"
+
+	// MetricGatewayDeclines counts declined payments by normalized reason
+	MetricGatewayDeclines = "gateway_declines"
+
+	// MetricGatewayVolume is the succeeded payment amount by currency
+	MetricGatewayVolume = "gateway_volume"
+
+	// MetricGatewayRefunds and MetricGatewayRefundAmount cover succeeded
+	// refunds by currency
+	MetricGatewayRefunds      = "gateway_refunds"
+	MetricGatewayRefundAmount = "gateway_refund_amount"
+
+	// MetricGatewayDisputes counts disputes by status
+	MetricGatewayDisputes = "gateway_disputes"
+)
+
+// GatewayTransactionType is the kind of a gateway transaction
+type GatewayTransactionType string
+
+const (
+	GatewayTransactionPayment GatewayTransactionType = "payment"
+	GatewayTransactionRefund  GatewayTransactionType = "refund"
+)
+
+// GatewayTransactionStatus is the normalized outcome of a transaction
+type GatewayTransactionStatus string
+
+const (
+	GatewayStatusSucceeded GatewayTransactionStatus = "succeeded"
+	GatewayStatusDeclined  GatewayTransactionStatus = "declined"
+	GatewayStatusFailed    GatewayTransactionStatus = "failed"
+	GatewayStatusPending   GatewayTransactionStatus = "pending"
+)
+
+// GatewayTransaction is a payment or refund in the common schema
+type GatewayTransaction struct {
+	ID     string
+	Type   GatewayTransactionType
+	Status GatewayTransactionStatus
+
+	// Amount is in major units and always positive
+	Amount   float64
+	Currency string
+
+	// DeclineReason is set for declined payments
+	DeclineReason string
+}
+
+// GatewayDispute is a dispute or chargeback in the common schema
+type GatewayDispute struct {
+	ID       string
+	Status   string
+	Reason   string
+	Amount   float64
+	Currency string
+}
+
+// GatewayReport is what a gateway returned for one window
+type GatewayReport struct {
+	Transactions []GatewayTransaction
+	Disputes     []GatewayDispute
+}
+
+// GatewaySource reads transactions and disputes from one gateway account
+type GatewaySource interface {
+	// Gateway returns the gateway tag, e.g. "paypal"
+	Gateway() string
+
+	// Account returns the account tag
+	Account() string
+
+	// Fetch returns the transactions and disputes created in [from, to)
+	Fetch(ctx context.Context, from, to time.Time) (GatewayReport, error)
+}
+
+// GatewayMetricsCollector reports the common gateway metrics for any
+// GatewaySource
+type GatewayMetricsCollector struct {
+	name               string
+	source             GatewaySource
+	window             time.Duration
+	enabled            atomic.Bool
+	lastUpdateTime     time.Time
+	collectionInterval time.Duration
+}
+
+// NewGatewayMetricsCollector creates a collector reporting the last window
+// of source's activity
+func NewGatewayMetricsCollector(name string, source GatewaySource, window time.Duration) *GatewayMetricsCollector {
+	if window <= 0 {
+		window = 24 * time.Hour
+	}
+
+	collector := &GatewayMetricsCollector{
+		name:               name,
+		source:             source,
+		window:             window,
+		collectionInterval: 5 * time.Minute,
+	}
+	collector.enabled.Store(true)
+	return collector
+}
+
+// Name returns the collector name
+func (g *GatewayMetricsCollector) Name() string {
+	return g.name
+}
+
+// IsEnabled returns if the collector is enabled
+func (g *GatewayMetricsCollector) IsEnabled() bool {
+	return g.enabled.Load()
+}
+
+// SetEnabled sets the enabled state
+func (g *GatewayMetricsCollector) SetEnabled(enabled bool) {
+	g.enabled.Store(enabled)
+}
+
+// GetLastUpdateTime returns when metrics were last collected
+func (g *GatewayMetricsCollector) GetLastUpdateTime() time.Time {
+	return g.lastUpdateTime
+}
+
+// GetCollectionInterval returns the collection interval
+func (g *GatewayMetricsCollector) GetCollectionInterval() time.Duration {
+	return g.collectionInterval
+}
+
+// Collect fetches the window from the gateway and converts it
+func (g *GatewayMetricsCollector) Collect(ctx context.Context) ([]MetricValue, error) {
+	if !g.enabled.Load() {
+		return []MetricValue{}, nil
+	}
+
+	now := time.Now()
+	report, err := g.source.Fetch(ctx, now.Add(-g.window), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s report: %w", g.source.Gateway(), err)
+	}
+
+	g.lastUpdateTime = now
+	return GatewayMetrics(report, now, map[string]string{
+		"collector": g.name,
+		"gateway":   g.source.Gateway(),
+		"account":   g.source.Account(),
+	}), nil
+}
+
+// GatewayMetrics converts a report into the common gateway metrics. Every
+// metric carries the base tags.
+func GatewayMetrics(report GatewayReport, at time.Time, base map[string]string) []MetricValue {
+	tags := func(extra ...string) map[string]string {
+		t := make(map[string]string, len(base)+len(extra)/2)
+		for k, v := range base {
+			t[k] = v
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			t[extra[i]] = extra[i+1]
+		}
+		return t
+	}
+
+	counts := make(map[GatewayTransactionType]map[GatewayTransactionStatus]int)
+	declines := make(map[string]int)
+	volume := make(map[string]float64)
+	refunds := make(map[string]int)
+	refundAmounts := make(map[string]float64)
+
+	for _, txn := range report.Transactions {
+		if counts[txn.Type] == nil {
+			counts[txn.Type] = make(map[GatewayTransactionStatus]int)
+		}
+		counts[txn.Type][txn.Status]++
+
+		currency := strings.ToLower(txn.Currency)
+		switch {
+		case txn.Type == GatewayTransactionPayment && txn.Status == GatewayStatusSucceeded:
+			volume[currency] += txn.Amount
+		case txn.Type == GatewayTransactionPayment && txn.Status == GatewayStatusDeclined:
+			declines[NormalizeDeclineReason(txn.DeclineReason)]++
+		case txn.Type == GatewayTransactionRefund && txn.Status == GatewayStatusSucceeded:
+			refunds[currency]++
+			refundAmounts[currency] += txn.Amount
+		}
+	}
+
+	var metrics []MetricValue
+
+	// Every type and status is reported, including zeros, so series don't
+	// disappear during quiet windows
+	for _, txnType := range []GatewayTransactionType{GatewayTransactionPayment, GatewayTransactionRefund} {
+		for _, status := range []GatewayTransactionStatus{GatewayStatusSucceeded, GatewayStatusDeclined, GatewayStatusFailed, GatewayStatusPending} {
+			metrics = append(metrics, MetricValue{
+				Name:        MetricGatewayTransactions,
+				Type:        MetricTypeGauge,
+				Value:       float64(counts[txnType][status]),
+				Timestamp:   at,
+				Tags:        tags("type", string(txnType), "status", string(status)),
+				Description: "Transactions in the window by type and status",
+			})
+		}
+	}
+
+	payments := counts[GatewayTransactionPayment]
+	attempts := payments[GatewayStatusSucceeded] + payments[GatewayStatusDeclined] + payments[GatewayStatusFailed]
+	if attempts > 0 {
+		metrics = append(metrics, MetricValue{
+			Name:        MetricGatewayAuthSuccessRate,
+			Type:        MetricTypeGauge,
+			Value:       float64(payments[GatewayStatusSucceeded]) / float64(attempts),
+			Timestamp:   at,
+			Tags:        tags(),
+			Description: "Share of completed payment attempts that were authorized",
+		})
+	}
+
+	for reason, count := range declines {
+		metrics = append(metrics, MetricValue{
+			Name:        MetricGatewayDeclines,
+			Type:        MetricTypeGauge,
+			Value:       float64(count),
+			Timestamp:   at,
+			Tags:        tags("reason", reason),
+			Description: "Declined payments in the window by reason",
+		})
+	}
+
+	for currency, amount := range volume {
+		metrics = append(metrics, MetricValue{
+			Name:        MetricGatewayVolume,
+			Type:        MetricTypeGauge,
+			Value:       amount,
+			Timestamp:   at,
+			Tags:        tags("currency", currency),
+			Description: "Succeeded payment amount in the window",
+		})
+	}
+
+	for currency, count := range refunds {
+		metrics = append(metrics,
+			MetricValue{
+				Name:        MetricGatewayRefunds,
+				Type:        MetricTypeGauge,
+				Value:       float64(count),
+				Timestamp:   at,
+				Tags:        tags("currency", currency),
+				Description: "Succeeded refunds in the window",
+			},
+			MetricValue{
+				Name:        MetricGatewayRefundAmount,
+				Type:        MetricTypeGauge,
+				Value:       refundAmounts[currency],
+				Timestamp:   at,
+				Tags:        tags("currency", currency),
+				Description: "Refunded amount in the window",
+			},
+		)
+	}
+
+	disputes := make(map[string]int)
+	for _, dispute := range report.Disputes {
+		disputes[dispute.Status]++
+	}
+	for status, count := range disputes {
+		metrics = append(metrics, MetricValue{
+			Name:        MetricGatewayDisputes,
+			Type:        MetricTypeGauge,
+			Value:       float64(count),
+			Timestamp:   at,
+			Tags:        tags("status", status),
+			Description: "Disputes opened in the window by status",
+		})
+	}
+
+	return metrics
+}
+
+// NormalizeDeclineReason turns a gateway's decline reason into a tag
+// value, e.g. "Not enough balance" becomes "not_enough_balance"
+func NormalizeDeclineReason(reason string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(strings.TrimSpace(reason)) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			underscore = false
+			continue
+		}
+		if !underscore && b.Len() > 0 {
+			b.WriteByte('_')
+			underscore = true
+		}
+	}
+
+	normalized := strings.TrimSuffix(b.String(), "_")
+	if normalized == "" {
+		return "unknown"
+	}
+	return normalized
+}
+
+// windowOption parses the optional "window" option shared by gateway
+// collectors
+func windowOption(options map[string]string) (time.Duration, error) {
+	if options["window"] == "" {
+		return 0, nil
+	}
+	window, err := ParseDuration(options["window"])
+	if err != nil {
+		return 0, fmt.Errorf("invalid window: %w", err)
+	}
+	return window, nil
+}
+
+// GatewayHTTPConfig holds the HTTP settings shared by gateway sources
+type GatewayHTTPConfig struct {
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// Rate-limited (429) and unavailable (503) responses are retried up to
+	// MaxRetries times, honoring Retry-After up to MaxBackoff, as long as
+	// the wait ends before the collection's deadline
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// MaxPages stops runaway pagination
+	MaxPages int
+}
+
+// DefaultGatewayHTTPConfig returns default gateway HTTP configuration
+func DefaultGatewayHTTPConfig() GatewayHTTPConfig {
+	return GatewayHTTPConfig{
+		HTTPClient:     &http.Client{Timeout: 15 * time.Second},
+		MaxRetries:     5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		MaxPages:       100,
+	}
+}
+
+// withDefaults fills in unset fields
+func (c GatewayHTTPConfig) withDefaults() GatewayHTTPConfig {
+	defaults := DefaultGatewayHTTPConfig()
+	if c.HTTPClient == nil {
+		c.HTTPClient = defaults.HTTPClient
+	}
+	if c.MaxRetries <= 0 {
+		c.MaxRetries = defaults.MaxRetries
+	}
+	if c.InitialBackoff <= 0 {
+		c.InitialBackoff = defaults.InitialBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaults.MaxBackoff
+	}
+	if c.MaxPages <= 0 {
+		c.MaxPages = defaults.MaxPages
+	}
+	c.BaseURL = strings.TrimSuffix(c.BaseURL, "/")
+	return c
+}
+
+// GatewayError is a non-2xx gateway response
+type GatewayError struct {
+	StatusCode int
+	Body       string
+	// RetryAfter is the delay from the Retry-After header, zero if absent
+	RetryAfter time.Duration
+}
+
+func (e *GatewayError) Error() string {
+	return fmt.Sprintf("gateway responded %d: %s", e.StatusCode, e.Body)
+}
+
+// Unwrap describes the response to the retrier's classifier
+func (e *GatewayError) Unwrap() error {
+	return &resilience.HTTPError{StatusCode: e.StatusCode, RetryAfter: e.RetryAfter}
+}
+
+// nextPage resolves a pagination link against BaseURL. Requests carry the
+// account's credentials, so a link to any other host is refused.
+func (c GatewayHTTPConfig) nextPage(href string) (string, error) {
+	base, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL: %w", err)
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid next page link: %w", err)
+	}
+
+	next := base.ResolveReference(ref)
+	if next.Scheme != base.Scheme || next.Host != base.Host {
+		return "", fmt.Errorf("next page link %q leaves %s", href, base.Host)
+	}
+	return next.String(), nil
+}
+
+// doJSON sends the request built by newRequest and decodes the JSON
+// response into v, retrying rate-limited and unavailable responses
+func (c GatewayHTTPConfig) doJSON(ctx context.Context, newRequest func() (*http.Request, error), v interface{}) error {
+	retrier := newRateLimitRetrier(c.MaxRetries, c.InitialBackoff, c.MaxBackoff, http.StatusTooManyRequests, http.StatusServiceUnavailable)
+
+	return retryRateLimited(ctx, retrier, func(ctx context.Context) error {
+		req, err := newRequest()
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
+		}
+
+		resp, err := c.HTTPClient.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("request failed: %w", err)
+		}
+
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read response: %w", err)
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return &GatewayError{
+				StatusCode: resp.StatusCode,
+				Body:       strings.TrimSpace(string(body)),
+				RetryAfter: resilience.NewHTTPError(resp).RetryAfter,
+			}
+		}
+
+		if err := json.Unmarshal(body, v); err != nil {
+			return fmt.Errorf("failed to decode response: %w", err)
+		}
+		return nil
+	})
+}
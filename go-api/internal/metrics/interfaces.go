@@ -48,11 +48,13 @@ type MetricsAggregator interface {
 	// RemoveCollector removes a collector by name
 	RemoveCollector(name string) error
 
-	// CollectAll gathers metrics from all registered collectors
-	CollectAll(ctx context.Context) (map[string][]MetricValue, error)
+	// CollectAll gathers metrics from all enabled collectors. A failing
+	// collector doesn't affect the others; its error is in its result.
+	CollectAll(ctx context.Context) CollectionResults
 
-	// CollectFrom gathers metrics from a specific collector
-	CollectFrom(ctx context.Context, collectorName string) ([]MetricValue, error)
+	// CollectFrom gathers metrics from a specific collector. The error is
+	// only set when no such collector is registered.
+	CollectFrom(ctx context.Context, collectorName string) (CollectorResult, error)
 
 	// GetCollectors returns list of registered collectors
 	GetCollectors() []string
@@ -69,8 +71,19 @@ type MetricsStore interface {
 	// RetrieveByName fetches metrics by name
 	RetrieveByName(ctx context.Context, name string, startTime, endTime time.Time) ([]MetricValue, error)
 
-	// Aggregate calculates aggregate statistics for a metric
-	Aggregate(ctx context.Context, name string, startTime, endTime time.Time) (map[string]interface{}, error)
+	// Select fetches metrics by name whose tags satisfy all matchers, oldest first
+	Select(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time) ([]MetricValue, error)
+
+	// Aggregate calculates statistics, percentiles and histograms for a
+	// metric, optionally grouped by tags and split into time buckets
+	Aggregate(ctx context.Context, query AggregateQuery) (*AggregateResult, error)
+}
+
+// MetricsWriter is implemented by stores whose Store only queues metrics
+// and that can also write them synchronously
+type MetricsWriter interface {
+	// Write saves metrics to persistent storage before returning
+	Write(ctx context.Context, metrics []MetricValue) error
 }
 
 // MetricsPublisher defines the interface for publishing metrics
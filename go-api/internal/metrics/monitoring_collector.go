@@ -4,15 +4,27 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	"api-monitor-saas/internal/database"
+	"api-monitor-go/internal/outbox"
 )
 
+func init() {
+	Register("monitoring", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		if deps.DB == nil {
+			return nil, fmt.Errorf("a database connection is required")
+		}
+		collector := NewMonitoringMetricsCollector(deps.DB)
+		collector.name = name
+		return collector, nil
+	})
+}
+
 // MonitoringMetricsCollector collects metrics from the monitoring system
 type MonitoringMetricsCollector struct {
 	name               string
-	enabled            bool
+	enabled            atomic.Bool
 	lastUpdateTime     time.Time
 	collectionInterval time.Duration
 	db                 *sql.DB
@@ -20,12 +32,13 @@ type MonitoringMetricsCollector struct {
 
 // NewMonitoringMetricsCollector creates a new monitoring metrics collector
 func NewMonitoringMetricsCollector(db *sql.DB) *MonitoringMetricsCollector {
-	return &MonitoringMetricsCollector{
+	collector := &MonitoringMetricsCollector{
 		name:               "monitoring",
-		enabled:            true,
 		collectionInterval: 5 * time.Minute,
 		db:                 db,
 	}
+	collector.enabled.Store(true)
+	return collector
 }
 
 // Name returns the collector name
@@ -35,12 +48,12 @@ func (m *MonitoringMetricsCollector) Name() string {
 
 // IsEnabled returns if the collector is enabled
 func (m *MonitoringMetricsCollector) IsEnabled() bool {
-	return m.enabled
+	return m.enabled.Load()
 }
 
 // SetEnabled sets the enabled state
 func (m *MonitoringMetricsCollector) SetEnabled(enabled bool) {
-	m.enabled = enabled
+	m.enabled.Store(enabled)
 }
 
 // GetLastUpdateTime returns when metrics were last collected
@@ -55,7 +68,7 @@ func (m *MonitoringMetricsCollector) GetCollectionInterval() time.Duration {
 
 // Collect gathers metrics from the monitoring system
 func (m *MonitoringMetricsCollector) Collect(ctx context.Context) ([]MetricValue, error) {
-	if !m.enabled {
+	if !m.enabled.Load() {
 		return []MetricValue{}, nil
 	}
 
@@ -89,6 +102,13 @@ func (m *MonitoringMetricsCollector) Collect(ctx context.Context) ([]MetricValue
 	}
 	metrics = append(metrics, perfMetrics...)
 
+	// Collect result fan-out backlog
+	outboxMetrics, err := m.collectOutboxMetrics(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect outbox metrics: %w", err)
+	}
+	metrics = append(metrics, outboxMetrics...)
+
 	m.lastUpdateTime = time.Now()
 	return metrics, nil
 }
@@ -100,7 +120,7 @@ func (m *MonitoringMetricsCollector) collectEndpointMetrics(ctx context.Context)
 
 	// Get total endpoints count
 	var totalEndpoints int
-	err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM endpoints WHERE deleted_at IS NULL").
+	err := m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM api_endpoints WHERE deleted_at IS NULL").
 		Scan(&totalEndpoints)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to count endpoints: %w", err)
@@ -108,7 +128,7 @@ func (m *MonitoringMetricsCollector) collectEndpointMetrics(ctx context.Context)
 
 	// Get active endpoints count
 	var activeEndpoints int
-	err = m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM endpoints WHERE deleted_at IS NULL AND is_active = true").
+	err = m.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM api_endpoints WHERE deleted_at IS NULL AND is_active = true").
 		Scan(&activeEndpoints)
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("failed to count active endpoints: %w", err)
@@ -119,7 +139,7 @@ func (m *MonitoringMetricsCollector) collectEndpointMetrics(ctx context.Context)
 		SELECT 
 			protocol,
 			COUNT(*) as count
-		FROM endpoints
+		FROM api_endpoints
 		WHERE deleted_at IS NULL
 		GROUP BY protocol
 	`
@@ -212,8 +232,8 @@ func (m *MonitoringMetricsCollector) collectMonitoringResultMetrics(ctx context.
 	// Get average response time in last 24 hours
 	var avgResponseTime sql.NullFloat64
 	err = m.db.QueryRowContext(ctx,
-		`SELECT AVG(response_time_ms) FROM monitoring_results 
-		 WHERE created_at > $1 AND response_time_ms > 0`,
+		`SELECT AVG(response_time) FROM monitoring_results 
+		 WHERE created_at > $1 AND response_time > 0`,
 		oneDayAgo).
 		Scan(&avgResponseTime)
 	if err != nil && err != sql.ErrNoRows {
@@ -223,8 +243,8 @@ func (m *MonitoringMetricsCollector) collectMonitoringResultMetrics(ctx context.
 	// Get max response time
 	var maxResponseTime sql.NullFloat64
 	err = m.db.QueryRowContext(ctx,
-		`SELECT MAX(response_time_ms) FROM monitoring_results 
-		 WHERE created_at > $1 AND response_time_ms > 0`,
+		`SELECT MAX(response_time) FROM monitoring_results 
+		 WHERE created_at > $1 AND response_time > 0`,
 		oneDayAgo).
 		Scan(&maxResponseTime)
 	if err != nil && err != sql.ErrNoRows {
@@ -305,7 +325,7 @@ func (m *MonitoringMetricsCollector) collectAlertMetrics(ctx context.Context) ([
 	var triggeredAlerts int
 	err = m.db.QueryRowContext(ctx,
 		`SELECT COUNT(*) FROM alerts 
-		 WHERE triggered_at IS NOT NULL AND triggered_at > $1`,
+		 WHERE last_triggered_at IS NOT NULL AND last_triggered_at > $1`,
 		oneDayAgo).
 		Scan(&triggeredAlerts)
 	if err != nil && err != sql.ErrNoRows {
@@ -386,3 +406,55 @@ func (m *MonitoringMetricsCollector) collectPerformanceMetrics(ctx context.Conte
 
 	return metrics, nil
 }
+
+// collectOutboxMetrics collects the backlog of undelivered results per sink
+func (m *MonitoringMetricsCollector) collectOutboxMetrics(ctx context.Context) ([]MetricValue, error) {
+	backlog, err := outbox.NewPostgresStore(m.db).Backlog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return OutboxBacklogMetrics(backlog, time.Now(), m.name), nil
+}
+
+// OutboxBacklogMetrics converts outbox backlog counts to metrics tagged by
+// sink
+func OutboxBacklogMetrics(backlog []outbox.Backlog, at time.Time, collector string) []MetricValue {
+	var metrics []MetricValue
+	for _, b := range backlog {
+		tags := map[string]string{"collector": collector, "sink": b.Sink}
+
+		var oldestAge float64
+		if !b.OldestPending.IsZero() {
+			oldestAge = at.Sub(b.OldestPending).Seconds()
+		}
+
+		metrics = append(metrics,
+			MetricValue{
+				Name:        "outbox_backlog",
+				Type:        MetricTypeGauge,
+				Value:       float64(b.Pending),
+				Timestamp:   at,
+				Tags:        tags,
+				Description: fmt.Sprintf("Results waiting to be delivered to %s", b.Sink),
+			},
+			MetricValue{
+				Name:        "outbox_oldest_pending_seconds",
+				Type:        MetricTypeGauge,
+				Value:       oldestAge,
+				Timestamp:   at,
+				Tags:        tags,
+				Description: fmt.Sprintf("Age of the oldest result waiting for %s", b.Sink),
+			},
+			MetricValue{
+				Name:        "outbox_dead",
+				Type:        MetricTypeGauge,
+				Value:       float64(b.Dead),
+				Timestamp:   at,
+				Tags:        tags,
+				Description: fmt.Sprintf("Results that could not be delivered to %s", b.Sink),
+			},
+		)
+	}
+	return metrics
+}
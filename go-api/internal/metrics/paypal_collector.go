@@ -0,0 +1,332 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	Register("paypal", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		clientID, err := requireOption(options, "client_id")
+		if err != nil {
+			return nil, err
+		}
+		clientSecret, err := requireOption(options, "client_secret")
+		if err != nil {
+			return nil, err
+		}
+		window, err := windowOption(options)
+		if err != nil {
+			return nil, err
+		}
+
+		source := NewPayPalSource(PayPalConfig{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Account:      options["account"],
+			HTTP:         GatewayHTTPConfig{BaseURL: options["base_url"]},
+		})
+		return NewGatewayMetricsCollector(name, source, window), nil
+	})
+}
+
+// paypalPageSize is the largest page the transaction search returns
+const paypalPageSize = 500
+
+// PayPalConfig holds PayPal collector configuration
+type PayPalConfig struct {
+	ClientID     string
+	ClientSecret string
+
+	// Account tags the metrics; defaults to the client ID
+	Account string
+
+	// HTTP.BaseURL defaults to the live REST API
+	HTTP GatewayHTTPConfig
+}
+
+// PayPalSource reads transactions from PayPal's transaction search
+// (/v1/reporting/transactions) and disputes from /v1/customer/disputes
+type PayPalSource struct {
+	config PayPalConfig
+	http   GatewayHTTPConfig
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewPayPalSource creates a new PayPal gateway source
+func NewPayPalSource(config PayPalConfig) *PayPalSource {
+	if config.HTTP.BaseURL == "" {
+		config.HTTP.BaseURL = "https://api-m.paypal.com"
+	}
+	if config.Account == "" {
+		config.Account = config.ClientID
+	}
+
+	return &PayPalSource{
+		config: config,
+		http:   config.HTTP.withDefaults(),
+	}
+}
+
+// Gateway returns "paypal"
+func (p *PayPalSource) Gateway() string {
+	return "paypal"
+}
+
+// Account returns the account tag
+func (p *PayPalSource) Account() string {
+	return p.config.Account
+}
+
+// Fetch returns the transactions and disputes created in [from, to)
+func (p *PayPalSource) Fetch(ctx context.Context, from, to time.Time) (GatewayReport, error) {
+	var report GatewayReport
+
+	transactions, err := p.fetchTransactions(ctx, from, to)
+	if err != nil {
+		return report, fmt.Errorf("failed to list transactions: %w", err)
+	}
+	report.Transactions = transactions
+
+	disputes, err := p.fetchDisputes(ctx, from, to)
+	if err != nil {
+		return report, fmt.Errorf("failed to list disputes: %w", err)
+	}
+	report.Disputes = disputes
+
+	return report, nil
+}
+
+type paypalMoney struct {
+	CurrencyCode string `json:"currency_code"`
+	Value        string `json:"value"`
+}
+
+// amount returns the absolute value; refunds are negative
+func (m paypalMoney) amount() float64 {
+	value, _ := strconv.ParseFloat(m.Value, 64)
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+type paypalTransactionsPage struct {
+	TransactionDetails []struct {
+		TransactionInfo struct {
+			TransactionID     string      `json:"transaction_id"`
+			EventCode         string      `json:"transaction_event_code"`
+			TransactionStatus string      `json:"transaction_status"`
+			Amount            paypalMoney `json:"transaction_amount"`
+		} `json:"transaction_info"`
+	} `json:"transaction_details"`
+	Page       int `json:"page"`
+	TotalPages int `json:"total_pages"`
+}
+
+func (p *PayPalSource) fetchTransactions(ctx context.Context, from, to time.Time) ([]GatewayTransaction, error) {
+	var transactions []GatewayTransaction
+
+	for page := 1; page <= p.http.MaxPages; page++ {
+		query := url.Values{
+			"start_date": {from.UTC().Format(time.RFC3339)},
+			"end_date":   {to.UTC().Format(time.RFC3339)},
+			"fields":     {"transaction_info"},
+			"page_size":  {strconv.Itoa(paypalPageSize)},
+			"page":       {strconv.Itoa(page)},
+		}
+
+		var result paypalTransactionsPage
+		if err := p.get(ctx, p.http.BaseURL+"/v1/reporting/transactions?"+query.Encode(), &result); err != nil {
+			return nil, err
+		}
+
+		for _, detail := range result.TransactionDetails {
+			info := detail.TransactionInfo
+			txnType, ok := paypalTransactionType(info.EventCode)
+			if !ok {
+				continue
+			}
+
+			txn := GatewayTransaction{
+				ID:       info.TransactionID,
+				Type:     txnType,
+				Status:   paypalTransactionStatus(info.TransactionStatus),
+				Amount:   info.Amount.amount(),
+				Currency: info.Amount.CurrencyCode,
+			}
+			if txn.Status == GatewayStatusDeclined {
+				// Transaction search doesn't say why a payment was denied
+				txn.DeclineReason = "denied"
+			}
+			transactions = append(transactions, txn)
+		}
+
+		if page >= result.TotalPages {
+			return transactions, nil
+		}
+	}
+
+	return nil, fmt.Errorf("more than %d pages", p.http.MaxPages)
+}
+
+// paypalTransactionType maps a transaction event code to a type. T00xx
+// codes are payments and T1107 is a refund; fees, transfers and holds are
+// skipped.
+func paypalTransactionType(eventCode string) (GatewayTransactionType, bool) {
+	switch {
+	case strings.HasPrefix(eventCode, "T00"):
+		return GatewayTransactionPayment, true
+	case eventCode == "T1107":
+		return GatewayTransactionRefund, true
+	}
+	return "", false
+}
+
+// paypalTransactionStatus maps S, D, P and V status codes
+func paypalTransactionStatus(status string) GatewayTransactionStatus {
+	switch status {
+	case "S":
+		return GatewayStatusSucceeded
+	case "D":
+		return GatewayStatusDeclined
+	case "P":
+		return GatewayStatusPending
+	}
+	return GatewayStatusFailed
+}
+
+type paypalDisputesPage struct {
+	Items []struct {
+		DisputeID     string      `json:"dispute_id"`
+		Reason        string      `json:"reason"`
+		Status        string      `json:"status"`
+		DisputeAmount paypalMoney `json:"dispute_amount"`
+		CreateTime    time.Time   `json:"create_time"`
+	} `json:"items"`
+	Links []struct {
+		Href string `json:"href"`
+		Rel  string `json:"rel"`
+	} `json:"links"`
+}
+
+func (p *PayPalSource) fetchDisputes(ctx context.Context, from, to time.Time) ([]GatewayDispute, error) {
+	var disputes []GatewayDispute
+
+	query := url.Values{
+		"start_time": {from.UTC().Format(time.RFC3339)},
+		"page_size":  {"50"},
+	}
+	next := p.http.BaseURL + "/v1/customer/disputes?" + query.Encode()
+
+	for page := 0; next != ""; page++ {
+		if page >= p.http.MaxPages {
+			return nil, fmt.Errorf("more than %d pages", p.http.MaxPages)
+		}
+
+		var result paypalDisputesPage
+		if err := p.get(ctx, next, &result); err != nil {
+			return nil, err
+		}
+
+		for _, item := range result.Items {
+			// The API only filters by start time
+			if !item.CreateTime.IsZero() && !item.CreateTime.Before(to) {
+				continue
+			}
+			disputes = append(disputes, GatewayDispute{
+				ID:       item.DisputeID,
+				Status:   strings.ToLower(item.Status),
+				Reason:   strings.ToLower(item.Reason),
+				Amount:   item.DisputeAmount.amount(),
+				Currency: item.DisputeAmount.CurrencyCode,
+			})
+		}
+
+		next = ""
+		for _, link := range result.Links {
+			if link.Rel != "next" {
+				continue
+			}
+			var err error
+			if next, err = p.http.nextPage(link.Href); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return disputes, nil
+}
+
+// get performs an authenticated GET, refreshing the access token once if
+// PayPal rejects it
+func (p *PayPalSource) get(ctx context.Context, rawURL string, v interface{}) error {
+	for attempt := 0; ; attempt++ {
+		token, err := p.accessToken(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = p.http.doJSON(ctx, func() (*http.Request, error) {
+			req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+			if err != nil {
+				return nil, err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			req.Header.Set("Accept", "application/json")
+			return req, nil
+		}, v)
+
+		var gatewayErr *GatewayError
+		if attempt == 0 && errors.As(err, &gatewayErr) && gatewayErr.StatusCode == http.StatusUnauthorized {
+			p.mu.Lock()
+			p.token = ""
+			p.mu.Unlock()
+			continue
+		}
+		return err
+	}
+}
+
+// accessToken returns a cached OAuth2 client-credentials token
+func (p *PayPalSource) accessToken(ctx context.Context) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	err := p.http.doJSON(ctx, func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, p.http.BaseURL+"/v1/oauth2/token", strings.NewReader("grant_type=client_credentials"))
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(p.config.ClientID, p.config.ClientSecret)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Accept", "application/json")
+		return req, nil
+	}, &result)
+	if err != nil {
+		return "", fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	// Refresh a minute early so requests don't race the expiry
+	p.token = result.AccessToken
+	p.tokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return p.token, nil
+}
@@ -5,8 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"sync"
+	"time"
 
-	"github.com/go-redis/redis/v8"
+	"api-monitor-go/internal/config"
+	"api-monitor-go/internal/events"
+	"api-monitor-go/internal/streams"
+	"github.com/redis/go-redis/v9"
 )
 
 // RedisMetricsPublisher publishes metrics to Redis streams
@@ -14,19 +18,73 @@ type RedisMetricsPublisher struct {
 	client   *redis.Client
 	handlers []MetricsHandler
 	mu       sync.RWMutex
-	streamKey string
+	config   PublisherConfig
 }
 
-// NewRedisMetricsPublisher creates a new Redis metrics publisher
+// PublisherConfig holds Redis metrics publisher configuration
+type PublisherConfig struct {
+	StreamKey string
+	Trim      streams.TrimConfig
+
+	// Events controls the envelope encoding; Source names this publisher
+	// in each envelope
+	Events events.StreamConfig
+	Source string
+}
+
+// DefaultPublisherConfig returns default publisher configuration
+func DefaultPublisherConfig() PublisherConfig {
+	return PublisherConfig{
+		StreamKey: "metrics:stream",
+		Trim:      streams.DefaultTrimConfig(),
+		Events:    events.DefaultStreamConfig(),
+		Source:    "go-api",
+	}
+}
+
+// PublisherConfigFromConfig returns the configuration for publishing to
+// the system metrics stream as source
+func PublisherConfigFromConfig(cfg *config.Config, source string) (PublisherConfig, error) {
+	eventConfig, err := events.StreamConfigFromConfig(cfg)
+	if err != nil {
+		return PublisherConfig{}, err
+	}
+
+	return PublisherConfig{
+		StreamKey: cfg.SystemMetricsStream,
+		Trim:      streams.TrimFromConfig(cfg),
+		Events:    eventConfig,
+		Source:    source,
+	}, nil
+}
+
+// NewRedisMetricsPublisher creates a new Redis metrics publisher with the
+// default configuration
 func NewRedisMetricsPublisher(client *redis.Client, streamKey string) *RedisMetricsPublisher {
-	if streamKey == "" {
-		streamKey = "metrics:stream"
+	config := DefaultPublisherConfig()
+	if streamKey != "" {
+		config.StreamKey = streamKey
+	}
+	return NewRedisMetricsPublisherWithConfig(client, config)
+}
+
+// NewRedisMetricsPublisherWithConfig creates a Redis metrics publisher
+func NewRedisMetricsPublisherWithConfig(client *redis.Client, config PublisherConfig) *RedisMetricsPublisher {
+	defaults := DefaultPublisherConfig()
+	if config.StreamKey == "" {
+		config.StreamKey = defaults.StreamKey
+	}
+	if config.Events.Encoding == "" {
+		config.Events.Encoding = defaults.Events.Encoding
+	}
+	if config.Source == "" {
+		config.Source = defaults.Source
 	}
 
 	return &RedisMetricsPublisher{
-		client:    client,
-		handlers:  make([]MetricsHandler, 0),
-		streamKey: streamKey,
+		client:   client,
+		handlers: make([]MetricsHandler, 0),
+		config:   config,
 	}
 }
 
@@ -87,24 +145,20 @@ func (p *RedisMetricsPublisher) Unsubscribe(handler MetricsHandler) error {
 // publishToRedis publishes metrics to Redis stream
 func (p *RedisMetricsPublisher) publishToRedis(ctx context.Context, metrics []MetricValue) error {
 	for _, metric := range metrics {
-		data := map[string]interface{}{
-			"name":        metric.Name,
-			"type":        metric.Type,
-			"value":       metric.Value,
-			"timestamp":   metric.Timestamp.Unix(),
-			"description": metric.Description,
-		}
-
-		// Add tags as individual fields
-		for k, v := range metric.Tags {
-			data["tag_"+k] = v
+		envelope := events.New(ctx, p.config.Source, MetricEvent(metric))
+		data, err := p.config.Events.StreamValues(envelope)
+		if err != nil {
+			return fmt.Errorf("failed to encode metric event: %w", err)
 		}
 
 		// Add to Redis stream
-		err := p.client.XAdd(ctx, &redis.XAddArgs{
-			Stream: p.streamKey,
+		args := &redis.XAddArgs{
+			Stream: p.config.StreamKey,
 			Values: data,
-		}).Err()
+		}
+		p.config.Trim.Apply(args, time.Now())
+
+		err = p.client.XAdd(ctx, args).Err()
 
 		if err != nil {
 			return fmt.Errorf("failed to add metric to stream: %w", err)
@@ -126,6 +180,18 @@ func (p *RedisMetricsPublisher) publishToRedis(ctx context.Context, metrics []Me
 	return nil
 }
 
+// MetricEvent returns the event payload for a metric value
+func MetricEvent(metric MetricValue) *events.Metric {
+	return &events.Metric{
+		Name:        metric.Name,
+		Type:        string(metric.Type),
+		Value:       metric.Value,
+		Timestamp:   metric.Timestamp.UTC(),
+		Tags:        metric.Tags,
+		Description: metric.Description,
+	}
+}
+
 // GetHandlerCount returns the number of registered handlers
 func (p *RedisMetricsPublisher) GetHandlerCount() int {
 	p.mu.RLock()
@@ -0,0 +1,538 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The query language is a small subset of PromQL:
+//
+//	expr        = aggregation | call | selector
+//	aggregation = aggop [ "by" "(" tags ")" ] "(" [ number "," ] expr ")" [ "by" "(" tags ")" ]
+//	call        = func "(" [ number "," ] selector "[" duration "]" ")"
+//	selector    = name [ "{" matcher { "," matcher } "}" ]
+//	matcher     = tag ( "=" | "!=" | "=~" | "!~" ) string
+//
+// Aggregations are sum, avg, min, max, count and quantile. Functions are
+// rate, increase and the *_over_time family. Metric names select the raw
+// samples in system_metrics, the series the collectors write, so they
+// reach back METRICS_RETENTION at most. For example:
+//
+//	max by (collector) (quantile_over_time(0.95, system_goroutines_total[1h]))
+//	sum by (protocol) (monitoring_endpoints_by_type)
+//
+// endpoint_response_time_ms selects the response times of endpoint checks,
+// tagged with endpoint_id and user_id. Range functions other than rate and
+// increase read it from the coarsest rollups that evenly divide the range,
+// so it reaches back as far as the rollups are kept. For example, the p95
+// response time of each of user 42's endpoints over the last 7 days:
+//
+//	quantile_over_time(0.95, endpoint_response_time_ms{user_id="42"}[7d])
+//
+// Matchers are applied in SQL.
+
+// MatchType is the comparison a Matcher performs
+type MatchType int
+
+const (
+	MatchEqual MatchType = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t MatchType) String() string {
+	switch t {
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	default:
+		return "="
+	}
+}
+
+// Matcher selects series by a tag value. A missing tag matches as "".
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+	re    *regexp.Regexp
+}
+
+// NewMatcher creates a matcher; regular expressions are fully anchored
+func NewMatcher(matchType MatchType, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: matchType, Name: name, Value: value}
+	if matchType == MatchRegexp || matchType == MatchNotRegexp {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", value, err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// Matches reports whether a tag value satisfies the matcher
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	default:
+		return value == m.Value
+	}
+}
+
+func (m *Matcher) String() string {
+	return m.Name + m.Type.String() + strconv.Quote(m.Value)
+}
+
+// matchesAll reports whether tags satisfy every matcher
+func matchesAll(matchers []*Matcher, tags map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Matches(tags[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterMatching keeps the values whose tags satisfy every matcher. Stores
+// apply matchers in SQL, but PostgreSQL's regular expressions differ from
+// Go's in corner cases, so rows are checked again against the documented
+// syntax.
+func filterMatching(values []MetricValue, matchers []*Matcher) []MetricValue {
+	selected := values[:0]
+	for _, v := range values {
+		if matchesAll(matchers, v.Tags) {
+			selected = append(selected, v)
+		}
+	}
+	return selected
+}
+
+// Expr is a parsed query expression
+type Expr interface {
+	String() string
+}
+
+// VectorSelector selects series by metric name and tag matchers. Range is
+// only set when the selector is the argument of a range function.
+type VectorSelector struct {
+	Name     string
+	Matchers []*Matcher
+	Range    time.Duration
+}
+
+func (v *VectorSelector) String() string {
+	var sb strings.Builder
+	sb.WriteString(v.Name)
+	if len(v.Matchers) > 0 {
+		parts := make([]string, len(v.Matchers))
+		for i, m := range v.Matchers {
+			parts[i] = m.String()
+		}
+		sb.WriteString("{" + strings.Join(parts, ", ") + "}")
+	}
+	if v.Range > 0 {
+		sb.WriteString("[" + v.Range.String() + "]")
+	}
+	return sb.String()
+}
+
+// Call applies a range function to a range selector
+type Call struct {
+	Func  string
+	Param float64 // quantile for quantile_over_time
+	Arg   *VectorSelector
+}
+
+func (c *Call) String() string {
+	if c.Func == "quantile_over_time" {
+		return fmt.Sprintf("%s(%g, %s)", c.Func, c.Param, c.Arg)
+	}
+	return fmt.Sprintf("%s(%s)", c.Func, c.Arg)
+}
+
+// Aggregation combines series at each step, optionally grouped by tags
+type Aggregation struct {
+	Op    string
+	Param float64 // quantile for quantile
+	By    []string
+	Expr  Expr
+}
+
+func (a *Aggregation) String() string {
+	var sb strings.Builder
+	sb.WriteString(a.Op)
+	if len(a.By) > 0 {
+		sb.WriteString(" by (" + strings.Join(a.By, ", ") + ")")
+	}
+	sb.WriteString(" (")
+	if a.Op == "quantile" {
+		sb.WriteString(strconv.FormatFloat(a.Param, 'g', -1, 64) + ", ")
+	}
+	sb.WriteString(a.Expr.String() + ")")
+	return sb.String()
+}
+
+var aggregationOps = map[string]bool{
+	"sum": true, "avg": true, "min": true, "max": true, "count": true, "quantile": true,
+}
+
+var rangeFuncs = map[string]bool{
+	"rate": true, "increase": true,
+	"avg_over_time": true, "min_over_time": true, "max_over_time": true,
+	"sum_over_time": true, "count_over_time": true, "quantile_over_time": true,
+}
+
+// ParseQuery parses a query expression
+func ParseQuery(input string) (Expr, error) {
+	p := &parser{input: input}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+	return expr, nil
+}
+
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("parse error at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.input)
+}
+
+func (p *parser) skipSpace() {
+	for !p.eof() && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips whitespace and reports whether the input continues with s
+func (p *parser) consume(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(s string) error {
+	if !p.consume(s) {
+		if p.eof() {
+			return p.errorf("expected %q, got end of query", s)
+		}
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (Expr, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case aggregationOps[name]:
+		return p.parseAggregation(name)
+	case rangeFuncs[name]:
+		return p.parseCall(name)
+	default:
+		return p.parseSelector(name)
+	}
+}
+
+func (p *parser) parseAggregation(op string) (Expr, error) {
+	agg := &Aggregation{Op: op}
+
+	by, err := p.parseBy()
+	if err != nil {
+		return nil, err
+	}
+	agg.By = by
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if op == "quantile" {
+		if agg.Param, err = p.parseQuantile(); err != nil {
+			return nil, err
+		}
+	}
+	if agg.Expr, err = p.parseExpr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	if agg.By == nil {
+		if agg.By, err = p.parseBy(); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg, nil
+}
+
+// parseBy parses an optional by (tag, ...) clause
+func (p *parser) parseBy() ([]string, error) {
+	p.skipSpace()
+	start := p.pos
+	if word, err := p.parseIdent(); err != nil || word != "by" {
+		p.pos = start
+		return nil, nil
+	}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	by := []string{}
+	for !p.consume(")") {
+		tag, err := p.parseIdent()
+		if err != nil {
+			return nil, err
+		}
+		by = append(by, tag)
+		if !p.consume(",") {
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return by, nil
+}
+
+func (p *parser) parseCall(name string) (Expr, error) {
+	call := &Call{Func: name}
+
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if name == "quantile_over_time" {
+		if call.Param, err = p.parseQuantile(); err != nil {
+			return nil, err
+		}
+	}
+
+	metric, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+	expr, err := p.parseSelector(metric)
+	if err != nil {
+		return nil, err
+	}
+	call.Arg = expr.(*VectorSelector)
+	if call.Arg.Range == 0 {
+		return nil, p.errorf("%s expects a range selector like %s[5m]", name, metric)
+	}
+
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return call, nil
+}
+
+// parseQuantile parses the leading "q," argument of quantile functions
+func (p *parser) parseQuantile() (float64, error) {
+	p.skipSpace()
+	start := p.pos
+	for !p.eof() && strings.ContainsRune("0123456789.eE+-", rune(p.input[p.pos])) {
+		p.pos++
+	}
+
+	q, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+	if err != nil || q < 0 || q > 1 {
+		p.pos = start
+		return 0, p.errorf("expected a quantile between 0 and 1")
+	}
+	if err := p.expect(","); err != nil {
+		return 0, err
+	}
+	return q, nil
+}
+
+func (p *parser) parseSelector(name string) (Expr, error) {
+	if aggregationOps[name] || rangeFuncs[name] || name == "by" {
+		return nil, p.errorf("%q is a reserved word", name)
+	}
+
+	sel := &VectorSelector{Name: name}
+
+	if p.consume("{") {
+		for !p.consume("}") {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			sel.Matchers = append(sel.Matchers, m)
+			if !p.consume(",") {
+				if err := p.expect("}"); err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	}
+
+	if p.consume("[") {
+		p.skipSpace()
+		end := strings.IndexByte(p.input[p.pos:], ']')
+		if end < 0 {
+			return nil, p.errorf("unterminated range")
+		}
+		d, err := ParseDuration(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+		if err != nil {
+			return nil, p.errorf("%v", err)
+		}
+		p.pos += end + 1
+		sel.Range = d
+	}
+
+	return sel, nil
+}
+
+func (p *parser) parseMatcher() (*Matcher, error) {
+	name, err := p.parseIdent()
+	if err != nil {
+		return nil, err
+	}
+
+	var matchType MatchType
+	switch {
+	case p.consume("=~"):
+		matchType = MatchRegexp
+	case p.consume("!~"):
+		matchType = MatchNotRegexp
+	case p.consume("!="):
+		matchType = MatchNotEqual
+	case p.consume("="):
+		matchType = MatchEqual
+	default:
+		return nil, p.errorf("expected one of =, !=, =~, !~ after %q", name)
+	}
+
+	value, err := p.parseString()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := NewMatcher(matchType, name, value)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	return m, nil
+}
+
+func (p *parser) parseString() (string, error) {
+	p.skipSpace()
+	if p.eof() || (p.input[p.pos] != '"' && p.input[p.pos] != '\'') {
+		return "", p.errorf("expected quoted string")
+	}
+
+	quote := p.input[p.pos]
+	for i := p.pos + 1; i < len(p.input); i++ {
+		switch p.input[i] {
+		case '\\':
+			i++
+		case quote:
+			raw := p.input[p.pos : i+1]
+			if quote == '\'' {
+				raw = `"` + strings.ReplaceAll(raw[1:len(raw)-1], `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(raw)
+			if err != nil {
+				return "", p.errorf("invalid string %s", p.input[p.pos:i+1])
+			}
+			p.pos = i + 1
+			return value, nil
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func (p *parser) parseIdent() (string, error) {
+	p.skipSpace()
+	start := p.pos
+	for !p.eof() {
+		c := rune(p.input[p.pos])
+		if c == '_' || c == ':' || unicode.IsLetter(c) || (p.pos > start && (unicode.IsDigit(c) || c == '.')) {
+			p.pos++
+			continue
+		}
+		break
+	}
+	if p.pos == start {
+		if p.eof() {
+			return "", p.errorf("unexpected end of query")
+		}
+		return "", p.errorf("expected identifier")
+	}
+	return p.input[start:p.pos], nil
+}
+
+var durationUnits = map[string]time.Duration{
+	"ms": time.Millisecond,
+	"s":  time.Second,
+	"m":  time.Minute,
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+}
+
+var durationPattern = regexp.MustCompile(`^(\d+)(ms|s|m|h|d|w)`)
+
+// ParseDuration parses Prometheus-style durations such as 90s, 1h30m or 7d
+func ParseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var total time.Duration
+	for rest := s; rest != ""; {
+		m := durationPattern.FindStringSubmatch(rest)
+		if m == nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		total += time.Duration(n) * durationUnits[m[2]]
+		rest = rest[len(m[0]):]
+	}
+
+	if total <= 0 {
+		return 0, fmt.Errorf("duration %q must be positive", s)
+	}
+	return total, nil
+}
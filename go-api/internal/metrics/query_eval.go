@@ -0,0 +1,472 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"api-monitor-go/internal/retention"
+	"api-monitor-go/internal/sketch"
+)
+
+// DefaultLookback is how far back an instant selector looks for the latest
+// sample at each step
+const DefaultLookback = 5 * time.Minute
+
+// maxQueryPoints caps the steps a range query may evaluate
+const maxQueryPoints = 11000
+
+// QueryResult is the outcome of a range query
+type QueryResult struct {
+	Series []QuerySeries `json:"series"`
+}
+
+// QuerySeries is one output series
+type QuerySeries struct {
+	Name   string            `json:"name,omitempty"`
+	Tags   map[string]string `json:"tags"`
+	Points []QueryPoint      `json:"points"`
+}
+
+// QueryPoint is a value at a step
+type QueryPoint struct {
+	Timestamp time.Time `json:"t"`
+	Value     float64   `json:"v"`
+}
+
+// QueryEngine evaluates query expressions against a MetricsStore and the
+// sources of metrics that aren't stored there
+type QueryEngine struct {
+	store    MetricsStore
+	sources  []SeriesSource
+	lookback time.Duration
+}
+
+// NewQueryEngine creates a new query engine
+func NewQueryEngine(store MetricsStore, sources ...SeriesSource) *QueryEngine {
+	return &QueryEngine{
+		store:    store,
+		sources:  sources,
+		lookback: DefaultLookback,
+	}
+}
+
+// source returns the source serving the named metric, or nil when the
+// metric is read from the store
+func (e *QueryEngine) source(name string) SeriesSource {
+	for _, src := range e.sources {
+		if src.Serves(name) {
+			return src
+		}
+	}
+	return nil
+}
+
+// QueryRange evaluates query at every step from start to end inclusive
+func (e *QueryEngine) QueryRange(ctx context.Context, query string, start, end time.Time, step time.Duration) (*QueryResult, error) {
+	expr, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+
+	if step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+	if end.Before(start) {
+		return nil, fmt.Errorf("end time must not be before start time")
+	}
+	if end.Sub(start)/step+1 > maxQueryPoints {
+		return nil, fmt.Errorf("query would return more than %d points per series; increase the step", maxQueryPoints)
+	}
+
+	var steps []time.Time
+	for t := start.UTC(); !t.After(end.UTC()); t = t.Add(step) {
+		steps = append(steps, t)
+	}
+
+	series, err := e.eval(ctx, expr, steps)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		return seriesKey(series[i].Name, series[i].Tags) < seriesKey(series[j].Name, series[j].Tags)
+	})
+
+	return &QueryResult{Series: series}, nil
+}
+
+func (e *QueryEngine) eval(ctx context.Context, expr Expr, steps []time.Time) ([]QuerySeries, error) {
+	switch expr := expr.(type) {
+	case *VectorSelector:
+		if expr.Range > 0 {
+			return nil, fmt.Errorf("range selector %s must be wrapped in a function such as rate()", expr)
+		}
+		return e.evalInstant(ctx, expr, steps)
+	case *Call:
+		return e.evalCall(ctx, expr, steps)
+	case *Aggregation:
+		inner, err := e.eval(ctx, expr.Expr, steps)
+		if err != nil {
+			return nil, err
+		}
+		return aggregateSeries(expr, inner), nil
+	default:
+		return nil, fmt.Errorf("unsupported expression %s", expr)
+	}
+}
+
+// rawSeries is the stored samples of one tag set, oldest first. Series
+// read from a source's rollups hold buckets instead.
+type rawSeries struct {
+	name    string
+	tags    map[string]string
+	samples []MetricValue
+	buckets []SeriesBucket
+}
+
+// fetch loads samples covering the steps plus the look-behind window
+func (e *QueryEngine) fetch(ctx context.Context, sel *VectorSelector, steps []time.Time, window time.Duration) ([]*rawSeries, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	selectSamples := e.store.Select
+	if src := e.source(sel.Name); src != nil {
+		selectSamples = src.Select
+	}
+	values, err := selectSamples(ctx, sel.Name, sel.Matchers, steps[0].Add(-window), steps[len(steps)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*rawSeries)
+	var order []*rawSeries
+	for _, v := range values {
+		key := seriesKey(v.Name, v.Tags)
+		rs, ok := byKey[key]
+		if !ok {
+			rs = &rawSeries{name: v.Name, tags: v.Tags}
+			byKey[key] = rs
+			order = append(order, rs)
+		}
+		rs.samples = append(rs.samples, v)
+	}
+
+	for _, rs := range order {
+		sort.SliceStable(rs.samples, func(i, j int) bool {
+			return rs.samples[i].Timestamp.Before(rs.samples[j].Timestamp)
+		})
+	}
+
+	return order, nil
+}
+
+// fetchBuckets loads buckets of step covering the steps plus the range
+func (e *QueryEngine) fetchBuckets(ctx context.Context, src SeriesSource, sel *VectorSelector, steps []time.Time, step time.Duration) ([]*rawSeries, error) {
+	if len(steps) == 0 {
+		return nil, nil
+	}
+
+	buckets, err := src.SelectBuckets(ctx, sel.Name, sel.Matchers, steps[0].Add(-sel.Range), steps[len(steps)-1], step)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]*rawSeries)
+	var order []*rawSeries
+	for _, b := range buckets {
+		key := seriesKey(b.Name, b.Tags)
+		rs, ok := byKey[key]
+		if !ok {
+			rs = &rawSeries{name: b.Name, tags: b.Tags}
+			byKey[key] = rs
+			order = append(order, rs)
+		}
+		rs.buckets = append(rs.buckets, b)
+	}
+
+	for _, rs := range order {
+		sort.Slice(rs.buckets, func(i, j int) bool {
+			return rs.buckets[i].Start.Before(rs.buckets[j].Start)
+		})
+	}
+
+	return order, nil
+}
+
+// bucketStep returns the coarsest rollup resolution that evenly divides a
+// range, or 0 when the range is shorter than the finest one
+func bucketStep(r time.Duration) time.Duration {
+	for i := len(retention.Resolutions) - 1; i >= 0; i-- {
+		if step := retention.Resolutions[i].Step; step <= r && r%step == 0 {
+			return step
+		}
+	}
+	return 0
+}
+
+// bucketWindow returns the buckets starting in [t-w, t)
+func (rs *rawSeries) bucketWindow(t time.Time, w time.Duration) []SeriesBucket {
+	from := sort.Search(len(rs.buckets), func(i int) bool {
+		return !rs.buckets[i].Start.Before(t.Add(-w))
+	})
+	to := sort.Search(len(rs.buckets), func(i int) bool {
+		return !rs.buckets[i].Start.Before(t)
+	})
+	return rs.buckets[from:to]
+}
+
+// window returns the samples with timestamps in (t-w, t]
+func (rs *rawSeries) window(t time.Time, w time.Duration) []MetricValue {
+	from := sort.Search(len(rs.samples), func(i int) bool {
+		return rs.samples[i].Timestamp.After(t.Add(-w))
+	})
+	to := sort.Search(len(rs.samples), func(i int) bool {
+		return rs.samples[i].Timestamp.After(t)
+	})
+	return rs.samples[from:to]
+}
+
+// evalInstant takes the latest sample within the lookback at each step
+func (e *QueryEngine) evalInstant(ctx context.Context, sel *VectorSelector, steps []time.Time) ([]QuerySeries, error) {
+	raw, err := e.fetch(ctx, sel, steps, e.lookback)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []QuerySeries
+	for _, rs := range raw {
+		qs := QuerySeries{Name: rs.name, Tags: rs.tags}
+		for _, t := range steps {
+			if samples := rs.window(t, e.lookback); len(samples) > 0 {
+				qs.Points = append(qs.Points, QueryPoint{Timestamp: t, Value: samples[len(samples)-1].Value})
+			}
+		}
+		if len(qs.Points) > 0 {
+			out = append(out, qs)
+		}
+	}
+	return out, nil
+}
+
+// evalCall applies a range function over each step's window
+func (e *QueryEngine) evalCall(ctx context.Context, call *Call, steps []time.Time) ([]QuerySeries, error) {
+	if src := e.source(call.Arg.Name); src != nil && call.Func != "rate" && call.Func != "increase" {
+		if step := bucketStep(call.Arg.Range); step > 0 {
+			return e.evalBucketCall(ctx, src, call, steps, step)
+		}
+	}
+
+	raw, err := e.fetch(ctx, call.Arg, steps, call.Arg.Range)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []QuerySeries
+	for _, rs := range raw {
+		qs := QuerySeries{Tags: rs.tags}
+		for _, t := range steps {
+			if v, ok := applyRangeFunc(call, rs.window(t, call.Arg.Range)); ok {
+				qs.Points = append(qs.Points, QueryPoint{Timestamp: t, Value: v})
+			}
+		}
+		if len(qs.Points) > 0 {
+			out = append(out, qs)
+		}
+	}
+	return out, nil
+}
+
+// evalBucketCall applies a range function over each step's window of
+// buckets, so long ranges are answered from rollups. Windows are rounded
+// to whole buckets; align the steps to the bucket size for exact results.
+func (e *QueryEngine) evalBucketCall(ctx context.Context, src SeriesSource, call *Call, steps []time.Time, step time.Duration) ([]QuerySeries, error) {
+	raw, err := e.fetchBuckets(ctx, src, call.Arg, steps, step)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []QuerySeries
+	for _, rs := range raw {
+		qs := QuerySeries{Tags: rs.tags}
+		for _, t := range steps {
+			v, ok, err := applyBucketFunc(call, rs.bucketWindow(t, call.Arg.Range))
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				qs.Points = append(qs.Points, QueryPoint{Timestamp: t, Value: v})
+			}
+		}
+		if len(qs.Points) > 0 {
+			out = append(out, qs)
+		}
+	}
+	return out, nil
+}
+
+// applyBucketFunc computes a range function, other than rate and
+// increase, over one window of buckets
+func applyBucketFunc(call *Call, buckets []SeriesBucket) (float64, bool, error) {
+	merged := sketch.New(sketch.DefaultRelativeAccuracy)
+	for _, b := range buckets {
+		if err := merged.Merge(b.Sketch); err != nil {
+			return 0, false, fmt.Errorf("failed to merge bucket at %s: %w", b.Start, err)
+		}
+	}
+	if merged.Count() == 0 {
+		return 0, false, nil
+	}
+
+	switch call.Func {
+	case "count_over_time":
+		return float64(merged.Count()), true, nil
+	case "sum_over_time":
+		return merged.Sum(), true, nil
+	case "avg_over_time":
+		return merged.Sum() / float64(merged.Count()), true, nil
+	case "min_over_time":
+		return merged.Min(), true, nil
+	case "max_over_time":
+		return merged.Max(), true, nil
+	case "quantile_over_time":
+		return merged.Quantile(call.Param), true, nil
+	default:
+		return math.NaN(), true, nil
+	}
+}
+
+// applyRangeFunc computes a range function over one window of samples
+func applyRangeFunc(call *Call, samples []MetricValue) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	switch call.Func {
+	case "rate", "increase":
+		if len(samples) < 2 {
+			return 0, false
+		}
+		// A drop in a counter means it was reset; count from zero again
+		var increase float64
+		for i := 1; i < len(samples); i++ {
+			delta := samples[i].Value - samples[i-1].Value
+			if delta < 0 {
+				delta = samples[i].Value
+			}
+			increase += delta
+		}
+		if call.Func == "rate" {
+			return increase / call.Arg.Range.Seconds(), true
+		}
+		return increase, true
+	case "count_over_time":
+		return float64(len(samples)), true
+	}
+
+	values := make([]float64, len(samples))
+	for i, s := range samples {
+		values[i] = s.Value
+	}
+	return reduce(call.Func, call.Param, values), true
+}
+
+// reduce applies an aggregation to values, which must not be empty
+func reduce(op string, param float64, values []float64) float64 {
+	switch op {
+	case "sum", "sum_over_time":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	case "avg", "avg_over_time":
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	case "min", "min_over_time":
+		min := values[0]
+		for _, v := range values[1:] {
+			min = math.Min(min, v)
+		}
+		return min
+	case "max", "max_over_time":
+		max := values[0]
+		for _, v := range values[1:] {
+			max = math.Max(max, v)
+		}
+		return max
+	case "count", "count_over_time":
+		return float64(len(values))
+	case "quantile", "quantile_over_time":
+		return quantile(param, values)
+	default:
+		return math.NaN()
+	}
+}
+
+// quantile interpolates linearly between the closest ranks
+func quantile(q float64, values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := rank - float64(lower)
+	return sorted[lower]*(1-weight) + sorted[upper]*weight
+}
+
+// aggregateSeries combines series step by step, grouped by agg.By
+func aggregateSeries(agg *Aggregation, inner []QuerySeries) []QuerySeries {
+	type group struct {
+		tags   map[string]string
+		values map[time.Time][]float64
+	}
+
+	groups := make(map[string]*group)
+	for _, s := range inner {
+		tags := make(map[string]string, len(agg.By))
+		for _, key := range agg.By {
+			if v, ok := s.Tags[key]; ok {
+				tags[key] = v
+			}
+		}
+
+		key := seriesKey("", tags)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{tags: tags, values: make(map[time.Time][]float64)}
+			groups[key] = g
+		}
+		for _, p := range s.Points {
+			g.values[p.Timestamp] = append(g.values[p.Timestamp], p.Value)
+		}
+	}
+
+	out := make([]QuerySeries, 0, len(groups))
+	for _, g := range groups {
+		qs := QuerySeries{Tags: g.tags}
+		for t, values := range g.values {
+			qs.Points = append(qs.Points, QueryPoint{Timestamp: t, Value: reduce(agg.Op, agg.Param, values)})
+		}
+		sort.Slice(qs.Points, func(i, j int) bool {
+			return qs.Points[i].Timestamp.Before(qs.Points[j].Timestamp)
+		})
+		out = append(out, qs)
+	}
+	return out
+}
+
+// seriesKey identifies a series by name and tags
+func seriesKey(name string, tags map[string]string) string {
+	// json.Marshal sorts map keys, so equal tag sets give equal keys
+	encoded, _ := json.Marshal(tags)
+	return name + string(encoded)
+}
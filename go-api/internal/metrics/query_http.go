@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultQueryRange is used when a query request sets no start time
+const defaultQueryRange = time.Hour
+
+// defaultQueryStep is used when a query request sets no step
+const defaultQueryStep = time.Minute
+
+// QueryHandler serves GET /metrics/query?query=...&start=...&end=...&step=...
+//
+// start and end are RFC 3339 or Unix seconds and default to the last hour;
+// step is a duration such as 30s or 5m, or a number of seconds. Passing
+// time instead of start and end evaluates the query at a single instant.
+type QueryHandler struct {
+	engine *QueryEngine
+}
+
+// NewQueryHandler creates a new query handler
+func NewQueryHandler(engine *QueryEngine) *QueryHandler {
+	return &QueryHandler{engine: engine}
+}
+
+func (h *QueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeQueryError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	query := r.FormValue("query")
+	if query == "" {
+		writeQueryError(w, http.StatusBadRequest, "query parameter is required")
+		return
+	}
+
+	start, end, step, err := parseQueryRange(r)
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	result, err := h.engine.QueryRange(r.Context(), query, start, end, step)
+	if err != nil {
+		writeQueryError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   result,
+	})
+}
+
+// parseQueryRange reads start, end, step or time from the request
+func parseQueryRange(r *http.Request) (time.Time, time.Time, time.Duration, error) {
+	if at := r.FormValue("time"); at != "" {
+		t, err := parseQueryTime(at)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		return t, t, defaultQueryStep, nil
+	}
+
+	end := time.Now().UTC()
+	if v := r.FormValue("end"); v != "" {
+		t, err := parseQueryTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		end = t
+	}
+
+	start := end.Add(-defaultQueryRange)
+	if v := r.FormValue("start"); v != "" {
+		t, err := parseQueryTime(v)
+		if err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+		start = t
+	}
+
+	step := defaultQueryStep
+	if v := r.FormValue("step"); v != "" {
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			step = time.Duration(seconds * float64(time.Second))
+		} else if step, err = ParseDuration(v); err != nil {
+			return time.Time{}, time.Time{}, 0, err
+		}
+	}
+
+	return start, end, step, nil
+}
+
+// parseQueryTime accepts RFC 3339 or (fractional) Unix seconds
+func parseQueryTime(v string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+		whole, frac := math.Modf(seconds)
+		return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
+
+func writeQueryError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "error",
+		"error":  message,
+	})
+}
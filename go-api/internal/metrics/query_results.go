@@ -0,0 +1,334 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/retention"
+	"api-monitor-go/internal/sketch"
+)
+
+// ResponseTimeMetric is the query name of endpoint response times. Its
+// samples are the checks in monitoring_results, tagged with endpoint_id
+// and the endpoint owner's user_id.
+const ResponseTimeMetric = "endpoint_response_time_ms"
+
+// SeriesSource serves metrics that aren't stored in system_metrics
+type SeriesSource interface {
+	// Serves reports whether the source holds the named metric
+	Serves(name string) bool
+
+	// Select fetches samples whose tags satisfy all matchers, oldest first
+	Select(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time) ([]MetricValue, error)
+
+	// SelectBuckets summarises the samples in [startTime, endTime) into
+	// buckets aligned to step, which must be a rollup resolution. Ranges
+	// that are rolled up are read from rollups.
+	SelectBuckets(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time, step time.Duration) ([]SeriesBucket, error)
+}
+
+// SeriesBucket summarises a series' samples in [Start, Start+step)
+type SeriesBucket struct {
+	Name   string
+	Tags   map[string]string
+	Start  time.Time
+	Sketch *sketch.Sketch
+}
+
+// resultTags maps the tags of ResponseTimeMetric to the columns holding them
+var resultTags = map[string]string{
+	"endpoint_id": "r.endpoint_id::text",
+	"user_id":     "COALESCE(e.user_id::text, '')",
+}
+
+// PostgresResultSource serves ResponseTimeMetric from monitoring_results
+// and, once rolled up and pruned, from monitoring_result_rollups
+type PostgresResultSource struct {
+	db *sql.DB
+}
+
+// NewPostgresResultSource creates a source for endpoint response times
+func NewPostgresResultSource(db *sql.DB) *PostgresResultSource {
+	return &PostgresResultSource{db: db}
+}
+
+// Serves reports whether name is ResponseTimeMetric
+func (s *PostgresResultSource) Serves(name string) bool {
+	return name == ResponseTimeMetric
+}
+
+// Select fetches the response times of checks in the range. Checks without
+// a response time are skipped.
+func (s *PostgresResultSource) Select(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time) ([]MetricValue, error) {
+	f := &sqlFilter{}
+	conds, ok := resultConditions(f, matchers)
+	if !ok {
+		return nil, nil
+	}
+	conds = append(conds,
+		"r.checked_at BETWEEN "+f.arg(startTime)+" AND "+f.arg(endTime),
+		"r.response_time IS NOT NULL")
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.endpoint_id, COALESCE(e.user_id::text, ''), r.response_time, r.checked_at
+		FROM monitoring_results r
+		LEFT JOIN api_endpoints e ON e.id = r.endpoint_id
+		WHERE `+strings.Join(conds, " AND ")+`
+		ORDER BY r.checked_at ASC`, f.args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select results: %w", err)
+	}
+	defer rows.Close()
+
+	var values []MetricValue
+	for rows.Next() {
+		var endpointID int
+		var userID string
+		var responseTime float64
+		var checkedAt time.Time
+
+		if err := rows.Scan(&endpointID, &userID, &responseTime, &checkedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan result: %w", err)
+		}
+
+		values = append(values, MetricValue{
+			Name:      ResponseTimeMetric,
+			Type:      MetricTypeTimer,
+			Value:     responseTime,
+			Timestamp: checkedAt,
+			Tags:      responseTimeTags(endpointID, userID),
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating result rows: %w", err)
+	}
+
+	return filterMatching(values, matchers), nil
+}
+
+// SelectBuckets summarises response times per endpoint into buckets of
+// step. Like Aggregate, it reads the coarsest rollups that cover the range
+// and raw rows for the edges that aren't rolled up yet.
+func (s *PostgresResultSource) SelectBuckets(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time, step time.Duration) ([]SeriesBucket, error) {
+	resIdx := -1
+	for i, res := range retention.Resolutions {
+		if res.Step <= step && step%res.Step == 0 {
+			resIdx = i
+		}
+	}
+	if resIdx < 0 {
+		return nil, fmt.Errorf("step %s is not a multiple of a rollup resolution", step)
+	}
+
+	f := &sqlFilter{}
+	conds, ok := resultConditions(f, matchers)
+	if !ok {
+		return nil, nil
+	}
+
+	watermarks, err := retention.Watermarks(ctx, s.db, retention.SourceMonitoringResults)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &resultBuckets{
+		step:    step,
+		conds:   conds,
+		args:    f.args,
+		buckets: make(map[string]*SeriesBucket),
+	}
+	start := startTime.UTC().Truncate(step)
+	end := alignUp(endTime.UTC(), step)
+	if err := s.loadRange(ctx, b, start, end, resIdx, watermarks); err != nil {
+		return nil, err
+	}
+
+	out := make([]SeriesBucket, 0, len(b.buckets))
+	for _, bucket := range b.buckets {
+		if matchesAll(matchers, bucket.Tags) {
+			out = append(out, *bucket)
+		}
+	}
+	return out, nil
+}
+
+// resultBuckets accumulates a SelectBuckets query
+type resultBuckets struct {
+	step    time.Duration
+	conds   []string
+	args    []interface{}
+	buckets map[string]*SeriesBucket
+}
+
+// add returns the bucket of an endpoint that t falls in
+func (b *resultBuckets) add(endpointID int, userID string, t time.Time) *SeriesBucket {
+	start := t.UTC().Truncate(b.step)
+	key := strconv.Itoa(endpointID) + "@" + start.Format(time.RFC3339)
+
+	bucket, ok := b.buckets[key]
+	if !ok {
+		bucket = &SeriesBucket{
+			Name:   ResponseTimeMetric,
+			Tags:   responseTimeTags(endpointID, userID),
+			Start:  start,
+			Sketch: sketch.New(sketch.DefaultRelativeAccuracy),
+		}
+		b.buckets[key] = bucket
+	}
+	return bucket
+}
+
+// filter returns the matcher conditions with a filter to add more to
+func (b *resultBuckets) filter() (*sqlFilter, []string) {
+	f := &sqlFilter{args: append([]interface{}(nil), b.args...)}
+	return f, append([]string(nil), b.conds...)
+}
+
+// loadRange covers [from, to) with rollups at resolution resIdx where
+// they exist and recurses to finer resolutions, and finally raw rows, for
+// the parts that aren't rolled up yet
+func (s *PostgresResultSource) loadRange(ctx context.Context, b *resultBuckets, from, to time.Time, resIdx int, watermarks map[string]time.Time) error {
+	if !from.Before(to) {
+		return nil
+	}
+	if resIdx < 0 {
+		return s.loadRaw(ctx, b, from, to)
+	}
+
+	res := retention.Resolutions[resIdx]
+	start := alignUp(from, res.Step)
+	end := to.Truncate(res.Step)
+	if watermark, ok := watermarks[res.Name]; !ok {
+		end = start
+	} else if watermark.Before(end) {
+		end = watermark.Truncate(res.Step)
+	}
+
+	if !start.Before(end) {
+		return s.loadRange(ctx, b, from, to, resIdx-1, watermarks)
+	}
+
+	if err := s.loadRange(ctx, b, from, start, resIdx-1, watermarks); err != nil {
+		return err
+	}
+	if err := s.loadRollups(ctx, b, res.Name, start, end); err != nil {
+		return err
+	}
+	return s.loadRange(ctx, b, end, to, resIdx-1, watermarks)
+}
+
+// loadRaw folds raw response times in [from, to) into b
+func (s *PostgresResultSource) loadRaw(ctx context.Context, b *resultBuckets, from, to time.Time) error {
+	f, conds := b.filter()
+	conds = append(conds,
+		"r.checked_at >= "+f.arg(from),
+		"r.checked_at < "+f.arg(to),
+		"r.response_time IS NOT NULL")
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.endpoint_id, COALESCE(e.user_id::text, ''), r.response_time, r.checked_at
+		FROM monitoring_results r
+		LEFT JOIN api_endpoints e ON e.id = r.endpoint_id
+		WHERE `+strings.Join(conds, " AND "), f.args...)
+	if err != nil {
+		return fmt.Errorf("failed to query results: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var endpointID int
+		var userID string
+		var responseTime float64
+		var checkedAt time.Time
+
+		if err := rows.Scan(&endpointID, &userID, &responseTime, &checkedAt); err != nil {
+			return fmt.Errorf("failed to scan result: %w", err)
+		}
+		b.add(endpointID, userID, checkedAt).Sketch.Add(responseTime)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating result rows: %w", err)
+	}
+
+	return nil
+}
+
+// loadRollups merges monitoring_result_rollups sketches in [from, to) into b
+func (s *PostgresResultSource) loadRollups(ctx context.Context, b *resultBuckets, resolution string, from, to time.Time) error {
+	f, conds := b.filter()
+	conds = append(conds,
+		"r.resolution = "+f.arg(resolution),
+		"r.bucket_start >= "+f.arg(from),
+		"r.bucket_start < "+f.arg(to))
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.endpoint_id, COALESCE(e.user_id::text, ''), r.bucket_start, r.sketch
+		FROM monitoring_result_rollups r
+		LEFT JOIN api_endpoints e ON e.id = r.endpoint_id
+		WHERE `+strings.Join(conds, " AND "), f.args...)
+	if err != nil {
+		return fmt.Errorf("failed to query result rollups: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var endpointID int
+		var userID string
+		var bucketStart time.Time
+		var sketchJSON []byte
+
+		if err := rows.Scan(&endpointID, &userID, &bucketStart, &sketchJSON); err != nil {
+			return fmt.Errorf("failed to scan result rollup: %w", err)
+		}
+		if len(sketchJSON) == 0 {
+			continue
+		}
+
+		var sk sketch.Sketch
+		if err := json.Unmarshal(sketchJSON, &sk); err != nil {
+			return err
+		}
+
+		if err := b.add(endpointID, userID, bucketStart).Sketch.Merge(&sk); err != nil {
+			return fmt.Errorf("failed to merge %s rollup at %s: %w", resolution, bucketStart, err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating result rollup rows: %w", err)
+	}
+
+	return nil
+}
+
+// resultConditions turns matchers into conditions on the result columns.
+// A tag results don't have matches as "", so its matcher is decided up
+// front; ok is false when such a matcher rules out every result.
+func resultConditions(f *sqlFilter, matchers []*Matcher) ([]string, bool) {
+	var conds []string
+	for _, m := range matchers {
+		column, ok := resultTags[m.Name]
+		if !ok {
+			if !m.Matches("") {
+				return nil, false
+			}
+			continue
+		}
+		conds = append(conds, f.match(column, m))
+	}
+	return conds, true
+}
+
+func responseTimeTags(endpointID int, userID string) map[string]string {
+	return map[string]string{
+		"endpoint_id": strconv.Itoa(endpointID),
+		"user_id":     userID,
+	}
+}
@@ -0,0 +1,506 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/sketch"
+)
+
+// MockStore is an in-memory MetricsStore for query tests
+type MockStore struct {
+	metrics []MetricValue
+}
+
+func (m *MockStore) Store(ctx context.Context, metrics []MetricValue) error {
+	m.metrics = append(m.metrics, metrics...)
+	return nil
+}
+
+func (m *MockStore) Retrieve(ctx context.Context, startTime, endTime time.Time) ([]MetricValue, error) {
+	return m.Select(ctx, "", nil, startTime, endTime)
+}
+
+func (m *MockStore) RetrieveByName(ctx context.Context, name string, startTime, endTime time.Time) ([]MetricValue, error) {
+	return m.Select(ctx, name, nil, startTime, endTime)
+}
+
+func (m *MockStore) Select(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time) ([]MetricValue, error) {
+	var out []MetricValue
+	for _, v := range m.metrics {
+		if (name == "" || v.Name == name) && !v.Timestamp.Before(startTime) && !v.Timestamp.After(endTime) && matchesAll(matchers, v.Tags) {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+func (m *MockStore) Aggregate(ctx context.Context, query AggregateQuery) (*AggregateResult, error) {
+	return &AggregateResult{Name: query.Name}, nil
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`up`, `up`},
+		{`response_time_ms{endpoint="a", user_id!="1"}`, `response_time_ms{endpoint="a", user_id!="1"}`},
+		{`errors{path=~"/api/.*",method!~'GET|HEAD'}`, `errors{path=~"/api/.*", method!~"GET|HEAD"}`},
+		{`rate(requests_total[5m])`, `rate(requests_total[5m0s])`},
+		{`quantile_over_time(0.95, latency{user_id="42"}[1d])`, `quantile_over_time(0.95, latency{user_id="42"}[24h0m0s])`},
+		{`sum by (region) (rate(requests_total[1m]))`, `sum by (region) (rate(requests_total[1m0s]))`},
+		{`max(increase(requests_total[1h])) by (endpoint, region)`, `max by (endpoint, region) (increase(requests_total[1h0m0s]))`},
+		{`quantile(0.5, cpu)`, `quantile (0.5, cpu)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := ParseQuery(tt.input)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("ParseQuery() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`rate(requests_total)`,
+		`sum(`,
+		`cpu{host="a"`,
+		`cpu{host~"a"}`,
+		`cpu{host=~"("}`,
+		`cpu[5x]`,
+		`quantile(1.5, cpu)`,
+		`cpu extra`,
+		`sum`,
+	}
+
+	for _, input := range tests {
+		if _, err := ParseQuery(input); err == nil {
+			t.Errorf("ParseQuery(%q) expected error", input)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := map[string]time.Duration{
+		"30s":   30 * time.Second,
+		"1h30m": 90 * time.Minute,
+		"7d":    7 * 24 * time.Hour,
+		"1w":    7 * 24 * time.Hour,
+		"250ms": 250 * time.Millisecond,
+	}
+	for input, want := range tests {
+		got, err := ParseDuration(input)
+		if err != nil || got != want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", input, got, err, want)
+		}
+	}
+
+	for _, input := range []string{"", "5", "m", "0s", "-1m"} {
+		if _, err := ParseDuration(input); err == nil {
+			t.Errorf("ParseDuration(%q) expected error", input)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	tests := []struct {
+		matchType MatchType
+		value     string
+		input     string
+		want      bool
+	}{
+		{MatchEqual, "a", "a", true},
+		{MatchEqual, "", "", true},
+		{MatchNotEqual, "a", "b", true},
+		{MatchRegexp, "a|b", "b", true},
+		{MatchRegexp, "a", "ab", false}, // anchored
+		{MatchNotRegexp, "a.*", "ba", true},
+		{MatchNotRegexp, "a.*", "ab", false},
+	}
+
+	for _, tt := range tests {
+		m, err := NewMatcher(tt.matchType, "tag", tt.value)
+		if err != nil {
+			t.Fatalf("NewMatcher() error = %v", err)
+		}
+		if got := m.Matches(tt.input); got != tt.want {
+			t.Errorf("%s matches %q = %v, want %v", m, tt.input, got, tt.want)
+		}
+	}
+}
+
+func newQueryTestStore(base time.Time) *MockStore {
+	store := &MockStore{}
+	for i := 0; i <= 10; i++ {
+		at := base.Add(time.Duration(i) * time.Minute)
+
+		// Counter that resets after minute 5
+		counter := float64(i * 60)
+		if i > 5 {
+			counter = float64((i - 5) * 60)
+		}
+
+		store.Store(context.Background(), []MetricValue{
+			{Name: "requests_total", Type: MetricTypeCounter, Value: counter, Timestamp: at, Tags: map[string]string{"endpoint": "a", "user_id": "1"}},
+			{Name: "latency_ms", Type: MetricTypeTimer, Value: float64(100 + i), Timestamp: at, Tags: map[string]string{"endpoint": "a", "user_id": "1"}},
+			{Name: "latency_ms", Type: MetricTypeTimer, Value: float64(200 + i), Timestamp: at, Tags: map[string]string{"endpoint": "b", "user_id": "1"}},
+			{Name: "latency_ms", Type: MetricTypeTimer, Value: 999, Timestamp: at, Tags: map[string]string{"endpoint": "c", "user_id": "2"}},
+		})
+	}
+	return store
+}
+
+func TestQueryRangeRateHandlesCounterReset(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine := NewQueryEngine(newQueryTestStore(base))
+
+	result, err := engine.QueryRange(context.Background(), `increase(requests_total[10m])`, base.Add(10*time.Minute), base.Add(10*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %v", err)
+	}
+	if len(result.Series) != 1 || len(result.Series[0].Points) != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+
+	// Window (0m, 10m] holds samples 1..10: +240 up to the reset, +60 for
+	// the reset sample itself and +240 after it
+	if got := result.Series[0].Points[0].Value; got != 540 {
+		t.Errorf("increase = %v, want 540", got)
+	}
+
+	result, err = engine.QueryRange(context.Background(), `rate(requests_total[10m])`, base.Add(10*time.Minute), base.Add(10*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %v", err)
+	}
+	if got := result.Series[0].Points[0].Value; math.Abs(got-0.9) > 1e-9 {
+		t.Errorf("rate = %v, want 0.9", got)
+	}
+}
+
+func TestQueryRangeQuantileByTag(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine := NewQueryEngine(newQueryTestStore(base))
+
+	result, err := engine.QueryRange(context.Background(),
+		`max by (endpoint) (quantile_over_time(0.5, latency_ms{user_id="1", endpoint=~"a|b"}[5m]))`,
+		base.Add(5*time.Minute), base.Add(10*time.Minute), 5*time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %v", err)
+	}
+
+	if len(result.Series) != 2 {
+		t.Fatalf("expected 2 series, got %+v", result.Series)
+	}
+
+	a := result.Series[0]
+	if a.Tags["endpoint"] != "a" || len(a.Points) != 2 {
+		t.Fatalf("unexpected series %+v", a)
+	}
+	// (0m, 5m] holds 101..105 and (5m, 10m] holds 106..110
+	if a.Points[0].Value != 103 || a.Points[1].Value != 108 {
+		t.Errorf("median latency = %v, %v; want 103, 108", a.Points[0].Value, a.Points[1].Value)
+	}
+}
+
+func TestQueryRangeInstantSelectorAndAggregation(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine := NewQueryEngine(newQueryTestStore(base))
+
+	result, err := engine.QueryRange(context.Background(), `sum(latency_ms{endpoint!="c"})`,
+		base.Add(2*time.Minute), base.Add(2*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %v", err)
+	}
+	if len(result.Series) != 1 || result.Series[0].Points[0].Value != 102+202 {
+		t.Fatalf("unexpected result %+v", result.Series)
+	}
+
+	// Past the lookback there are no samples
+	result, err = engine.QueryRange(context.Background(), `latency_ms`,
+		base.Add(time.Hour), base.Add(time.Hour), time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %v", err)
+	}
+	if len(result.Series) != 0 {
+		t.Errorf("expected no series past lookback, got %d", len(result.Series))
+	}
+}
+
+func TestQueryRangeLimits(t *testing.T) {
+	engine := NewQueryEngine(&MockStore{})
+	now := time.Now()
+
+	if _, err := engine.QueryRange(context.Background(), `cpu`, now.Add(-365*24*time.Hour), now, time.Second); err == nil {
+		t.Error("expected error for too many points")
+	}
+	if _, err := engine.QueryRange(context.Background(), `cpu[5m]`, now, now, time.Minute); err == nil {
+		t.Error("expected error for bare range selector")
+	}
+}
+
+func TestQueryHandler(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	handler := NewQueryHandler(NewQueryEngine(newQueryTestStore(base)))
+
+	params := url.Values{
+		"query": {`avg by (user_id) (latency_ms)`},
+		"start": {base.Add(time.Minute).Format(time.RFC3339)},
+		"end":   {base.Add(3 * time.Minute).Format(time.RFC3339)},
+		"step":  {"60"},
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?"+params.Encode(), nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Status string      `json:"status"`
+		Data   QueryResult `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if body.Status != "success" || len(body.Data.Series) != 2 || len(body.Data.Series[0].Points) != 3 {
+		t.Fatalf("unexpected response %+v", body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?query="+url.QueryEscape(`rate(x)`), nil))
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "range selector") {
+		t.Errorf("expected 400 with parse error, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestQueryHandlerDocumentedQuery(t *testing.T) {
+	// Store what the system collector actually writes over the last hour
+	store := &MockStore{}
+	collector := NewSystemMetricsCollector()
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 6; i++ {
+		collected, err := collector.Collect(context.Background())
+		if err != nil {
+			t.Fatalf("Collect() error = %v", err)
+		}
+		for j := range collected {
+			collected[j].Timestamp = now.Add(-time.Duration(i) * 10 * time.Minute)
+		}
+		store.Store(context.Background(), collected)
+	}
+
+	handler := NewQueryHandler(NewQueryEngine(store))
+	params := url.Values{
+		"query": {`max by (collector) (quantile_over_time(0.95, system_goroutines_total[1h]))`},
+		"time":  {strconv.FormatInt(now.Unix(), 10)},
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/query?"+params.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body.String())
+	}
+
+	var body struct {
+		Data QueryResult `json:"data"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(body.Data.Series) != 1 || body.Data.Series[0].Tags["collector"] != "system" || len(body.Data.Series[0].Points) != 1 {
+		t.Fatalf("expected one series for the system collector, got %+v", body.Data)
+	}
+	if got := body.Data.Series[0].Points[0].Value; got < 1 {
+		t.Errorf("expected the goroutine count, got %v", got)
+	}
+}
+
+// MockSeriesSource serves one metric from memory and records how it was read
+type MockSeriesSource struct {
+	name        string
+	samples     []MetricValue
+	selects     int
+	bucketSteps []time.Duration
+}
+
+func (m *MockSeriesSource) Serves(name string) bool {
+	return name == m.name
+}
+
+func (m *MockSeriesSource) Select(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time) ([]MetricValue, error) {
+	m.selects++
+	store := &MockStore{metrics: m.samples}
+	return store.Select(ctx, name, matchers, startTime, endTime)
+}
+
+func (m *MockSeriesSource) SelectBuckets(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time, step time.Duration) ([]SeriesBucket, error) {
+	m.bucketSteps = append(m.bucketSteps, step)
+
+	buckets := make(map[string]*SeriesBucket)
+	var out []SeriesBucket
+	for _, v := range m.samples {
+		if v.Timestamp.Before(startTime.Truncate(step)) || !v.Timestamp.Before(endTime) || !matchesAll(matchers, v.Tags) {
+			continue
+		}
+		start := v.Timestamp.Truncate(step)
+		key := seriesKey(v.Name, v.Tags) + start.String()
+		b, ok := buckets[key]
+		if !ok {
+			b = &SeriesBucket{Name: v.Name, Tags: v.Tags, Start: start, Sketch: sketch.New(sketch.DefaultRelativeAccuracy)}
+			buckets[key] = b
+		}
+		b.Sketch.Add(v.Value)
+	}
+	for _, b := range buckets {
+		out = append(out, *b)
+	}
+	return out, nil
+}
+
+func newResponseTimeSource(base time.Time) *MockSeriesSource {
+	src := &MockSeriesSource{name: ResponseTimeMetric}
+	for i := 0; i < 7*24; i++ {
+		at := base.Add(time.Duration(i) * time.Hour)
+		for j := 1; j <= 20; j++ {
+			src.samples = append(src.samples,
+				MetricValue{Name: ResponseTimeMetric, Value: float64(j * 10), Timestamp: at, Tags: map[string]string{"endpoint_id": "1", "user_id": "42"}},
+				MetricValue{Name: ResponseTimeMetric, Value: float64(j * 100), Timestamp: at, Tags: map[string]string{"endpoint_id": "2", "user_id": "42"}},
+				MetricValue{Name: ResponseTimeMetric, Value: 5, Timestamp: at, Tags: map[string]string{"endpoint_id": "3", "user_id": "7"}},
+			)
+		}
+	}
+	return src
+}
+
+func TestQueryRangeResponseTimeFromRollups(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := newResponseTimeSource(base)
+	engine := NewQueryEngine(&MockStore{}, src)
+
+	end := base.Add(7 * 24 * time.Hour)
+	result, err := engine.QueryRange(context.Background(),
+		`quantile_over_time(0.95, endpoint_response_time_ms{user_id="42"}[7d])`, end, end, time.Minute)
+	if err != nil {
+		t.Fatalf("QueryRange() error = %v", err)
+	}
+
+	if src.selects != 0 || len(src.bucketSteps) != 1 || src.bucketSteps[0] != 24*time.Hour {
+		t.Fatalf("expected one read of daily buckets, got %d selects and steps %v", src.selects, src.bucketSteps)
+	}
+	if len(result.Series) != 2 {
+		t.Fatalf("expected one series per endpoint of user 42, got %+v", result.Series)
+	}
+
+	// Each hour holds 10..200 for endpoint 1 and 100..2000 for endpoint 2
+	for i, want := range []float64{190, 1900} {
+		s := result.Series[i]
+		if s.Tags["endpoint_id"] != strconv.Itoa(i+1) || len(s.Points) != 1 {
+			t.Fatalf("unexpected series %+v", s)
+		}
+		if got := s.Points[0].Value; math.Abs(got-want)/want > 0.02 {
+			t.Errorf("endpoint %d p95 = %v, want about %v", i+1, got, want)
+		}
+	}
+}
+
+func TestQueryRangeResponseTimeFromSamples(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := newResponseTimeSource(base)
+	engine := NewQueryEngine(&MockStore{}, src)
+
+	// Shorter than the finest rollup, and instant selectors, read samples
+	queries := []string{
+		`max_over_time(endpoint_response_time_ms{endpoint_id="1"}[30s])`,
+		`endpoint_response_time_ms{endpoint_id="1"}`,
+	}
+	for _, query := range queries {
+		result, err := engine.QueryRange(context.Background(), query, base.Add(time.Hour), base.Add(time.Hour), time.Minute)
+		if err != nil {
+			t.Fatalf("QueryRange(%s) error = %v", query, err)
+		}
+		if len(result.Series) != 1 || result.Series[0].Points[0].Value != 200 {
+			t.Errorf("QueryRange(%s) = %+v, want 200 for endpoint 1", query, result.Series)
+		}
+	}
+
+	if src.selects != 2 || len(src.bucketSteps) != 0 {
+		t.Errorf("expected samples only, got %d selects and steps %v", src.selects, src.bucketSteps)
+	}
+}
+
+func TestBuildSelectQueryPushesDownMatchers(t *testing.T) {
+	matchers := []*Matcher{
+		mustMatcher(t, MatchEqual, "endpoint", "a"),
+		mustMatcher(t, MatchNotEqual, "region", "eu"),
+		mustMatcher(t, MatchRegexp, "path", "/api/.*"),
+		mustMatcher(t, MatchNotRegexp, "method", "GET|HEAD"),
+		mustMatcher(t, MatchEqual, "host", ""),
+	}
+
+	query, args, err := buildSelectQuery("requests", matchers, time.Unix(0, 0), time.Unix(60, 0))
+	if err != nil {
+		t.Fatalf("buildSelectQuery() error = %v", err)
+	}
+
+	for _, cond := range []string{
+		"COALESCE(tags->>$4, '') <> $5",
+		"COALESCE(tags->>$6, '') ~ $7",
+		"COALESCE(tags->>$8, '') !~ $9",
+		"COALESCE(tags->>$10, '') = $11",
+		"tags @> $12",
+	} {
+		if !strings.Contains(query, cond) {
+			t.Errorf("query lacks %q:\n%s", cond, query)
+		}
+	}
+	if len(args) != 12 || args[6] != "^(?:/api/.*)$" || string(args[11].([]byte)) != `{"endpoint":"a"}` {
+		t.Errorf("unexpected args %v", args)
+	}
+}
+
+func TestResultConditions(t *testing.T) {
+	tests := []struct {
+		name    string
+		matcher *Matcher
+		want    string
+		ok      bool
+	}{
+		{"user tag", mustMatcher(t, MatchEqual, "user_id", "42"), "COALESCE(e.user_id::text, '') = $1", true},
+		{"endpoint regexp", mustMatcher(t, MatchRegexp, "endpoint_id", "1|2"), "r.endpoint_id::text ~ $1", true},
+		{"missing tag matches empty", mustMatcher(t, MatchEqual, "region", ""), "", true},
+		{"missing tag rules out results", mustMatcher(t, MatchEqual, "region", "eu"), "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conds, ok := resultConditions(&sqlFilter{}, []*Matcher{tt.matcher})
+			if ok != tt.ok || strings.Join(conds, " AND ") != tt.want {
+				t.Errorf("resultConditions() = %v, %v; want %q, %v", conds, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func mustMatcher(t *testing.T, matchType MatchType, name, value string) *Matcher {
+	t.Helper()
+	m, err := NewMatcher(matchType, name, value)
+	if err != nil {
+		t.Fatalf("NewMatcher() error = %v", err)
+	}
+	return m
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"api-monitor-go/internal/middleware"
+)
+
+// Rate limit metrics, tagged with the policy name
+const (
+	MetricRateLimitAllowed  = "rate_limit_allowed_total"
+	MetricRateLimitRejected = "rate_limit_rejected_total"
+	MetricRateLimitErrors   = "rate_limit_errors_total"
+)
+
+// RateLimitMetrics returns the decisions of a rate limit policy
+func RateLimitMetrics(stats middleware.RateLimitStats, at time.Time) []MetricValue {
+	tags := map[string]string{"policy": stats.Policy}
+
+	return []MetricValue{
+		{
+			Name:        MetricRateLimitAllowed,
+			Type:        MetricTypeCounter,
+			Value:       float64(stats.Allowed),
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Requests the rate limit policy let through",
+		},
+		{
+			Name:        MetricRateLimitRejected,
+			Type:        MetricTypeCounter,
+			Value:       float64(stats.Rejected),
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Requests the rate limit policy rejected",
+		},
+		{
+			Name:        MetricRateLimitErrors,
+			Type:        MetricTypeCounter,
+			Value:       float64(stats.Errors),
+			Timestamp:   at,
+			Tags:        tags,
+			Description: "Requests let through because no limiter could decide",
+		},
+	}
+}
+
+// RateLimitCollector reports the decisions of the API's rate limit
+// policies
+type RateLimitCollector struct {
+	name     string
+	enabled  atomic.Bool
+	policies func() []middleware.RateLimitStats
+}
+
+// NewRateLimitCollector creates a collector for the policies returned by
+// policies
+func NewRateLimitCollector(policies func() []middleware.RateLimitStats) *RateLimitCollector {
+	collector := &RateLimitCollector{
+		name:     "rate_limits",
+		policies: policies,
+	}
+	collector.enabled.Store(true)
+	return collector
+}
+
+// Name returns the collector name
+func (c *RateLimitCollector) Name() string {
+	return c.name
+}
+
+// IsEnabled returns if the collector is enabled
+func (c *RateLimitCollector) IsEnabled() bool {
+	return c.enabled.Load()
+}
+
+// SetEnabled sets the enabled state
+func (c *RateLimitCollector) SetEnabled(enabled bool) {
+	c.enabled.Store(enabled)
+}
+
+// Collect returns the metrics of every policy
+func (c *RateLimitCollector) Collect(ctx context.Context) ([]MetricValue, error) {
+	if !c.enabled.Load() {
+		return []MetricValue{}, nil
+	}
+
+	now := time.Now()
+	var metrics []MetricValue
+	for _, stats := range c.policies() {
+		metrics = append(metrics, RateLimitMetrics(stats, now)...)
+	}
+	return metrics, nil
+}
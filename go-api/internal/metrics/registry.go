@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CollectorConfig declares one collector instance
+type CollectorConfig struct {
+	// Type selects the registered factory, e.g. "stripe"
+	Type string `json:"type"`
+
+	// Name distinguishes instances of the same type; the collector is
+	// named "type:name", or just "type" when Name is empty
+	Name string `json:"name,omitempty"`
+
+	// Interval overrides the collector's own interval, e.g. "1m" or "1d"
+	Interval string `json:"interval,omitempty"`
+
+	// Enabled defaults to true
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Options are passed to the factory. An option that is exactly a
+	// ${VAR} reference is read from the environment so secrets can stay
+	// out of the file; any other value is used as written.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// InstanceName returns the name the collector is registered under
+func (c CollectorConfig) InstanceName() string {
+	if c.Name == "" {
+		return c.Type
+	}
+	return c.Type + ":" + c.Name
+}
+
+// CollectorDeps are shared resources available to collector factories
+type CollectorDeps struct {
+	DB      *sql.DB
+	Cursors CursorStore
+}
+
+// CollectorFactory creates a collector named name from its options
+type CollectorFactory func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error)
+
+// Registry maps collector type names to factories
+type Registry struct {
+	mu        sync.RWMutex
+	factories map[string]CollectorFactory
+}
+
+// DefaultRegistry holds the built-in collector types
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry creates an empty collector registry
+func NewRegistry() *Registry {
+	return &Registry{factories: make(map[string]CollectorFactory)}
+}
+
+// Register adds a factory to DefaultRegistry and panics on duplicates, so
+// it can be called from init
+func Register(typeName string, factory CollectorFactory) {
+	if err := DefaultRegistry.Register(typeName, factory); err != nil {
+		panic(err)
+	}
+}
+
+// Register adds a factory for typeName
+func (r *Registry) Register(typeName string, factory CollectorFactory) error {
+	if typeName == "" || strings.Contains(typeName, ":") {
+		return fmt.Errorf("invalid collector type '%s'", typeName)
+	}
+	if factory == nil {
+		return fmt.Errorf("factory for collector type '%s' cannot be nil", typeName)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.factories[typeName]; exists {
+		return fmt.Errorf("collector type '%s' already registered", typeName)
+	}
+	r.factories[typeName] = factory
+	return nil
+}
+
+// Types returns the registered type names, sorted
+func (r *Registry) Types() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	types := make([]string, 0, len(r.factories))
+	for typeName := range r.factories {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Build creates the collector declared by config. The returned interval
+// is zero unless config overrides it.
+func (r *Registry) Build(config CollectorConfig, deps CollectorDeps) (MetricsCollector, time.Duration, error) {
+	r.mu.RLock()
+	factory, exists := r.factories[config.Type]
+	r.mu.RUnlock()
+
+	if !exists {
+		return nil, 0, fmt.Errorf("unknown collector type '%s' (registered: %s)", config.Type, strings.Join(r.Types(), ", "))
+	}
+
+	var interval time.Duration
+	if config.Interval != "" {
+		d, err := ParseDuration(config.Interval)
+		if err != nil {
+			return nil, 0, fmt.Errorf("collector '%s': invalid interval: %w", config.InstanceName(), err)
+		}
+		interval = d
+	}
+
+	options := make(map[string]string, len(config.Options))
+	for key, value := range config.Options {
+		options[key] = expandOption(value)
+	}
+
+	name := config.InstanceName()
+	collector, err := factory(name, options, deps)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create collector '%s': %w", name, err)
+	}
+	if collector.Name() != name {
+		return nil, 0, fmt.Errorf("factory for '%s' returned collector named '%s'", config.Type, collector.Name())
+	}
+
+	if config.Enabled != nil && !*config.Enabled {
+		collector.SetEnabled(false)
+	}
+
+	return collector, interval, nil
+}
+
+// LoadCollectorConfigs reads a JSON array of collector configs
+func LoadCollectorConfigs(path string) ([]CollectorConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read collector config: %w", err)
+	}
+
+	var configs []CollectorConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("failed to parse collector config: %w", err)
+	}
+	return configs, nil
+}
+
+// requireOption returns options[key] or an error if it's empty
+func requireOption(options map[string]string, key string) (string, error) {
+	value := options[key]
+	if value == "" {
+		return "", fmt.Errorf("option '%s' is required", key)
+	}
+	return value, nil
+}
+
+// expandOption returns the environment variable an option that is exactly
+// a ${VAR} reference names. Other values may contain a literal $, e.g. in a
+// password, so they are left alone.
+func expandOption(value string) string {
+	name, ok := strings.CutPrefix(value, "${")
+	if !ok {
+		return value
+	}
+	name, ok = strings.CutSuffix(name, "}")
+	if !ok || name == "" || strings.ContainsAny(name, "${}") {
+		return value
+	}
+	return os.Getenv(name)
+}
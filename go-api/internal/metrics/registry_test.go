@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMockRegistry(t *testing.T) *Registry {
+	registry := NewRegistry()
+	err := registry.Register("mock", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		if _, err := requireOption(options, "token"); err != nil {
+			return nil, err
+		}
+		return &MockCollector{name: name, enabled: true}, nil
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	return registry
+}
+
+func TestRegistryRegister(t *testing.T) {
+	registry := newMockRegistry(t)
+
+	if err := registry.Register("mock", func(string, map[string]string, CollectorDeps) (MetricsCollector, error) { return nil, nil }); err == nil {
+		t.Error("expected error for duplicate type")
+	}
+	if err := registry.Register("a:b", func(string, map[string]string, CollectorDeps) (MetricsCollector, error) { return nil, nil }); err == nil {
+		t.Error("expected error for type containing ':'")
+	}
+
+	for _, typeName := range []string{"monitoring", "stripe", "system"} {
+		found := false
+		for _, registered := range DefaultRegistry.Types() {
+			found = found || registered == typeName
+		}
+		if !found {
+			t.Errorf("built-in type '%s' not registered", typeName)
+		}
+	}
+}
+
+func TestRegistryBuild(t *testing.T) {
+	registry := newMockRegistry(t)
+	disabled := false
+	t.Setenv("MOCK_TOKEN", "secret")
+
+	tests := []struct {
+		name         string
+		config       CollectorConfig
+		wantName     string
+		wantInterval time.Duration
+		wantEnabled  bool
+		wantErr      string
+	}{
+		{"default name", CollectorConfig{Type: "mock", Options: map[string]string{"token": "x"}}, "mock", 0, true, ""},
+		{"instance name and interval", CollectorConfig{Type: "mock", Name: "eu", Interval: "1d", Options: map[string]string{"token": "${MOCK_TOKEN}"}}, "mock:eu", 24 * time.Hour, true, ""},
+		{"disabled", CollectorConfig{Type: "mock", Name: "us", Enabled: &disabled, Options: map[string]string{"token": "x"}}, "mock:us", 0, false, ""},
+		{"unknown type", CollectorConfig{Type: "nope"}, "", 0, false, "unknown collector type"},
+		{"missing option", CollectorConfig{Type: "mock", Options: map[string]string{"token": "${UNSET_MOCK_TOKEN}"}}, "", 0, false, "option 'token' is required"},
+		{"bad interval", CollectorConfig{Type: "mock", Interval: "soon", Options: map[string]string{"token": "x"}}, "", 0, false, "invalid interval"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector, interval, err := registry.Build(tt.config, CollectorDeps{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Build() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			if collector.Name() != tt.wantName || interval != tt.wantInterval || collector.IsEnabled() != tt.wantEnabled {
+				t.Errorf("Build() = %s, %v, enabled %v", collector.Name(), interval, collector.IsEnabled())
+			}
+		})
+	}
+}
+
+func TestExpandOption(t *testing.T) {
+	t.Setenv("MOCK_TOKEN", "secret")
+
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"${MOCK_TOKEN}", "secret"},
+		{"${UNSET_MOCK_TOKEN}", ""},
+		{"pa$$word", "pa$$word"},
+		{"$MOCK_TOKEN", "$MOCK_TOKEN"},
+		{"prefix-${MOCK_TOKEN}", "prefix-${MOCK_TOKEN}"},
+		{"${MOCK_TOKEN}-suffix", "${MOCK_TOKEN}-suffix"},
+		{"${MOCK_TOKEN}${MOCK_TOKEN}", "${MOCK_TOKEN}${MOCK_TOKEN}"},
+		{"${}", "${}"},
+		{"plain", "plain"},
+	}
+
+	for _, tt := range tests {
+		if got := expandOption(tt.value); got != tt.expected {
+			t.Errorf("expandOption(%q) = %q, want %q", tt.value, got, tt.expected)
+		}
+	}
+}
+
+func TestWorkerAddFromConfigMultipleInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collectors.json")
+	os.WriteFile(path, []byte(`[
+		{"type": "stripe", "name": "eu", "options": {"api_key": "sk_test_eu"}},
+		{"type": "stripe", "name": "us", "interval": "15m", "enabled": false, "options": {"api_key": "sk_test_us"}},
+		{"type": "system"}
+	]`), 0o600)
+
+	configs, err := LoadCollectorConfigs(path)
+	if err != nil {
+		t.Fatalf("LoadCollectorConfigs() error = %v", err)
+	}
+
+	worker := NewWorker(newTestAggregator(), &syncStore{}, &MockPublisher{}, WorkerConfig{Timeout: time.Second})
+	for _, config := range configs {
+		if err := worker.AddFromConfig(DefaultRegistry, config, CollectorDeps{}); err != nil {
+			t.Fatalf("AddFromConfig() error = %v", err)
+		}
+	}
+
+	states := worker.Scheduler().States()
+	if len(states) != 3 {
+		t.Fatalf("expected 3 collectors, got %+v", states)
+	}
+	if states[0].Name != "stripe:eu" || states[0].Interval != 5*time.Minute || !states[0].Enabled {
+		t.Errorf("unexpected state %+v", states[0])
+	}
+	if states[1].Name != "stripe:us" || states[1].Interval != 15*time.Minute || states[1].Enabled {
+		t.Errorf("unexpected state %+v", states[1])
+	}
+	if states[2].Name != "system" || states[2].Interval != 30*time.Second {
+		t.Errorf("unexpected state %+v", states[2])
+	}
+}
+
+func TestSchedulerAdminHandler(t *testing.T) {
+	s := newTestScheduler(&syncStore{})
+	collector := &MockCollector{name: "stripe:eu", enabled: true}
+	s.Add(collector)
+	handler := s.AdminHandler("/admin/collectors")
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{http.MethodGet, "/admin/collectors", "", http.StatusOK},
+		{http.MethodPost, "/admin/collectors/stripe:eu", `{"enabled": false}`, http.StatusOK},
+		{http.MethodPost, "/admin/collectors/stripe:us", `{"enabled": false}`, http.StatusNotFound},
+		{http.MethodPost, "/admin/collectors/stripe:eu", `{}`, http.StatusBadRequest},
+		{http.MethodDelete, "/admin/collectors/stripe:eu", "", http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d, want %d (%s)", tt.method, tt.path, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+
+	if collector.IsEnabled() {
+		t.Error("expected collector to be disabled")
+	}
+}
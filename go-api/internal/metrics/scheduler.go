@@ -0,0 +1,482 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/resilience"
+)
+
+// SchedulerConfig holds collector scheduling configuration
+type SchedulerConfig struct {
+	// DefaultInterval is used for collectors that don't report their own
+	// through ExternalMetricsCollector
+	DefaultInterval time.Duration
+
+	// Jitter randomises each wait by up to this fraction of the interval so
+	// collectors sharing an interval don't all fire at once
+	Jitter float64
+
+	// Timeout bounds a single collection; it never exceeds the interval
+	Timeout time.Duration
+}
+
+// DefaultSchedulerConfig returns default scheduler configuration
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		DefaultInterval: 5 * time.Minute,
+		Jitter:          0.1,
+		Timeout:         30 * time.Second,
+	}
+}
+
+// CollectorState reports the schedule and recent outcomes of one collector
+type CollectorState struct {
+	Name          string                          `json:"name"`
+	Enabled       bool                            `json:"enabled"`
+	Interval      time.Duration                   `json:"interval"`
+	Timeout       time.Duration                   `json:"timeout"`
+	Running       bool                            `json:"running"`
+	Runs          int64                           `json:"runs"`
+	Failures      int64                           `json:"failures"`
+	Skipped       int64                           `json:"skipped"`
+	LastRunAt     time.Time                       `json:"last_run_at,omitempty"`
+	LastSuccessAt time.Time                       `json:"last_success_at,omitempty"`
+	LastFailureAt time.Time                       `json:"last_failure_at,omitempty"`
+	LastError     string                          `json:"last_error,omitempty"`
+	LastDuration  time.Duration                   `json:"last_duration"`
+	LastCount     int                             `json:"last_count"`
+	LastAttempts  int                             `json:"last_attempts"`
+	Circuit       resilience.CircuitBreakerState  `json:"circuit,omitempty"`
+	Breaker       *resilience.CircuitBreakerStats `json:"breaker,omitempty"`
+	NextRunAt     time.Time                       `json:"next_run_at,omitempty"`
+}
+
+// scheduleEntry is a collector with its own schedule and state
+type scheduleEntry struct {
+	collector MetricsCollector
+	interval  time.Duration
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	state CollectorState
+}
+
+// Scheduler runs every collector on its own interval. Runs of the same
+// collector never overlap: a tick that arrives while the previous run is
+// still going (even past its timeout) is skipped.
+type Scheduler struct {
+	aggregator MetricsAggregator
+	store      MetricsStore
+	publisher  MetricsPublisher
+	config     SchedulerConfig
+	log        *logger.Logger
+
+	mu      sync.RWMutex
+	entries map[string]*scheduleEntry
+
+	started bool
+	quit    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that collects through aggregator and
+// stores and publishes each collector's metrics as soon as they arrive
+func NewScheduler(aggregator MetricsAggregator, store MetricsStore, publisher MetricsPublisher, config SchedulerConfig) *Scheduler {
+	defaults := DefaultSchedulerConfig()
+	if config.DefaultInterval <= 0 {
+		config.DefaultInterval = defaults.DefaultInterval
+	}
+	if config.Jitter < 0 || config.Jitter >= 1 {
+		config.Jitter = defaults.Jitter
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+
+	return &Scheduler{
+		aggregator: aggregator,
+		store:      store,
+		publisher:  publisher,
+		config:     config,
+		log:        logger.New().WithField("component", "metrics-scheduler"),
+		entries:    make(map[string]*scheduleEntry),
+		quit:       make(chan struct{}),
+	}
+}
+
+// Add registers a collector using its own collection interval if it
+// reports one, or DefaultInterval otherwise
+func (s *Scheduler) Add(collector MetricsCollector) error {
+	interval := s.config.DefaultInterval
+	if ext, ok := collector.(ExternalMetricsCollector); ok && ext.GetCollectionInterval() > 0 {
+		interval = ext.GetCollectionInterval()
+	}
+	return s.AddWithSchedule(collector, interval, s.config.Timeout)
+}
+
+// AddWithSchedule registers a collector with an explicit interval and timeout
+func (s *Scheduler) AddWithSchedule(collector MetricsCollector, interval, timeout time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("collection interval must be positive")
+	}
+	if timeout <= 0 || timeout > interval {
+		timeout = interval
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return fmt.Errorf("cannot add collectors after the scheduler has started")
+	}
+	if err := s.aggregator.AddCollector(collector); err != nil {
+		return err
+	}
+
+	name := collector.Name()
+	s.entries[name] = &scheduleEntry{
+		collector: collector,
+		interval:  interval,
+		timeout:   timeout,
+		state:     CollectorState{Name: name, Interval: interval, Timeout: timeout},
+	}
+	return nil
+}
+
+// Start launches one goroutine per collector. The first run of each is
+// delayed by a random fraction of the jitter to spread start-up load.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	for _, entry := range s.entries {
+		s.wg.Add(1)
+		go s.loop(entry)
+	}
+}
+
+// Stop stops scheduling and waits for in-flight runs to finish
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	select {
+	case <-s.quit:
+	default:
+		close(s.quit)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RunOnce runs every enabled collector once, concurrently, and returns the
+// first error
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	s.mu.RLock()
+	entries := make([]*scheduleEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	s.mu.RUnlock()
+
+	errs := make(chan error, len(entries))
+	var wg sync.WaitGroup
+	for _, entry := range entries {
+		wg.Add(1)
+		go func(e *scheduleEntry) {
+			defer wg.Done()
+			errs <- s.run(ctx, e)
+		}(entry)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// States returns the state of every collector, sorted by name
+func (s *Scheduler) States() []CollectorState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	states := make([]CollectorState, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entry.mu.Lock()
+		state := entry.state
+		entry.mu.Unlock()
+
+		state.Enabled = entry.collector.IsEnabled()
+		states = append(states, state)
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Name < states[j].Name
+	})
+	return states
+}
+
+// ServeHTTP reports collector states as JSON
+func (s *Scheduler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.States())
+}
+
+// SetEnabled enables or disables a collector at runtime. A disabled
+// collector keeps its schedule but skips its runs.
+func (s *Scheduler) SetEnabled(name string, enabled bool) error {
+	s.mu.RLock()
+	entry, exists := s.entries[name]
+	s.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("collector with name '%s' not found", name)
+	}
+
+	entry.collector.SetEnabled(enabled)
+	s.log.Infof("collector '%s' enabled: %v", name, enabled)
+	return nil
+}
+
+// AdminHandler serves collector administration under prefix:
+//
+//	GET  {prefix}        lists collector states
+//	POST {prefix}{name}  with {"enabled": true|false} toggles a collector
+func (s *Scheduler) AdminHandler(prefix string) http.Handler {
+	return http.StripPrefix(prefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.Trim(r.URL.Path, "/")
+
+		if name == "" {
+			if r.Method != http.MethodGet {
+				writeQueryError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			s.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			writeQueryError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			writeQueryError(w, http.StatusBadRequest, `body must be {"enabled": true|false}`)
+			return
+		}
+
+		if err := s.SetEnabled(name, *body.Enabled); err != nil {
+			writeQueryError(w, http.StatusNotFound, err.Error())
+			return
+		}
+
+		for _, state := range s.States() {
+			if state.Name == name {
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode(state)
+				return
+			}
+		}
+	}))
+}
+
+func (s *Scheduler) loop(entry *scheduleEntry) {
+	defer s.wg.Done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-s.quit
+		cancel()
+	}()
+
+	delay := time.Duration(rand.Float64() * s.config.Jitter * float64(entry.interval))
+	for {
+		entry.mu.Lock()
+		entry.state.NextRunAt = time.Now().Add(delay)
+		entry.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-s.quit:
+			timer.Stop()
+			return
+		}
+
+		if err := s.run(ctx, entry); err != nil {
+			s.log.Errorf("collector '%s' failed: %v", entry.collector.Name(), err)
+		}
+
+		delay = s.jittered(entry.interval)
+	}
+}
+
+// jittered returns interval shifted by up to ±Jitter
+func (s *Scheduler) jittered(interval time.Duration) time.Duration {
+	offset := (rand.Float64()*2 - 1) * s.config.Jitter * float64(interval)
+	return interval + time.Duration(offset)
+}
+
+type collectResult struct {
+	result CollectorResult
+	err    error
+}
+
+// run collects from one collector unless it's disabled or still running
+// from an earlier tick, then stores and publishes its metrics together
+// with its health and circuit breaker metrics
+func (s *Scheduler) run(ctx context.Context, entry *scheduleEntry) error {
+	if !entry.collector.IsEnabled() {
+		return nil
+	}
+
+	entry.mu.Lock()
+	if entry.state.Running {
+		entry.state.Skipped++
+		entry.mu.Unlock()
+		return nil
+	}
+	entry.state.Running = true
+	entry.state.LastRunAt = time.Now()
+	entry.mu.Unlock()
+
+	name := entry.collector.Name()
+	start := time.Now()
+
+	runCtx, cancel := context.WithTimeout(ctx, entry.timeout)
+	defer cancel()
+
+	// Collect in the background so a collector that ignores its context
+	// can't hold up the schedule; it stays marked running until it returns
+	results := make(chan collectResult, 1)
+	go func() {
+		result, err := s.aggregator.CollectFrom(runCtx, name)
+		results <- collectResult{result: result, err: err}
+	}()
+
+	var collected collectResult
+	select {
+	case collected = <-results:
+	case <-runCtx.Done():
+		go func() {
+			<-results
+			entry.mu.Lock()
+			entry.state.Running = false
+			entry.mu.Unlock()
+		}()
+
+		result := CollectorResult{
+			Collector: name,
+			Err:       fmt.Errorf("collection timed out after %v", entry.timeout),
+			Duration:  time.Since(start),
+		}
+		s.finish(entry, result, false)
+
+		// The run's own deadline has passed; give recording its health a
+		// fresh one
+		healthCtx, cancel := context.WithTimeout(ctx, entry.timeout)
+		defer cancel()
+		if err := s.deliver(healthCtx, result.HealthMetrics(time.Now())); err != nil {
+			s.log.Errorf("failed to record health of collector '%s': %v", name, err)
+		}
+		return result.Err
+	}
+
+	if collected.err != nil {
+		result := CollectorResult{Collector: name, Err: collected.err, Duration: time.Since(start)}
+		s.finish(entry, result, true)
+		return collected.err
+	}
+
+	result := collected.result
+	err := result.Err
+
+	metrics := make([]MetricValue, 0, len(result.Metrics)+6)
+	metrics = append(metrics, result.Metrics...)
+	metrics = append(metrics, result.HealthMetrics(time.Now())...)
+	if result.Breaker.Name != "" {
+		metrics = append(metrics, CircuitBreakerMetrics(result.Breaker, time.Now())...)
+	}
+	if deliverErr := s.deliver(runCtx, metrics); deliverErr != nil && err == nil {
+		err = deliverErr
+		result.Err = err
+	}
+
+	s.finish(entry, result, true)
+	return err
+}
+
+// deliver stores and then publishes metrics
+func (s *Scheduler) deliver(ctx context.Context, metrics []MetricValue) error {
+	if err := s.store.Store(ctx, metrics); err != nil {
+		return fmt.Errorf("storage failed: %w", err)
+	}
+	if err := s.publisher.Publish(ctx, metrics); err != nil {
+		return fmt.Errorf("publishing failed: %w", err)
+	}
+	return nil
+}
+
+// finish records the outcome of a run
+func (s *Scheduler) finish(entry *scheduleEntry, result CollectorResult, done bool) {
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+
+	now := time.Now()
+	entry.state.Runs++
+	entry.state.LastDuration = result.Duration
+	entry.state.LastCount = len(result.Metrics)
+	entry.state.LastAttempts = result.Attempts
+	if result.Circuit != "" {
+		entry.state.Circuit = result.Circuit
+	}
+	if result.Breaker.Name != "" {
+		breaker := result.Breaker
+		entry.state.Breaker = &breaker
+	}
+	if done {
+		entry.state.Running = false
+	}
+
+	if result.Err != nil {
+		entry.state.Failures++
+		entry.state.LastFailureAt = now
+		entry.state.LastError = result.Err.Error()
+		return
+	}
+
+	entry.state.LastSuccessAt = now
+	entry.state.LastError = ""
+}
@@ -0,0 +1,232 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MockPublisher records published metrics
+type MockPublisher struct {
+	mu      sync.Mutex
+	metrics []MetricValue
+}
+
+func (m *MockPublisher) Publish(ctx context.Context, metrics []MetricValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metrics = append(m.metrics, metrics...)
+	return nil
+}
+
+func (m *MockPublisher) Subscribe(handler MetricsHandler) error {
+	return nil
+}
+
+func (m *MockPublisher) Unsubscribe(handler MetricsHandler) error {
+	return nil
+}
+
+// blockingCollector blocks in Collect until released, ignoring its context
+type blockingCollector struct {
+	MockCollector
+	release chan struct{}
+}
+
+func (b *blockingCollector) Collect(ctx context.Context) ([]MetricValue, error) {
+	b.MockCollector.Collect(ctx)
+	<-b.release
+	return nil, nil
+}
+
+// syncStore is a MockStore that is safe for concurrent use
+type syncStore struct {
+	mu sync.Mutex
+	MockStore
+}
+
+func (s *syncStore) Store(ctx context.Context, metrics []MetricValue) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.MockStore.Store(ctx, metrics)
+}
+
+func (s *syncStore) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.metrics)
+}
+
+func newTestScheduler(store MetricsStore) *Scheduler {
+	return NewScheduler(newTestAggregator(), store, &MockPublisher{}, SchedulerConfig{
+		DefaultInterval: time.Hour,
+		Jitter:          0,
+		Timeout:         time.Second,
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSchedulerRunsCollectorsOnOwnIntervals(t *testing.T) {
+	store := &syncStore{}
+	s := newTestScheduler(store)
+
+	fast := &MockCollector{name: "fast", enabled: true, metrics: []MetricValue{{Name: "a", Timestamp: time.Now()}}}
+	slow := &MockCollector{name: "slow", enabled: true}
+
+	if err := s.AddWithSchedule(fast, 20*time.Millisecond, 10*time.Millisecond); err != nil {
+		t.Fatalf("AddWithSchedule() error = %v", err)
+	}
+	if err := s.Add(slow); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+
+	s.Start()
+	waitFor(t, func() bool { return fast.GetCallCount() >= 3 })
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	// The slow collector's first run is at most Jitter (zero) into its hour
+	if got := slow.GetCallCount(); got != 1 {
+		t.Errorf("slow collector ran %d times, want 1", got)
+	}
+	if store.count() < 3 {
+		t.Errorf("expected stored metrics for each fast run, got %d", store.count())
+	}
+	if err := s.Add(&MockCollector{name: "late", enabled: true}); err == nil {
+		t.Error("expected error adding a collector after Start")
+	}
+}
+
+func TestSchedulerTimeoutAndNoOverlap(t *testing.T) {
+	s := newTestScheduler(&syncStore{})
+
+	stuck := &blockingCollector{
+		MockCollector: MockCollector{name: "stuck", enabled: true},
+		release:       make(chan struct{}),
+	}
+	if err := s.AddWithSchedule(stuck, time.Hour, 20*time.Millisecond); err != nil {
+		t.Fatalf("AddWithSchedule() error = %v", err)
+	}
+
+	err := s.RunOnce(context.Background())
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+
+	state := s.States()[0]
+	if !state.Running || state.Failures != 1 || state.LastError == "" || state.LastFailureAt.IsZero() {
+		t.Fatalf("unexpected state after timeout %+v", state)
+	}
+
+	// The collector is still blocked, so the next run is skipped rather
+	// than started alongside it
+	if err := s.RunOnce(context.Background()); err != nil {
+		t.Fatalf("RunOnce() error = %v", err)
+	}
+	if got := stuck.GetCallCount(); got != 1 {
+		t.Errorf("collector ran %d times concurrently, want 1", got)
+	}
+	if state := s.States()[0]; state.Skipped != 1 {
+		t.Errorf("skipped = %d, want 1", state.Skipped)
+	}
+
+	close(stuck.release)
+	waitFor(t, func() bool { return !s.States()[0].Running })
+}
+
+func TestSchedulerRecordsState(t *testing.T) {
+	store := &syncStore{}
+	s := newTestScheduler(store)
+
+	ok := &MockCollector{name: "ok", enabled: true, metrics: []MetricValue{{Name: "a", Timestamp: time.Now()}}}
+	failing := &MockCollector{name: "failing", enabled: true, err: errors.New("boom")}
+	disabled := &MockCollector{name: "disabled", enabled: false}
+
+	for _, c := range []MetricsCollector{ok, failing, disabled} {
+		if err := s.Add(c); err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+	}
+
+	if err := s.RunOnce(context.Background()); err == nil {
+		t.Fatal("expected error from failing collector")
+	}
+
+	states := make(map[string]CollectorState)
+	for _, state := range s.States() {
+		states[state.Name] = state
+	}
+
+	if st := states["ok"]; st.Runs != 1 || st.Failures != 0 || st.LastSuccessAt.IsZero() || st.LastCount != 1 || st.Interval != time.Hour {
+		t.Errorf("unexpected ok state %+v", st)
+	}
+	if st := states["failing"]; st.Failures != 1 || st.LastAttempts != 3 || !strings.Contains(st.LastError, "boom") || !st.LastSuccessAt.IsZero() {
+		t.Errorf("unexpected failing state %+v", st)
+	}
+	if st := states["disabled"]; st.Runs != 0 || st.Enabled {
+		t.Errorf("unexpected disabled state %+v", st)
+	}
+	if disabled.GetCallCount() != 0 {
+		t.Error("disabled collector should not run")
+	}
+
+	// Health metrics are stored alongside the data, including for failures
+	up := make(map[string]float64)
+	for _, m := range store.metrics {
+		if m.Name == MetricCollectorUp {
+			up[m.Tags["collector"]] = m.Value
+		}
+	}
+	if len(up) != 2 || up["ok"] != 1 || up["failing"] != 0 {
+		t.Errorf("unexpected collector_up values %v", up)
+	}
+
+	// So are the metrics of each collector's circuit breaker
+	if st := states["failing"]; st.Breaker == nil || st.Breaker.Name != "collector:failing" || st.Breaker.Failures != 1 {
+		t.Errorf("unexpected failing breaker %+v", st.Breaker)
+	}
+	rates := make(map[string]float64)
+	for _, m := range store.metrics {
+		if m.Name == MetricCircuitBreakerFailureRate {
+			rates[m.Tags["breaker"]] = m.Value
+		}
+	}
+	if len(rates) != 2 || rates["collector:ok"] != 0 || rates["collector:failing"] != 1 {
+		t.Errorf("unexpected circuit_breaker_failure_rate values %v", rates)
+	}
+}
+
+func TestSchedulerServeHTTP(t *testing.T) {
+	s := newTestScheduler(&syncStore{})
+	s.Add(&MockCollector{name: "b", enabled: true})
+	s.Add(&MockCollector{name: "a", enabled: true})
+	s.RunOnce(context.Background())
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats/collectors", nil))
+
+	var states []CollectorState
+	if err := json.NewDecoder(rec.Body).Decode(&states); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(states) != 2 || states[0].Name != "a" || states[1].Runs != 1 {
+		t.Fatalf("unexpected response %+v", states)
+	}
+}
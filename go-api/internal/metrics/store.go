@@ -5,40 +5,127 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
+
+	"api-monitor-go/internal/database"
 )
 
-// PostgresMetricsStore stores metrics in PostgreSQL
+// PostgresMetricsStore stores metrics in PostgreSQL. Writes go through a
+// write-behind batcher, so close the store to flush what is still queued.
 type PostgresMetricsStore struct {
-	db *sql.DB
+	db      *sql.DB
+	batcher *database.Batcher[MetricValue]
 }
 
 // NewPostgresMetricsStore creates a new PostgreSQL metrics store
 func NewPostgresMetricsStore(db *sql.DB) *PostgresMetricsStore {
-	return &PostgresMetricsStore{
+	return NewPostgresMetricsStoreWithConfig(db, database.BatcherConfig{Name: "system_metrics"})
+}
+
+// NewPostgresMetricsStoreWithConfig creates a PostgreSQL metrics store
+// whose writes are batched as configured
+func NewPostgresMetricsStoreWithConfig(db *sql.DB, batchConfig database.BatcherConfig) *PostgresMetricsStore {
+	s := &PostgresMetricsStore{
 		db: db,
 	}
+	s.batcher = database.NewBatcher(batchConfig, s.insert)
+	return s
+}
+
+// Close flushes queued metrics and stops accepting new ones
+func (s *PostgresMetricsStore) Close(ctx context.Context) error {
+	return s.batcher.Close(ctx)
+}
+
+// BatcherStats returns batch size and flush latency statistics for
+// metric writes
+func (s *PostgresMetricsStore) BatcherStats() database.BatcherStats {
+	return s.batcher.Stats()
 }
 
-// Store saves metrics to persistent storage
+// storeBatchSize caps rows per INSERT to stay well below PostgreSQL's
+// 65535 bind parameter limit (6 parameters per row)
+const storeBatchSize = 1000
+
+// Store queues metrics for the next batch. It blocks while the queue is
+// full, pushing back on collectors when PostgreSQL is slow.
 func (s *PostgresMetricsStore) Store(ctx context.Context, metrics []MetricValue) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, metric := range metrics {
+		if err := s.batcher.Add(ctx, metric); err != nil {
+			return fmt.Errorf("failed to queue metric '%s': %w", metric.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// Write stores metrics immediately instead of queueing them, for callers
+// that must not acknowledge metrics before they are stored
+func (s *PostgresMetricsStore) Write(ctx context.Context, metrics []MetricValue) error {
+	return s.insert(ctx, metrics)
+}
+
+// insert writes a batch of metrics in a single transaction using
+// multi-row INSERTs
+func (s *PostgresMetricsStore) insert(ctx context.Context, metrics []MetricValue) error {
 	if len(metrics) == 0 {
 		return nil
 	}
 
-	query := `
-		INSERT INTO system_metrics (name, type, value, timestamp, tags, description)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		ON CONFLICT DO NOTHING
-	`
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	for _, metric := range metrics {
+	for start := 0; start < len(metrics); start += storeBatchSize {
+		end := start + storeBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		query, args, err := buildInsertQuery(metrics[start:end])
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+			return fmt.Errorf("failed to store %d metrics: %w", end-start, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit metrics: %w", err)
+	}
+
+	return nil
+}
+
+// buildInsertQuery builds one multi-row INSERT for the given metrics
+func buildInsertQuery(metrics []MetricValue) (string, []interface{}, error) {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO system_metrics (name, type, value, timestamp, tags, description) VALUES ")
+
+	args := make([]interface{}, 0, len(metrics)*6)
+	for i, metric := range metrics {
 		tagsJSON, err := json.Marshal(metric.Tags)
 		if err != nil {
-			return fmt.Errorf("failed to marshal tags: %w", err)
+			return "", nil, fmt.Errorf("failed to marshal tags for '%s': %w", metric.Name, err)
+		}
+
+		if i > 0 {
+			sb.WriteString(", ")
 		}
+		n := len(args)
+		fmt.Fprintf(&sb, "($%d, $%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5, n+6)
 
-		_, err = s.db.ExecContext(ctx, query,
+		args = append(args,
 			metric.Name,
 			metric.Type,
 			metric.Value,
@@ -46,12 +133,10 @@ func (s *PostgresMetricsStore) Store(ctx context.Context, metrics []MetricValue)
 			tagsJSON,
 			metric.Description,
 		)
-		if err != nil {
-			return fmt.Errorf("failed to store metric '%s': %w", metric.Name, err)
-		}
 	}
+	sb.WriteString(" ON CONFLICT DO NOTHING")
 
-	return nil
+	return sb.String(), args, nil
 }
 
 // Retrieve fetches metrics for a time range
@@ -69,39 +154,7 @@ func (s *PostgresMetricsStore) Retrieve(ctx context.Context, startTime, endTime
 	}
 	defer rows.Close()
 
-	var metrics []MetricValue
-	for rows.Next() {
-		var metric MetricValue
-		var tagsJSON []byte
-
-		err := rows.Scan(
-			&metric.Name,
-			&metric.Type,
-			&metric.Value,
-			&metric.Timestamp,
-			&tagsJSON,
-			&metric.Description,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan metric: %w", err)
-		}
-
-		metric.Tags = make(map[string]string)
-		if len(tagsJSON) > 0 {
-			err = json.Unmarshal(tagsJSON, &metric.Tags)
-			if err != nil {
-				return nil, fmt.Errorf("failed to unmarshal tags: %w", err)
-			}
-		}
-
-		metrics = append(metrics, metric)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating metric rows: %w", err)
-	}
-
-	return metrics, nil
+	return scanMetrics(rows)
 }
 
 // RetrieveByName fetches metrics by name
@@ -119,6 +172,92 @@ func (s *PostgresMetricsStore) RetrieveByName(ctx context.Context, name string,
 	}
 	defer rows.Close()
 
+	return scanMetrics(rows)
+}
+
+// Select fetches metrics by name whose tags satisfy every matcher, oldest
+// first. Matchers are applied in SQL; equality matchers use the tags index.
+func (s *PostgresMetricsStore) Select(ctx context.Context, name string, matchers []*Matcher, startTime, endTime time.Time) ([]MetricValue, error) {
+	query, args, err := buildSelectQuery(name, matchers, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select metrics: %w", err)
+	}
+	defer rows.Close()
+
+	metrics, err := scanMetrics(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return filterMatching(metrics, matchers), nil
+}
+
+// buildSelectQuery builds the query behind Select
+func buildSelectQuery(name string, matchers []*Matcher, startTime, endTime time.Time) (string, []interface{}, error) {
+	f := &sqlFilter{}
+	conds := []string{
+		"name = " + f.arg(name),
+		"timestamp BETWEEN " + f.arg(startTime) + " AND " + f.arg(endTime),
+	}
+
+	contains := make(map[string]string)
+	for _, m := range matchers {
+		// An empty value also matches a missing tag, which @> can't express
+		if m.Type == MatchEqual && m.Value != "" {
+			contains[m.Name] = m.Value
+			continue
+		}
+		conds = append(conds, f.match("COALESCE(tags->>"+f.arg(m.Name)+", '')", m))
+	}
+	if len(contains) > 0 {
+		containsJSON, err := json.Marshal(contains)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to marshal tag filter: %w", err)
+		}
+		conds = append(conds, "tags @> "+f.arg(containsJSON))
+	}
+
+	query := `
+		SELECT name, type, value, timestamp, tags, description
+		FROM system_metrics
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY timestamp ASC`
+	return query, f.args, nil
+}
+
+// sqlFilter collects the arguments of a query's conditions
+type sqlFilter struct {
+	args []interface{}
+}
+
+// arg adds an argument and returns its placeholder
+func (f *sqlFilter) arg(v interface{}) string {
+	f.args = append(f.args, v)
+	return "$" + strconv.Itoa(len(f.args))
+}
+
+// match returns the condition that the text expression expr satisfies m.
+// Regular expressions are anchored like Matcher.Matches.
+func (f *sqlFilter) match(expr string, m *Matcher) string {
+	switch m.Type {
+	case MatchNotEqual:
+		return expr + " <> " + f.arg(m.Value)
+	case MatchRegexp:
+		return expr + " ~ " + f.arg("^(?:"+m.Value+")$")
+	case MatchNotRegexp:
+		return expr + " !~ " + f.arg("^(?:"+m.Value+")$")
+	default:
+		return expr + " = " + f.arg(m.Value)
+	}
+}
+
+// scanMetrics reads name, type, value, timestamp, tags, description rows
+func scanMetrics(rows *sql.Rows) ([]MetricValue, error) {
 	var metrics []MetricValue
 	for rows.Next() {
 		var metric MetricValue
@@ -153,46 +292,3 @@ func (s *PostgresMetricsStore) RetrieveByName(ctx context.Context, name string,
 
 	return metrics, nil
 }
-
-// Aggregate calculates aggregate statistics for a metric
-func (s *PostgresMetricsStore) Aggregate(ctx context.Context, name string, startTime, endTime time.Time) (map[string]interface{}, error) {
-	query := `
-		SELECT 
-			COUNT(*) as count,
-			AVG(value) as average,
-			MIN(value) as minimum,
-			MAX(value) as maximum,
-			STDDEV(value) as stddev
-		FROM system_metrics
-		WHERE name = $1 AND timestamp BETWEEN $2 AND $3
-	`
-
-	var count int
-	var avg, min, max, stddev sql.NullFloat64
-
-	err := s.db.QueryRowContext(ctx, query, name, startTime, endTime).
-		Scan(&count, &avg, &min, &max, &stddev)
-	if err != nil {
-		return nil, fmt.Errorf("failed to aggregate metrics: %w", err)
-	}
-
-	result := map[string]interface{}{
-		"name":  name,
-		"count": count,
-	}
-
-	if avg.Valid {
-		result["average"] = avg.Float64
-	}
-	if min.Valid {
-		result["minimum"] = min.Float64
-	}
-	if max.Valid {
-		result["maximum"] = max.Float64
-	}
-	if stddev.Valid {
-		result["stddev"] = stddev.Float64
-	}
-
-	return result, nil
-}
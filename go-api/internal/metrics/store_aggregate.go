@@ -0,0 +1,435 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"api-monitor-go/internal/retention"
+	"api-monitor-go/internal/sketch"
+)
+
+// DefaultPercentiles are reported when an AggregateQuery doesn't set any
+var DefaultPercentiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+// DefaultHistogramBuckets are the upper bounds used for histogram metrics
+// when an AggregateQuery doesn't set any
+var DefaultHistogramBuckets = []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+
+// AggregateQuery describes which metric to aggregate and how
+type AggregateQuery struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+
+	// Interval splits the range into buckets aligned to multiples of
+	// Interval; zero aggregates the whole range into one bucket.
+	Interval time.Duration
+
+	// GroupBy lists tag keys; each distinct combination is its own series
+	GroupBy []string
+
+	// Percentiles to report, between 0 and 1. Defaults to DefaultPercentiles.
+	Percentiles []float64
+
+	// HistogramBuckets are upper bounds. Histograms are reported for
+	// MetricTypeHistogram metrics, or for any metric when this is set.
+	HistogramBuckets []float64
+}
+
+// AggregateResult is the outcome of MetricsStore.Aggregate
+type AggregateResult struct {
+	Name     string            `json:"name"`
+	Type     MetricType        `json:"type,omitempty"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Interval time.Duration     `json:"interval,omitempty"`
+	Series   []AggregateSeries `json:"series"`
+}
+
+// AggregateSeries holds the buckets for one combination of group-by tags
+type AggregateSeries struct {
+	Tags    map[string]string `json:"tags"`
+	Buckets []AggregateBucket `json:"buckets"`
+}
+
+// AggregateBucket holds the statistics for one time bucket
+type AggregateBucket struct {
+	Start   time.Time `json:"start"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+	Average float64   `json:"average"`
+	Minimum float64   `json:"minimum"`
+	Maximum float64   `json:"maximum"`
+	StdDev  float64   `json:"stddev"`
+	P50     float64   `json:"p50"`
+	P90     float64   `json:"p90"`
+	P95     float64   `json:"p95"`
+	P99     float64   `json:"p99"`
+
+	// Percentiles holds the requested percentiles keyed like "p99.9"
+	Percentiles map[string]float64 `json:"percentiles"`
+
+	// Histogram holds cumulative counts per upper bound; the implicit +Inf
+	// bucket equals Count.
+	Histogram []HistogramBucket `json:"histogram,omitempty"`
+}
+
+// HistogramBucket is a cumulative histogram bucket
+type HistogramBucket struct {
+	UpperBound float64 `json:"le"`
+	Count      uint64  `json:"count"`
+}
+
+// PercentileLabel formats a quantile as a percentile key, e.g. 0.999 -> "p99.9"
+func PercentileLabel(q float64) string {
+	return "p" + strconv.FormatFloat(math.Round(q*1e6)/1e4, 'f', -1, 64)
+}
+
+// Aggregate calculates count, sum, average, min, max, stddev, percentiles
+// and histograms for a metric. Ranges already rolled up by the retention
+// service are read from the coarsest suitable rollup and only the edges
+// that aren't covered are read from raw rows.
+func (s *PostgresMetricsStore) Aggregate(ctx context.Context, query AggregateQuery) (*AggregateResult, error) {
+	if query.Name == "" {
+		return nil, fmt.Errorf("metric name is required")
+	}
+	if !query.End.After(query.Start) {
+		return nil, fmt.Errorf("end time must be after start time")
+	}
+	if query.Interval < 0 {
+		return nil, fmt.Errorf("interval must not be negative")
+	}
+	for _, q := range query.Percentiles {
+		if q < 0 || q > 1 {
+			return nil, fmt.Errorf("percentile %v out of range [0, 1]", q)
+		}
+	}
+
+	query.Start = query.Start.UTC()
+	query.End = query.End.UTC()
+
+	watermarks, err := retention.Watermarks(ctx, s.db, retention.SourceSystemMetrics)
+	if err != nil {
+		return nil, err
+	}
+
+	agg := newAggregation(query)
+	if err := s.aggregateRange(ctx, agg, query.Start, query.End, coarsestResolution(query), watermarks); err != nil {
+		return nil, err
+	}
+
+	if agg.metricType == "" {
+		if err := s.lookupType(ctx, agg); err != nil {
+			return nil, err
+		}
+	}
+
+	return agg.result(), nil
+}
+
+// coarsestResolution returns the index of the coarsest rollup resolution
+// that fits in the range and evenly divides the bucket interval, or -1.
+func coarsestResolution(query AggregateQuery) int {
+	for i := len(retention.Resolutions) - 1; i >= 0; i-- {
+		step := retention.Resolutions[i].Step
+		if step > query.End.Sub(query.Start) {
+			continue
+		}
+		if query.Interval > 0 && query.Interval%step != 0 {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// aggregateRange covers [from, to) with rollups at resolution resIdx where
+// they exist and recurses to finer resolutions, and finally raw rows, for
+// the parts that aren't aligned or aren't rolled up yet.
+func (s *PostgresMetricsStore) aggregateRange(ctx context.Context, agg *aggregation, from, to time.Time, resIdx int, watermarks map[string]time.Time) error {
+	if !from.Before(to) {
+		return nil
+	}
+	if resIdx < 0 {
+		return s.aggregateRaw(ctx, agg, from, to)
+	}
+
+	res := retention.Resolutions[resIdx]
+	start := alignUp(from, res.Step)
+	end := to.Truncate(res.Step)
+	if watermark, ok := watermarks[res.Name]; !ok {
+		end = start
+	} else if watermark.Before(end) {
+		end = watermark.Truncate(res.Step)
+	}
+
+	if !start.Before(end) {
+		return s.aggregateRange(ctx, agg, from, to, resIdx-1, watermarks)
+	}
+
+	if err := s.aggregateRange(ctx, agg, from, start, resIdx-1, watermarks); err != nil {
+		return err
+	}
+	if err := s.aggregateRollups(ctx, agg, res.Name, start, end); err != nil {
+		return err
+	}
+	return s.aggregateRange(ctx, agg, end, to, resIdx-1, watermarks)
+}
+
+// aggregateRaw folds raw system_metrics rows in [from, to) into agg
+func (s *PostgresMetricsStore) aggregateRaw(ctx context.Context, agg *aggregation, from, to time.Time) error {
+	query := `
+		SELECT type, tags, value, timestamp
+		FROM system_metrics
+		WHERE name = $1 AND timestamp >= $2 AND timestamp < $3
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, agg.query.Name, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to query metrics: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var metricType MetricType
+		var tagsJSON []byte
+		var value float64
+		var timestamp time.Time
+
+		if err := rows.Scan(&metricType, &tagsJSON, &value, &timestamp); err != nil {
+			return fmt.Errorf("failed to scan metric: %w", err)
+		}
+
+		tags, err := decodeTags(tagsJSON)
+		if err != nil {
+			return err
+		}
+
+		if agg.metricType == "" {
+			agg.metricType = metricType
+		}
+		agg.bucket(tags, timestamp).Add(value)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating metric rows: %w", err)
+	}
+
+	return nil
+}
+
+// aggregateRollups merges system_metric_rollups sketches in [from, to) into agg
+func (s *PostgresMetricsStore) aggregateRollups(ctx context.Context, agg *aggregation, resolution string, from, to time.Time) error {
+	query := `
+		SELECT tags, bucket_start, sketch
+		FROM system_metric_rollups
+		WHERE name = $1 AND resolution = $2 AND bucket_start >= $3 AND bucket_start < $4
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, agg.query.Name, resolution, from, to)
+	if err != nil {
+		return fmt.Errorf("failed to query metric rollups: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var tagsJSON, sketchJSON []byte
+		var bucketStart time.Time
+
+		if err := rows.Scan(&tagsJSON, &bucketStart, &sketchJSON); err != nil {
+			return fmt.Errorf("failed to scan metric rollup: %w", err)
+		}
+		if len(sketchJSON) == 0 {
+			continue
+		}
+
+		tags, err := decodeTags(tagsJSON)
+		if err != nil {
+			return err
+		}
+
+		var sk sketch.Sketch
+		if err := json.Unmarshal(sketchJSON, &sk); err != nil {
+			return err
+		}
+
+		if err := agg.bucket(tags, bucketStart).Merge(&sk); err != nil {
+			return fmt.Errorf("failed to merge %s rollup at %s: %w", resolution, bucketStart, err)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating metric rollup rows: %w", err)
+	}
+
+	return nil
+}
+
+// lookupType finds the metric type when all data came from rollups
+func (s *PostgresMetricsStore) lookupType(ctx context.Context, agg *aggregation) error {
+	err := s.db.QueryRowContext(ctx,
+		`SELECT type FROM system_metrics WHERE name = $1 LIMIT 1`, agg.query.Name,
+	).Scan(&agg.metricType)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to look up metric type: %w", err)
+	}
+	return nil
+}
+
+func decodeTags(data []byte) (map[string]string, error) {
+	tags := make(map[string]string)
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tags: %w", err)
+		}
+	}
+	return tags, nil
+}
+
+// alignUp rounds t up to a multiple of step
+func alignUp(t time.Time, step time.Duration) time.Time {
+	aligned := t.Truncate(step)
+	if aligned.Before(t) {
+		aligned = aligned.Add(step)
+	}
+	return aligned
+}
+
+// aggregation accumulates one sketch per series and time bucket
+type aggregation struct {
+	query      AggregateQuery
+	metricType MetricType
+	series     map[string]*seriesAggregation
+}
+
+type seriesAggregation struct {
+	tags    map[string]string
+	buckets map[time.Time]*sketch.Sketch
+}
+
+func newAggregation(query AggregateQuery) *aggregation {
+	return &aggregation{
+		query:  query,
+		series: make(map[string]*seriesAggregation),
+	}
+}
+
+// bucket returns the sketch for the series and bucket that tags and t fall in
+func (a *aggregation) bucket(tags map[string]string, t time.Time) *sketch.Sketch {
+	group := make(map[string]string, len(a.query.GroupBy))
+	for _, key := range a.query.GroupBy {
+		if value, ok := tags[key]; ok {
+			group[key] = value
+		}
+	}
+
+	// json.Marshal sorts map keys, so equal groups get equal keys
+	key, _ := json.Marshal(group)
+	series, ok := a.series[string(key)]
+	if !ok {
+		series = &seriesAggregation{tags: group, buckets: make(map[time.Time]*sketch.Sketch)}
+		a.series[string(key)] = series
+	}
+
+	start := a.query.Start
+	if a.query.Interval > 0 {
+		start = t.UTC().Truncate(a.query.Interval)
+	}
+
+	sk, ok := series.buckets[start]
+	if !ok {
+		sk = sketch.New(sketch.DefaultRelativeAccuracy)
+		series.buckets[start] = sk
+	}
+	return sk
+}
+
+// result converts the accumulated sketches into an AggregateResult
+func (a *aggregation) result() *AggregateResult {
+	percentiles := a.query.Percentiles
+	if len(percentiles) == 0 {
+		percentiles = DefaultPercentiles
+	}
+
+	var bounds []float64
+	if len(a.query.HistogramBuckets) > 0 {
+		bounds = append(bounds, a.query.HistogramBuckets...)
+		sort.Float64s(bounds)
+	} else if a.metricType == MetricTypeHistogram {
+		bounds = DefaultHistogramBuckets
+	}
+
+	keys := make([]string, 0, len(a.series))
+	for key := range a.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := &AggregateResult{
+		Name:     a.query.Name,
+		Type:     a.metricType,
+		Start:    a.query.Start,
+		End:      a.query.End,
+		Interval: a.query.Interval,
+		Series:   make([]AggregateSeries, 0, len(keys)),
+	}
+
+	for _, key := range keys {
+		series := a.series[key]
+
+		starts := make([]time.Time, 0, len(series.buckets))
+		for start := range series.buckets {
+			starts = append(starts, start)
+		}
+		sort.Slice(starts, func(i, j int) bool {
+			return starts[i].Before(starts[j])
+		})
+
+		out := AggregateSeries{Tags: series.tags, Buckets: make([]AggregateBucket, 0, len(starts))}
+		for _, start := range starts {
+			out.Buckets = append(out.Buckets, summarize(start, series.buckets[start], percentiles, bounds))
+		}
+		result.Series = append(result.Series, out)
+	}
+
+	return result
+}
+
+// summarize computes the statistics of one bucket from its sketch
+func summarize(start time.Time, sk *sketch.Sketch, percentiles, bounds []float64) AggregateBucket {
+	bucket := AggregateBucket{
+		Start:       start,
+		Count:       sk.Count(),
+		Sum:         sk.Sum(),
+		Minimum:     sk.Min(),
+		Maximum:     sk.Max(),
+		StdDev:      sk.StdDev(),
+		P50:         sk.Quantile(0.5),
+		P90:         sk.Quantile(0.9),
+		P95:         sk.Quantile(0.95),
+		P99:         sk.Quantile(0.99),
+		Percentiles: make(map[string]float64, len(percentiles)),
+	}
+	if bucket.Count > 0 {
+		bucket.Average = bucket.Sum / float64(bucket.Count)
+	}
+
+	for _, q := range percentiles {
+		bucket.Percentiles[PercentileLabel(q)] = sk.Quantile(q)
+	}
+
+	if len(bounds) > 0 {
+		bucket.Histogram = make([]HistogramBucket, len(bounds))
+		for i, bound := range bounds {
+			bucket.Histogram[i] = HistogramBucket{UpperBound: bound, Count: sk.CountAtOrBelow(bound)}
+		}
+	}
+
+	return bucket
+}
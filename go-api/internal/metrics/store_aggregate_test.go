@@ -0,0 +1,165 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/sketch"
+)
+
+func TestCoarsestResolution(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		duration time.Duration
+		interval time.Duration
+		want     int
+	}{
+		{name: "under a minute uses raw rows", duration: 30 * time.Second, want: -1},
+		{name: "hours use 1h", duration: 6 * time.Hour, want: 1},
+		{name: "weeks use 1d", duration: 7 * 24 * time.Hour, want: 2},
+		{name: "interval limits resolution", duration: 7 * 24 * time.Hour, interval: 5 * time.Minute, want: 0},
+		{name: "odd interval uses raw rows", duration: time.Hour, interval: 90 * time.Second, want: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := AggregateQuery{Start: base, End: base.Add(tt.duration), Interval: tt.interval}
+			if got := coarsestResolution(query); got != tt.want {
+				t.Errorf("coarsestResolution() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAlignUp(t *testing.T) {
+	base := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	if got := alignUp(base, time.Hour); !got.Equal(base) {
+		t.Errorf("alignUp(aligned) = %v, want %v", got, base)
+	}
+	if got := alignUp(base.Add(time.Second), time.Hour); !got.Equal(base.Add(time.Hour)) {
+		t.Errorf("alignUp(unaligned) = %v, want %v", got, base.Add(time.Hour))
+	}
+}
+
+func TestAggregationGroupsByTagsAndInterval(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	agg := newAggregation(AggregateQuery{
+		Name:     "latency",
+		Start:    base,
+		End:      base.Add(2 * time.Minute),
+		Interval: time.Minute,
+		GroupBy:  []string{"region"},
+	})
+
+	for i := 1; i <= 100; i++ {
+		agg.bucket(map[string]string{"region": "eu", "host": "a"}, base.Add(10*time.Second)).Add(float64(i))
+	}
+	agg.bucket(map[string]string{"region": "eu", "host": "b"}, base.Add(70*time.Second)).Add(500)
+	agg.bucket(map[string]string{"region": "us"}, base).Add(1)
+
+	result := agg.result()
+	if len(result.Series) != 2 {
+		t.Fatalf("expected 2 series, got %d", len(result.Series))
+	}
+
+	eu := result.Series[0]
+	if eu.Tags["region"] != "eu" || len(eu.Tags) != 1 {
+		t.Fatalf("unexpected tags %v", eu.Tags)
+	}
+	if len(eu.Buckets) != 2 {
+		t.Fatalf("expected 2 buckets, got %d", len(eu.Buckets))
+	}
+
+	first := eu.Buckets[0]
+	if !first.Start.Equal(base) || first.Count != 100 || first.Average != 50.5 {
+		t.Errorf("unexpected first bucket %+v", first)
+	}
+	if first.P50 < 49 || first.P50 > 51 || first.P99 < 98 || first.P99 > 100 {
+		t.Errorf("percentiles p50=%v p99=%v out of expected range", first.P50, first.P99)
+	}
+	if _, ok := first.Percentiles["p95"]; !ok {
+		t.Errorf("default percentiles missing p95: %v", first.Percentiles)
+	}
+	if first.Histogram != nil {
+		t.Error("gauge metrics should not get a histogram by default")
+	}
+
+	if second := eu.Buckets[1]; !second.Start.Equal(base.Add(time.Minute)) || second.Count != 1 {
+		t.Errorf("unexpected second bucket %+v", second)
+	}
+}
+
+func TestAggregationHistogram(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	agg := newAggregation(AggregateQuery{
+		Name:        "request_duration",
+		Start:       base,
+		End:         base.Add(time.Hour),
+		Percentiles: []float64{0.999},
+	})
+	agg.metricType = MetricTypeHistogram
+
+	for _, v := range []float64{3, 8, 40, 40, 700, 20000} {
+		agg.bucket(nil, base).Add(v)
+	}
+
+	bucket := agg.result().Series[0].Buckets[0]
+	if len(bucket.Histogram) != len(DefaultHistogramBuckets) {
+		t.Fatalf("expected %d histogram buckets, got %d", len(DefaultHistogramBuckets), len(bucket.Histogram))
+	}
+
+	want := map[float64]uint64{5: 1, 10: 2, 50: 4, 1000: 5, 10000: 5}
+	for _, h := range bucket.Histogram {
+		if n, ok := want[h.UpperBound]; ok && h.Count != n {
+			t.Errorf("histogram le=%v count = %d, want %d", h.UpperBound, h.Count, n)
+		}
+	}
+
+	if _, ok := bucket.Percentiles["p99.9"]; !ok {
+		t.Errorf("configured percentile missing: %v", bucket.Percentiles)
+	}
+}
+
+func TestAggregationMergesRollupSketches(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	agg := newAggregation(AggregateQuery{Name: "latency", Start: base, End: base.Add(2 * time.Hour)})
+
+	// A stored rollup sketch plus raw rows must combine like one sketch
+	stored := sketch.New(sketch.DefaultRelativeAccuracy)
+	for i := 1; i <= 50; i++ {
+		stored.Add(float64(i))
+	}
+	data, err := json.Marshal(stored)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var decoded sketch.Sketch
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if err := agg.bucket(nil, base).Merge(&decoded); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+	for i := 51; i <= 100; i++ {
+		agg.bucket(nil, base.Add(time.Hour)).Add(float64(i))
+	}
+
+	bucket := agg.result().Series[0].Buckets[0]
+	if bucket.Count != 100 || bucket.Minimum != 1 || bucket.Maximum != 100 || bucket.Sum != 5050 {
+		t.Errorf("unexpected merged bucket %+v", bucket)
+	}
+}
+
+func TestPercentileLabel(t *testing.T) {
+	tests := map[float64]string{0.5: "p50", 0.99: "p99", 0.999: "p99.9"}
+	for q, want := range tests {
+		if got := PercentileLabel(q); got != want {
+			t.Errorf("PercentileLabel(%v) = %q, want %q", q, got, want)
+		}
+	}
+}
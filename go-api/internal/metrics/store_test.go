@@ -270,10 +270,15 @@ func TestAggregate(t *testing.T) {
 
 	store.Store(context.Background(), metrics)
 
+	// Flush the write-behind batch before reading it back
+	if err := store.Close(context.Background()); err != nil {
+		t.Fatalf("failed to flush metrics: %v", err)
+	}
+
 	startTime := now.Add(-1 * time.Minute)
 	endTime := now.Add(1 * time.Minute)
 
-	result, err := store.Aggregate(context.Background(), metricName, startTime, endTime)
+	result, err := store.Aggregate(context.Background(), AggregateQuery{Name: metricName, Start: startTime, End: endTime})
 	if err != nil {
 		t.Fatalf("failed to aggregate: %v", err)
 	}
@@ -282,8 +287,13 @@ func TestAggregate(t *testing.T) {
 		t.Fatalf("expected aggregation result")
 	}
 
-	if count, ok := result["count"]; ok {
-		t.Logf("Aggregation count: %v", count)
+	if len(result.Series) == 1 && len(result.Series[0].Buckets) == 1 {
+		bucket := result.Series[0].Buckets[0]
+		if bucket.Count != 3 || bucket.Average != 20 || bucket.Minimum != 10 || bucket.Maximum != 30 {
+			t.Fatalf("unexpected aggregate: %+v", bucket)
+		}
+	} else {
+		t.Fatalf("expected one series with one bucket, got %+v", result.Series)
 	}
 }
 
@@ -297,7 +307,7 @@ func TestAggregateNonexistentMetric(t *testing.T) {
 	startTime := now.Add(-1 * time.Hour)
 	endTime := now.Add(1 * time.Hour)
 
-	result, err := store.Aggregate(context.Background(), "nonexistent", startTime, endTime)
+	result, err := store.Aggregate(context.Background(), AggregateQuery{Name: "nonexistent", Start: startTime, End: endTime})
 	if err != nil {
 		t.Fatalf("expected no error: %v", err)
 	}
@@ -306,8 +316,8 @@ func TestAggregateNonexistentMetric(t *testing.T) {
 		t.Fatalf("expected aggregation result")
 	}
 
-	if count, ok := result["count"]; ok && count == 0 {
-		t.Logf("Aggregation returned 0 count as expected")
+	if len(result.Series) != 0 {
+		t.Fatalf("expected no series for nonexistent metric, got %d", len(result.Series))
 	}
 }
 
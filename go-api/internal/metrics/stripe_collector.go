@@ -2,37 +2,132 @@ package metrics
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"sync/atomic"
 	"time"
 
+	"api-monitor-go/internal/resilience"
 	"github.com/stripe/stripe-go/v72"
-	"github.com/stripe/stripe-go/v72/charge"
-	"github.com/stripe/stripe-go/v72/customer"
-	"github.com/stripe/stripe-go/v72/subscription"
+	"github.com/stripe/stripe-go/v72/client"
 )
 
-// StripeMetricsCollector collects metrics from Stripe API
+func init() {
+	Register("stripe", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		apiKey, err := requireOption(options, "api_key")
+		if err != nil {
+			return nil, err
+		}
+
+		collector := NewStripeMetricsCollectorWithConfig(StripeConfig{
+			APIKey:  apiKey,
+			BaseURL: options["base_url"],
+			Cursors: deps.Cursors,
+		})
+		collector.name = name
+		return collector, nil
+	})
+}
+
+// stripePageSize is the largest page Stripe's list endpoints return
+const stripePageSize = 100
+
+// StripeConfig holds Stripe collector configuration
+type StripeConfig struct {
+	APIKey string
+
+	// BaseURL overrides Stripe's API URL, e.g. for a local stand-in
+	BaseURL string
+
+	HTTPClient *http.Client
+
+	// Cursors persists how far charges and customers have been read. When
+	// nil, cursors live in memory and every restart reads from the start.
+	Cursors CursorStore
+
+	// Rate-limited (429) requests are retried up to MaxRetries times with
+	// exponential backoff between InitialBackoff and MaxBackoff, as long as
+	// the wait ends before the collection's deadline
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// DefaultStripeConfig returns default Stripe collector configuration
+func DefaultStripeConfig() StripeConfig {
+	return StripeConfig{
+		HTTPClient:     &http.Client{Timeout: 10 * time.Second},
+		MaxRetries:     5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+	}
+}
+
+// StripeMetricsCollector collects metrics from Stripe API. Customers and
+// charges are read incrementally from a persisted cursor; subscriptions
+// and the balance are read in full because they change in place.
 type StripeMetricsCollector struct {
-	name                string
-	enabled             bool
-	lastUpdateTime      time.Time
-	collectionInterval  time.Duration
-	apiKey              string
-	httpClient          *http.Client
+	name               string
+	enabled            atomic.Bool
+	lastUpdateTime     time.Time
+	collectionInterval time.Duration
+	config             StripeConfig
+	client             *client.API
+	retrier            *resilience.Retrier
+	cursors            CursorStore
 }
 
 // NewStripeMetricsCollector creates a new Stripe metrics collector
 func NewStripeMetricsCollector(apiKey string) *StripeMetricsCollector {
-	stripe.Key = apiKey
+	return NewStripeMetricsCollectorWithConfig(StripeConfig{APIKey: apiKey})
+}
+
+// NewStripeMetricsCollectorWithConfig creates a Stripe metrics collector
+// with custom configuration
+func NewStripeMetricsCollectorWithConfig(config StripeConfig) *StripeMetricsCollector {
+	defaults := DefaultStripeConfig()
+	if config.HTTPClient == nil {
+		config.HTTPClient = defaults.HTTPClient
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = defaults.MaxRetries
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaults.InitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaults.MaxBackoff
+	}
+	if config.Cursors == nil {
+		config.Cursors = NewMemoryCursorStore()
+	}
+
+	// Rate limits are handled here so the library must not retry as well
+	backendConfig := &stripe.BackendConfig{
+		HTTPClient:        config.HTTPClient,
+		MaxNetworkRetries: stripe.Int64(0),
+		LeveledLogger:     &stripe.LeveledLogger{Level: stripe.LevelError},
+	}
+	if config.BaseURL != "" {
+		backendConfig.URL = stripe.String(config.BaseURL)
+	}
 
-	return &StripeMetricsCollector{
-		name:                "stripe",
-		enabled:             true,
-		collectionInterval:  5 * time.Minute,
-		apiKey:              apiKey,
-		httpClient:          &http.Client{Timeout: 10 * time.Second},
+	// Each collector has its own client so several accounts can be collected
+	backend := stripe.GetBackendWithConfig(stripe.APIBackend, backendConfig)
+	api := client.New(config.APIKey, &stripe.Backends{API: backend, Connect: backend, Uploads: backend})
+
+	collector := &StripeMetricsCollector{
+		name:               "stripe",
+		collectionInterval: 5 * time.Minute,
+		config:             config,
+		client:             api,
+		retrier:            newRateLimitRetrier(config.MaxRetries, config.InitialBackoff, config.MaxBackoff, http.StatusTooManyRequests),
+		cursors:            config.Cursors,
 	}
+	collector.enabled.Store(true)
+	return collector
 }
 
 // Name returns the collector name
@@ -42,12 +137,12 @@ func (s *StripeMetricsCollector) Name() string {
 
 // IsEnabled returns if the collector is enabled
 func (s *StripeMetricsCollector) IsEnabled() bool {
-	return s.enabled
+	return s.enabled.Load()
 }
 
 // SetEnabled sets the enabled state
 func (s *StripeMetricsCollector) SetEnabled(enabled bool) {
-	s.enabled = enabled
+	s.enabled.Store(enabled)
 }
 
 // GetLastUpdateTime returns when metrics were last collected
@@ -62,7 +157,7 @@ func (s *StripeMetricsCollector) GetCollectionInterval() time.Duration {
 
 // Collect gathers metrics from Stripe
 func (s *StripeMetricsCollector) Collect(ctx context.Context) ([]MetricValue, error) {
-	if !s.enabled {
+	if !s.enabled.Load() {
 		return []MetricValue{}, nil
 	}
 
@@ -100,86 +195,131 @@ func (s *StripeMetricsCollector) Collect(ctx context.Context) ([]MetricValue, er
 	return metrics, nil
 }
 
-// collectCustomerMetrics collects metrics related to Stripe customers
-func (s *StripeMetricsCollector) collectCustomerMetrics(ctx context.Context) ([]MetricValue, error) {
-	var metrics []MetricValue
+// createdCursor marks how far a newest-first list sorted by creation time
+// has been read. Objects created in the same second as the cursor may
+// arrive after it was saved, so the IDs already seen at that second are
+// kept and the next read starts at (not after) Created.
+type createdCursor struct {
+	Created int64    `json:"created"`
+	IDs     []string `json:"ids,omitempty"`
+}
+
+// seen reports whether the object was counted by an earlier run
+func (c *createdCursor) seen(id string, created int64) bool {
+	if created < c.Created {
+		return true
+	}
+	if created > c.Created {
+		return false
+	}
+	for _, seenID := range c.IDs {
+		if seenID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// advance moves the cursor past an object
+func (c *createdCursor) advance(id string, created int64) {
+	switch {
+	case created > c.Created:
+		c.Created = created
+		c.IDs = []string{id}
+	case created == c.Created:
+		c.IDs = append(c.IDs, id)
+	}
+}
 
-	// Get customer count
-	params := &stripe.CustomerListParams{}
-	params.AddExpand("data")
+// customerCursor counts customers created so far
+type customerCursor struct {
+	createdCursor
+	Total int64 `json:"total"`
+}
 
-	i := customer.List(params)
-	totalCustomers := 0
+// chargeCursor holds running totals of all charges read so far
+type chargeCursor struct {
+	createdCursor
+	Counts  map[string]int64 `json:"counts"`  // by status
+	Amounts map[string]int64 `json:"amounts"` // succeeded, by currency in minor units
+}
 
-	for i.Next() {
-		totalCustomers++
+// collectCustomerMetrics counts customers created since the last run and
+// adds them to the persisted total. Deleted customers are not subtracted.
+func (s *StripeMetricsCollector) collectCustomerMetrics(ctx context.Context) ([]MetricValue, error) {
+	var cursor customerCursor
+	if _, err := s.cursors.Load(ctx, s.name, "customers", &cursor); err != nil {
+		return nil, err
 	}
 
-	if i.Err() != nil {
-		return nil, fmt.Errorf("failed to list customers: %w", i.Err())
+	next := cursor
+	next.IDs = append([]string(nil), cursor.IDs...)
+
+	err := s.paginate(ctx, func(page *stripe.ListParams) stripeIter {
+		params := &stripe.CustomerListParams{ListParams: *page}
+		if cursor.Created > 0 {
+			params.CreatedRange = &stripe.RangeQueryParams{GreaterThanOrEqual: cursor.Created}
+		}
+		return s.client.Customers.List(params)
+	}, func(item interface{}) string {
+		c := item.(*stripe.Customer)
+		if !cursor.seen(c.ID, c.Created) {
+			next.Total++
+			next.advance(c.ID, c.Created)
+		}
+		return c.ID
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list customers: %w", err)
 	}
 
-	metrics = append(metrics, MetricValue{
+	if err := s.cursors.Save(ctx, s.name, "customers", next); err != nil {
+		return nil, err
+	}
+
+	return []MetricValue{{
 		Name:      "stripe_customers_total",
 		Type:      MetricTypeGauge,
-		Value:     float64(totalCustomers),
+		Value:     float64(next.Total),
 		Timestamp: time.Now(),
 		Tags: map[string]string{
 			"collector": s.name,
 		},
 		Description: "Total number of Stripe customers",
-	})
-
-	return metrics, nil
+	}}, nil
 }
 
-// collectSubscriptionMetrics collects metrics related to Stripe subscriptions
+// collectSubscriptionMetrics counts active and past due subscriptions and
+// sums their monthly recurring revenue per currency
 func (s *StripeMetricsCollector) collectSubscriptionMetrics(ctx context.Context) ([]MetricValue, error) {
-	var metrics []MetricValue
-
-	// Get subscription metrics
-	params := &stripe.SubscriptionListParams{}
-	params.AddExpand("data.customer")
-
-	var activeCount int64
-	var pastDueCount int64
-	var totalMRR float64
-
-	i := subscription.List(params)
-
-	for i.Next() {
-		sub := i.Subscription()
-
-		switch sub.Status {
-		case stripe.SubscriptionStatusActive:
-			activeCount++
-		case stripe.SubscriptionStatusPastDue:
-			pastDueCount++
-		}
-
-		// Calculate MRR (Monthly Recurring Revenue)
-		if sub.Items != nil && len(sub.Items.Data) > 0 {
-			for _, item := range sub.Items.Data {
-				if item.Price.Recurring != nil {
-					if item.Price.Recurring.AggregateUsage == "" {
-						totalMRR += float64(item.Price.UnitAmount) / 100.0
-					}
-				}
+	counts := make(map[stripe.SubscriptionStatus]int64)
+	mrr := make(map[string]float64)
+	now := time.Now()
+
+	// Past due subscriptions still count toward MRR until they're canceled
+	for _, status := range []stripe.SubscriptionStatus{stripe.SubscriptionStatusActive, stripe.SubscriptionStatusPastDue} {
+		err := s.paginate(ctx, func(page *stripe.ListParams) stripeIter {
+			return s.client.Subscriptions.List(&stripe.SubscriptionListParams{ListParams: *page, Status: string(status)})
+		}, func(item interface{}) string {
+			sub := item.(*stripe.Subscription)
+			counts[status]++
+			if currency, amount := subscriptionMRR(sub, now); currency != "" {
+				mrr[currency] += amount
 			}
+			return sub.ID
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list subscriptions: %w", err)
 		}
 	}
 
-	if i.Err() != nil {
-		return nil, fmt.Errorf("failed to list subscriptions: %w", i.Err())
-	}
-
 	timestamp := time.Now()
 
-	metrics = append(metrics,
-		MetricValue{
+	metrics := []MetricValue{
+		{
 			Name:      "stripe_subscriptions_active",
 			Type:      MetricTypeGauge,
-			Value:     float64(activeCount),
+			Value:     float64(counts[stripe.SubscriptionStatusActive]),
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"collector": s.name,
@@ -187,10 +327,10 @@ func (s *StripeMetricsCollector) collectSubscriptionMetrics(ctx context.Context)
 			},
 			Description: "Number of active Stripe subscriptions",
 		},
-		MetricValue{
+		{
 			Name:      "stripe_subscriptions_past_due",
 			Type:      MetricTypeGauge,
-			Value:     float64(pastDueCount),
+			Value:     float64(counts[stripe.SubscriptionStatusPastDue]),
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"collector": s.name,
@@ -198,105 +338,334 @@ func (s *StripeMetricsCollector) collectSubscriptionMetrics(ctx context.Context)
 			},
 			Description: "Number of past due Stripe subscriptions",
 		},
-		MetricValue{
+	}
+
+	for currency, amount := range mrr {
+		metrics = append(metrics, MetricValue{
 			Name:      "stripe_mrr_total",
 			Type:      MetricTypeGauge,
-			Value:     totalMRR,
+			Value:     amount,
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"collector": s.name,
-				"currency":  "usd",
+				"currency":  currency,
 			},
 			Description: "Total monthly recurring revenue",
-		},
-	)
+		})
+	}
 
 	return metrics, nil
 }
 
-// collectChargeMetrics collects metrics related to Stripe charges
+// collectChargeMetrics reads charges created since the cursor and reports
+// running totals by status and succeeded amounts by currency
 func (s *StripeMetricsCollector) collectChargeMetrics(ctx context.Context) ([]MetricValue, error) {
-	var metrics []MetricValue
-
-	// Get charge metrics for the last 24 hours
-	oneDayAgo := time.Now().Add(-24 * time.Hour)
-
-	params := &stripe.ChargeListParams{}
-	params.Filters.AddFilter("created", "gte", fmt.Sprintf("%d", oneDayAgo.Unix()))
-
-	var successCount int64
-	var failureCount int64
-	var totalAmount float64
-
-	i := charge.List(params)
+	var cursor chargeCursor
+	if _, err := s.cursors.Load(ctx, s.name, "charges", &cursor); err != nil {
+		return nil, err
+	}
 
-	for i.Next() {
-		c := i.Charge()
+	next := chargeCursor{
+		createdCursor: createdCursor{Created: cursor.Created, IDs: append([]string(nil), cursor.IDs...)},
+		Counts:        make(map[string]int64),
+		Amounts:       make(map[string]int64),
+	}
+	for status, count := range cursor.Counts {
+		next.Counts[status] = count
+	}
+	for currency, amount := range cursor.Amounts {
+		next.Amounts[currency] = amount
+	}
 
-		if c.Paid {
-			successCount++
-		} else if c.Refunded || (c.Status == stripe.ChargeStatusFailed) {
-			failureCount++
+	err := s.paginate(ctx, func(page *stripe.ListParams) stripeIter {
+		params := &stripe.ChargeListParams{ListParams: *page}
+		if cursor.Created > 0 {
+			params.CreatedRange = &stripe.RangeQueryParams{GreaterThanOrEqual: cursor.Created}
+		}
+		return s.client.Charges.List(params)
+	}, func(item interface{}) string {
+		c := item.(*stripe.Charge)
+		if cursor.seen(c.ID, c.Created) {
+			return c.ID
 		}
 
-		totalAmount += float64(c.Amount) / 100.0
+		next.Counts[string(c.Status)]++
+		if c.Status == stripe.ChargeStatusSucceeded {
+			next.Amounts[strings.ToLower(string(c.Currency))] += c.Amount
+		}
+		next.advance(c.ID, c.Created)
+		return c.ID
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list charges: %w", err)
 	}
 
-	if i.Err() != nil {
-		return nil, fmt.Errorf("failed to list charges: %w", i.Err())
+	if err := s.cursors.Save(ctx, s.name, "charges", next); err != nil {
+		return nil, err
 	}
 
 	timestamp := time.Now()
+	var metrics []MetricValue
 
-	metrics = append(metrics,
-		MetricValue{
-			Name:      "stripe_charges_successful_24h",
+	for _, status := range []stripe.ChargeStatus{stripe.ChargeStatusSucceeded, stripe.ChargeStatusFailed, stripe.ChargeStatusPending} {
+		metrics = append(metrics, MetricValue{
+			Name:      "stripe_charges_total",
 			Type:      MetricTypeCounter,
-			Value:     float64(successCount),
+			Value:     float64(next.Counts[string(status)]),
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"collector": s.name,
-				"status":    "successful",
-				"period":    "24h",
+				"status":    string(status),
 			},
-			Description: "Number of successful charges in the last 24 hours",
-		},
-		MetricValue{
-			Name:      "stripe_charges_failed_24h",
+			Description: "Number of charges by status at creation",
+		})
+	}
+
+	for currency, amount := range next.Amounts {
+		metrics = append(metrics, MetricValue{
+			Name:      "stripe_charges_amount_total",
 			Type:      MetricTypeCounter,
-			Value:     float64(failureCount),
-			Timestamp: timestamp,
-			Tags: map[string]string{
-				"collector": s.name,
-				"status":    "failed",
-				"period":    "24h",
-			},
-			Description: "Number of failed charges in the last 24 hours",
-		},
-		MetricValue{
-			Name:      "stripe_charges_amount_24h",
-			Type:      MetricTypeGauge,
-			Value:     totalAmount,
+			Value:     float64(amount) / minorUnits(currency),
 			Timestamp: timestamp,
 			Tags: map[string]string{
 				"collector": s.name,
-				"currency":  "usd",
-				"period":    "24h",
+				"currency":  currency,
 			},
-			Description: "Total charge amount in the last 24 hours",
-		},
-	)
+			Description: "Amount of succeeded charges",
+		})
+	}
 
 	return metrics, nil
 }
 
-// collectBalanceMetrics collects metrics related to Stripe account balance
+// collectBalanceMetrics reports the available and pending balance per
+// currency
 func (s *StripeMetricsCollector) collectBalanceMetrics(ctx context.Context) ([]MetricValue, error) {
+	var balance *stripe.Balance
+	err := s.withBackoff(ctx, func() error {
+		params := &stripe.BalanceParams{}
+		params.Context = ctx
+
+		var err error
+		balance, err = s.client.Balance.Get(params)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get balance: %w", err)
+	}
+
+	timestamp := time.Now()
 	var metrics []MetricValue
 
-	// This would require the Stripe balance API
-	// For now, we'll return empty metrics as this requires more complex setup
-	// In production, you would call stripe.Balance.Get()
+	add := func(name, description string, amounts []*stripe.Amount) {
+		totals := make(map[string]int64)
+		for _, amount := range amounts {
+			totals[strings.ToLower(string(amount.Currency))] += amount.Value
+		}
+		for currency, total := range totals {
+			metrics = append(metrics, MetricValue{
+				Name:      name,
+				Type:      MetricTypeGauge,
+				Value:     float64(total) / minorUnits(currency),
+				Timestamp: timestamp,
+				Tags: map[string]string{
+					"collector": s.name,
+					"currency":  currency,
+				},
+				Description: description,
+			})
+		}
+	}
+
+	add("stripe_balance_available", "Funds available to be paid out", balance.Available)
+	add("stripe_balance_pending", "Funds not yet available", balance.Pending)
 
 	return metrics, nil
 }
+
+// stripeIter is the part of Stripe's typed list iterators used here
+type stripeIter interface {
+	Next() bool
+	Current() interface{}
+	Err() error
+	Meta() *stripe.ListMeta
+}
+
+// paginate walks every page of a list. A page that hits the rate limit is
+// retried on its own, so an interrupted walk doesn't start over; visit
+// sees each page only once it was read in full and returns the item's ID.
+func (s *StripeMetricsCollector) paginate(ctx context.Context, list func(page *stripe.ListParams) stripeIter, visit func(item interface{}) string) error {
+	var startingAfter *string
+
+	for {
+		var items []interface{}
+		var hasMore bool
+
+		err := s.withBackoff(ctx, func() error {
+			page := &stripe.ListParams{
+				Context:       ctx,
+				Limit:         stripe.Int64(stripePageSize),
+				Single:        true,
+				StartingAfter: startingAfter,
+			}
+
+			it := list(page)
+			items = items[:0]
+			for it.Next() {
+				items = append(items, it.Current())
+			}
+			if err := it.Err(); err != nil {
+				return err
+			}
+
+			hasMore = it.Meta() != nil && it.Meta().HasMore
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		var lastID string
+		for _, item := range items {
+			lastID = visit(item)
+		}
+
+		if !hasMore || lastID == "" {
+			return nil
+		}
+		startingAfter = stripe.String(lastID)
+	}
+}
+
+// withBackoff runs fn, retrying while Stripe responds 429 Too Many Requests
+func (s *StripeMetricsCollector) withBackoff(ctx context.Context, fn func() error) error {
+	return retryRateLimited(ctx, s.retrier, func(context.Context) error {
+		return stripeHTTPError(fn())
+	})
+}
+
+// stripeHTTPError describes a Stripe API error by its status code so the
+// retrier can classify it. Stripe's errors don't carry Retry-After.
+func stripeHTTPError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) || stripeErr.HTTPStatusCode == 0 {
+		return err
+	}
+	return fmt.Errorf("%w: %w", &resilience.HTTPError{StatusCode: stripeErr.HTTPStatusCode}, err)
+}
+
+// subscriptionMRR returns a subscription's currency and its recurring
+// revenue normalized to one month, after discounts, in major units.
+// Metered and tiered prices have no fixed amount and are left out.
+func subscriptionMRR(sub *stripe.Subscription, now time.Time) (string, float64) {
+	if sub.Items == nil {
+		return "", 0
+	}
+
+	currency := strings.ToLower(string(sub.Currency))
+	var total float64          // minor units per month
+	var intervalMonths float64 // billing period of the subscription
+
+	for _, item := range sub.Items.Data {
+		price := item.Price
+		if price == nil || price.Recurring == nil {
+			continue
+		}
+		if currency == "" {
+			currency = strings.ToLower(string(price.Currency))
+		}
+
+		months := intervalInMonths(price.Recurring.Interval, price.Recurring.IntervalCount)
+		if months <= 0 {
+			continue
+		}
+		if intervalMonths == 0 {
+			intervalMonths = months
+		}
+
+		if price.Recurring.UsageType == stripe.PriceRecurringUsageTypeMetered || price.BillingScheme == stripe.PriceBillingSchemeTiered {
+			continue
+		}
+
+		unitAmount := price.UnitAmountDecimal
+		if unitAmount == 0 {
+			unitAmount = float64(price.UnitAmount)
+		}
+
+		total += unitAmount * float64(item.Quantity) / months
+	}
+
+	if currency == "" {
+		return "", 0
+	}
+
+	total = applyDiscount(total, sub.Discount, currency, intervalMonths, now)
+	return currency, total / minorUnits(currency)
+}
+
+// applyDiscount reduces a monthly amount by a discount that still applies.
+// One-off coupons don't recur and are ignored; amount-off coupons apply
+// once per billing period.
+func applyDiscount(monthly float64, discount *stripe.Discount, currency string, intervalMonths float64, now time.Time) float64 {
+	if discount == nil || discount.Coupon == nil {
+		return monthly
+	}
+	if discount.End != 0 && discount.End <= now.Unix() {
+		return monthly
+	}
+
+	coupon := discount.Coupon
+	if coupon.Duration == stripe.CouponDurationOnce {
+		return monthly
+	}
+
+	switch {
+	case coupon.PercentOff > 0:
+		monthly *= 1 - coupon.PercentOff/100
+	case coupon.AmountOff > 0 && strings.EqualFold(string(coupon.Currency), currency) && intervalMonths > 0:
+		monthly -= float64(coupon.AmountOff) / intervalMonths
+	}
+
+	if monthly < 0 {
+		return 0
+	}
+	return monthly
+}
+
+// intervalInMonths converts a billing interval to months
+func intervalInMonths(interval stripe.PriceRecurringInterval, count int64) float64 {
+	if count <= 0 {
+		count = 1
+	}
+
+	var months float64
+	switch interval {
+	case stripe.PriceRecurringIntervalDay:
+		months = 12.0 / 365.25
+	case stripe.PriceRecurringIntervalWeek:
+		months = 12.0 / 52.0
+	case stripe.PriceRecurringIntervalMonth:
+		months = 1
+	case stripe.PriceRecurringIntervalYear:
+		months = 12
+	default:
+		return 0
+	}
+	return months * float64(count)
+}
+
+// zeroDecimalCurrencies have no minor unit; amounts are in whole units
+var zeroDecimalCurrencies = map[string]bool{
+	"bif": true, "clp": true, "djf": true, "gnf": true, "jpy": true,
+	"kmf": true, "krw": true, "mga": true, "pyg": true, "rwf": true,
+	"ugx": true, "vnd": true, "vuv": true, "xaf": true, "xof": true,
+	"xpf": true,
+}
+
+// minorUnits returns how many minor units make up one unit of currency
+func minorUnits(currency string) float64 {
+	if zeroDecimalCurrencies[strings.ToLower(currency)] {
+		return 1
+	}
+	return 100
+}
@@ -0,0 +1,365 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stripe/stripe-go/v72"
+)
+
+// fakeStripe is a local stand-in for the parts of the Stripe API the
+// collector reads. Lists are newest first and paginated like Stripe's.
+type fakeStripe struct {
+	mu            sync.Mutex
+	objects       map[string][]map[string]interface{} // by list path
+	balance       map[string]interface{}
+	rateLimitNext int
+	requests      map[string]int
+}
+
+func newFakeStripe() *fakeStripe {
+	return &fakeStripe{
+		objects:  make(map[string][]map[string]interface{}),
+		requests: make(map[string]int),
+		balance: map[string]interface{}{
+			"object":    "balance",
+			"available": []map[string]interface{}{{"amount": 12345, "currency": "usd"}, {"amount": 500, "currency": "jpy"}},
+			"pending":   []map[string]interface{}{{"amount": 1000, "currency": "usd"}},
+		},
+	}
+}
+
+func (f *fakeStripe) add(path string, object map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[path] = append(f.objects[path], object)
+}
+
+func (f *fakeStripe) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.requests[r.URL.Path]++
+	w.Header().Set("Content-Type", "application/json")
+
+	if f.rateLimitNext > 0 {
+		f.rateLimitNext--
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprint(w, `{"error":{"type":"invalid_request_error","code":"rate_limit","message":"Too many requests"}}`)
+		return
+	}
+
+	if r.URL.Path == "/v1/balance" {
+		json.NewEncoder(w).Encode(f.balance)
+		return
+	}
+
+	objects, ok := f.objects[r.URL.Path]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"not found"}}`)
+		return
+	}
+
+	query := r.URL.Query()
+	limit, _ := strconv.Atoi(query.Get("limit"))
+	if limit <= 0 {
+		limit = 10
+	}
+	createdGTE, _ := strconv.ParseInt(query.Get("created[gte]"), 10, 64)
+
+	// Newest first
+	sorted := append([]map[string]interface{}(nil), objects...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i]["created"].(int64) > sorted[j]["created"].(int64)
+	})
+
+	var matching []map[string]interface{}
+	for _, object := range sorted {
+		if status := query.Get("status"); status != "" && object["status"] != status {
+			continue
+		}
+		if object["created"].(int64) < createdGTE {
+			continue
+		}
+		matching = append(matching, object)
+	}
+
+	if after := query.Get("starting_after"); after != "" {
+		for i, object := range matching {
+			if object["id"] == after {
+				matching = matching[i+1:]
+				break
+			}
+		}
+	}
+
+	hasMore := len(matching) > limit
+	if hasMore {
+		matching = matching[:limit]
+	}
+	if matching == nil {
+		matching = []map[string]interface{}{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":   "list",
+		"url":      r.URL.Path,
+		"has_more": hasMore,
+		"data":     matching,
+	})
+}
+
+func newTestStripeCollector(t *testing.T, fake *fakeStripe, cursors CursorStore) *StripeMetricsCollector {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	return NewStripeMetricsCollectorWithConfig(StripeConfig{
+		APIKey:         "sk_test_123",
+		BaseURL:        server.URL,
+		Cursors:        cursors,
+		MaxRetries:     3,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     time.Millisecond,
+	})
+}
+
+func metricValue(metrics []MetricValue, name string, tags map[string]string) (float64, bool) {
+	for _, m := range metrics {
+		if m.Name != name {
+			continue
+		}
+		match := true
+		for k, v := range tags {
+			match = match && m.Tags[k] == v
+		}
+		if match {
+			return m.Value, true
+		}
+	}
+	return 0, false
+}
+
+func stripeItem(amount int64, currency, interval string, intervalCount, quantity int64) map[string]interface{} {
+	return map[string]interface{}{
+		"id":       fmt.Sprintf("si_%d_%s", amount, interval),
+		"quantity": quantity,
+		"price": map[string]interface{}{
+			"id":             "price_" + interval,
+			"currency":       currency,
+			"unit_amount":    amount,
+			"billing_scheme": "per_unit",
+			"recurring": map[string]interface{}{
+				"interval":       interval,
+				"interval_count": intervalCount,
+				"usage_type":     "licensed",
+			},
+		},
+	}
+}
+
+func TestStripeCollectorAgainstStandIn(t *testing.T) {
+	fake := newFakeStripe()
+	base := time.Now().Add(-time.Hour).Unix()
+
+	// More customers and charges than fit on one page
+	for i := 0; i < 250; i++ {
+		fake.add("/v1/customers", map[string]interface{}{"id": fmt.Sprintf("cus_%03d", i), "object": "customer", "created": base + int64(i/10)})
+	}
+	for i := 0; i < 120; i++ {
+		status := "succeeded"
+		if i%4 == 0 {
+			status = "failed"
+		}
+		fake.add("/v1/charges", map[string]interface{}{"id": fmt.Sprintf("ch_%03d", i), "object": "charge", "created": base + int64(i), "status": status, "amount": int64(1000), "currency": "usd"})
+	}
+
+	fake.add("/v1/subscriptions", map[string]interface{}{
+		"id": "sub_yearly", "object": "subscription", "created": base, "status": "active", "currency": "usd",
+		"items": map[string]interface{}{"object": "list", "data": []interface{}{stripeItem(12000, "usd", "year", 1, 2)}},
+	})
+	fake.add("/v1/subscriptions", map[string]interface{}{
+		"id": "sub_monthly_discounted", "object": "subscription", "created": base + 1, "status": "active", "currency": "usd",
+		"items":    map[string]interface{}{"object": "list", "data": []interface{}{stripeItem(5000, "usd", "month", 1, 1)}},
+		"discount": map[string]interface{}{"id": "di_1", "coupon": map[string]interface{}{"id": "half", "percent_off": 50, "duration": "forever"}},
+	})
+	fake.add("/v1/subscriptions", map[string]interface{}{
+		"id": "sub_jpy_past_due", "object": "subscription", "created": base + 2, "status": "past_due", "currency": "jpy",
+		"items": map[string]interface{}{"object": "list", "data": []interface{}{stripeItem(1000, "jpy", "week", 1, 3)}},
+	})
+
+	// The first page request is rate limited twice before it succeeds
+	fake.rateLimitNext = 2
+
+	cursors := NewMemoryCursorStore()
+	collector := newTestStripeCollector(t, fake, cursors)
+
+	metrics, err := collector.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	checks := []struct {
+		name string
+		tags map[string]string
+		want float64
+	}{
+		{"stripe_customers_total", nil, 250},
+		{"stripe_subscriptions_active", nil, 2},
+		{"stripe_subscriptions_past_due", nil, 1},
+		// 2 × $120/year = $20/month, plus $50/month at 50% off
+		{"stripe_mrr_total", map[string]string{"currency": "usd"}, 45},
+		// 3 × ¥1000/week × 52/12, zero-decimal
+		{"stripe_mrr_total", map[string]string{"currency": "jpy"}, 3000 * 52.0 / 12},
+		{"stripe_charges_total", map[string]string{"status": "succeeded"}, 90},
+		{"stripe_charges_total", map[string]string{"status": "failed"}, 30},
+		{"stripe_charges_amount_total", map[string]string{"currency": "usd"}, 900},
+		{"stripe_balance_available", map[string]string{"currency": "usd"}, 123.45},
+		{"stripe_balance_available", map[string]string{"currency": "jpy"}, 500},
+		{"stripe_balance_pending", map[string]string{"currency": "usd"}, 10},
+	}
+	for _, c := range checks {
+		got, ok := metricValue(metrics, c.name, c.tags)
+		if !ok || math.Abs(got-c.want) > 1e-6 {
+			t.Errorf("%s%v = %v (found %v), want %v", c.name, c.tags, got, ok, c.want)
+		}
+	}
+
+	// 250 customers take three pages
+	if got := fake.requests["/v1/customers"]; got != 2+3 {
+		t.Errorf("customer requests = %d, want 5 (2 rate limited + 3 pages)", got)
+	}
+
+	// A new charge in the same second as the cursor is picked up; nothing
+	// else is counted twice
+	fake.add("/v1/charges", map[string]interface{}{"id": "ch_new", "object": "charge", "created": base + 119, "status": "succeeded", "amount": int64(2500), "currency": "usd"})
+	fake.add("/v1/customers", map[string]interface{}{"id": "cus_new", "object": "customer", "created": base + 100})
+
+	collector = newTestStripeCollector(t, fake, cursors)
+	metrics, err = collector.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("Collect() error = %v", err)
+	}
+
+	if got, _ := metricValue(metrics, "stripe_charges_total", map[string]string{"status": "succeeded"}); got != 91 {
+		t.Errorf("succeeded charges after second run = %v, want 91", got)
+	}
+	if got, _ := metricValue(metrics, "stripe_charges_amount_total", map[string]string{"currency": "usd"}); got != 925 {
+		t.Errorf("charge amount after second run = %v, want 925", got)
+	}
+	if got, _ := metricValue(metrics, "stripe_customers_total", nil); got != 251 {
+		t.Errorf("customers after second run = %v, want 251", got)
+	}
+}
+
+func TestStripeCollectorGivesUpWhenRateLimited(t *testing.T) {
+	fake := newFakeStripe()
+	fake.add("/v1/customers", map[string]interface{}{"id": "cus_1", "object": "customer", "created": int64(1)})
+	fake.rateLimitNext = 100
+
+	collector := newTestStripeCollector(t, fake, nil)
+	_, err := collector.Collect(context.Background())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if got := fake.requests["/v1/customers"]; got != 4 {
+		t.Errorf("requests = %d, want 4 (1 + 3 retries)", got)
+	}
+}
+
+func TestStripeCollectorStopsRetryingBeforeDeadline(t *testing.T) {
+	fake := newFakeStripe()
+	fake.add("/v1/customers", map[string]interface{}{"id": "cus_1", "object": "customer", "created": int64(1)})
+	fake.rateLimitNext = 100
+
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	collector := NewStripeMetricsCollectorWithConfig(StripeConfig{
+		APIKey:         "sk_test_123",
+		BaseURL:        server.URL,
+		MaxRetries:     5,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+	})
+
+	// The backoff would outlast the deadline, so the collection reports
+	// the rate limit instead of sleeping into a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := collector.Collect(ctx)
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "rate limited") {
+		t.Fatalf("expected a rate limit error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+		t.Errorf("gave up after %v, want well before the deadline", elapsed)
+	}
+	if got := fake.requests["/v1/customers"]; got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+func TestSubscriptionMRR(t *testing.T) {
+	now := time.Now()
+	price := func(amount int64, currency string, interval stripe.PriceRecurringInterval, count int64) *stripe.Price {
+		return &stripe.Price{
+			Currency:      stripe.Currency(currency),
+			UnitAmount:    amount,
+			BillingScheme: stripe.PriceBillingSchemePerUnit,
+			Recurring:     &stripe.PriceRecurring{Interval: interval, IntervalCount: count, UsageType: stripe.PriceRecurringUsageTypeLicensed},
+		}
+	}
+	sub := func(discount *stripe.Discount, items ...*stripe.SubscriptionItem) *stripe.Subscription {
+		return &stripe.Subscription{Discount: discount, Items: &stripe.SubscriptionItemList{Data: items}}
+	}
+
+	metered := price(100, "usd", stripe.PriceRecurringIntervalMonth, 1)
+	metered.Recurring.UsageType = stripe.PriceRecurringUsageTypeMetered
+
+	tests := []struct {
+		name         string
+		sub          *stripe.Subscription
+		wantCurrency string
+		want         float64
+	}{
+		{"monthly quantity", sub(nil, &stripe.SubscriptionItem{Price: price(1000, "usd", "month", 1), Quantity: 3}), "usd", 30},
+		{"every 3 months", sub(nil, &stripe.SubscriptionItem{Price: price(3000, "eur", "month", 3), Quantity: 1}), "eur", 10},
+		{"yearly", sub(nil, &stripe.SubscriptionItem{Price: price(12000, "usd", "year", 1), Quantity: 1}), "usd", 10},
+		{"daily", sub(nil, &stripe.SubscriptionItem{Price: price(100, "usd", "day", 1), Quantity: 1}), "usd", 365.25 / 12},
+		{"metered ignored", sub(nil, &stripe.SubscriptionItem{Price: metered, Quantity: 1}), "usd", 0},
+		{"amount off per yearly invoice", sub(
+			&stripe.Discount{Coupon: &stripe.Coupon{AmountOff: 1200, Currency: "usd", Duration: stripe.CouponDurationForever}},
+			&stripe.SubscriptionItem{Price: price(12000, "usd", "year", 1), Quantity: 1},
+		), "usd", 9},
+		{"one-off coupon ignored", sub(
+			&stripe.Discount{Coupon: &stripe.Coupon{PercentOff: 100, Duration: stripe.CouponDurationOnce}},
+			&stripe.SubscriptionItem{Price: price(1000, "usd", "month", 1), Quantity: 1},
+		), "usd", 10},
+		{"expired discount ignored", sub(
+			&stripe.Discount{End: now.Add(-time.Hour).Unix(), Coupon: &stripe.Coupon{PercentOff: 50, Duration: stripe.CouponDurationRepeating}},
+			&stripe.SubscriptionItem{Price: price(1000, "usd", "month", 1), Quantity: 1},
+		), "usd", 10},
+		{"zero decimal", sub(nil, &stripe.SubscriptionItem{Price: price(1000, "jpy", "month", 1), Quantity: 1}), "jpy", 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			currency, got := subscriptionMRR(tt.sub, now)
+			if currency != tt.wantCurrency || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("subscriptionMRR() = %s %v, want %s %v", currency, got, tt.wantCurrency, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,322 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"github.com/redis/go-redis/v9"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+// StripeWebhookConfig holds Stripe webhook configuration
+type StripeWebhookConfig struct {
+	// Secret is the endpoint's signing secret (whsec_...)
+	Secret string
+
+	// Tolerance is how old a signature may be before it's rejected
+	Tolerance time.Duration
+
+	// DedupeTTL is how long delivered event IDs are remembered. Stripe
+	// retries failed deliveries for up to three days.
+	DedupeTTL time.Duration
+
+	// MaxBodyBytes limits the request body size
+	MaxBodyBytes int64
+}
+
+// DefaultStripeWebhookConfig returns default Stripe webhook configuration
+func DefaultStripeWebhookConfig() StripeWebhookConfig {
+	return StripeWebhookConfig{
+		Tolerance:    webhook.DefaultTolerance,
+		DedupeTTL:    72 * time.Hour,
+		MaxBodyBytes: 1 << 20,
+	}
+}
+
+// StripeWebhookHandler serves POST /webhooks/stripe. Verified events are
+// deduplicated by ID and turned into metrics that go through the same
+// store and publisher as collected metrics. Metrics are written before the
+// event is acknowledged.
+type StripeWebhookHandler struct {
+	config    StripeWebhookConfig
+	rdb       *redis.Client
+	store     MetricsStore
+	publisher MetricsPublisher
+	log       *logger.Logger
+}
+
+// NewStripeWebhookHandler creates a new Stripe webhook handler
+func NewStripeWebhookHandler(rdb *redis.Client, store MetricsStore, publisher MetricsPublisher, config StripeWebhookConfig) *StripeWebhookHandler {
+	defaults := DefaultStripeWebhookConfig()
+	if config.Tolerance <= 0 {
+		config.Tolerance = defaults.Tolerance
+	}
+	if config.DedupeTTL <= 0 {
+		config.DedupeTTL = defaults.DedupeTTL
+	}
+	if config.MaxBodyBytes <= 0 {
+		config.MaxBodyBytes = defaults.MaxBodyBytes
+	}
+
+	return &StripeWebhookHandler{
+		config:    config,
+		rdb:       rdb,
+		store:     store,
+		publisher: publisher,
+		log:       logger.New().WithField("component", "stripe-webhook"),
+	}
+}
+
+func (h *StripeWebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, h.config.MaxBodyBytes))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	event, err := webhook.ConstructEventWithTolerance(payload, r.Header.Get("Stripe-Signature"), h.config.Secret, h.config.Tolerance)
+	if err != nil {
+		h.log.Warnf("rejected webhook: %v", err)
+		http.Error(w, "invalid signature", http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	// Claim the event before processing it so concurrent redeliveries
+	// are only counted once
+	key := "stripe:webhook:" + event.ID
+	claimed, err := h.rdb.SetNX(ctx, key, time.Now().Unix(), h.config.DedupeTTL).Result()
+	if err != nil {
+		h.log.Errorf("failed to deduplicate event %s: %v", event.ID, err)
+		http.Error(w, "temporarily unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !claimed {
+		writeWebhookAck(w, true)
+		return
+	}
+
+	if err := h.process(ctx, event); err != nil {
+		h.log.Errorf("failed to process event %s (%s): %v", event.ID, event.Type, err)
+
+		// Release the claim so Stripe's retry is processed
+		h.rdb.Del(context.Background(), key)
+		http.Error(w, "processing failed", http.StatusInternalServerError)
+		return
+	}
+
+	writeWebhookAck(w, false)
+}
+
+// process stores and publishes the event's metrics
+func (h *StripeWebhookHandler) process(ctx context.Context, event stripe.Event) error {
+	metrics, err := StripeEventMetrics(event)
+	if err != nil {
+		return err
+	}
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	// Stripe stops redelivering once the event is acknowledged, so the
+	// metrics must be written rather than queued for a batch that may
+	// still fail
+	store := h.store.Store
+	if writer, ok := h.store.(MetricsWriter); ok {
+		store = writer.Write
+	}
+	if err := store(ctx, metrics); err != nil {
+		return fmt.Errorf("storage failed: %w", err)
+	}
+	if err := h.publisher.Publish(ctx, metrics); err != nil {
+		return fmt.Errorf("publishing failed: %w", err)
+	}
+	return nil
+}
+
+func writeWebhookAck(w http.ResponseWriter, duplicate bool) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{
+		"received":  true,
+		"duplicate": duplicate,
+	})
+}
+
+// StripeEventMetrics converts a webhook event into metrics. Each event is
+// one sample: stripe_payments and stripe_payment_failures have the value
+// 1, so counts and success rates come from sum_over_time. Both charge and
+// payment_intent events are converted; filter on the source tag to avoid
+// counting one payment twice. Unhandled event types yield no metrics.
+func StripeEventMetrics(event stripe.Event) ([]MetricValue, error) {
+	if event.Data == nil {
+		return nil, nil
+	}
+
+	at := time.Unix(event.Created, 0).UTC()
+	base := func(extra map[string]string) map[string]string {
+		tags := map[string]string{"collector": "stripe_webhook", "event": event.Type}
+		if event.Account != "" {
+			tags["account"] = event.Account
+		}
+		for k, v := range extra {
+			tags[k] = v
+		}
+		return tags
+	}
+
+	switch event.Type {
+	case "charge.succeeded", "charge.failed":
+		var c stripe.Charge
+		if err := json.Unmarshal(event.Data.Raw, &c); err != nil {
+			return nil, fmt.Errorf("failed to decode charge: %w", err)
+		}
+
+		reason := c.FailureCode
+		if c.Outcome != nil && c.Outcome.Reason != "" && reason == "" {
+			reason = c.Outcome.Reason
+		}
+		return paymentMetrics(at, base, "charge", c.Status == stripe.ChargeStatusSucceeded, string(c.Currency), c.Amount, reason), nil
+
+	case "payment_intent.succeeded", "payment_intent.payment_failed":
+		var pi stripe.PaymentIntent
+		if err := json.Unmarshal(event.Data.Raw, &pi); err != nil {
+			return nil, fmt.Errorf("failed to decode payment intent: %w", err)
+		}
+
+		var reason string
+		if pi.LastPaymentError != nil {
+			reason = string(pi.LastPaymentError.DeclineCode)
+			if reason == "" {
+				reason = string(pi.LastPaymentError.Code)
+			}
+		}
+		return paymentMetrics(at, base, "payment_intent", event.Type == "payment_intent.succeeded", string(pi.Currency), pi.Amount, reason), nil
+
+	case "invoice.payment_succeeded", "invoice.payment_failed":
+		var inv stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &inv); err != nil {
+			return nil, fmt.Errorf("failed to decode invoice: %w", err)
+		}
+
+		outcome := "succeeded"
+		amount := inv.AmountPaid
+		if event.Type == "invoice.payment_failed" {
+			outcome = "failed"
+			amount = inv.AmountDue
+		}
+		currency := strings.ToLower(string(inv.Currency))
+
+		return []MetricValue{
+			{
+				Name:        "stripe_invoice_payments",
+				Type:        MetricTypeGauge,
+				Value:       1,
+				Timestamp:   at,
+				Tags:        base(map[string]string{"outcome": outcome, "currency": currency, "billing_reason": string(inv.BillingReason)}),
+				Description: "One sample per invoice payment attempt",
+			},
+			{
+				Name:        "stripe_invoice_attempts",
+				Type:        MetricTypeGauge,
+				Value:       float64(inv.AttemptCount),
+				Timestamp:   at,
+				Tags:        base(map[string]string{"outcome": outcome}),
+				Description: "Payment attempts made on the invoice so far",
+			},
+			{
+				Name:        "stripe_invoice_amount",
+				Type:        MetricTypeGauge,
+				Value:       float64(amount) / minorUnits(currency),
+				Timestamp:   at,
+				Tags:        base(map[string]string{"outcome": outcome, "currency": currency}),
+				Description: "Amount paid, or due when the payment failed",
+			},
+		}, nil
+
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var sub stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &sub); err != nil {
+			return nil, fmt.Errorf("failed to decode subscription: %w", err)
+		}
+
+		metrics := []MetricValue{{
+			Name:        "stripe_subscription_events",
+			Type:        MetricTypeGauge,
+			Value:       1,
+			Timestamp:   at,
+			Tags:        base(map[string]string{"status": string(sub.Status)}),
+			Description: "One sample per subscription change",
+		}}
+
+		if currency, mrr := subscriptionMRR(&sub, at); currency != "" {
+			metrics = append(metrics, MetricValue{
+				Name:        "stripe_subscription_mrr",
+				Type:        MetricTypeGauge,
+				Value:       mrr,
+				Timestamp:   at,
+				Tags:        base(map[string]string{"status": string(sub.Status), "currency": currency}),
+				Description: "Monthly recurring revenue of the changed subscription",
+			})
+		}
+		return metrics, nil
+	}
+
+	return nil, nil
+}
+
+// paymentMetrics returns the metrics for one payment outcome
+func paymentMetrics(at time.Time, base func(map[string]string) map[string]string, source string, succeeded bool, currency string, amount int64, reason string) []MetricValue {
+	outcome := "succeeded"
+	if !succeeded {
+		outcome = "failed"
+	}
+	currency = strings.ToLower(currency)
+
+	metrics := []MetricValue{
+		{
+			Name:        "stripe_payments",
+			Type:        MetricTypeGauge,
+			Value:       1,
+			Timestamp:   at,
+			Tags:        base(map[string]string{"source": source, "outcome": outcome, "currency": currency}),
+			Description: "One sample per payment outcome",
+		},
+		{
+			Name:        "stripe_payment_amount",
+			Type:        MetricTypeGauge,
+			Value:       float64(amount) / minorUnits(currency),
+			Timestamp:   at,
+			Tags:        base(map[string]string{"source": source, "outcome": outcome, "currency": currency}),
+			Description: "Amount of the payment",
+		},
+	}
+
+	if !succeeded {
+		if reason == "" {
+			reason = "unknown"
+		}
+		metrics = append(metrics, MetricValue{
+			Name:        "stripe_payment_failures",
+			Type:        MetricTypeGauge,
+			Value:       1,
+			Timestamp:   at,
+			Tags:        base(map[string]string{"source": source, "reason": reason}),
+			Description: "One sample per failed payment, by failure reason",
+		})
+	}
+
+	return metrics
+}
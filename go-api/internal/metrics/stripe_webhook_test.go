@@ -0,0 +1,287 @@
+package metrics
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stripe/stripe-go/v72"
+	"github.com/stripe/stripe-go/v72/webhook"
+)
+
+const testWebhookSecret = "whsec_test"
+
+func newTestWebhookHandler(t *testing.T) (*StripeWebhookHandler, *MockStore, *MockPublisher, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	store := &MockStore{}
+	publisher := &MockPublisher{}
+	config := DefaultStripeWebhookConfig()
+	config.Secret = testWebhookSecret
+
+	return NewStripeWebhookHandler(rdb, store, publisher, config), store, publisher, mr
+}
+
+func stripeEventJSON(id, eventType string, object map[string]interface{}) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":      id,
+		"object":  "event",
+		"type":    eventType,
+		"created": 1700000000,
+		"data":    map[string]interface{}{"object": object},
+	})
+	return data
+}
+
+func signedWebhookRequest(payload []byte, secret string) *http.Request {
+	now := time.Now()
+	signature := hex.EncodeToString(webhook.ComputeSignature(now, payload, secret))
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/stripe", strings.NewReader(string(payload)))
+	req.Header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", now.Unix(), signature))
+	return req
+}
+
+func TestStripeWebhookHandler(t *testing.T) {
+	handler, store, publisher, mr := newTestWebhookHandler(t)
+
+	payload := stripeEventJSON("evt_1", "charge.failed", map[string]interface{}{
+		"id":           "ch_1",
+		"object":       "charge",
+		"amount":       2500,
+		"currency":     "usd",
+		"status":       "failed",
+		"failure_code": "card_declined",
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedWebhookRequest(payload, testWebhookSecret))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(store.metrics) != 3 || len(publisher.metrics) != 3 {
+		t.Fatalf("expected 3 metrics stored and published, got %d and %d", len(store.metrics), len(publisher.metrics))
+	}
+	if !mr.Exists("stripe:webhook:evt_1") {
+		t.Error("expected event ID to be recorded")
+	}
+
+	// Stripe redelivers the same event
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedWebhookRequest(payload, testWebhookSecret))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 for duplicate, got %d", rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `"duplicate":true`) {
+		t.Errorf("expected duplicate ack, got %s", rec.Body.String())
+	}
+	if len(store.metrics) != 3 {
+		t.Errorf("expected duplicate to be ignored, got %d metrics", len(store.metrics))
+	}
+}
+
+// writingStore queues on Store like the Postgres store and fails Write
+// until told otherwise
+type writingStore struct {
+	MockStore
+	queued  []MetricValue
+	failing bool
+}
+
+func (s *writingStore) Store(ctx context.Context, metrics []MetricValue) error {
+	s.queued = append(s.queued, metrics...)
+	return nil
+}
+
+func (s *writingStore) Write(ctx context.Context, metrics []MetricValue) error {
+	if s.failing {
+		return errors.New("database unavailable")
+	}
+	return s.MockStore.Store(ctx, metrics)
+}
+
+func TestStripeWebhookHandlerWritesBeforeAck(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	store := &writingStore{failing: true}
+	config := DefaultStripeWebhookConfig()
+	config.Secret = testWebhookSecret
+	handler := NewStripeWebhookHandler(rdb, store, &MockPublisher{}, config)
+
+	payload := stripeEventJSON("evt_3", "charge.succeeded", map[string]interface{}{
+		"id":       "ch_3",
+		"object":   "charge",
+		"amount":   1000,
+		"currency": "usd",
+		"status":   "succeeded",
+	})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedWebhookRequest(payload, testWebhookSecret))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 when the write fails, got %d", rec.Code)
+	}
+	if mr.Exists("stripe:webhook:evt_3") {
+		t.Error("expected the claim to be released so Stripe's retry is processed")
+	}
+
+	// Stripe retries once the database is back
+	store.failing = false
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, signedWebhookRequest(payload, testWebhookSecret))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(store.metrics) != 2 {
+		t.Errorf("expected 2 metrics written, got %d", len(store.metrics))
+	}
+	if len(store.queued) != 0 {
+		t.Errorf("expected no metrics queued, got %d", len(store.queued))
+	}
+}
+
+func TestStripeWebhookHandlerRejectsBadSignature(t *testing.T) {
+	handler, store, _, mr := newTestWebhookHandler(t)
+
+	payload := stripeEventJSON("evt_2", "charge.succeeded", map[string]interface{}{"id": "ch_2", "status": "succeeded"})
+
+	tests := []struct {
+		name string
+		req  *http.Request
+	}{
+		{"wrong secret", signedWebhookRequest(payload, "whsec_other")},
+		{"missing header", httptest.NewRequest(http.MethodPost, "/webhooks/stripe", strings.NewReader(string(payload)))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, tt.req)
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("expected 400, got %d", rec.Code)
+			}
+		})
+	}
+
+	if len(store.metrics) != 0 {
+		t.Errorf("expected no metrics, got %d", len(store.metrics))
+	}
+	if mr.Exists("stripe:webhook:evt_2") {
+		t.Error("rejected event should not be recorded")
+	}
+}
+
+func TestStripeEventMetrics(t *testing.T) {
+	decode := func(payload []byte) stripe.Event {
+		var event stripe.Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		return event
+	}
+
+	tests := []struct {
+		name    string
+		event   stripe.Event
+		metric  string
+		tags    map[string]string
+		value   float64
+		metrics int
+	}{
+		{
+			name: "charge succeeded",
+			event: decode(stripeEventJSON("evt_a", "charge.succeeded", map[string]interface{}{
+				"amount": 1999, "currency": "eur", "status": "succeeded",
+			})),
+			metric:  "stripe_payment_amount",
+			tags:    map[string]string{"source": "charge", "outcome": "succeeded", "currency": "eur"},
+			value:   19.99,
+			metrics: 2,
+		},
+		{
+			name: "payment intent failed uses decline code",
+			event: decode(stripeEventJSON("evt_b", "payment_intent.payment_failed", map[string]interface{}{
+				"amount": 500, "currency": "jpy", "status": "requires_payment_method",
+				"last_payment_error": map[string]interface{}{"code": "card_declined", "decline_code": "insufficient_funds"},
+			})),
+			metric:  "stripe_payment_failures",
+			tags:    map[string]string{"source": "payment_intent", "reason": "insufficient_funds"},
+			value:   1,
+			metrics: 3,
+		},
+		{
+			name: "invoice payment failed",
+			event: decode(stripeEventJSON("evt_c", "invoice.payment_failed", map[string]interface{}{
+				"amount_due": 4900, "amount_paid": 0, "currency": "usd", "attempt_count": 2, "billing_reason": "subscription_cycle",
+			})),
+			metric:  "stripe_invoice_amount",
+			tags:    map[string]string{"outcome": "failed", "currency": "usd"},
+			value:   49,
+			metrics: 3,
+		},
+		{
+			name: "subscription deleted",
+			event: decode(stripeEventJSON("evt_d", "customer.subscription.deleted", map[string]interface{}{
+				"status": "canceled",
+			})),
+			metric:  "stripe_subscription_events",
+			tags:    map[string]string{"status": "canceled"},
+			value:   1,
+			metrics: 1,
+		},
+		{
+			name:    "unhandled type",
+			event:   decode(stripeEventJSON("evt_e", "customer.created", map[string]interface{}{})),
+			metrics: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := StripeEventMetrics(tt.event)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(metrics) != tt.metrics {
+				t.Fatalf("expected %d metrics, got %d: %+v", tt.metrics, len(metrics), metrics)
+			}
+			if tt.metric == "" {
+				return
+			}
+
+			for _, m := range metrics {
+				if m.Name != tt.metric {
+					continue
+				}
+				for k, v := range tt.tags {
+					if m.Tags[k] != v {
+						t.Errorf("tag %s: expected %q, got %q", k, v, m.Tags[k])
+					}
+				}
+				if m.Value != tt.value {
+					t.Errorf("expected value %v, got %v", tt.value, m.Value)
+				}
+				if !m.Timestamp.Equal(time.Unix(1700000000, 0)) {
+					t.Errorf("expected event timestamp, got %v", m.Timestamp)
+				}
+				return
+			}
+			t.Errorf("metric %s not found", tt.metric)
+		})
+	}
+}
@@ -2,27 +2,36 @@ package metrics
 
 import (
 	"context"
-	"fmt"
 	"os"
 	"runtime"
+	"sync/atomic"
 	"time"
 )
 
+func init() {
+	Register("system", func(name string, options map[string]string, deps CollectorDeps) (MetricsCollector, error) {
+		collector := NewSystemMetricsCollector()
+		collector.name = name
+		return collector, nil
+	})
+}
+
 // SystemMetricsCollector collects system metrics (memory, CPU, goroutines)
 type SystemMetricsCollector struct {
 	name               string
-	enabled            bool
+	enabled            atomic.Bool
 	lastUpdateTime     time.Time
 	collectionInterval time.Duration
 }
 
 // NewSystemMetricsCollector creates a new system metrics collector
 func NewSystemMetricsCollector() *SystemMetricsCollector {
-	return &SystemMetricsCollector{
+	collector := &SystemMetricsCollector{
 		name:               "system",
-		enabled:            true,
 		collectionInterval: 30 * time.Second,
 	}
+	collector.enabled.Store(true)
+	return collector
 }
 
 // Name returns the collector name
@@ -32,12 +41,12 @@ func (s *SystemMetricsCollector) Name() string {
 
 // IsEnabled returns if the collector is enabled
 func (s *SystemMetricsCollector) IsEnabled() bool {
-	return s.enabled
+	return s.enabled.Load()
 }
 
 // SetEnabled sets the enabled state
 func (s *SystemMetricsCollector) SetEnabled(enabled bool) {
-	s.enabled = enabled
+	s.enabled.Store(enabled)
 }
 
 // GetLastUpdateTime returns when metrics were last collected
@@ -52,7 +61,7 @@ func (s *SystemMetricsCollector) GetCollectionInterval() time.Duration {
 
 // Collect gathers system metrics
 func (s *SystemMetricsCollector) Collect(ctx context.Context) ([]MetricValue, error) {
-	if !s.enabled {
+	if !s.enabled.Load() {
 		return []MetricValue{}, nil
 	}
 
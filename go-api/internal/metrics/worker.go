@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"api-monitor-go/internal/config"
+	"api-monitor-go/internal/database"
+	"api-monitor-go/internal/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// WorkerConfig holds metrics worker configuration
+type WorkerConfig struct {
+	Interval time.Duration // default time between runs of a collector
+	Timeout  time.Duration // deadline for one run of a collector
+	Jitter   float64       // fraction of the interval to randomise each wait by
+}
+
+// DefaultWorkerConfig returns default metrics worker configuration
+func DefaultWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		Interval: 5 * time.Minute,
+		Timeout:  30 * time.Second,
+		Jitter:   0.1,
+	}
+}
+
+// Worker runs every collector on its own schedule, storing and publishing
+// what each one collects. It runs embedded in the API process or on its
+// own in cmd/collect-metrics.
+type Worker struct {
+	store     MetricsStore
+	timeout   time.Duration
+	scheduler *Scheduler
+	log       *logger.Logger
+}
+
+// NewWorker creates a new metrics worker. Collectors are added with
+// AddCollector before Start.
+func NewWorker(aggregator MetricsAggregator, store MetricsStore, publisher MetricsPublisher, config WorkerConfig) *Worker {
+	defaults := DefaultWorkerConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+
+	return &Worker{
+		store:   store,
+		timeout: config.Timeout,
+		scheduler: NewScheduler(aggregator, store, publisher, SchedulerConfig{
+			DefaultInterval: config.Interval,
+			Jitter:          config.Jitter,
+			Timeout:         config.Timeout,
+		}),
+		log: logger.New().WithField("component", "metrics-worker"),
+	}
+}
+
+// NewWorkerFromConfig wires the configured collectors, the PostgreSQL store
+// and the Redis publisher from application configuration. Collectors come
+// from METRICS_COLLECTORS_FILE when set, or DefaultCollectorConfigs.
+func NewWorkerFromConfig(db *database.DB, rdb *redis.Client, cfg *config.Config) (*Worker, error) {
+	if db == nil || db.Postgres == nil {
+		return nil, fmt.Errorf("metrics worker requires a database connection")
+	}
+	if rdb == nil {
+		return nil, fmt.Errorf("metrics worker requires a redis client")
+	}
+
+	collectors := DefaultCollectorConfigs(cfg)
+	if cfg.MetricsCollectorsFile != "" {
+		var err error
+		if collectors, err = LoadCollectorConfigs(cfg.MetricsCollectorsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	publisherConfig, err := PublisherConfigFromConfig(cfg, "go-metrics-worker")
+	if err != nil {
+		return nil, err
+	}
+
+	worker := NewWorker(
+		NewMetricsAggregator(5),
+		NewPostgresMetricsStore(db.Postgres),
+		NewRedisMetricsPublisherWithConfig(rdb, publisherConfig),
+		WorkerConfig{
+			Interval: cfg.MetricsInterval,
+			Timeout:  cfg.MetricsTimeout,
+			Jitter:   cfg.MetricsJitter,
+		},
+	)
+
+	deps := CollectorDeps{DB: db.Postgres, Cursors: NewPostgresCursorStore(db.Postgres)}
+	for _, collectorConfig := range collectors {
+		if err := worker.AddFromConfig(DefaultRegistry, collectorConfig, deps); err != nil {
+			return nil, err
+		}
+	}
+
+	return worker, nil
+}
+
+// DefaultCollectorConfigs declares the system and monitoring collectors,
+// plus Stripe when STRIPE_SECRET_KEY is set
+func DefaultCollectorConfigs(cfg *config.Config) []CollectorConfig {
+	collectors := []CollectorConfig{
+		{Type: "system"},
+		{Type: "monitoring"},
+	}
+	if cfg.StripeSecretKey != "" {
+		collectors = append(collectors, CollectorConfig{
+			Type:    "stripe",
+			Options: map[string]string{"api_key": cfg.StripeSecretKey},
+		})
+	}
+	return collectors
+}
+
+// AddFromConfig builds a collector from registry and schedules it
+func (w *Worker) AddFromConfig(registry *Registry, collectorConfig CollectorConfig, deps CollectorDeps) error {
+	collector, interval, err := registry.Build(collectorConfig, deps)
+	if err != nil {
+		return err
+	}
+
+	if interval > 0 {
+		return w.scheduler.AddWithSchedule(collector, interval, w.timeout)
+	}
+	return w.scheduler.Add(collector)
+}
+
+// AddCollector schedules a collector at its own collection interval, or the
+// worker's Interval if it doesn't report one
+func (w *Worker) AddCollector(collector MetricsCollector) error {
+	return w.scheduler.Add(collector)
+}
+
+// Store returns the store metrics are written to
+func (w *Worker) Store() MetricsStore {
+	return w.store
+}
+
+// Scheduler returns the scheduler, which reports per-collector state
+func (w *Worker) Scheduler() *Scheduler {
+	return w.scheduler
+}
+
+// CollectOnce runs every collector once
+func (w *Worker) CollectOnce(ctx context.Context) error {
+	w.log.Debug("starting metrics collection")
+	startTime := time.Now()
+
+	if err := w.scheduler.RunOnce(ctx); err != nil {
+		return fmt.Errorf("collection failed: %w", err)
+	}
+
+	w.log.Infof("metrics collection completed in %v", time.Since(startTime))
+	return nil
+}
+
+// Start starts every collector's schedule
+func (w *Worker) Start() {
+	w.scheduler.Start()
+}
+
+// Stop stops the worker, waits for in-flight collections to finish and
+// flushes the metrics its store still has queued
+func (w *Worker) Stop(ctx context.Context) error {
+	err := w.scheduler.Stop(ctx)
+
+	if closer, ok := w.store.(interface{ Close(context.Context) error }); ok {
+		if closeErr := closer.Close(ctx); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// DefaultTrustedProxies are loopback and private networks, where the
+// reverse proxy in front of the API runs
+var DefaultTrustedProxies = []string{
+	"127.0.0.0/8", "::1/128",
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+}
+
+// ClientIPConfig holds client IP resolution configuration
+type ClientIPConfig struct {
+	// TrustedProxies are the CIDRs or addresses whose forwarding headers
+	// are believed; headers from anyone else are ignored
+	TrustedProxies []string
+
+	// IPv6Prefix is how many bits of an IPv6 address identify a client.
+	// A subscriber usually gets a whole /64, so counting single addresses
+	// would let them rotate through it.
+	IPv6Prefix int
+}
+
+// DefaultClientIPConfig returns default client IP resolution configuration
+func DefaultClientIPConfig() ClientIPConfig {
+	return ClientIPConfig{
+		TrustedProxies: DefaultTrustedProxies,
+		IPv6Prefix:     64,
+	}
+}
+
+// ClientIPResolver finds the address a request came from behind trusted
+// proxies
+type ClientIPResolver struct {
+	trusted    []netip.Prefix
+	ipv6Prefix int
+}
+
+// NewClientIPResolver creates a client IP resolver
+func NewClientIPResolver(config ClientIPConfig) (*ClientIPResolver, error) {
+	trusted, err := ParseCIDRs(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	if config.IPv6Prefix <= 0 || config.IPv6Prefix > 128 {
+		config.IPv6Prefix = DefaultClientIPConfig().IPv6Prefix
+	}
+	return &ClientIPResolver{trusted: trusted, ipv6Prefix: config.IPv6Prefix}, nil
+}
+
+// ParseCIDRs parses CIDRs; bare addresses are taken as single-address
+// prefixes
+func ParseCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			prefix = prefix.Masked()
+			if prefix.Addr().Is4In6() {
+				prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+			}
+			prefixes = append(prefixes, prefix)
+			continue
+		}
+
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// containsAddr reports whether any prefix contains addr
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address of the client. Forwarding headers are only
+// read when the direct peer is a trusted proxy, and then from the right,
+// skipping trusted hops: the first untrusted hop is the client, since
+// anything to its left could have been made up by it.
+func (c *ClientIPResolver) ClientIP(r *http.Request) netip.Addr {
+	addr, ok := parseHost(r.RemoteAddr)
+	if !ok || !containsAddr(c.trusted, addr) {
+		return addr
+	}
+
+	hops := forwardedFor(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHost(hops[i])
+		if !ok {
+			// An unknown or obfuscated hop hides everything before it
+			break
+		}
+		addr = hop
+		if !containsAddr(c.trusted, hop) {
+			break
+		}
+	}
+	return addr
+}
+
+// Key returns the client's address for counting requests: IPv6 addresses
+// are cut to their network prefix. Requests without a parseable peer
+// address share the key "unknown".
+func (c *ClientIPResolver) Key(r *http.Request) string {
+	addr := c.ClientIP(r)
+	if !addr.IsValid() {
+		return "unknown"
+	}
+	if addr.Is6() {
+		prefix, err := addr.Prefix(c.ipv6Prefix)
+		if err == nil {
+			return prefix.String()
+		}
+	}
+	return addr.String()
+}
+
+// forwardedFor returns the hops a request went through, closest last. The
+// RFC 7239 Forwarded header wins over X-Forwarded-For, which wins over
+// X-Real-IP.
+func forwardedFor(h http.Header) []string {
+	if values := h.Values("Forwarded"); len(values) > 0 {
+		var hops []string
+		for _, value := range values {
+			hops = append(hops, parseForwarded(value)...)
+		}
+		return hops
+	}
+
+	if values := h.Values("X-Forwarded-For"); len(values) > 0 {
+		var hops []string
+		for _, value := range values {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+		return hops
+	}
+
+	if realIP := h.Get("X-Real-IP"); realIP != "" {
+		return []string{strings.TrimSpace(realIP)}
+	}
+	return nil
+}
+
+// parseForwarded returns the for= nodes of a Forwarded header, e.g.
+// `for=192.0.2.60;proto=http, for="[2001:db8::17]:4711"`. Elements without
+// one yield an empty node, which stops the walk like an unknown hop.
+func parseForwarded(value string) []string {
+	var nodes []string
+	for _, element := range splitQuoted(value, ',') {
+		node := ""
+		for _, pair := range splitQuoted(element, ';') {
+			name, val, found := strings.Cut(strings.TrimSpace(pair), "=")
+			if found && strings.EqualFold(name, "for") {
+				node = strings.Trim(strings.TrimSpace(val), `"`)
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// splitQuoted splits s at sep outside double quotes
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// parseHost parses an address with an optional port, as found in
+// RemoteAddr and forwarding headers: "192.0.2.1", "192.0.2.1:80",
+// "2001:db8::1", "[2001:db8::1]:80". IPv4-mapped IPv6 addresses are
+// unmapped and zones dropped.
+func parseHost(value string) (netip.Addr, bool) {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap().WithZone(""), true
+}
+
+// defaultClientIP resolves client IPs for the middleware not given a
+// resolver
+var defaultClientIP, _ = NewClientIPResolver(DefaultClientIPConfig())
+
+// getClientIP returns the rate limiting key of the request's client
+func getClientIP(r *http.Request) string {
+	return defaultClientIP.Key(r)
+}
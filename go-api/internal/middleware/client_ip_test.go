@@ -0,0 +1,189 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func newTestRequest(remoteAddr string, headers map[string][]string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.RemoteAddr = remoteAddr
+	for name, values := range headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	return req
+}
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver(ClientIPConfig{
+		TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::/48", "198.51.100.7"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string][]string
+		want       string
+	}{
+		{"direct", "203.0.113.5:4000", nil, "203.0.113.5"},
+		{"untrusted peer's headers are ignored", "203.0.113.5:4000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.2:4000",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.5"}}, "203.0.113.5"},
+		{"spoofed entries left of the client", "10.0.0.2:4000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.5"}}, "203.0.113.5"},
+		{"trusted hops are skipped", "10.0.0.2:4000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.5, 198.51.100.7, 10.1.1.1"}}, "203.0.113.5"},
+		{"repeated headers", "10.0.0.2:4000",
+			map[string][]string{"X-Forwarded-For": {"1.2.3.4, 203.0.113.5", "10.1.1.1"}}, "203.0.113.5"},
+		{"only trusted hops", "10.0.0.2:4000",
+			map[string][]string{"X-Forwarded-For": {"10.3.3.3, 10.1.1.1"}}, "10.3.3.3"},
+		{"garbage hop", "10.0.0.2:4000",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.5, not-an-ip, 10.1.1.1"}}, "10.1.1.1"},
+		{"X-Real-IP", "10.0.0.2:4000",
+			map[string][]string{"X-Real-IP": {"203.0.113.5"}}, "203.0.113.5"},
+		{"Forwarded", "10.0.0.2:4000",
+			map[string][]string{"Forwarded": {`for=1.2.3.4, for=203.0.113.5;proto=https;by=10.0.0.2`}}, "203.0.113.5"},
+		{"Forwarded wins over X-Forwarded-For", "10.0.0.2:4000",
+			map[string][]string{"Forwarded": {"for=203.0.113.5"}, "X-Forwarded-For": {"1.2.3.4"}}, "203.0.113.5"},
+		{"Forwarded IPv6 with port", "10.0.0.2:4000",
+			map[string][]string{"Forwarded": {`For="[2001:db8:cafe::17]:4711"`}}, "2001:db8:cafe::17"},
+		{"Forwarded quoted separators", "10.0.0.2:4000",
+			map[string][]string{"Forwarded": {`for=1.2.3.4;ext="a,b;c", for=203.0.113.5`}}, "203.0.113.5"},
+		{"Forwarded unknown hop", "10.0.0.2:4000",
+			map[string][]string{"Forwarded": {"for=203.0.113.5, for=unknown, for=10.1.1.1"}}, "10.1.1.1"},
+		{"Forwarded element without for", "10.0.0.2:4000",
+			map[string][]string{"Forwarded": {"for=203.0.113.5, proto=https"}}, "10.0.0.2"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.2]:4000",
+			map[string][]string{"X-Forwarded-For": {"203.0.113.5"}}, "203.0.113.5"},
+		{"IPv6 trusted proxy", "[2001:db8:ffff::1]:4000",
+			map[string][]string{"X-Forwarded-For": {"2001:db8:1:2:3:4:5:6"}}, "2001:db8:1:2:3:4:5:6"},
+		{"IPv6 zone", "[fe80::1%eth0]:4000", nil, "fe80::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resolver.ClientIP(newTestRequest(tt.remoteAddr, tt.headers))
+			if got.String() != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestClientIPResolverKey(t *testing.T) {
+	resolver, err := NewClientIPResolver(DefaultClientIPConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remoteAddr string
+		want       string
+	}{
+		{"203.0.113.5:4000", "203.0.113.5"},
+		{"[2001:db8:1:2:aaaa::1]:4000", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:2:bbbb::9]:4000", "2001:db8:1:2::/64"},
+		{"[2001:db8:1:3::1]:4000", "2001:db8:1:3::/64"},
+		{"[::ffff:203.0.113.5]:4000", "203.0.113.5"},
+		{"@", "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := resolver.Key(newTestRequest(tt.remoteAddr, nil)); got != tt.want {
+			t.Errorf("%s: expected key %s, got %s", tt.remoteAddr, tt.want, got)
+		}
+	}
+}
+
+func TestParseCIDRs(t *testing.T) {
+	prefixes, err := ParseCIDRs([]string{"10.1.2.3/8", " 192.0.2.1 ", "::ffff:10.0.0.0/104", "2001:db8::/32", ""})
+	if err != nil {
+		t.Fatalf("ParseCIDRs: %v", err)
+	}
+
+	want := []string{"10.0.0.0/8", "192.0.2.1/32", "10.0.0.0/8", "2001:db8::/32"}
+	if len(prefixes) != len(want) {
+		t.Fatalf("expected %v, got %v", want, prefixes)
+	}
+	for i := range want {
+		if prefixes[i].String() != want[i] {
+			t.Errorf("expected %s, got %s", want[i], prefixes[i])
+		}
+	}
+
+	if _, err := ParseCIDRs([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected an error for an invalid prefix")
+	}
+	if _, err := NewClientIPResolver(ClientIPConfig{TrustedProxies: []string{"proxy"}}); err == nil {
+		t.Error("expected an error for an invalid trusted proxy")
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	resolver, err := NewClientIPResolver(ClientIPConfig{TrustedProxies: []string{"10.0.0.0/8"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := NewIPFilter(IPFilterConfig{
+		Allow: []string{"203.0.113.0/24", "2001:db8::/32"},
+		Deny:  []string{"203.0.113.66"},
+	}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"203.0.113.5", true},
+		{"203.0.113.66", false},
+		{"198.51.100.1", false},
+		{"2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		if got := filter.Allowed(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("%s: expected allowed %v, got %v", tt.addr, tt.want, got)
+		}
+	}
+
+	handler := filter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	codes := map[string]int{}
+	for name, req := range map[string]*http.Request{
+		"allowed":          newTestRequest("203.0.113.5:4000", nil),
+		"denied":           newTestRequest("198.51.100.1:4000", nil),
+		"behind proxy":     newTestRequest("10.0.0.2:4000", map[string][]string{"X-Forwarded-For": {"203.0.113.5"}}),
+		"spoofed":          newTestRequest("198.51.100.1:4000", map[string][]string{"X-Forwarded-For": {"203.0.113.5"}}),
+		"denied via proxy": newTestRequest("10.0.0.2:4000", map[string][]string{"X-Forwarded-For": {"203.0.113.66"}}),
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes[name] = rec.Code
+	}
+
+	want := map[string]int{
+		"allowed":          http.StatusOK,
+		"denied":           http.StatusForbidden,
+		"behind proxy":     http.StatusOK,
+		"spoofed":          http.StatusForbidden,
+		"denied via proxy": http.StatusForbidden,
+	}
+	for name, code := range want {
+		if codes[name] != code {
+			t.Errorf("%s: expected %d, got %d", name, code, codes[name])
+		}
+	}
+
+	if _, err := NewIPFilter(IPFilterConfig{Deny: []string{"nope"}}, resolver); err == nil {
+		t.Error("expected an error for an invalid CIDR")
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+)
+
+// Identity is who a request was made by, as far as the API could tell.
+// Requests without valid credentials have a zero Identity.
+type Identity struct {
+	// UserID identifies an authenticated user
+	UserID string
+}
+
+type identityKey struct{}
+
+// WithIdentity adds an identity to the context
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity in the context, if any
+func IdentityFrom(ctx context.Context) Identity {
+	identity, _ := ctx.Value(identityKey{}).(Identity)
+	return identity
+}
+
+// Authenticator identifies the caller of a request. It returns false when
+// the request carries no credentials it accepts.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, bool)
+}
+
+// Authenticate adds the identity of the first authenticator accepting the
+// request to its context. Requests no authenticator accepts are passed on
+// anonymously; handlers that require a caller must check themselves.
+func Authenticate(authenticators ...Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, authenticator := range authenticators {
+				if identity, ok := authenticator.Authenticate(r); ok {
+					r = r.WithContext(WithIdentity(r.Context(), identity))
+					break
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+)
+
+// IPFilterConfig holds IP filter configuration
+type IPFilterConfig struct {
+	// Allow lets only these CIDRs through when set; Deny rejects these
+	// CIDRs and wins over Allow
+	Allow []string
+	Deny  []string
+}
+
+// IPFilter rejects requests by client address
+type IPFilter struct {
+	allow    []netip.Prefix
+	deny     []netip.Prefix
+	resolver *ClientIPResolver
+}
+
+// NewIPFilter creates an IP filter. Client addresses are found by
+// resolver, so addresses behind trusted proxies are filtered too.
+func NewIPFilter(config IPFilterConfig, resolver *ClientIPResolver) (*IPFilter, error) {
+	allow, err := ParseCIDRs(config.Allow)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowed CIDRs: %w", err)
+	}
+	deny, err := ParseCIDRs(config.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("invalid denied CIDRs: %w", err)
+	}
+	return &IPFilter{allow: allow, deny: deny, resolver: resolver}, nil
+}
+
+// Allowed reports whether addr may make requests. Unknown addresses are
+// only allowed without an allowlist.
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	if !addr.IsValid() {
+		return len(f.allow) == 0
+	}
+	if containsAddr(f.deny, addr) {
+		return false
+	}
+	return len(f.allow) == 0 || containsAddr(f.allow, addr)
+}
+
+// Middleware rejects requests from addresses the filter doesn't allow
+func (f *IPFilter) Middleware(next http.Handler) http.Handler {
+	if len(f.allow) == 0 && len(f.deny) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !f.Allowed(f.resolver.ClientIP(r)) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": "forbidden"}`))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,120 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// signToken signs claims the way Symfony does
+func signToken(t *testing.T, key *rsa.PrivateKey, alg string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": alg})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTAuthenticatorVerify(t *testing.T) {
+	key, publicKey := newTestKey(t)
+	otherKey, _ := newTestKey(t)
+
+	auth, err := NewJWTAuthenticator(publicKey, "")
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	auth.now = func() time.Time { return now }
+
+	valid := map[string]interface{}{"username": "alice@example.com", "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	tampered := signToken(t, key, "RS256", valid)
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", signToken(t, key, "RS256", valid), nil},
+		{"expired", signToken(t, key, "RS256", map[string]interface{}{"username": "alice@example.com", "exp": now.Add(-time.Second).Unix()}), ErrTokenExpired},
+		{"without expiry", signToken(t, key, "RS256", map[string]interface{}{"username": "alice@example.com"}), ErrTokenExpired},
+		{"not yet valid", signToken(t, key, "RS256", map[string]interface{}{"username": "alice@example.com", "nbf": now.Add(time.Minute).Unix(), "exp": now.Add(time.Hour).Unix()}), ErrInvalidToken},
+		{"other key", signToken(t, otherKey, "RS256", valid), ErrInvalidToken},
+		{"other algorithm", signToken(t, key, "none", valid), ErrInvalidToken},
+		{"tampered", tampered, ErrInvalidToken},
+		{"no identity", signToken(t, key, "RS256", map[string]interface{}{"exp": now.Add(time.Hour).Unix()}), ErrInvalidToken},
+		{"malformed", "not-a-token", ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := auth.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, err)
+			}
+			if err == nil && user != "alice@example.com" {
+				t.Errorf("expected the username claim, got %q", user)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAddsIdentity(t *testing.T) {
+	key, publicKey := newTestKey(t)
+	auth, err := NewJWTAuthenticator(publicKey, "username")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Identity
+	handler := Authenticate(auth)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFrom(r.Context())
+	}))
+
+	token := signToken(t, key, "RS256", map[string]interface{}{"username": "bob@example.com", "exp": time.Now().Add(time.Hour).Unix()})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got.UserID != "bob@example.com" {
+		t.Errorf("expected the token's user, got %+v", got)
+	}
+
+	// Bad credentials leave the request anonymous
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer "+token+"x")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != (Identity{}) {
+		t.Errorf("expected an anonymous request, got %+v", got)
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limiter decides whether a request for a key, such as a client IP, is
+// allowed. RateLimiter keeps its state in process memory and RedisLimiter
+// shares it between replicas.
+type Limiter interface {
+	Allow(ctx context.Context, key string) (Decision, error)
+}
+
+// Decision is a limiter's answer for one request
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+
+	// RetryAfter is how long a denied request should wait before the next
+	// one can be allowed
+	RetryAfter time.Duration
+
+	// ResetAfter is how long until the limit is fully available again
+	ResetAfter time.Duration
+}
+
+// Algorithm selects how a limiter counts requests
+type Algorithm string
+
+const (
+	// AlgorithmTokenBucket allows bursts of up to the capacity and refills
+	// at a steady rate
+	AlgorithmTokenBucket Algorithm = "token_bucket"
+	// AlgorithmSlidingWindow allows a number of requests within any window
+	// of the given length
+	AlgorithmSlidingWindow Algorithm = "sliding_window"
+)
+
+// bucketDecision builds the decision for a token bucket left with tokens
+func bucketDecision(capacity, refillRate float64, allowed bool, tokens float64) Decision {
+	d := Decision{
+		Allowed:   allowed,
+		Limit:     int(capacity),
+		Remaining: int(math.Floor(math.Max(tokens, 0))),
+	}
+	if refillRate <= 0 {
+		return d
+	}
+
+	d.ResetAfter = secondsDuration((capacity - tokens) / refillRate)
+	if !allowed {
+		d.RetryAfter = secondsDuration((1 - tokens) / refillRate)
+	}
+	return d
+}
+
+func secondsDuration(seconds float64) time.Duration {
+	if seconds <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
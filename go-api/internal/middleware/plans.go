@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PlanResolver returns the subscription plan of a user, for a module when
+// one is given. It returns "" for unknown users.
+type PlanResolver interface {
+	Plan(ctx context.Context, userID, module string) (string, error)
+}
+
+// PostgresPlanStore reads plans from the tables Symfony owns. A user's
+// enabled subscription to the module wins over their account's tier.
+type PostgresPlanStore struct {
+	db *sql.DB
+}
+
+// NewPostgresPlanStore creates a Postgres plan store
+func NewPostgresPlanStore(db *sql.DB) *PostgresPlanStore {
+	return &PostgresPlanStore{db: db}
+}
+
+// Plan implements PlanResolver. Users are identified by email, which is
+// what Symfony's tokens carry.
+func (s *PostgresPlanStore) Plan(ctx context.Context, userID, module string) (string, error) {
+	var plan string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(
+			(SELECT s.tier FROM user_module_subscriptions s
+			 WHERE s.user_id::text = u.id::text AND s.module_name = $2 AND s.enabled),
+			u.subscription_tier, '')
+		FROM users u
+		WHERE u.email = $1`, userID, module).Scan(&plan)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up plan: %w", err)
+	}
+	return plan, nil
+}
+
+// maxCachedPlans bounds the plans a CachedPlanResolver keeps
+const maxCachedPlans = 10000
+
+type cachedPlan struct {
+	key     string
+	plan    string
+	expires time.Time
+}
+
+// CachedPlanResolver keeps plans for a while, so limiting a request doesn't
+// cost a query. Plan changes take up to the TTL to apply. Past
+// maxCachedPlans, the least recently used plan makes room.
+type CachedPlanResolver struct {
+	resolver PlanResolver
+	ttl      time.Duration
+	size     int
+	now      func() time.Time
+
+	mu    sync.Mutex
+	plans map[string]*list.Element
+	// order holds the cached plans, least recently used first
+	order *list.List
+}
+
+// NewCachedPlanResolver creates a plan cache in front of resolver
+func NewCachedPlanResolver(resolver PlanResolver, ttl time.Duration) *CachedPlanResolver {
+	return &CachedPlanResolver{
+		resolver: resolver,
+		ttl:      ttl,
+		size:     maxCachedPlans,
+		now:      time.Now,
+		plans:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Plan implements PlanResolver. Lookup errors aren't cached.
+func (c *CachedPlanResolver) Plan(ctx context.Context, userID, module string) (string, error) {
+	key := module + "\x00" + userID
+	now := c.now()
+
+	c.mu.Lock()
+	if el, ok := c.plans[key]; ok {
+		cached := el.Value.(*cachedPlan)
+		if now.Before(cached.expires) {
+			c.order.MoveToBack(el)
+			c.mu.Unlock()
+			return cached.plan, nil
+		}
+	}
+	c.mu.Unlock()
+
+	plan, err := c.resolver.Plan(ctx, userID, module)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.plans[key]; ok {
+		c.order.Remove(el)
+		delete(c.plans, key)
+	}
+	for len(c.plans) >= c.size {
+		oldest := c.order.Front()
+		c.order.Remove(oldest)
+		delete(c.plans, oldest.Value.(*cachedPlan).key)
+	}
+	c.plans[key] = c.order.PushBack(&cachedPlan{key: key, plan: plan, expires: now.Add(c.ttl)})
+	return plan, nil
+}
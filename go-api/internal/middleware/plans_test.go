@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCachedPlanResolver(t *testing.T) {
+	plans := &fakePlans{plans: map[string]string{":alice": "pro", "ecommerce:alice": "enterprise"}}
+	cache := NewCachedPlanResolver(plans, time.Minute)
+	now := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if plan, _ := cache.Plan(ctx, "alice", ""); plan != "pro" {
+			t.Fatalf("expected pro, got %q", plan)
+		}
+	}
+	if plan, _ := cache.Plan(ctx, "alice", "ecommerce"); plan != "enterprise" {
+		t.Errorf("expected the module plan, got %q", plan)
+	}
+	if plans.calls != 2 {
+		t.Errorf("expected one lookup per user and module, got %d", plans.calls)
+	}
+
+	// Expired plans are looked up again, and errors aren't cached
+	now = now.Add(time.Minute)
+	plans.err = errors.New("database down")
+	if _, err := cache.Plan(ctx, "alice", ""); err == nil {
+		t.Error("expected the lookup error")
+	}
+	plans.err = nil
+	plans.plans[":alice"] = "free"
+	if plan, _ := cache.Plan(ctx, "alice", ""); plan != "free" {
+		t.Errorf("expected the changed plan, got %q", plan)
+	}
+}
+
+func TestCachedPlanResolverEvictsLeastRecentlyUsed(t *testing.T) {
+	plans := &fakePlans{plans: map[string]string{":alice": "pro", ":bob": "free", ":carol": "enterprise"}}
+	cache := NewCachedPlanResolver(plans, time.Minute)
+	cache.size = 2
+
+	ctx := context.Background()
+	for _, user := range []string{"alice", "bob", "alice", "carol"} {
+		cache.Plan(ctx, user, "")
+	}
+	if plans.calls != 3 || len(cache.plans) != 2 || cache.order.Len() != 2 {
+		t.Fatalf("expected 3 lookups and 2 cached plans, got %d and %d", plans.calls, len(cache.plans))
+	}
+
+	// Bob was used least recently, so his plan made room for Carol's
+	cache.Plan(ctx, "alice", "")
+	cache.Plan(ctx, "carol", "")
+	if plans.calls != 3 {
+		t.Errorf("expected alice and carol to stay cached, got %d lookups", plans.calls)
+	}
+	if plan, _ := cache.Plan(ctx, "bob", ""); plan != "free" || plans.calls != 4 {
+		t.Errorf("expected bob to be looked up again, got %q after %d lookups", plan, plans.calls)
+	}
+}
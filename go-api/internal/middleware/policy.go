@@ -0,0 +1,432 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// KeyKind selects what a policy counts requests by
+type KeyKind string
+
+const (
+	KeyUser KeyKind = "user"
+	KeyIP   KeyKind = "ip"
+)
+
+// PolicyLimit allows Limit requests per Window. Token buckets hold Burst
+// tokens and refill at Limit per Window; Burst defaults to Limit.
+type PolicyLimit struct {
+	Limit  float64 `json:"limit"`
+	Window string  `json:"window,omitempty"`
+	Burst  float64 `json:"burst,omitempty"`
+
+	window time.Duration
+}
+
+// RateLimitPolicy limits the requests to a set of routes. Requests are
+// counted per caller, identified by the first of Keys the request has.
+// Limits are picked per user first, then per plan, then the policy's own.
+type RateLimitPolicy struct {
+	Name string `json:"name"`
+
+	// Routes are path prefixes; a policy without routes matches every
+	// request no other policy matches
+	Routes []string `json:"routes,omitempty"`
+
+	Keys      []KeyKind `json:"keys,omitempty"`
+	Algorithm Algorithm `json:"algorithm,omitempty"`
+
+	// Module looks up the user's plan for a module subscription rather
+	// than their account
+	Module string `json:"module,omitempty"`
+
+	PolicyLimit
+	Plans map[string]PolicyLimit `json:"plans,omitempty"`
+	Users map[string]PolicyLimit `json:"users,omitempty"`
+}
+
+// DefaultRateLimitPolicies returns the policies used without a policy file
+func DefaultRateLimitPolicies() []RateLimitPolicy {
+	plans := map[string]PolicyLimit{
+		"pro":        {Limit: 30, Window: "1s"},
+		"enterprise": {Limit: 100, Window: "1s"},
+	}
+	return []RateLimitPolicy{
+		{Name: "websocket", Routes: []string{"/ws"}, PolicyLimit: PolicyLimit{Limit: 10, Window: "1s"}, Plans: plans},
+		{Name: "monitor", Routes: []string{"/monitor"}, PolicyLimit: PolicyLimit{Limit: 10, Window: "1s"}, Plans: plans},
+	}
+}
+
+// LoadRateLimitPolicies reads a JSON array of policies
+func LoadRateLimitPolicies(path string) ([]RateLimitPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit policies: %w", err)
+	}
+
+	var policies []RateLimitPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("failed to parse rate limit policies: %w", err)
+	}
+	return policies, nil
+}
+
+// LimiterFactory creates the limiter for one limit of a policy. The
+// config's Prefix is unique to the policy and limit.
+type LimiterFactory func(config RedisLimiterConfig) Limiter
+
+// MemoryLimiters creates in-memory limiters
+func MemoryLimiters(config RedisLimiterConfig) Limiter {
+	capacity, refillRate := config.LocalRate()
+	return NewRateLimiter(capacity, refillRate, 5*time.Minute)
+}
+
+// RedisLimiters creates Redis limiters that fall back to in-memory ones.
+// base sets the Redis timeouts.
+func RedisLimiters(client *redis.Client, base RedisLimiterConfig) LimiterFactory {
+	return func(config RedisLimiterConfig) Limiter {
+		config.Timeout = base.Timeout
+		config.RetryInterval = base.RetryInterval
+		return NewRedisLimiter(client, config, MemoryLimiters(config))
+	}
+}
+
+// RateLimitStats counts a policy's decisions
+type RateLimitStats struct {
+	Policy   string `json:"policy"`
+	Allowed  int64  `json:"allowed"`
+	Rejected int64  `json:"rejected"`
+	Errors   int64  `json:"errors"`
+}
+
+// compiledPolicy is a validated policy with its counters
+type compiledPolicy struct {
+	RateLimitPolicy
+
+	allowed  atomic.Int64
+	rejected atomic.Int64
+	errors   atomic.Int64
+}
+
+// RateLimits applies rate limit policies to requests
+type RateLimits struct {
+	policies   []*compiledPolicy
+	routes     []policyRoute
+	fallback   *compiledPolicy
+	plans      PlanResolver
+	newLimiter LimiterFactory
+	log        *logger.Logger
+
+	// ClientIP returns the address requests are counted by when keyed by
+	// IP
+	ClientIP func(r *http.Request) string
+
+	mu       sync.Mutex
+	limiters map[string]Limiter
+}
+
+type policyRoute struct {
+	prefix string
+	policy *compiledPolicy
+}
+
+// NewRateLimits validates policies and creates their middleware. plans may
+// be nil, in which case plan limits never apply.
+func NewRateLimits(policies []RateLimitPolicy, plans PlanResolver, newLimiter LimiterFactory) (*RateLimits, error) {
+	rl := &RateLimits{
+		plans:      plans,
+		newLimiter: newLimiter,
+		log:        logger.New().WithField("component", "rate-limits"),
+		ClientIP:   getClientIP,
+		limiters:   make(map[string]Limiter),
+	}
+
+	names := make(map[string]bool)
+	for _, policy := range policies {
+		compiled, err := compilePolicy(policy)
+		if err != nil {
+			return nil, err
+		}
+		if names[policy.Name] {
+			return nil, fmt.Errorf("duplicate rate limit policy '%s'", policy.Name)
+		}
+		names[policy.Name] = true
+		rl.policies = append(rl.policies, compiled)
+
+		if len(policy.Routes) == 0 {
+			if rl.fallback != nil {
+				return nil, fmt.Errorf("rate limit policies '%s' and '%s' both match every route", rl.fallback.Name, policy.Name)
+			}
+			rl.fallback = compiled
+		}
+		for _, route := range policy.Routes {
+			rl.routes = append(rl.routes, policyRoute{prefix: strings.TrimSuffix(route, "/"), policy: compiled})
+		}
+	}
+
+	// The longest matching prefix wins
+	sort.SliceStable(rl.routes, func(i, j int) bool {
+		return len(rl.routes[i].prefix) > len(rl.routes[j].prefix)
+	})
+	return rl, nil
+}
+
+// compilePolicy validates a policy and fills in its defaults
+func compilePolicy(policy RateLimitPolicy) (*compiledPolicy, error) {
+	if policy.Name == "" {
+		return nil, fmt.Errorf("rate limit policy without a name")
+	}
+	if len(policy.Keys) == 0 {
+		policy.Keys = []KeyKind{KeyUser, KeyIP}
+	}
+	for _, kind := range policy.Keys {
+		if kind != KeyUser && kind != KeyIP {
+			return nil, fmt.Errorf("rate limit policy '%s': unknown key '%s'", policy.Name, kind)
+		}
+	}
+	switch policy.Algorithm {
+	case "":
+		policy.Algorithm = AlgorithmTokenBucket
+	case AlgorithmTokenBucket, AlgorithmSlidingWindow:
+	default:
+		return nil, fmt.Errorf("rate limit policy '%s': unknown algorithm '%s'", policy.Name, policy.Algorithm)
+	}
+
+	var err error
+	if policy.PolicyLimit, err = compileLimit(policy.PolicyLimit); err != nil {
+		return nil, fmt.Errorf("rate limit policy '%s': %w", policy.Name, err)
+	}
+	if policy.Plans, err = compileLimits(policy.Name, policy.Plans); err != nil {
+		return nil, err
+	}
+	if policy.Users, err = compileLimits(policy.Name, policy.Users); err != nil {
+		return nil, err
+	}
+
+	return &compiledPolicy{RateLimitPolicy: policy}, nil
+}
+
+// compileLimits compiles a copy of named limits, so policies may share
+// them
+func compileLimits(policy string, limits map[string]PolicyLimit) (map[string]PolicyLimit, error) {
+	compiled := make(map[string]PolicyLimit, len(limits))
+	for name, limit := range limits {
+		var err error
+		if compiled[name], err = compileLimit(limit); err != nil {
+			return nil, fmt.Errorf("rate limit policy '%s', limit for '%s': %w", policy, name, err)
+		}
+	}
+	return compiled, nil
+}
+
+func compileLimit(limit PolicyLimit) (PolicyLimit, error) {
+	if limit.Limit < 1 {
+		return limit, fmt.Errorf("limit must be at least 1")
+	}
+
+	limit.window = time.Second
+	if limit.Window != "" {
+		window, err := time.ParseDuration(limit.Window)
+		if err != nil || window <= 0 {
+			return limit, fmt.Errorf("invalid window '%s'", limit.Window)
+		}
+		limit.window = window
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Limit
+	}
+	return limit, nil
+}
+
+// header returns the limit as a RateLimit-Policy header value
+func (l PolicyLimit) header() string {
+	return strconv.FormatFloat(l.Limit, 'f', -1, 64) + ";w=" +
+		strconv.FormatFloat(math.Ceil(l.window.Seconds()), 'f', -1, 64)
+}
+
+// match returns the policy for a request path, or nil
+func (rl *RateLimits) match(path string) *compiledPolicy {
+	for _, route := range rl.routes {
+		if path == route.prefix || strings.HasPrefix(path, route.prefix+"/") || route.prefix == "" {
+			return route.policy
+		}
+	}
+	return rl.fallback
+}
+
+// key returns what the request is counted by under policy
+func (rl *RateLimits) key(r *http.Request, policy *compiledPolicy, identity Identity) string {
+	for _, kind := range policy.Keys {
+		switch kind {
+		case KeyUser:
+			if identity.UserID != "" {
+				return "user:" + identity.UserID
+			}
+		case KeyIP:
+			return "ip:" + rl.ClientIP(r)
+		}
+	}
+	// Policies keyed only by callers the request doesn't have fall back to
+	// the address rather than letting it through
+	return "ip:" + rl.ClientIP(r)
+}
+
+// limit returns the limit that applies to identity and a name for it
+func (rl *RateLimits) limit(r *http.Request, policy *compiledPolicy, identity Identity) (string, PolicyLimit) {
+	if identity.UserID == "" {
+		return "default", policy.PolicyLimit
+	}
+	if limit, ok := policy.Users[identity.UserID]; ok {
+		return "user:" + identity.UserID, limit
+	}
+	if len(policy.Plans) == 0 || rl.plans == nil {
+		return "default", policy.PolicyLimit
+	}
+
+	plan, err := rl.plans.Plan(r.Context(), identity.UserID, policy.Module)
+	if err != nil {
+		rl.log.Warnf("policy %s: %v, applying the default limit", policy.Name, err)
+		return "default", policy.PolicyLimit
+	}
+	if limit, ok := policy.Plans[plan]; ok {
+		return "plan:" + plan, limit
+	}
+	return "default", policy.PolicyLimit
+}
+
+// limiter returns the limiter enforcing one limit of a policy
+func (rl *RateLimits) limiter(policy *compiledPolicy, name string, limit PolicyLimit) Limiter {
+	prefix := "ratelimit:" + policy.Name + ":" + name
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if limiter, ok := rl.limiters[prefix]; ok {
+		return limiter
+	}
+	config := RedisLimiterConfig{
+		Algorithm:  policy.Algorithm,
+		Prefix:     prefix,
+		Capacity:   limit.Burst,
+		RefillRate: limit.Limit / limit.window.Seconds(),
+		Window:     limit.window,
+	}
+	// A sliding window has no burst beyond its limit
+	if policy.Algorithm == AlgorithmSlidingWindow {
+		config.Capacity = limit.Limit
+	}
+
+	limiter := rl.newLimiter(config)
+	rl.limiters[prefix] = limiter
+	return limiter
+}
+
+// Middleware limits requests by the policy matching their route. Requests
+// no policy matches are let through.
+func (rl *RateLimits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		policy := rl.match(r.URL.Path)
+		if policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		identity := IdentityFrom(r.Context())
+		name, limit := rl.limit(r, policy, identity)
+
+		decision, err := rl.limiter(policy, name, limit).Allow(r.Context(), rl.key(r, policy, identity))
+		if err != nil {
+			// No limiter could decide; let the request through rather
+			// than failing the API
+			policy.errors.Add(1)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("RateLimit-Policy", limit.header())
+		if !decision.Allowed {
+			policy.rejected.Add(1)
+			writeRateLimited(w, decision)
+			return
+		}
+
+		policy.allowed.Add(1)
+		writeRateLimitHeaders(w.Header(), decision)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Handler wraps a handler with Middleware
+func (rl *RateLimits) Handler(next http.HandlerFunc) http.HandlerFunc {
+	return rl.Middleware(next).ServeHTTP
+}
+
+// Stats returns the decisions of every policy
+func (rl *RateLimits) Stats() []RateLimitStats {
+	stats := make([]RateLimitStats, 0, len(rl.policies))
+	for _, policy := range rl.policies {
+		stats = append(stats, RateLimitStats{
+			Policy:   policy.Name,
+			Allowed:  policy.allowed.Load(),
+			Rejected: policy.rejected.Load(),
+			Errors:   policy.errors.Load(),
+		})
+	}
+	return stats
+}
+
+// Close releases the limiters
+func (rl *RateLimits) Close() {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	for prefix, limiter := range rl.limiters {
+		if closer, ok := limiter.(interface{ Close() }); ok {
+			closer.Close()
+		}
+		delete(rl.limiters, prefix)
+	}
+}
+
+// writeRateLimitHeaders sets the RateLimit headers of a decision, plus the
+// X-RateLimit ones older clients read
+func writeRateLimitHeaders(h http.Header, d Decision) {
+	limit := strconv.Itoa(d.Limit)
+	remaining := strconv.Itoa(d.Remaining)
+	h.Set("RateLimit-Limit", limit)
+	h.Set("RateLimit-Remaining", remaining)
+	h.Set("RateLimit-Reset", strconv.FormatInt(ceilSeconds(d.ResetAfter), 10))
+	h.Set("X-RateLimit-Limit", limit)
+	h.Set("X-RateLimit-Remaining", remaining)
+}
+
+// writeRateLimited answers a denied request
+func writeRateLimited(w http.ResponseWriter, d Decision) {
+	writeRateLimitHeaders(w.Header(), d)
+
+	retryAfter := ceilSeconds(d.RetryAfter)
+	if retryAfter < 1 {
+		retryAfter = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write([]byte(`{"error": "rate limit exceeded"}`))
+}
+
+// ceilSeconds rounds d up to whole seconds
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakePlans returns fixed plans per user
+type fakePlans struct {
+	plans map[string]string
+	err   error
+	calls int
+}
+
+func (p *fakePlans) Plan(ctx context.Context, userID, module string) (string, error) {
+	p.calls++
+	return p.plans[module+":"+userID], p.err
+}
+
+func newTestRateLimits(t *testing.T, policies []RateLimitPolicy, plans PlanResolver) (*RateLimits, http.Handler) {
+	t.Helper()
+
+	rl, err := NewRateLimits(policies, plans, MemoryLimiters)
+	if err != nil {
+		t.Fatalf("NewRateLimits: %v", err)
+	}
+	t.Cleanup(rl.Close)
+
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	return rl, handler
+}
+
+// request sends a request from addr, as user when set
+func request(handler http.Handler, path, addr, user string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = addr + ":4000"
+	if user != "" {
+		req = req.WithContext(WithIdentity(req.Context(), Identity{UserID: user}))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// allowedRequests counts the requests out of n that are let through
+func allowedRequests(handler http.Handler, n int, path, addr, user string) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if request(handler, path, addr, user).Code == http.StatusOK {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRateLimitsMatchRoutes(t *testing.T) {
+	_, handler := newTestRateLimits(t, []RateLimitPolicy{
+		{Name: "api", Routes: []string{"/api/"}, PolicyLimit: PolicyLimit{Limit: 5, Window: "1h"}},
+		{Name: "reports", Routes: []string{"/api/reports"}, PolicyLimit: PolicyLimit{Limit: 1, Window: "1h"}},
+	}, nil)
+
+	// A fresh address per path keeps the counts apart
+	tests := []struct {
+		path    string
+		addr    string
+		allowed int
+	}{
+		{"/api/reports/daily", "192.0.2.1", 1},
+		{"/api/endpoints", "192.0.2.2", 5},
+		{"/apis", "192.0.2.3", 10},
+		{"/health", "192.0.2.4", 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := allowedRequests(handler, 10, tt.path, tt.addr, ""); got != tt.allowed {
+				t.Errorf("expected %d requests allowed, got %d", tt.allowed, got)
+			}
+		})
+	}
+}
+
+func TestRateLimitsFallbackPolicy(t *testing.T) {
+	_, handler := newTestRateLimits(t, []RateLimitPolicy{
+		{Name: "ws", Routes: []string{"/ws"}, PolicyLimit: PolicyLimit{Limit: 3, Window: "1h"}},
+		{Name: "everything", PolicyLimit: PolicyLimit{Limit: 2, Window: "1h"}},
+	}, nil)
+
+	if got := allowedRequests(handler, 5, "/ws", "192.0.2.1", ""); got != 3 {
+		t.Errorf("expected the route's policy, allowed %d", got)
+	}
+	if got := allowedRequests(handler, 5, "/anything", "192.0.2.1", ""); got != 2 {
+		t.Errorf("expected the catch-all policy, allowed %d", got)
+	}
+}
+
+func TestRateLimitsKeys(t *testing.T) {
+	_, handler := newTestRateLimits(t, []RateLimitPolicy{
+		{Name: "default", PolicyLimit: PolicyLimit{Limit: 2, Window: "1h"}},
+	}, nil)
+
+	// Anonymous requests are counted per address
+	allowedRequests(handler, 2, "/", "192.0.2.1", "")
+	if got := allowedRequests(handler, 1, "/", "192.0.2.1", ""); got != 0 {
+		t.Error("expected the address to be limited")
+	}
+	if got := allowedRequests(handler, 1, "/", "192.0.2.2", ""); got != 1 {
+		t.Error("expected another address to have its own limit")
+	}
+
+	// Users are counted across addresses
+	allowedRequests(handler, 1, "/", "192.0.2.3", "alice")
+	allowedRequests(handler, 1, "/", "192.0.2.4", "alice")
+	if got := allowedRequests(handler, 1, "/", "192.0.2.5", "alice"); got != 0 {
+		t.Error("expected the user to be limited from any address")
+	}
+	if got := allowedRequests(handler, 1, "/", "192.0.2.3", "bob"); got != 1 {
+		t.Error("expected another user at the same address to have their own limit")
+	}
+}
+
+func TestRateLimitsPlanAndUserLimits(t *testing.T) {
+	plans := &fakePlans{plans: map[string]string{
+		":pro-user":            "pro",
+		":free-user":           "free",
+		"ecommerce:pro-user":   "free",
+		"ecommerce:shop-owner": "enterprise",
+	}}
+
+	limits := map[string]PolicyLimit{
+		"pro":        {Limit: 4, Window: "1h"},
+		"enterprise": {Limit: 6, Window: "1h"},
+	}
+	_, handler := newTestRateLimits(t, []RateLimitPolicy{
+		{
+			Name:        "api",
+			Routes:      []string{"/api"},
+			PolicyLimit: PolicyLimit{Limit: 2, Window: "1h"},
+			Plans:       limits,
+			Users:       map[string]PolicyLimit{"vip": {Limit: 8, Window: "1h"}},
+		},
+		{
+			Name:        "shop",
+			Routes:      []string{"/shop"},
+			Module:      "ecommerce",
+			PolicyLimit: PolicyLimit{Limit: 2, Window: "1h"},
+			Plans:       limits,
+		},
+	}, plans)
+
+	tests := []struct {
+		path    string
+		user    string
+		allowed int
+	}{
+		{"/api", "", 2},
+		{"/api", "free-user", 2},
+		{"/api", "pro-user", 4},
+		{"/api", "vip", 8},
+		{"/shop", "pro-user", 2},
+		{"/shop", "shop-owner", 6},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.user, func(t *testing.T) {
+			if got := allowedRequests(handler, 10, tt.path, "192.0.2.1", tt.user); got != tt.allowed {
+				t.Errorf("expected %d requests allowed, got %d", tt.allowed, got)
+			}
+		})
+	}
+
+	// Plan lookups that fail apply the policy's own limit
+	plans.err = errors.New("database down")
+	if got := allowedRequests(handler, 10, "/api", "192.0.2.1", "unknown"); got != 2 {
+		t.Errorf("expected the default limit when the plan is unknown, allowed %d", got)
+	}
+}
+
+func TestRateLimitsHeaders(t *testing.T) {
+	_, handler := newTestRateLimits(t, []RateLimitPolicy{
+		{Name: "api", PolicyLimit: PolicyLimit{Limit: 2, Window: "10s"}},
+	}, nil)
+
+	rec := request(handler, "/", "192.0.2.1", "")
+	h := rec.Header()
+	if h.Get("RateLimit-Limit") != "2" || h.Get("RateLimit-Remaining") != "1" || h.Get("RateLimit-Reset") != "5" {
+		t.Errorf("unexpected headers on an allowed request: %v", h)
+	}
+	if h.Get("RateLimit-Policy") != "2;w=10" {
+		t.Errorf("unexpected policy header %q", h.Get("RateLimit-Policy"))
+	}
+	if h.Get("X-RateLimit-Limit") != "2" || h.Get("Retry-After") != "" {
+		t.Errorf("unexpected headers on an allowed request: %v", h)
+	}
+
+	request(handler, "/", "192.0.2.1", "")
+	rec = request(handler, "/", "192.0.2.1", "")
+	h = rec.Header()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rec.Code)
+	}
+	if h.Get("RateLimit-Remaining") != "0" || h.Get("RateLimit-Reset") != "10" || h.Get("Retry-After") != "5" {
+		t.Errorf("unexpected headers on a rejected request: %v", h)
+	}
+}
+
+func TestRateLimitsStats(t *testing.T) {
+	rl, handler := newTestRateLimits(t, []RateLimitPolicy{
+		{Name: "ws", Routes: []string{"/ws"}, PolicyLimit: PolicyLimit{Limit: 3, Window: "1h"}},
+		{Name: "monitor", Routes: []string{"/monitor"}, PolicyLimit: PolicyLimit{Limit: 1, Window: "1h"}},
+	}, nil)
+
+	allowedRequests(handler, 5, "/ws", "192.0.2.1", "")
+	allowedRequests(handler, 2, "/monitor", "192.0.2.1", "")
+
+	want := []RateLimitStats{
+		{Policy: "ws", Allowed: 3, Rejected: 2},
+		{Policy: "monitor", Allowed: 1, Rejected: 1},
+	}
+	stats := rl.Stats()
+	if len(stats) != len(want) {
+		t.Fatalf("expected %d policies, got %+v", len(want), stats)
+	}
+	for i := range want {
+		if stats[i] != want[i] {
+			t.Errorf("expected %+v, got %+v", want[i], stats[i])
+		}
+	}
+}
+
+func TestRateLimitsWithRedis(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	policies := []RateLimitPolicy{
+		{Name: "api", Algorithm: AlgorithmSlidingWindow, PolicyLimit: PolicyLimit{Limit: 3, Window: "1m"}},
+	}
+
+	// Two replicas share the limit
+	var handlers []http.Handler
+	for i := 0; i < 2; i++ {
+		rl, err := NewRateLimits(policies, nil, RedisLimiters(rdb, DefaultRedisLimiterConfig()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(rl.Close)
+		handlers = append(handlers, rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	}
+
+	allowed := 0
+	for i := 0; i < 6; i++ {
+		allowed += allowedRequests(handlers[i%2], 1, "/", "192.0.2.1", "")
+	}
+	if allowed != 3 {
+		t.Errorf("expected the replicas to share a limit of 3, allowed %d", allowed)
+	}
+	if n, _ := rdb.ZCard(context.Background(), "ratelimit:api:default:ip:192.0.2.1").Result(); n != 3 {
+		t.Errorf("expected the policy's log in Redis, got %d entries", n)
+	}
+}
+
+func TestNewRateLimitsValidates(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []RateLimitPolicy
+	}{
+		{"missing name", []RateLimitPolicy{{PolicyLimit: PolicyLimit{Limit: 1}}}},
+		{"zero limit", []RateLimitPolicy{{Name: "a"}}},
+		{"bad window", []RateLimitPolicy{{Name: "a", PolicyLimit: PolicyLimit{Limit: 1, Window: "soon"}}}},
+		{"bad plan limit", []RateLimitPolicy{{Name: "a", PolicyLimit: PolicyLimit{Limit: 1}, Plans: map[string]PolicyLimit{"pro": {}}}}},
+		{"unknown key", []RateLimitPolicy{{Name: "a", Keys: []KeyKind{"session"}, PolicyLimit: PolicyLimit{Limit: 1}}}},
+		{"unknown algorithm", []RateLimitPolicy{{Name: "a", Algorithm: "leaky", PolicyLimit: PolicyLimit{Limit: 1}}}},
+		{"duplicate name", []RateLimitPolicy{
+			{Name: "a", Routes: []string{"/a"}, PolicyLimit: PolicyLimit{Limit: 1}},
+			{Name: "a", Routes: []string{"/b"}, PolicyLimit: PolicyLimit{Limit: 1}},
+		}},
+		{"two catch-alls", []RateLimitPolicy{
+			{Name: "a", PolicyLimit: PolicyLimit{Limit: 1}},
+			{Name: "b", PolicyLimit: PolicyLimit{Limit: 1}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewRateLimits(tt.policies, nil, MemoryLimiters); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if _, err := NewRateLimits(DefaultRateLimitPolicies(), nil, MemoryLimiters); err != nil {
+		t.Errorf("expected the default policies to be valid: %v", err)
+	}
+}
+
+func TestLoadRateLimitPolicies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.json")
+	data := `[{"name": "api", "routes": ["/api"], "limit": 100, "window": "1m", "burst": 20,
+		"plans": {"pro": {"limit": 1000, "window": "1m"}}}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := LoadRateLimitPolicies(path)
+	if err != nil {
+		t.Fatalf("LoadRateLimitPolicies: %v", err)
+	}
+	if len(policies) != 1 {
+		t.Fatalf("expected 1 policy, got %d", len(policies))
+	}
+	p := policies[0]
+	if p.Name != "api" || p.Limit != 100 || p.Window != "1m" || p.Burst != 20 || p.Plans["pro"].Limit != 1000 {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+}
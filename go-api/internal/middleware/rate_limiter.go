@@ -1,7 +1,7 @@
 package middleware
 
 import (
-	"net"
+	"context"
 	"net/http"
 	"sync"
 	"time"
@@ -28,6 +28,12 @@ func NewTokenBucket(capacity, refillRate float64) *TokenBucket {
 
 // Allow checks if a token can be consumed
 func (tb *TokenBucket) Allow() bool {
+	allowed, _ := tb.take()
+	return allowed
+}
+
+// take consumes a token if one is left and returns the tokens remaining
+func (tb *TokenBucket) take() (bool, float64) {
 	tb.mu.Lock()
 	defer tb.mu.Unlock()
 
@@ -46,13 +52,14 @@ func (tb *TokenBucket) Allow() bool {
 	// Check if we have at least 1 token
 	if tb.tokens >= 1 {
 		tb.tokens--
-		return true
+		return true, tb.tokens
 	}
 
-	return false
+	return false, tb.tokens
 }
 
-// RateLimiter limits requests per IP address
+// RateLimiter limits requests per key in process memory. Each replica
+// keeps its own buckets, so see RedisLimiter for limits shared between them.
 type RateLimiter struct {
 	buckets map[string]*TokenBucket
 	capacity float64
@@ -80,17 +87,18 @@ func NewRateLimiter(capacity, refillRate float64, cleanupInterval time.Duration)
 	return rl
 }
 
-// Allow checks if request from IP is allowed
-func (rl *RateLimiter) Allow(ip string) bool {
+// Allow checks if a request for key is allowed
+func (rl *RateLimiter) Allow(ctx context.Context, key string) (Decision, error) {
 	rl.mu.Lock()
-	bucket, exists := rl.buckets[ip]
+	bucket, exists := rl.buckets[key]
 	if !exists {
 		bucket = NewTokenBucket(rl.capacity, rl.refillRate)
-		rl.buckets[ip] = bucket
+		rl.buckets[key] = bucket
 	}
 	rl.mu.Unlock()
 
-	return bucket.Allow()
+	allowed, tokens := bucket.take()
+	return bucketDecision(rl.capacity, rl.refillRate, allowed, tokens), nil
 }
 
 // cleanup periodically removes old buckets
@@ -121,72 +129,28 @@ func (rl *RateLimiter) Close() {
 	close(rl.quit)
 }
 
-// RateLimitMiddleware creates HTTP middleware for rate limiting
-func RateLimitMiddleware(limiter *RateLimiter, rateLimit, refillRate float64) func(http.Handler) http.Handler {
+// RateLimitMiddleware limits every request by client IP with one limiter.
+// Use RateLimits to apply policies per route, user and plan.
+func RateLimitMiddleware(limiter Limiter) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// Extract client IP
 			ip := getClientIP(r)
 
-			// Check rate limit
-			if !limiter.Allow(ip) {
-				w.Header().Set("Content-Type", "application/json")
-				w.Header().Set("X-RateLimit-Limit", "10")
-				w.Header().Set("X-RateLimit-Remaining", "0")
-				w.WriteHeader(http.StatusTooManyRequests)
-				w.Write([]byte(`{"error": "rate limit exceeded"}`))
+			// Check rate limit; an error means no limiter could decide, and
+			// the request is let through rather than failing the API
+			decision, err := limiter.Allow(r.Context(), ip)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !decision.Allowed {
+				writeRateLimited(w, decision)
 				return
 			}
 
+			writeRateLimitHeaders(w.Header(), decision)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
-
-// getClientIP extracts the client IP from request
-func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For header (for proxied requests)
-	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
-		// X-Forwarded-For can contain multiple IPs, get the first one
-		ips := parseIPList(forwarded)
-		if len(ips) > 0 {
-			return ips[0]
-		}
-	}
-
-	// Check X-Real-IP header
-	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
-		return realIP
-	}
-
-	// Fall back to remote address
-	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
-	}
-
-	return ip
-}
-
-// parseIPList parses comma-separated IP list
-func parseIPList(ips string) []string {
-	var result []string
-	for i := 0; i < len(ips); i++ {
-		// Skip spaces
-		for i < len(ips) && ips[i] == ' ' {
-			i++
-		}
-
-		// Extract IP
-		start := i
-		for i < len(ips) && ips[i] != ',' {
-			i++
-		}
-
-		if start < i {
-			result = append(result, ips[start:i])
-		}
-	}
-
-	return result
-}
@@ -0,0 +1,283 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/resilience"
+	"github.com/redis/go-redis/v9"
+)
+
+// The scripts read the clock with TIME so every replica agrees on it.
+// Redis 5 and later replicate script effects, which allows writing after
+// a non-deterministic command.
+
+// tokenBucketScript refills the bucket in KEYS[1] for the time elapsed and
+// takes a token if one is left. ARGV holds the capacity and the refill rate
+// per second. It returns whether the request is allowed and the tokens left.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+if now > ts then
+  tokens = math.min(capacity, tokens + (now - ts) / 1000 * rate)
+  ts = now
+end
+
+local allowed = 0
+if tokens >= 1 then
+  tokens = tokens - 1
+  allowed = 1
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil((capacity - tokens) / rate * 1000) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// slidingWindowScript drops the entries of the log in KEYS[1] older than
+// the window and logs the request if fewer than the limit are left. ARGV
+// holds the limit, the window in milliseconds and a unique member for the
+// request. It returns whether the request is allowed, the requests in the
+// window, and the milliseconds until the oldest and the newest leave it.
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+
+local allowed = 0
+if count < limit then
+  redis.call('ZADD', KEYS[1], now, ARGV[3])
+  count = count + 1
+  allowed = 1
+end
+
+local retry = 0
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+  retry = tonumber(oldest[2]) + window - now
+end
+local reset = 0
+local newest = redis.call('ZRANGE', KEYS[1], -1, -1, 'WITHSCORES')
+if newest[2] then
+  reset = tonumber(newest[2]) + window - now
+end
+
+redis.call('PEXPIRE', KEYS[1], window)
+return {allowed, count, retry, reset}
+`)
+
+// RedisLimiterConfig holds Redis rate limiter configuration
+type RedisLimiterConfig struct {
+	Algorithm Algorithm
+
+	// Prefix namespaces the limiter's keys
+	Prefix string
+
+	// Capacity is the token bucket size, or the requests allowed per Window
+	// for the sliding window; RefillRate is the tokens added per second
+	Capacity   float64
+	RefillRate float64
+	Window     time.Duration
+
+	// Timeout bounds each Redis call
+	Timeout time.Duration
+
+	// After a few failed calls the fallback limiter decides alone, and
+	// Redis is tried again every RetryInterval
+	RetryInterval time.Duration
+}
+
+// DefaultRedisLimiterConfig returns default Redis rate limiter configuration
+func DefaultRedisLimiterConfig() RedisLimiterConfig {
+	return RedisLimiterConfig{
+		Algorithm:     AlgorithmTokenBucket,
+		Prefix:        "ratelimit",
+		Capacity:      10,
+		RefillRate:    10,
+		Window:        time.Second,
+		Timeout:       100 * time.Millisecond,
+		RetryInterval: 5 * time.Second,
+	}
+}
+
+// LocalRate returns the token bucket capacity and refill rate a local
+// limiter needs to approximate this configuration
+func (c RedisLimiterConfig) LocalRate() (capacity, refillRate float64) {
+	if c.Algorithm == AlgorithmSlidingWindow {
+		return c.Capacity, c.Capacity / c.Window.Seconds()
+	}
+	return c.Capacity, c.RefillRate
+}
+
+// RedisLimiter keeps its counters in Redis, so every replica enforces the
+// same limit and limits survive restarts. Each check is a single Lua
+// script, which keeps it atomic between replicas.
+//
+// When Redis is unreachable the fallback limiter decides instead, so the
+// API keeps limiting per replica rather than failing or letting everything
+// through.
+type RedisLimiter struct {
+	client   *redis.Client
+	config   RedisLimiterConfig
+	fallback Limiter
+	breaker  *resilience.CircuitBreaker
+	log      *logger.Logger
+}
+
+// NewRedisLimiter creates a Redis rate limiter. fallback may be nil, in
+// which case Allow returns the Redis error.
+func NewRedisLimiter(client *redis.Client, config RedisLimiterConfig, fallback Limiter) *RedisLimiter {
+	defaults := DefaultRedisLimiterConfig()
+	if config.Algorithm == "" {
+		config.Algorithm = defaults.Algorithm
+	}
+	if config.Prefix == "" {
+		config.Prefix = defaults.Prefix
+	}
+	if config.Capacity < 1 {
+		config.Capacity = defaults.Capacity
+	}
+	if config.RefillRate <= 0 {
+		config.RefillRate = defaults.RefillRate
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaults.Timeout
+	}
+	if config.RetryInterval <= 0 {
+		config.RetryInterval = defaults.RetryInterval
+	}
+
+	l := &RedisLimiter{
+		client:   client,
+		config:   config,
+		fallback: fallback,
+		log: logger.New().WithField("component", "rate-limiter").
+			WithField("prefix", config.Prefix),
+	}
+
+	// The breaker keeps a dead Redis from costing every request a timeout
+	l.breaker = resilience.NewCircuitBreaker(resilience.CircuitBreakerConfig{
+		Name:           "rate-limiter-redis",
+		MaxFailures:    3,
+		Timeout:        config.Timeout,
+		ResetInterval:  config.RetryInterval,
+		HalfOpenProbes: 1,
+		OnStateChange: func(name string, from, to resilience.CircuitBreakerState) {
+			switch to {
+			case resilience.StateOpen:
+				l.log.Warnf("redis unreachable, rate limiting locally for %s", config.RetryInterval)
+			case resilience.StateClosed:
+				l.log.Info("redis reachable again, rate limits are shared")
+			}
+		},
+	})
+
+	return l
+}
+
+// Allow checks if a request for key is allowed
+func (l *RedisLimiter) Allow(ctx context.Context, key string) (Decision, error) {
+	var decision Decision
+	err := l.breaker.ExecuteWithContext(ctx, func(ctx context.Context) error {
+		var err error
+		decision, err = l.allow(ctx, l.config.Prefix+":"+key)
+		return err
+	})
+	if err == nil {
+		return decision, nil
+	}
+
+	if l.fallback == nil {
+		return Decision{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	return l.fallback.Allow(ctx, key)
+}
+
+// allow runs the configured algorithm's script
+func (l *RedisLimiter) allow(ctx context.Context, key string) (Decision, error) {
+	if l.config.Algorithm == AlgorithmSlidingWindow {
+		return l.allowSlidingWindow(ctx, key)
+	}
+	return l.allowTokenBucket(ctx, key)
+}
+
+func (l *RedisLimiter) allowTokenBucket(ctx context.Context, key string) (Decision, error) {
+	result, err := tokenBucketScript.Run(ctx, l.client, []string{key},
+		l.config.Capacity, l.config.RefillRate).Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(result) != 2 {
+		return Decision{}, fmt.Errorf("unexpected token bucket result: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	raw, _ := result[1].(string)
+	tokens, err := strconv.ParseFloat(raw, 64)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to parse tokens %q: %w", raw, err)
+	}
+
+	return bucketDecision(l.config.Capacity, l.config.RefillRate, allowed == 1, tokens), nil
+}
+
+func (l *RedisLimiter) allowSlidingWindow(ctx context.Context, key string) (Decision, error) {
+	// Requests logged in the same millisecond need distinct members
+	member := strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	result, err := slidingWindowScript.Run(ctx, l.client, []string{key},
+		int(l.config.Capacity), l.config.Window.Milliseconds(), member).Slice()
+	if err != nil {
+		return Decision{}, err
+	}
+	if len(result) != 4 {
+		return Decision{}, fmt.Errorf("unexpected sliding window result: %v", result)
+	}
+
+	values := make([]int64, len(result))
+	for i, v := range result {
+		values[i], _ = v.(int64)
+	}
+
+	limit := int(l.config.Capacity)
+	d := Decision{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  limit - int(values[1]),
+		ResetAfter: time.Duration(values[3]) * time.Millisecond,
+	}
+	if !d.Allowed {
+		d.RetryAfter = time.Duration(values[2]) * time.Millisecond
+	}
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	return d, nil
+}
+
+// CircuitBreakerStats returns the stats of the breaker guarding Redis; an
+// open breaker means the fallback limiter is deciding
+func (l *RedisLimiter) CircuitBreakerStats() resilience.CircuitBreakerStats {
+	return l.breaker.Stats()
+}
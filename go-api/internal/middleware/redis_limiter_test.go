@@ -0,0 +1,220 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/resilience"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testNow = time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	mr.SetTime(testNow)
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return mr, rdb
+}
+
+// allowN makes n requests for key and returns how many were allowed and
+// the last decision
+func allowN(t *testing.T, l Limiter, key string, n int) (int, Decision) {
+	t.Helper()
+
+	allowed := 0
+	var last Decision
+	for i := 0; i < n; i++ {
+		d, err := l.Allow(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Allow: %v", err)
+		}
+		if d.Allowed {
+			allowed++
+		}
+		last = d
+	}
+	return allowed, last
+}
+
+func TestRedisLimiterTokenBucket(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	l := NewRedisLimiter(rdb, RedisLimiterConfig{Capacity: 5, RefillRate: 1}, nil)
+
+	allowed, last := allowN(t, l, "10.0.0.1", 7)
+	if allowed != 5 {
+		t.Fatalf("expected a burst of 5, allowed %d", allowed)
+	}
+	if last.Allowed || last.Remaining != 0 || last.Limit != 5 {
+		t.Errorf("unexpected decision after the burst: %+v", last)
+	}
+	if last.RetryAfter != time.Second {
+		t.Errorf("expected retry after 1s, got %s", last.RetryAfter)
+	}
+	if last.ResetAfter != 5*time.Second {
+		t.Errorf("expected reset after 5s, got %s", last.ResetAfter)
+	}
+
+	// Other keys have their own bucket
+	if allowed, _ := allowN(t, l, "10.0.0.2", 1); allowed != 1 {
+		t.Error("expected another key to be allowed")
+	}
+
+	mr.SetTime(testNow.Add(2500 * time.Millisecond))
+	allowed, last = allowN(t, l, "10.0.0.1", 3)
+	if allowed != 2 {
+		t.Errorf("expected 2 tokens refilled after 2.5s, allowed %d", allowed)
+	}
+	if last.RetryAfter != 500*time.Millisecond {
+		t.Errorf("expected retry after 500ms, got %s", last.RetryAfter)
+	}
+
+	ttl := mr.TTL("ratelimit:10.0.0.1")
+	if ttl <= 0 || ttl > 6*time.Second {
+		t.Errorf("expected the bucket to expire once refilled, ttl %s", ttl)
+	}
+}
+
+func TestRedisLimiterSlidingWindow(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	l := NewRedisLimiter(rdb, RedisLimiterConfig{
+		Algorithm: AlgorithmSlidingWindow,
+		Capacity:  3,
+		Window:    10 * time.Second,
+	}, nil)
+
+	allowN(t, l, "key", 2)
+	mr.SetTime(testNow.Add(4 * time.Second))
+	allowed, last := allowN(t, l, "key", 2)
+	if allowed != 1 {
+		t.Fatalf("expected 1 more request within the window, allowed %d", allowed)
+	}
+	if last.Allowed || last.Remaining != 0 || last.Limit != 3 {
+		t.Errorf("unexpected decision at the limit: %+v", last)
+	}
+	if last.RetryAfter != 6*time.Second {
+		t.Errorf("expected retry once the oldest requests leave the window, got %s", last.RetryAfter)
+	}
+	if last.ResetAfter != 10*time.Second {
+		t.Errorf("expected reset once the newest request leaves the window, got %s", last.ResetAfter)
+	}
+
+	// Unlike a bucket, the window frees exactly the slots that expired
+	mr.SetTime(testNow.Add(10 * time.Second))
+	allowed, last = allowN(t, l, "key", 3)
+	if allowed != 2 {
+		t.Errorf("expected the 2 expired slots back, allowed %d", allowed)
+	}
+	if last.RetryAfter != 4*time.Second {
+		t.Errorf("expected retry after 4s, got %s", last.RetryAfter)
+	}
+	if n, _ := rdb.ZCard(context.Background(), "ratelimit:key").Result(); n != 3 {
+		t.Errorf("expected denied requests not to be logged, got %d entries", n)
+	}
+}
+
+func TestRedisLimiterSharedBetweenReplicas(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RedisLimiterConfig
+	}{
+		{"token bucket", RedisLimiterConfig{Capacity: 4, RefillRate: 0.1}},
+		{"sliding window", RedisLimiterConfig{Algorithm: AlgorithmSlidingWindow, Capacity: 4, Window: time.Minute}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, rdb := newTestRedis(t)
+			replicas := []*RedisLimiter{
+				NewRedisLimiter(rdb, tt.config, nil),
+				NewRedisLimiter(rdb, tt.config, nil),
+			}
+
+			allowed := 0
+			for i := 0; i < 6; i++ {
+				n, _ := allowN(t, replicas[i%2], "client", 1)
+				allowed += n
+			}
+			if allowed != 4 {
+				t.Errorf("expected the replicas to share a limit of 4, allowed %d", allowed)
+			}
+		})
+	}
+}
+
+func TestRedisLimiterFallsBackWhenRedisIsDown(t *testing.T) {
+	mr, rdb := newTestRedis(t)
+	local := NewRateLimiter(2, 0.001, time.Minute)
+	defer local.Close()
+
+	l := NewRedisLimiter(rdb, RedisLimiterConfig{Capacity: 5, RefillRate: 1, Timeout: time.Second}, local)
+	if allowed, _ := allowN(t, l, "client", 1); allowed != 1 {
+		t.Fatal("expected Redis to allow the first request")
+	}
+
+	mr.Close()
+
+	// The local bucket starts full and limits on its own
+	allowed, last := allowN(t, l, "client", 5)
+	if allowed != 2 {
+		t.Errorf("expected the local limit of 2, allowed %d", allowed)
+	}
+	if last.Limit != 2 {
+		t.Errorf("expected the local decision, got %+v", last)
+	}
+	if state := l.CircuitBreakerStats().State; state != resilience.StateOpen {
+		t.Errorf("expected Redis to be skipped after repeated failures, breaker %s", state)
+	}
+
+	// Without a fallback the error is returned
+	l = NewRedisLimiter(rdb, RedisLimiterConfig{Timeout: time.Second}, nil)
+	if _, err := l.Allow(context.Background(), "client"); err == nil {
+		t.Error("expected an error without a fallback")
+	}
+}
+
+func TestRateLimiterDecision(t *testing.T) {
+	l := NewRateLimiter(3, 1, time.Minute)
+	defer l.Close()
+
+	allowed, last := allowN(t, l, "client", 4)
+	if allowed != 3 {
+		t.Errorf("expected a burst of 3, allowed %d", allowed)
+	}
+	if last.Allowed || last.Limit != 3 || last.Remaining != 0 {
+		t.Errorf("unexpected decision: %+v", last)
+	}
+	if last.RetryAfter <= 0 || last.RetryAfter > time.Second {
+		t.Errorf("expected retry within a second, got %s", last.RetryAfter)
+	}
+}
+
+func TestRateLimitMiddlewareWithRedis(t *testing.T) {
+	_, rdb := newTestRedis(t)
+	l := NewRedisLimiter(rdb, RedisLimiterConfig{Capacity: 2, RefillRate: 0.1}, nil)
+
+	handler := RateLimitMiddleware(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var codes []int
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/monitor", nil)
+		req.RemoteAddr = "192.0.2.10:4000"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		codes = append(codes, rec.Code)
+	}
+
+	if codes[0] != http.StatusOK || codes[1] != http.StatusOK || codes[2] != http.StatusTooManyRequests {
+		t.Errorf("expected two requests then 429, got %v", codes)
+	}
+}
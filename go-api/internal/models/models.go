@@ -13,14 +13,88 @@ type Endpoint struct {
 	Timeout     int             `json:"timeout"`
 	Headers     json.RawMessage `json:"headers"`
 	IsActive    bool            `json:"is_active"`
+
+	// Protocol selects how the endpoint is checked; CheckOptions tune
+	// checks other than HTTP
+	Protocol     string          `json:"protocol,omitempty"`
+	CheckOptions json.RawMessage `json:"check_options,omitempty"`
 }
 
+// Endpoint protocols
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	ProtocolTCP   = "tcp"
+	ProtocolUDP   = "udp"
+	ProtocolSMTP  = "smtp"
+	ProtocolIMAP  = "imap"
+	ProtocolPOP3  = "pop3"
+)
+
 type MonitoringResult struct {
 	EndpointID   int    `json:"endpoint_id"`
 	ResponseTime int    `json:"response_time"`
 	StatusCode   *int   `json:"status_code"`
 	ErrorMessage *string `json:"error_message"`
 	CheckedAt    time.Time `json:"checked_at"`
+
+	// Method and URL describe the request that was checked
+	Method string `json:"method,omitempty"`
+	URL    string `json:"url,omitempty"`
+
+	// Region and AgentID name the check agent that measured the result;
+	// both are empty for checks run by the API itself
+	Region  string `json:"region,omitempty"`
+	AgentID string `json:"agent_id,omitempty"`
+
+	// Location is where the check ran from; an endpoint's status is
+	// decided by a quorum of locations
+	Location string `json:"location,omitempty"`
+}
+
+// Failed reports whether the check found the endpoint failing: no
+// response, an error, or a 4xx or 5xx status
+func (r MonitoringResult) Failed() bool {
+	return r.StatusCode == nil || *r.StatusCode >= 400 || r.ErrorMessage != nil
+}
+
+// Consensus statuses of an endpoint
+const (
+	StatusUnknown  = "unknown"
+	StatusUp       = "up"
+	StatusDegraded = "degraded"
+	StatusDown     = "down"
+)
+
+// LocationCheck is the latest check of an endpoint from one location
+type LocationCheck struct {
+	Location     string    `json:"location"`
+	Failed       bool      `json:"failed"`
+	ResponseTime int       `json:"response_time"`
+	CheckedAt    time.Time `json:"checked_at"`
+}
+
+// EndpointStatus is an endpoint's status as decided by its locations
+type EndpointStatus struct {
+	EndpointID       int       `json:"endpoint_id"`
+	Status           string    `json:"status"`
+	PreviousStatus   string    `json:"previous_status,omitempty"`
+	Failing          int       `json:"failing"`
+	Locations        int       `json:"locations"`
+	FailingLocations []string  `json:"failing_locations"`
+	ChangedAt        time.Time `json:"changed_at"`
+	EvaluatedAt      time.Time `json:"evaluated_at"`
+}
+
+// LocationLatency summarizes an endpoint's checks from one location
+type LocationLatency struct {
+	Location        string    `json:"location"`
+	Checks          int       `json:"checks"`
+	Failures        int       `json:"failures"`
+	AvgResponseTime float64   `json:"avg_response_time"`
+	P50ResponseTime float64   `json:"p50_response_time"`
+	P95ResponseTime float64   `json:"p95_response_time"`
+	LastCheckedAt   time.Time `json:"last_checked_at"`
 }
 
 type Alert struct {
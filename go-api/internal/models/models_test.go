@@ -242,3 +242,25 @@ func TestAlertThresholdVariations(t *testing.T) {
 		})
 	}
 }
+
+func TestMonitoringResultFailed(t *testing.T) {
+	ok, notFound, unavailable := 200, 404, 503
+	errorMsg := "request failed"
+
+	tests := []struct {
+		name   string
+		result MonitoringResult
+		want   bool
+	}{
+		{"success", MonitoringResult{StatusCode: &ok}, false},
+		{"client error", MonitoringResult{StatusCode: &notFound}, true},
+		{"server error", MonitoringResult{StatusCode: &unavailable}, true},
+		{"no response", MonitoringResult{}, true},
+		{"error", MonitoringResult{StatusCode: &ok, ErrorMessage: &errorMsg}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.result.Failed(); got != tt.want {
+			t.Errorf("%s: expected failed %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package monitoring
+
+import (
+	"context"
+	"encoding/json"
+
+	"api-monitor-go/internal/models"
+)
+
+// BroadcastChannel is the Redis channel stored results and endpoint status
+// changes are published on. Every replica subscribes and sends them to its
+// own WebSocket clients, whichever replica stored them.
+const BroadcastChannel = "monitoring:broadcast"
+
+// broadcast is a result or an endpoint status change on BroadcastChannel
+type broadcast struct {
+	Result *models.MonitoringResult `json:"result,omitempty"`
+	Status *models.EndpointStatus   `json:"status,omitempty"`
+}
+
+// publishBroadcasts sends stored results and status changes to the
+// WebSocket clients of every replica. Live updates aren't redelivered, so
+// when Redis is unavailable they only reach this replica's clients.
+func (s *Service) publishBroadcasts(ctx context.Context, results []models.MonitoringResult, changes []models.EndpointStatus) {
+	messages := make([]broadcast, 0, len(results)+len(changes))
+	for i := range results {
+		messages = append(messages, broadcast{Result: &results[i]})
+	}
+	for i := range changes {
+		messages = append(messages, broadcast{Status: &changes[i]})
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	pipe := s.rdb.Pipeline()
+	for _, msg := range messages {
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			s.log.Errorf("failed to encode broadcast: %v", err)
+			continue
+		}
+		pipe.Publish(ctx, BroadcastChannel, payload)
+	}
+	_, err := pipe.Exec(ctx)
+	if err == nil {
+		return
+	}
+	s.log.Warnf("failed to publish %d broadcasts, sending them to local clients only: %v", len(messages), err)
+
+	for _, msg := range messages {
+		s.broadcastLocally(ctx, msg)
+	}
+}
+
+// startBroadcasts subscribes to BroadcastChannel and sends what arrives to
+// this replica's WebSocket clients until stopBroadcasts is called
+func (s *Service) startBroadcasts() {
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := s.rdb.Subscribe(ctx, BroadcastChannel)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for msg := range sub.Channel() {
+			var b broadcast
+			if err := json.Unmarshal([]byte(msg.Payload), &b); err != nil {
+				s.log.Warnf("dropped undecodable broadcast: %v", err)
+				continue
+			}
+			s.broadcastLocally(ctx, b)
+		}
+	}()
+
+	s.stopBroadcasts = func(ctx context.Context) error {
+		cancel()
+		err := sub.Close()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return err
+	}
+}
+
+// broadcastLocally sends a broadcast to this replica's WebSocket clients
+func (s *Service) broadcastLocally(ctx context.Context, b broadcast) {
+	var err error
+	switch {
+	case b.Result != nil:
+		err = s.hub.BroadcastContext(ctx, *b.Result)
+	case b.Status != nil:
+		err = s.hub.BroadcastStatus(ctx, *b.Status)
+	}
+	if err != nil {
+		s.log.Warnf("failed to broadcast to WebSocket clients: %v", err)
+	}
+}
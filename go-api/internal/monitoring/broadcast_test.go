@@ -0,0 +1,144 @@
+package monitoring
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/websocket"
+	"github.com/alicebob/miniredis/v2"
+	gorilla "github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+// TestBroadcastsReachEveryReplica tests that results and status changes
+// stored by one replica reach the WebSocket clients of all of them
+func TestBroadcastsReachEveryReplica(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	var replicas []*Service
+	var clients []*gorilla.Conn
+	for i := 0; i < 2; i++ {
+		rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+		t.Cleanup(func() { rdb.Close() })
+
+		s := newTestService()
+		s.rdb = rdb
+		s.hub = websocket.NewHub()
+		go s.hub.Run()
+		t.Cleanup(s.hub.Stop)
+		s.startBroadcasts()
+		t.Cleanup(func() { s.stopBroadcasts(context.Background()) })
+
+		replicas = append(replicas, s)
+		clients = append(clients, dialHub(t, s.hub))
+	}
+	waitForSubscribers(t, mr, len(replicas))
+
+	code := 200
+	results := []models.MonitoringResult{{EndpointID: 7, StatusCode: &code, Location: "eu"}}
+	changes := []models.EndpointStatus{{EndpointID: 7, Status: models.StatusUp, PreviousStatus: models.StatusDown}}
+	replicas[0].publishBroadcasts(context.Background(), results, changes)
+
+	for _, client := range clients {
+		assertBroadcasts(t, client)
+	}
+}
+
+// TestBroadcastsFallBackToLocalClients tests that a replica that can't
+// publish still sends what it stored to its own clients
+func TestBroadcastsFallBackToLocalClients(t *testing.T) {
+	s := newTestService()
+	s.rdb = unavailableRedis(t)
+	s.hub = websocket.NewHub()
+	go s.hub.Run()
+	defer s.hub.Stop()
+
+	client := dialHub(t, s.hub)
+
+	code := 200
+	results := []models.MonitoringResult{{EndpointID: 7, StatusCode: &code, Location: "eu"}}
+	changes := []models.EndpointStatus{{EndpointID: 7, Status: models.StatusUp, PreviousStatus: models.StatusDown}}
+	s.publishBroadcasts(context.Background(), results, changes)
+
+	assertBroadcasts(t, client)
+}
+
+// assertBroadcasts reads the result and the status change of endpoint 7
+// from a client. The hub queues them separately, so either may come first.
+func assertBroadcasts(t *testing.T, client *gorilla.Conn) {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	var gotResult, gotStatus bool
+	for i := 0; i < 2; i++ {
+		var msg struct {
+			Type       string `json:"type"`
+			EndpointID int    `json:"endpoint_id"`
+			Location   string `json:"location"`
+			Status     string `json:"status"`
+		}
+		if err := client.ReadJSON(&msg); err != nil {
+			t.Fatalf("failed to read broadcast: %v", err)
+		}
+
+		switch {
+		case msg.Type == "endpoint_status" && msg.EndpointID == 7 && msg.Status == models.StatusUp:
+			gotStatus = true
+		case msg.Type == "" && msg.EndpointID == 7 && msg.Location == "eu":
+			gotResult = true
+		default:
+			t.Errorf("unexpected broadcast %+v", msg)
+		}
+	}
+	if !gotResult || !gotStatus {
+		t.Errorf("expected a result and a status change, got result %v, status change %v", gotResult, gotStatus)
+	}
+}
+
+// dialHub connects a client to hub and waits until it is registered
+func dialHub(t *testing.T, hub *websocket.Hub) *gorilla.Conn {
+	t.Helper()
+	before := hub.GetClientCount()
+
+	upgrader := gorilla.Upgrader{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		hub.Register(conn)
+	}))
+	t.Cleanup(ts.Close)
+
+	client, _, err := gorilla.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("failed to dial hub: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.GetClientCount() == before {
+		if time.Now().After(deadline) {
+			t.Fatal("client was not registered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return client
+}
+
+// waitForSubscribers waits until n clients subscribe to BroadcastChannel
+func waitForSubscribers(t *testing.T, mr *miniredis.Miniredis, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for mr.PubSubNumSub(BroadcastChannel)[BroadcastChannel] < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d subscribers", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
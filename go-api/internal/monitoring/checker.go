@@ -0,0 +1,153 @@
+package monitoring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/checks"
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/resilience"
+)
+
+// EndpointChecker checks endpoints over their protocol, retrying
+// transient failures behind a circuit breaker. The API and the check
+// agents share it, so a result means the same wherever it was measured.
+type EndpointChecker struct {
+	circuitBreaker *resilience.CircuitBreaker
+	retrier        *resilience.Retrier
+	checks         *resilience.Pipeline
+	checkers       map[string]checks.Checker
+	log            *logger.Logger
+}
+
+// NewEndpointChecker creates an endpoint checker
+func NewEndpointChecker(log *logger.Logger) *EndpointChecker {
+	circuitBreakerConfig := resilience.CircuitBreakerConfig{
+		Name:          "endpoint-checker",
+		MaxFailures:   5,
+		Timeout:       30 * time.Second,
+		ResetInterval: 60 * time.Second,
+		// Open when half of the last minute's checks failed
+		WindowType:           resilience.WindowTime,
+		WindowDuration:       time.Minute,
+		MinimumCalls:         20,
+		FailureRateThreshold: 0.5,
+		HalfOpenProbes:       3,
+		OnStateChange: func(name string, from, to resilience.CircuitBreakerState) {
+			log.WithField("breaker", name).Warnf("circuit breaker changed from %s to %s", from, to)
+		},
+	}
+
+	retryConfig := resilience.RetryConfig{
+		MaxAttempts:  3,
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     2 * time.Second,
+		Multiplier:   2.0,
+		Jitter:       true,
+		Classifier:   resilience.DefaultClassifier(),
+		// Checks share one budget, so an outage doesn't triple the load on
+		// every failing endpoint
+		Budget: resilience.NewRetryBudget(resilience.DefaultRetryBudgetConfig()),
+		OnAttempt: func(attempt resilience.Attempt) {
+			if attempt.Outcome == resilience.OutcomeRetry {
+				log.Debugf("endpoint check attempt %d failed, retrying in %v: %v", attempt.Number, attempt.Delay, attempt.Err)
+			}
+		},
+	}
+
+	c := &EndpointChecker{
+		circuitBreaker: resilience.NewCircuitBreaker(circuitBreakerConfig),
+		retrier:        resilience.NewRetrier(retryConfig),
+		checkers:       checks.Checkers(log),
+		log:            log,
+	}
+
+	// Checks retry within the breaker, so one failing check counts once
+	c.checks = resilience.Policy(
+		resilience.WithCircuitBreaker(c.circuitBreaker),
+		resilience.WithRetry(c.retrier),
+	)
+
+	return c
+}
+
+// CircuitBreakerStats returns a snapshot of the checker's circuit breaker
+func (c *EndpointChecker) CircuitBreakerStats() resilience.CircuitBreakerStats {
+	return c.circuitBreaker.Stats()
+}
+
+// Check checks an endpoint with the checker of its protocol. Failures
+// are reported in the result rather than returned.
+func (c *EndpointChecker) Check(ctx context.Context, endpoint models.Endpoint) models.MonitoringResult {
+	protocol := strings.ToLower(endpoint.Protocol)
+	if protocol == "" {
+		protocol = models.ProtocolHTTP
+	}
+
+	checker, ok := c.checkers[protocol]
+	if !ok {
+		errorMsg := fmt.Sprintf("unsupported protocol %q", endpoint.Protocol)
+		c.log.WithField("endpoint_id", endpoint.ID).Warn(errorMsg)
+		return models.MonitoringResult{
+			EndpointID:   endpoint.ID,
+			ErrorMessage: &errorMsg,
+			CheckedAt:    time.Now(),
+			URL:          endpoint.URL,
+		}
+	}
+
+	// Validate endpoint URL
+	if !isValidEndpointURL(endpoint.URL) {
+		errorMsg := "invalid endpoint URL format"
+		c.log.WithField("endpoint_id", endpoint.ID).Warnf("invalid URL: %s", endpoint.URL)
+		return models.MonitoringResult{
+			EndpointID:   endpoint.ID,
+			ResponseTime: 0,
+			StatusCode:   nil,
+			ErrorMessage: &errorMsg,
+			CheckedAt:    time.Now(),
+			URL:          endpoint.URL,
+		}
+	}
+
+	// Use circuit breaker and retry logic for endpoint check
+	var result models.MonitoringResult
+	err := c.checks.Execute(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = checker.Check(ctx, endpoint)
+		return err
+	})
+
+	var httpErr *resilience.HTTPError
+	if errors.As(err, &httpErr) {
+		// The endpoint answered; its status code is the result
+		c.log.WithField("endpoint_id", endpoint.ID).Warnf("endpoint still returned %d after retries", httpErr.StatusCode)
+	} else if err != nil {
+		// Log circuit breaker or retry errors
+		c.log.WithField("endpoint_id", endpoint.ID).Warnf("endpoint check failed after retries: %v", err)
+		errorMsg := fmt.Sprintf("request failed: %v", err)
+		result.ErrorMessage = &errorMsg
+		result.EndpointID = endpoint.ID
+		result.CheckedAt = time.Now()
+	}
+	result.URL = endpoint.URL
+
+	return result
+}
+
+// isValidEndpointURL validates that a URL is properly formatted
+func isValidEndpointURL(urlStr string) bool {
+	if urlStr == "" {
+		return false
+	}
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return false
+	}
+	return u.Scheme != "" && u.Host != ""
+}
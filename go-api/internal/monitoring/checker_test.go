@@ -0,0 +1,65 @@
+package monitoring
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/models"
+)
+
+func TestEndpointCheckerProtocols(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	checker := NewEndpointChecker(logger.New())
+
+	tests := []struct {
+		name       string
+		endpoint   models.Endpoint
+		wantMethod string
+		wantError  string
+	}{
+		{"default protocol", models.Endpoint{ID: 1, URL: ts.URL}, http.MethodGet, ""},
+		{"TCP", models.Endpoint{ID: 2, URL: "tcp://" + addr, Protocol: "TCP"}, "TCP", ""},
+		{"unsupported protocol", models.Endpoint{ID: 3, URL: "gopher://" + addr, Protocol: "gopher"}, "", "unsupported protocol"},
+		{"invalid URL", models.Endpoint{ID: 4, URL: "not a url", Protocol: models.ProtocolTCP}, "", "invalid endpoint URL"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := checker.Check(context.Background(), tt.endpoint)
+			if result.EndpointID != tt.endpoint.ID || result.URL != tt.endpoint.URL || result.Method != tt.wantMethod {
+				t.Errorf("unexpected result %+v", result)
+			}
+			if tt.wantError == "" {
+				if result.Failed() {
+					t.Errorf("expected the check to pass, got %+v", result)
+				}
+				return
+			}
+			if result.ErrorMessage == nil || !strings.Contains(*result.ErrorMessage, tt.wantError) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantError, result.ErrorMessage)
+			}
+		})
+	}
+}
+
+func TestEndpointCheckerReportsFailures(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	result := NewEndpointChecker(logger.New()).Check(context.Background(), models.Endpoint{ID: 1, URL: "tcp://" + addr, Protocol: models.ProtocolTCP})
+	if result.ErrorMessage == nil || !strings.HasPrefix(*result.ErrorMessage, "request failed") || result.Method != "TCP" {
+		t.Errorf("expected a failed TCP result, got %+v", result)
+	}
+}
@@ -0,0 +1,216 @@
+package monitoring
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/events"
+	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/outbox"
+	"github.com/redis/go-redis/v9"
+)
+
+// Outbox sinks every saved result is delivered to
+const (
+	SinkStream  = "stream"
+	SinkSymfony = "symfony"
+)
+
+// Outbox sinks endpoint status changes are delivered to
+const (
+	SinkStatusStream = "status_stream"
+)
+
+// sinks returns the sinks the outbox relay delivers results and status
+// changes to
+func (s *Service) sinks() []outbox.Sink {
+	return []outbox.Sink{
+		outbox.SinkFunc{SinkName: SinkStream, Fn: s.deliverStream},
+		outbox.SinkFunc{SinkName: SinkSymfony, Fn: s.deliverSymfony},
+		outbox.SinkFunc{SinkName: SinkStatusStream, Fn: s.deliverStatusStream},
+	}
+}
+
+// outboxMessages returns one message per sink for each result and each
+// status change. A result's messages share an idempotency key, which also
+// becomes the ID of its stream event. WebSocket clients aren't a sink:
+// only the replica claiming a message delivers it, so they are sent
+// results by publishBroadcasts instead. A failed result only goes to
+// Symfony's alert evaluation when its endpoint is down, so one failing
+// location doesn't page anyone.
+func outboxMessages(results []models.MonitoringResult, statuses map[int]models.EndpointStatus, changes []models.EndpointStatus) ([]outbox.Message, error) {
+	messages := make([]outbox.Message, 0, len(results)*2+len(changes))
+	for _, result := range results {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode result: %w", err)
+		}
+
+		sinks := []string{SinkStream}
+		if !result.Failed() || statuses[result.EndpointID].Status == models.StatusDown {
+			sinks = append(sinks, SinkSymfony)
+		}
+
+		key := events.NewID()
+		for _, sink := range sinks {
+			messages = append(messages, outbox.Message{Sink: sink, Key: key, Payload: payload})
+		}
+	}
+
+	for _, status := range changes {
+		payload, err := json.Marshal(status)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode endpoint status: %w", err)
+		}
+
+		messages = append(messages, outbox.Message{Sink: SinkStatusStream, Key: events.NewID(), Payload: payload})
+	}
+	return messages, nil
+}
+
+// decodeResult decodes the result carried by an outbox message. A payload
+// that can't be decoded never will be, so the error is permanent.
+func decodeResult(msg outbox.Message) (models.MonitoringResult, error) {
+	var result models.MonitoringResult
+	if err := json.Unmarshal(msg.Payload, &result); err != nil {
+		return result, outbox.Permanent(fmt.Errorf("failed to decode result: %w", err))
+	}
+	return result, nil
+}
+
+// decodeStatus decodes the endpoint status carried by an outbox message
+func decodeStatus(msg outbox.Message) (models.EndpointStatus, error) {
+	var status models.EndpointStatus
+	if err := json.Unmarshal(msg.Payload, &status); err != nil {
+		return status, outbox.Permanent(fmt.Errorf("failed to decode endpoint status: %w", err))
+	}
+	return status, nil
+}
+
+// deliverStream publishes a result to the Redis stream for analytics
+func (s *Service) deliverStream(ctx context.Context, msg outbox.Message) error {
+	result, err := decodeResult(msg)
+	if err != nil {
+		return err
+	}
+	return s.publishToStream(ctx, result, msg.Key)
+}
+
+// deliverSymfony sends a result to Symfony for alert evaluation
+func (s *Service) deliverSymfony(ctx context.Context, msg outbox.Message) error {
+	result, err := decodeResult(msg)
+	if err != nil {
+		return err
+	}
+	return s.notifySymfonyForAlertEvaluation(ctx, result, msg.Key)
+}
+
+// deliverStatusStream publishes an endpoint status change to the alerts
+// stream. Redelivered changes keep their event ID, so consumers can drop
+// repeats.
+func (s *Service) deliverStatusStream(ctx context.Context, msg outbox.Message) error {
+	status, err := decodeStatus(msg)
+	if err != nil {
+		return err
+	}
+
+	args := &redis.XAddArgs{
+		Stream: AlertsStream,
+		ID:     "*",
+		Values: map[string]interface{}{
+			"event_id":          msg.Key,
+			"endpoint_id":       status.EndpointID,
+			"status":            status.Status,
+			"previous_status":   status.PreviousStatus,
+			"failing":           status.Failing,
+			"locations":         status.Locations,
+			"failing_locations": strings.Join(status.FailingLocations, ","),
+			"changed_at":        status.ChangedAt.Format(time.RFC3339),
+		},
+	}
+	s.streamTrim.Apply(args, time.Now())
+
+	if err := s.rdb.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to publish status change: %w", err)
+	}
+	return nil
+}
+
+// publishToStream adds a result's check event to the api-metrics stream.
+// Redelivered results keep their event ID, so consumers can drop repeats.
+func (s *Service) publishToStream(ctx context.Context, result models.MonitoringResult, eventID string) error {
+	envelope := events.New(ctx, eventSource, events.NewAPICheck(result))
+	envelope.ID = eventID
+
+	streamData, err := s.events.StreamValues(envelope)
+	if err != nil {
+		return outbox.Permanent(fmt.Errorf("failed to encode check event: %w", err))
+	}
+
+	args := &redis.XAddArgs{
+		Stream: MetricsStream,
+		ID:     "*",
+		Values: streamData,
+	}
+	s.streamTrim.Apply(args, time.Now())
+
+	if err := s.rdb.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to publish to stream: %w", err)
+	}
+	return nil
+}
+
+// notifySymfonyForAlertEvaluation posts a result to Symfony's alert
+// evaluation with an Idempotency-Key header. Rate limiting and server
+// errors are retried; other rejections are permanent.
+func (s *Service) notifySymfonyForAlertEvaluation(ctx context.Context, result models.MonitoringResult, key string) error {
+	payload := map[string]interface{}{
+		"endpoint_id":   result.EndpointID,
+		"response_time": result.ResponseTime,
+		"status_code":   result.StatusCode,
+		"error_message": result.ErrorMessage,
+		"checked_at":    result.CheckedAt,
+		"location":      result.Location,
+	}
+
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return outbox.Permanent(fmt.Errorf("failed to marshal monitoring result for alert evaluation: %w", err))
+	}
+
+	alertURL := s.symphonyAPIURL + "/api/monitoring/evaluate-alerts"
+	req, err := http.NewRequestWithContext(ctx, "POST", alertURL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return outbox.Permanent(fmt.Errorf("failed to create alert notification request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Idempotency-Key", key)
+
+	var status int
+	err = s.symfony.Execute(ctx, func(ctx context.Context) error {
+		resp, err := s.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return fmt.Errorf("failed to notify Symfony for alert evaluation: %w", err)
+		}
+		defer resp.Body.Close()
+
+		status = resp.StatusCode
+		if status == http.StatusTooManyRequests || status >= 500 {
+			return fmt.Errorf("symfony alert evaluation returned %d", status)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if status < 200 || status >= 300 {
+		return outbox.Permanent(fmt.Errorf("symfony alert evaluation rejected the result with %d", status))
+	}
+	return nil
+}
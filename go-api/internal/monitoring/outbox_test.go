@@ -0,0 +1,67 @@
+package monitoring
+
+import (
+	"encoding/json"
+	"testing"
+
+	"api-monitor-go/internal/models"
+)
+
+func TestOutboxMessagesGateAlertEvaluation(t *testing.T) {
+	ok, failed := 200, 503
+	results := []models.MonitoringResult{
+		{EndpointID: 1, StatusCode: &ok, Location: "eu"},
+		{EndpointID: 1, StatusCode: &failed, Location: "us"},
+		{EndpointID: 2, StatusCode: &failed, Location: "us"},
+		{EndpointID: 3, Location: "ap"},
+	}
+	statuses := map[int]models.EndpointStatus{
+		1: {EndpointID: 1, Status: models.StatusDegraded},
+		2: {EndpointID: 2, Status: models.StatusDown},
+		3: {EndpointID: 3, Status: models.StatusUp},
+	}
+	changes := []models.EndpointStatus{{EndpointID: 2, Status: models.StatusDown, PreviousStatus: models.StatusDegraded}}
+
+	messages, err := outboxMessages(results, statuses, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sinks := map[string][]int{}
+	for _, msg := range messages {
+		switch msg.Sink {
+		case SinkStatusStream:
+			var status models.EndpointStatus
+			if err := json.Unmarshal(msg.Payload, &status); err != nil {
+				t.Fatal(err)
+			}
+			sinks[msg.Sink] = append(sinks[msg.Sink], status.EndpointID)
+		default:
+			var result models.MonitoringResult
+			if err := json.Unmarshal(msg.Payload, &result); err != nil {
+				t.Fatal(err)
+			}
+			sinks[msg.Sink] = append(sinks[msg.Sink], result.EndpointID)
+		}
+	}
+
+	want := map[string][]int{
+		SinkStream: {1, 1, 2, 3},
+		// A failure from one location of an endpoint that isn't down isn't
+		// evaluated for alerts
+		SinkSymfony:      {1, 2},
+		SinkStatusStream: {2},
+	}
+	for sink, ids := range want {
+		if len(sinks[sink]) != len(ids) {
+			t.Errorf("%s: expected endpoints %v, got %v", sink, ids, sinks[sink])
+			continue
+		}
+		for i := range ids {
+			if sinks[sink][i] != ids[i] {
+				t.Errorf("%s: expected endpoints %v, got %v", sink, ids, sinks[sink])
+				break
+			}
+		}
+	}
+}
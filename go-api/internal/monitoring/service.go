@@ -1,20 +1,22 @@
 package monitoring
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
-	"strconv"
 	"sync"
 	"time"
 
+	"api-monitor-go/internal/consensus"
 	"api-monitor-go/internal/database"
+	"api-monitor-go/internal/events"
 	"api-monitor-go/internal/logger"
 	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/outbox"
 	"api-monitor-go/internal/resilience"
+	"api-monitor-go/internal/streams"
 	"api-monitor-go/internal/websocket"
 	"github.com/redis/go-redis/v9"
 )
@@ -25,6 +27,12 @@ const (
 	AlertsStream  = "alerts-fired"
 )
 
+// eventSource names this service in published event envelopes
+const eventSource = "go-api"
+
+// defaultMaxConcurrentChecks bounds in-flight endpoint checks per cycle
+const defaultMaxConcurrentChecks = 100
+
 // Service coordinates endpoint checks, result persistence, alert evaluation,
 // WebSocket broadcasting, and publishing to the Redis stream.
 type Service struct {
@@ -34,41 +42,108 @@ type Service struct {
 	rdb            *redis.Client
 	symphonyAPIURL string
 	httpClientTimeout time.Duration
-	circuitBreaker *resilience.CircuitBreaker
-	retrier        *resilience.Retrier
+	checker        *EndpointChecker
+	symfony        *resilience.Pipeline
+	results        *database.Batcher[models.MonitoringResult]
+	maxConcurrentChecks int
+	location       string
+	consensus      consensus.Config
+	streamTrim     streams.TrimConfig
+	events         events.StreamConfig
+	outbox         outbox.Store
+	relay          *outbox.Relay
+	stopBroadcasts func(ctx context.Context) error
 	log            *logger.Logger
 }
 
-func NewService(repo *database.Repository, hub *websocket.Hub, rdb *redis.Client, symphonyAPIURL string, httpClientTimeout time.Duration) *Service {
+func NewService(repo *database.Repository, hub *websocket.Hub, rdb *redis.Client, symphonyAPIURL string, httpClientTimeout time.Duration, batchConfig database.BatcherConfig, streamTrim streams.TrimConfig, eventConfig events.StreamConfig, outboxStore outbox.Store, relayConfig outbox.RelayConfig, location string, consensusConfig consensus.Config) *Service {
 	log := logger.New()
 	log.SetLevel(logger.LevelInfo)
 
-	circuitBreakerConfig := resilience.CircuitBreakerConfig{
-		Name:          "endpoint-checker",
-		MaxFailures:   5,
-		Timeout:       30 * time.Second,
-		ResetInterval: 60 * time.Second,
+	consensusDefaults := consensus.DefaultConfig()
+	if consensusConfig.Quorum <= 0 {
+		consensusConfig.Quorum = consensusDefaults.Quorum
 	}
-
-	retryConfig := resilience.RetryConfig{
-		MaxAttempts:  3,
-		InitialDelay: 100 * time.Millisecond,
-		MaxDelay:     2 * time.Second,
-		Multiplier:   2.0,
-		Jitter:       true,
+	if consensusConfig.Window <= 0 {
+		consensusConfig.Window = consensusDefaults.Window
 	}
 
-	return &Service{
+	s := &Service{
 		repo:           repo,
 		hub:            hub,
 		client:         &http.Client{Timeout: httpClientTimeout},
 		rdb:            rdb,
 		symphonyAPIURL: symphonyAPIURL,
 		httpClientTimeout: httpClientTimeout,
-		circuitBreaker: resilience.NewCircuitBreaker(circuitBreakerConfig),
-		retrier:        resilience.NewRetrier(retryConfig),
+		checker:        NewEndpointChecker(log),
+		maxConcurrentChecks: defaultMaxConcurrentChecks,
+		location:       location,
+		consensus:      consensusConfig,
+		streamTrim:     streamTrim,
+		events:         eventConfig,
+		outbox:         outboxStore,
 		log:            log,
 	}
+
+	// A slow Symfony holds at most a bounded number of relay deliveries,
+	// fewer as its latency grows; rejected deliveries are retried by the
+	// outbox
+	s.symfony = resilience.Policy().
+		Bulkhead(resilience.NewBulkhead(resilience.BulkheadConfig{
+			Name:          "symfony",
+			MaxConcurrent: 10,
+			MaxQueue:      20,
+			QueueTimeout:  5 * time.Second,
+		})).
+		Limit(resilience.NewAdaptiveLimiter(resilience.AdaptiveLimiterConfig{
+			Name:         "symfony",
+			Algorithm:    resilience.LimitGradient,
+			InitialLimit: 10,
+			MaxLimit:     10,
+		}))
+
+	// Results are persisted write-behind; fan-out happens once a batch is stored
+	if batchConfig.Name == "" {
+		batchConfig.Name = "monitoring_results"
+	}
+	s.results = database.NewBatcher(batchConfig, s.persistResults)
+
+	s.relay = outbox.NewRelay(outboxStore, relayConfig, s.sinks()...)
+	s.relay.Start()
+	s.startBroadcasts()
+
+	return s
+}
+
+// Close flushes results that are still queued for persistence and stops
+// the outbox relay and broadcasts; undelivered results are picked up on
+// the next start
+func (s *Service) Close(ctx context.Context) error {
+	err := s.results.Close(ctx)
+	if stopErr := s.relay.Stop(ctx); err == nil {
+		err = stopErr
+	}
+	if stopErr := s.stopBroadcasts(ctx); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// OutboxStats returns the delivery backlog and counters of each result sink
+func (s *Service) OutboxStats(ctx context.Context) ([]outbox.SinkStats, error) {
+	return s.relay.Stats(ctx)
+}
+
+// CircuitBreakerStats returns a snapshot of the endpoint checker's circuit
+// breaker
+func (s *Service) CircuitBreakerStats() resilience.CircuitBreakerStats {
+	return s.checker.CircuitBreakerStats()
+}
+
+// ResultBatcherStats returns batch size and flush latency statistics for
+// result persistence
+func (s *Service) ResultBatcherStats() database.BatcherStats {
+	return s.results.Stats()
 }
 
 // MonitorEndpoints performs concurrent monitoring of all active endpoints
@@ -98,64 +173,40 @@ func (s *Service) MonitorEndpointsWithContext(ctx context.Context) error {
 	}
 
 	var wg sync.WaitGroup
-	results := make(chan models.MonitoringResult, len(endpoints))
+	var errMu sync.Mutex
+	var processingErrors []error
+
+	// A check slot is released only once its result is queued for
+	// persistence, so a slow database throttles new checks instead of
+	// letting results pile up in memory.
+	slots := make(chan struct{}, s.maxConcurrentChecks)
 
 	for _, endpoint := range endpoints {
-		// Check context before spawning goroutine
 		select {
+		case slots <- struct{}{}:
 		case <-ctx.Done():
-			close(results)
+			wg.Wait()
 			return ctx.Err()
-		default:
 		}
 
 		// Track one goroutine per endpoint to check.
 		wg.Add(1)
 		go func(e models.Endpoint) {
 			defer wg.Done()
-			result := s.checkEndpoint(e)
-			results <- result
+			defer func() { <-slots }()
+
+			result := s.checkEndpoint(ctx, e)
+			result.Location = s.location
+			if err := s.results.Add(ctx, result); err != nil {
+				s.log.WithField("endpoint_id", result.EndpointID).Errorf("failed to queue result: %v", err)
+				errMu.Lock()
+				processingErrors = append(processingErrors, err)
+				errMu.Unlock()
+			}
 		}(endpoint)
 	}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// Collect errors during result processing
-	var processingErrors []error
-
-	for result := range results {
-		// Check context during result processing
-		select {
-		case <-ctx.Done():
-			processingErrors = append(processingErrors, ctx.Err())
-			break
-		default:
-		}
-
-		if err := s.repo.SaveResult(result); err != nil {
-			s.log.WithField("endpoint_id", result.EndpointID).Errorf("failed to save result: %v", err)
-			processingErrors = append(processingErrors, err)
-		} else {
-			// Log for monitoring
-			s.log.WithFields(map[string]interface{}{
-				"endpoint_id":   result.EndpointID,
-				"response_time": result.ResponseTime,
-				"status_code":   result.StatusCode,
-			}).Info("endpoint checked successfully")
-
-			// Broadcast to WebSocket clients
-			s.hub.Broadcast(result)
-
-			// Publish to Redis stream for analytics (async)
-			go s.publishToStream(result)
-
-			// Notify Symfony for alert evaluation asynchronously (non-blocking)
-			go s.notifySymfonyForAlertEvaluation(result)
-		}
-	}
+	wg.Wait()
 
 	// If there were processing errors, log them but don't fail the entire cycle
 	if len(processingErrors) > 0 {
@@ -165,178 +216,169 @@ func (s *Service) MonitorEndpointsWithContext(ctx context.Context) error {
 	return nil
 }
 
-func (s *Service) checkEndpoint(endpoint models.Endpoint) models.MonitoringResult {
-	// Validate endpoint URL
-	if !isValidEndpointURL(endpoint.URL) {
-		errorMsg := "invalid endpoint URL format"
-		s.log.WithField("endpoint_id", endpoint.ID).Warnf("invalid URL: %s", endpoint.URL)
-		return models.MonitoringResult{
-			EndpointID:   endpoint.ID,
-			ResponseTime: 0,
-			StatusCode:   nil,
-			ErrorMessage: &errorMsg,
-			CheckedAt:    time.Now(),
-		}
-	}
+// IngestBatch stores a batch of results an agent measured, with their
+// fan-out, before returning, so the agent may discard them once it is
+// acknowledged. The batch ID is recorded with the results; a batch that
+// was already stored, by this or another replica, is not stored again
+// and duplicate is true. Batch IDs recorded before expired are forgotten.
+func (s *Service) IngestBatch(ctx context.Context, agentID, batchID string, expired time.Time, results []models.MonitoringResult) (duplicate bool, err error) {
+	start := time.Now()
+	var changes []models.EndpointStatus
 
-	client := &http.Client{
-		Timeout: time.Duration(endpoint.Timeout) * time.Millisecond,
+	err = s.repo.SaveAgentBatch(ctx, agentID, batchID, expired, results, s.fanOut(ctx, results, &changes))
+	if errors.Is(err, database.ErrDuplicateBatch) {
+		return true, nil
 	}
-
-	// Use circuit breaker and retry logic for endpoint check
-	var result models.MonitoringResult
-	err := s.circuitBreaker.Execute(func() error {
-		return s.retrier.Do(func() error {
-			return s.executeEndpointCheck(client, endpoint, &result)
-		})
-	})
-
 	if err != nil {
-		// Log circuit breaker or retry errors
-		s.log.WithField("endpoint_id", endpoint.ID).Warnf("endpoint check failed after retries: %v", err)
-		errorMsg := fmt.Sprintf("request failed: %v", err)
-		result.ErrorMessage = &errorMsg
-		result.EndpointID = endpoint.ID
-		result.CheckedAt = time.Now()
+		s.log.WithFields(map[string]interface{}{
+			"agent_id":   agentID,
+			"batch_size": len(results),
+		}).Errorf("failed to save results: %v", err)
+		return false, err
 	}
 
-	return result
+	s.saved(ctx, results, changes, start)
+	return false, nil
 }
 
-// executeEndpointCheck performs the actual HTTP request to the endpoint
-func (s *Service) executeEndpointCheck(client *http.Client, endpoint models.Endpoint, result *models.MonitoringResult) error {
-	req, err := http.NewRequest("GET", endpoint.URL, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Add headers if any
-	if len(endpoint.Headers) > 0 {
-		var headers map[string]string
-		if err := json.Unmarshal(endpoint.Headers, &headers); err != nil {
-			s.log.WithField("endpoint_id", endpoint.ID).Warnf("failed to parse headers: %v", err)
-		} else {
-			for key, value := range headers {
-				req.Header.Set(key, value)
-			}
-		}
-	}
-
+// persistResults stores a batch of results together with outbox messages
+// that fan each one out to the Redis stream and Symfony,
+// and with the endpoint statuses the results decide
+func (s *Service) persistResults(ctx context.Context, results []models.MonitoringResult) error {
 	start := time.Now()
-	resp, err := client.Do(req)
-	responseTime := int(time.Since(start).Milliseconds())
-
-	result.EndpointID = endpoint.ID
-	result.ResponseTime = responseTime
-	result.CheckedAt = time.Now()
+	var changes []models.EndpointStatus
 
+	err := s.repo.SaveResultsWith(ctx, results, s.fanOut(ctx, results, &changes))
 	if err != nil {
+		s.log.WithField("batch_size", len(results)).Errorf("failed to save results: %v", err)
 		return err
 	}
 
-	defer resp.Body.Close()
-
-	result.StatusCode = &resp.StatusCode
-	result.ErrorMessage = nil
-
+	s.saved(ctx, results, changes, start)
 	return nil
 }
 
-// isValidEndpointURL validates that a URL is properly formatted
-func isValidEndpointURL(urlStr string) bool {
-	if urlStr == "" {
-		return false
-	}
-	u, err := url.Parse(urlStr)
-	if err != nil {
-		return false
+// fanOut returns the work stored in the transaction of a batch of results:
+// the statuses they decide and the outbox messages fanning them out. The
+// status changes are set in changes.
+func (s *Service) fanOut(ctx context.Context, results []models.MonitoringResult, changes *[]models.EndpointStatus) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		statuses, changed, err := s.evaluateConsensus(ctx, tx, results)
+		if err != nil {
+			return err
+		}
+		messages, err := outboxMessages(results, statuses, changed)
+		if err != nil {
+			return err
+		}
+		*changes = changed
+		return s.outbox.Enqueue(ctx, tx, messages)
 	}
-	return u.Scheme != "" && u.Host != ""
 }
 
-func (s *Service) notifySymfonyForAlertEvaluation(result models.MonitoringResult) {
-	// Create context with timeout for this notification
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// saved wakes the relay for a committed batch of results, broadcasts it
+// and logs it
+func (s *Service) saved(ctx context.Context, results []models.MonitoringResult, changes []models.EndpointStatus, start time.Time) {
+	// Deliveries were committed with the results; the relay fans them out
+	s.relay.Notify()
+	s.publishBroadcasts(ctx, results, changes)
+
+	s.log.WithFields(map[string]interface{}{
+		"batch_size": len(results),
+		"duration":   time.Since(start).String(),
+	}).Debug("result batch saved")
+
+	for _, result := range results {
+		// Log for monitoring
+		s.log.WithFields(map[string]interface{}{
+			"endpoint_id":   result.EndpointID,
+			"response_time": result.ResponseTime,
+			"status_code":   result.StatusCode,
+			"location":      result.Location,
+		}).Info("endpoint checked successfully")
+	}
+	for _, status := range changes {
+		s.log.WithFields(map[string]interface{}{
+			"endpoint_id":       status.EndpointID,
+			"failing_locations": status.FailingLocations,
+		}).Warnf("endpoint status changed from %s to %s", status.PreviousStatus, status.Status)
+	}
+}
 
-	payload := map[string]interface{}{
-		"endpoint_id":   result.EndpointID,
-		"response_time": result.ResponseTime,
-		"status_code":   result.StatusCode,
-		"error_message": result.ErrorMessage,
-		"checked_at":    result.CheckedAt,
+// evaluateConsensus decides the status of the batch's endpoints from the
+// latest check of every location, including the batch's own, and stores
+// it. It returns each endpoint's status and the changes worth notifying.
+// Status rows stay locked until tx ends, so a replica storing results for
+// the same endpoints waits and then sees these.
+func (s *Service) evaluateConsensus(ctx context.Context, tx *sql.Tx, results []models.MonitoringResult) (map[int]models.EndpointStatus, []models.EndpointStatus, error) {
+	seen := make(map[int]bool, len(results))
+	ids := make([]int, 0, len(results))
+	for _, result := range results {
+		if !seen[result.EndpointID] {
+			seen[result.EndpointID] = true
+			ids = append(ids, result.EndpointID)
+		}
 	}
 
-	jsonData, err := json.Marshal(payload)
+	previous, err := s.repo.LockEndpointStatuses(ctx, tx, ids)
 	if err != nil {
-		s.log.Errorf("failed to marshal monitoring result for alert evaluation: %v", err)
-		return
+		return nil, nil, err
 	}
 
-	// Construct alert evaluation URL
-	alertURL := s.symphonyAPIURL + "/api/monitoring/evaluate-alerts"
-	if _, err := url.Parse(alertURL); err != nil {
-		s.log.Errorf("invalid Symfony API URL: %v", err)
-		return
+	now := time.Now()
+	checks, err := s.repo.LatestLocationChecks(ctx, tx, ids, now.Add(-s.consensus.Window))
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// POST to Symfony for alert evaluation with retry logic
-	err = s.retrier.DoWithContext(ctx, func(retryCtx context.Context) error {
-		req, err := http.NewRequestWithContext(retryCtx, "POST", alertURL, bytes.NewBuffer(jsonData))
-		if err != nil {
-			return fmt.Errorf("failed to create alert notification request: %w", err)
+	statuses := make(map[int]models.EndpointStatus, len(ids))
+	updated := make([]models.EndpointStatus, 0, len(ids))
+	var changed []models.EndpointStatus
+	for _, id := range ids {
+		prev := previous[id]
+		status := consensus.Evaluate(s.consensus, id, checks[id], now)
+		if status.Status == models.StatusUnknown {
+			// The results are older than the window; keep what was decided
+			statuses[id] = prev
+			continue
 		}
-		req.Header.Set("Content-Type", "application/json")
 
-		resp, err := s.client.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to notify Symfony for alert evaluation: %w", err)
+		status.ChangedAt = prev.ChangedAt
+		if status.Status != prev.Status {
+			status.ChangedAt = now
+			status.PreviousStatus = prev.Status
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-			s.log.WithField("status_code", resp.StatusCode).Warnf("Symfony alert evaluation returned unexpected status")
+		if consensus.Notify(prev.Status, status.Status) {
+			changed = append(changed, status)
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		s.log.WithField("endpoint_id", result.EndpointID).Warnf("failed to notify Symfony after retries: %v", err)
-	}
-}
-
-func (s *Service) publishToStream(result models.MonitoringResult) {
-	// Create context with timeout for Redis operation
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	streamData := map[string]interface{}{
-		"endpoint_id":   strconv.Itoa(result.EndpointID),
-		"response_time": strconv.Itoa(result.ResponseTime),
-		"timestamp":     result.CheckedAt.Format(time.RFC3339),
+		statuses[id] = status
+		updated = append(updated, status)
 	}
 
-	if result.StatusCode != nil {
-		streamData["status_code"] = strconv.Itoa(*result.StatusCode)
+	if err := s.repo.SaveEndpointStatuses(ctx, tx, updated); err != nil {
+		return nil, nil, err
 	}
+	return statuses, changed, nil
+}
 
-	if result.ErrorMessage != nil {
-		streamData["error_message"] = *result.ErrorMessage
+// LocationReport returns an endpoint's consensus status and compares its
+// response times per location over the last window
+func (s *Service) LocationReport(ctx context.Context, endpointID int, window time.Duration) (models.EndpointStatus, []models.LocationLatency, error) {
+	status, err := s.repo.GetEndpointStatus(ctx, endpointID)
+	if err != nil {
+		return status, nil, err
 	}
-
-	// Publish to Redis stream with retry logic
-	err := s.retrier.DoWithContext(ctx, func(retryCtx context.Context) error {
-		return s.rdb.XAdd(retryCtx, &redis.XAddArgs{
-			Stream: MetricsStream,
-			ID:     "*",
-			Values: streamData,
-		}).Err()
-	})
-
+	latencies, err := s.repo.LocationLatency(ctx, endpointID, time.Now().Add(-window))
 	if err != nil {
-		s.log.WithField("endpoint_id", result.EndpointID).Warnf("failed to publish metrics to Redis stream: %v", err)
+		return status, nil, err
 	}
+	return status, latencies, nil
+}
+
+// checkEndpoint checks an endpoint within ctx, so a cancelled or expired
+// monitoring cycle stops its checks
+func (s *Service) checkEndpoint(ctx context.Context, endpoint models.Endpoint) models.MonitoringResult {
+	return s.checker.Check(ctx, endpoint)
 }
 
 // Removed: evaluateAlerts function
@@ -3,7 +3,6 @@ package monitoring
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
@@ -11,15 +10,20 @@ import (
 	"testing"
 	"time"
 
+	"api-monitor-go/internal/events"
+	"api-monitor-go/internal/logger"
 	"api-monitor-go/internal/models"
+	"api-monitor-go/internal/outbox"
+	"api-monitor-go/internal/resilience"
+	"api-monitor-go/internal/websocket"
 	"github.com/redis/go-redis/v9"
 )
 
 func TestCheckEndpointHandlesError(t *testing.T) {
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 1, URL: "http://localhost:0", Timeout: 10}
 
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	
 	// Verify endpoint ID
 	if got, want := res.EndpointID, 1; got != want {
@@ -50,9 +54,9 @@ func TestCheckEndpointSuccessPath(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 2, URL: url, Timeout: 1000}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.StatusCode == nil || *res.StatusCode != 200 {
 		t.Fatalf("expected 200, got %+v", res.StatusCode)
 	}
@@ -76,8 +80,8 @@ func TestCheckEndpointWithHeaders(t *testing.T) {
 	headers, _ := json.Marshal(map[string]string{"Authorization": "Bearer token123"})
 	endpoint := models.Endpoint{ID: 3, URL: url, Timeout: 1000, Headers: headers}
 
-	s := &Service{}
-	res := s.checkEndpoint(endpoint)
+	s := newTestService()
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.StatusCode == nil || *res.StatusCode != 200 {
 		t.Fatalf("expected 200 with proper headers, got %+v", res.StatusCode)
 	}
@@ -99,9 +103,9 @@ func TestCheckEndpointTimeout(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 4, URL: url, Timeout: 50}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	// Verify status code is nil on timeout
 	if res.StatusCode != nil {
@@ -115,14 +119,14 @@ func TestCheckEndpointTimeout(t *testing.T) {
 }
 
 func TestCheckEndpointInvalidURL(t *testing.T) {
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{
 		ID:      5,
 		URL:     "ht!tp://invalid[url",
 		Timeout: 1000,
 	}
 
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.StatusCode != nil {
 		t.Fatalf("expected nil status code on invalid URL, got %+v", res.StatusCode)
 	}
@@ -143,9 +147,9 @@ func TestCheckEndpointResponseTime(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 6, URL: url, Timeout: 5000}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	if res.ResponseTime < 90 {
 		t.Fatalf("expected response time >= 90ms, got %d", res.ResponseTime)
@@ -164,9 +168,9 @@ func TestCheckEndpointEmptyHeaders(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 7, URL: url, Timeout: 1000, Headers: []byte{}}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	if res.StatusCode == nil || *res.StatusCode != 200 {
 		t.Fatalf("expected 200, got %+v", res.StatusCode)
@@ -195,8 +199,8 @@ func TestCheckEndpointMultipleHeaders(t *testing.T) {
 	})
 	endpoint := models.Endpoint{ID: 8, URL: url, Timeout: 1000, Headers: headers}
 
-	s := &Service{}
-	res := s.checkEndpoint(endpoint)
+	s := newTestService()
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.StatusCode == nil || *res.StatusCode != 200 {
 		t.Fatalf("expected 200 with proper headers, got %+v", res.StatusCode)
 	}
@@ -232,9 +236,9 @@ func TestCheckEndpointStatusCodeVariety(t *testing.T) {
 			defer ts.Close()
 
 			url := "http://" + listener.Addr().String() + "/"
-			s := &Service{}
+			s := newTestService()
 			endpoint := models.Endpoint{ID: 9, URL: url, Timeout: 1000}
-			res := s.checkEndpoint(endpoint)
+			res := s.checkEndpoint(context.Background(), endpoint)
 
 			// Verify correct status code
 			if res.StatusCode == nil {
@@ -258,9 +262,9 @@ func TestCheckEndpointLargeTimeout(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 10, URL: url, Timeout: 30000}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	if res.StatusCode == nil || *res.StatusCode != 200 {
 		t.Fatalf("expected 200, got %+v", res.StatusCode)
@@ -280,9 +284,9 @@ func TestCheckEndpointSmallTimeout(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 11, URL: url, Timeout: 1}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	if res.StatusCode != nil {
 		t.Fatalf("expected nil status code on timeout, got %+v", res.StatusCode)
@@ -303,9 +307,9 @@ func TestCheckEndpointResponseTimeAccuracy(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 12, URL: url, Timeout: 5000}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	if res.ResponseTime < int(delayMs-10) || res.ResponseTime > int(delayMs+50) {
 		t.Fatalf("expected response time around %dms, got %d", delayMs, res.ResponseTime)
@@ -313,27 +317,42 @@ func TestCheckEndpointResponseTimeAccuracy(t *testing.T) {
 }
 
 
+// TestCheckEndpointStopsWithContext tests that cancelling the monitoring
+// cycle stops a check before the endpoint's own timeout
+func TestCheckEndpointStopsWithContext(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(200)
+	}))
+	defer ts.Close()
+	defer close(release)
 
-// TestPublishToStreamWithStatusCode tests publishing to Redis stream with status code
-func TestPublishToStreamWithStatusCode(t *testing.T) {
-	// Skip if Redis is not available
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-	})
-	defer rdb.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
 
-	_, err := rdb.Ping(context.Background()).Result()
-	if err != nil {
-		t.Skip("Redis not available, skipping test")
-	}
+	s := newTestService()
+	endpoint := models.Endpoint{ID: 23, URL: ts.URL, Timeout: 5000}
 
-	s := &Service{
-		repo:   nil,
-		hub:    nil,
-		client: &http.Client{Timeout: 5 * time.Second},
-		rdb:    rdb,
-		ctx:    context.Background(),
+	start := time.Now()
+	res := s.checkEndpoint(ctx, endpoint)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("check ran for %v after the cycle context expired", elapsed)
 	}
+	if res.StatusCode != nil || res.ErrorMessage == nil {
+		t.Fatalf("expected a failed result, got %+v", res)
+	}
+}
+
+// TestPublishToStreamWithStatusCode tests publishing to Redis stream with status code
+func TestPublishToStreamWithStatusCode(t *testing.T) {
+	rdb := getTestRedis(t)
+
+	s := newTestService()
+	s.rdb = rdb
 
 	statusCode := 200
 	result := models.MonitoringResult{
@@ -343,29 +362,19 @@ func TestPublishToStreamWithStatusCode(t *testing.T) {
 		CheckedAt:    time.Now(),
 	}
 
-	s.publishToStream(result)
+	eventID := events.NewID()
+	if err := s.publishToStream(context.Background(), result, eventID); err != nil {
+		t.Fatalf("publishToStream: %v", err)
+	}
+	assertLatestEvent(t, rdb, eventID)
 }
 
 // TestPublishToStreamWithError tests publishing to Redis stream with error
 func TestPublishToStreamWithError(t *testing.T) {
-	// Skip if Redis is not available
-	rdb := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-	})
-	defer rdb.Close()
+	rdb := getTestRedis(t)
 
-	_, err := rdb.Ping(context.Background()).Result()
-	if err != nil {
-		t.Skip("Redis not available, skipping test")
-	}
-
-	s := &Service{
-		repo:   nil,
-		hub:    nil,
-		client: &http.Client{Timeout: 5 * time.Second},
-		rdb:    rdb,
-		ctx:    context.Background(),
-	}
+	s := newTestService()
+	s.rdb = rdb
 
 	errMsg := "connection timeout"
 	result := models.MonitoringResult{
@@ -376,75 +385,122 @@ func TestPublishToStreamWithError(t *testing.T) {
 		CheckedAt:    time.Now(),
 	}
 
-	s.publishToStream(result)
+	eventID := events.NewID()
+	if err := s.publishToStream(context.Background(), result, eventID); err != nil {
+		t.Fatalf("publishToStream: %v", err)
+	}
+	assertLatestEvent(t, rdb, eventID)
 }
 
-// TestSendAlertNotificationSuccess tests sending alert notification to Symfony
-func TestSendAlertNotificationSuccess(t *testing.T) {
-	// Create a mock Symfony API server
-	listener, _ := net.Listen("tcp", "127.0.0.1:0")
-	ts := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == "/api/alerts/trigger" && r.Method == "POST" {
-				w.WriteHeader(http.StatusOK)
-			} else {
-				w.WriteHeader(http.StatusNotFound)
-			}
-		}),
+// TestPublishToStreamRedisUnavailable tests that a Redis outage is retried
+// by the outbox rather than marking the delivery dead
+func TestPublishToStreamRedisUnavailable(t *testing.T) {
+	s := newTestService()
+	s.rdb = unavailableRedis(t)
+
+	err := s.publishToStream(context.Background(), models.MonitoringResult{EndpointID: 1, CheckedAt: time.Now()}, events.NewID())
+	if err == nil {
+		t.Fatal("expected an error when Redis is unavailable")
 	}
-	go ts.Serve(listener)
+	if outbox.IsPermanent(err) {
+		t.Errorf("expected a retryable error, got permanent %v", err)
+	}
+}
+
+// TestNotifySymfonyForAlertEvaluationSuccess tests sending a result to
+// Symfony's alert evaluation
+func TestNotifySymfonyForAlertEvaluationSuccess(t *testing.T) {
+	var gotKey string
+	var gotBody map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/monitoring/evaluate-alerts" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		gotKey = r.Header.Get("Idempotency-Key")
+		_ = json.NewDecoder(r.Body).Decode(&gotBody)
+		w.WriteHeader(http.StatusOK)
+	}))
 	defer ts.Close()
 
-	t.Setenv("SYMFONY_API_URL", "http://"+listener.Addr().String())
+	s := newTestService()
+	s.symphonyAPIURL = ts.URL
 
-	s := &Service{
-		client: &http.Client{Timeout: 5 * time.Second},
+	statusCode := 200
+	result := models.MonitoringResult{EndpointID: 7, ResponseTime: 120, StatusCode: &statusCode, Location: "eu", CheckedAt: time.Now()}
+	if err := s.notifySymfonyForAlertEvaluation(context.Background(), result, "key-1"); err != nil {
+		t.Fatalf("notifySymfonyForAlertEvaluation: %v", err)
 	}
 
-	s.sendAlertNotification(1, "Test alert message")
+	if gotKey != "key-1" {
+		t.Errorf("Idempotency-Key: got %q, want %q", gotKey, "key-1")
+	}
+	if gotBody["endpoint_id"] != float64(7) || gotBody["location"] != "eu" {
+		t.Errorf("unexpected payload %v", gotBody)
+	}
 }
 
-// TestSendAlertNotificationDefaultURL tests using default Symfony URL
-func TestSendAlertNotificationDefaultURL(t *testing.T) {
-	t.Setenv("SYMFONY_API_URL", "")
-
-	listener, _ := net.Listen("tcp", "127.0.0.1:0")
-	ts := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			w.WriteHeader(http.StatusOK)
-		}),
+// TestNotifySymfonyForAlertEvaluationFailures tests which failures the
+// outbox retries and which mark the delivery dead
+func TestNotifySymfonyForAlertEvaluationFailures(t *testing.T) {
+	tests := []struct {
+		name          string
+		status        int
+		wantPermanent bool
+	}{
+		{"RateLimited", http.StatusTooManyRequests, false},
+		{"ServerError", http.StatusServiceUnavailable, false},
+		{"Rejected", http.StatusUnprocessableEntity, true},
+		{"NotFound", http.StatusNotFound, true},
 	}
-	go ts.Serve(listener)
-	defer ts.Close()
 
-	s := &Service{
-		client: &http.Client{Timeout: 5 * time.Second},
-	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+			defer ts.Close()
+
+			s := newTestService()
+			s.symphonyAPIURL = ts.URL
 
-	s.sendAlertNotification(1, "Test alert")
+			err := s.notifySymfonyForAlertEvaluation(context.Background(), models.MonitoringResult{EndpointID: 1}, "key")
+			if err == nil {
+				t.Fatalf("expected an error for status %d", tt.status)
+			}
+			if got := outbox.IsPermanent(err); got != tt.wantPermanent {
+				t.Errorf("IsPermanent: got %v, want %v (%v)", got, tt.wantPermanent, err)
+			}
+		})
+	}
 }
 
-// TestSendAlertNotificationInvalidURL tests handling of invalid request URL
-func TestSendAlertNotificationInvalidURL(t *testing.T) {
-	t.Setenv("SYMFONY_API_URL", "http://invalid-host-that-does-not-exist:9999")
+// TestNotifySymfonyForAlertEvaluationUnreachable tests that an unreachable
+// Symfony is retried
+func TestNotifySymfonyForAlertEvaluationUnreachable(t *testing.T) {
+	s := newTestService()
+	s.client = &http.Client{Timeout: 100 * time.Millisecond}
+	s.symphonyAPIURL = "http://" + closedAddr(t)
 
-	s := &Service{
-		client: &http.Client{Timeout: 100 * time.Millisecond},
+	err := s.notifySymfonyForAlertEvaluation(context.Background(), models.MonitoringResult{EndpointID: 1}, "key")
+	if err == nil {
+		t.Fatal("expected an error when Symfony is unreachable")
+	}
+	if outbox.IsPermanent(err) {
+		t.Errorf("expected a retryable error, got permanent %v", err)
 	}
-
-	s.sendAlertNotification(1, "Test alert")
 }
 
 // TestCheckEndpointRetainErrorMessage tests that error message is preserved in result
 func TestCheckEndpointRetainErrorMessage(t *testing.T) {
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{
 		ID:      13,
 		URL:     "http://localhost:1/nonexistent",
 		Timeout: 100,
 	}
 
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.ErrorMessage == nil {
 		t.Fatalf("expected error message")
 	}
@@ -466,33 +522,19 @@ func TestCheckEndpointResponseTimeNonZero(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{
 		ID:      14,
 		URL:     url,
 		Timeout: 1000,
 	}
 
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.ResponseTime == 0 {
 		t.Fatalf("expected non-zero response time")
 	}
 }
 
-// TestEvaluateAlertsWithEmptyList tests alert evaluation with empty list
-func TestEvaluateAlertsWithEmptyList(t *testing.T) {
-	s := &Service{
-		client: &http.Client{Timeout: 5 * time.Second},
-	}
-
-	result := models.MonitoringResult{
-		EndpointID:   1,
-		ResponseTime: 100,
-	}
-
-	s.evaluateAlerts(result, []models.Alert{})
-}
-
 // TestCheckEndpointInvalidHeaderJSON tests endpoint with malformed headers JSON
 func TestCheckEndpointInvalidHeaderJSON(t *testing.T) {
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
@@ -505,7 +547,7 @@ func TestCheckEndpointInvalidHeaderJSON(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{
 		ID:      15,
 		URL:     url,
@@ -513,7 +555,7 @@ func TestCheckEndpointInvalidHeaderJSON(t *testing.T) {
 		Headers: []byte("{invalid json"),
 	}
 
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.StatusCode == nil {
 		t.Fatalf("expected valid status code even with bad headers JSON")
 	}
@@ -521,14 +563,14 @@ func TestCheckEndpointInvalidHeaderJSON(t *testing.T) {
 
 // TestCheckEndpointRequestCreationError tests handling of NewRequest errors
 func TestCheckEndpointRequestCreationError(t *testing.T) {
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{
 		ID:      16,
 		URL:     "ht!tp://[invalid:url",
 		Timeout: 1000,
 	}
 
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	if res.StatusCode != nil {
 		t.Fatalf("expected nil status code on request creation error")
 	}
@@ -549,11 +591,11 @@ func TestCheckEndpointCheckedAtTime(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 20, URL: url, Timeout: 1000}
 	
 	beforeCheck := time.Now()
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 	afterCheck := time.Now()
 
 	if res.CheckedAt.Before(beforeCheck) || res.CheckedAt.After(afterCheck) {
@@ -561,29 +603,6 @@ func TestCheckEndpointCheckedAtTime(t *testing.T) {
 	}
 }
 
-// TestEvaluateAlertsStatusCodeNilNoExpectedCodes tests status code alert with nil status and no alert on failure
-func TestEvaluateAlertsStatusCodeNilNoAlertOnFailure(t *testing.T) {
-	s := &Service{
-		client: &http.Client{Timeout: 5 * time.Second},
-	}
-	threshold := map[string]interface{}{
-		"expected_codes":   []interface{}{float64(200), float64(201)},
-		"alert_on_failure": false,
-	}
-	alert := models.Alert{
-		ID:        8,
-		AlertType: "status_code",
-		Threshold: mustMarshal(threshold),
-	}
-
-	result := models.MonitoringResult{
-		EndpointID: 1,
-		StatusCode: nil,
-	}
-
-	s.evaluateAlerts(result, []models.Alert{alert})
-}
-
 // TestCheckEndpointZeroResponseTime tests that response time is recorded correctly
 func TestCheckEndpointZeroResponseTime(t *testing.T) {
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
@@ -596,34 +615,15 @@ func TestCheckEndpointZeroResponseTime(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 21, URL: url, Timeout: 5000}
-	res := s.checkEndpoint(endpoint)
+	res := s.checkEndpoint(context.Background(), endpoint)
 
 	if res.ResponseTime < 0 {
 		t.Fatalf("expected non-negative response time, got %d", res.ResponseTime)
 	}
 }
 
-// TestEvaluateAlertsInvalidThresholdJSON tests handling of invalid JSON in alert threshold
-func TestEvaluateAlertsInvalidThresholdJSON(t *testing.T) {
-	s := &Service{
-		client: &http.Client{Timeout: 5 * time.Second},
-	}
-	alert := models.Alert{
-		ID:        9,
-		AlertType: "response_time",
-		Threshold: []byte("invalid json"),
-	}
-
-	result := models.MonitoringResult{
-		EndpointID:   1,
-		ResponseTime: 100,
-	}
-
-	s.evaluateAlerts(result, []models.Alert{alert})
-}
-
 // TestCheckEndpointHTTPMethods tests that checkEndpoint uses GET method
 func TestCheckEndpointHTTPMethods(t *testing.T) {
 	listener, _ := net.Listen("tcp", "127.0.0.1:0")
@@ -638,17 +638,159 @@ func TestCheckEndpointHTTPMethods(t *testing.T) {
 	defer ts.Close()
 
 	url := "http://" + listener.Addr().String() + "/"
-	s := &Service{}
+	s := newTestService()
 	endpoint := models.Endpoint{ID: 22, URL: url, Timeout: 1000}
-	s.checkEndpoint(endpoint)
+	s.checkEndpoint(context.Background(), endpoint)
 
 	if len(requestMethods) != 1 || requestMethods[0] != "GET" {
 		t.Fatalf("expected GET request, got %v", requestMethods)
 	}
 }
 
-// Helper function to marshal JSON
-func mustMarshal(v interface{}) []byte {
-	b, _ := json.Marshal(v)
-	return b
+// TestSinksFanOutResult tests that a saved result and the status change it
+// caused reach every sink under one idempotency key
+func TestSinksFanOutResult(t *testing.T) {
+	var mu sync.Mutex
+	var symfonyKeys []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		symfonyKeys = append(symfonyKeys, r.Header.Get("Idempotency-Key"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	s := newTestService()
+	s.hub = websocket.NewHub()
+	s.rdb = unavailableRedis(t)
+	s.symphonyAPIURL = ts.URL
+
+	failed := 503
+	results := []models.MonitoringResult{{EndpointID: 1, StatusCode: &failed, Location: "eu", CheckedAt: time.Now()}}
+	statuses := map[int]models.EndpointStatus{1: {EndpointID: 1, Status: models.StatusDown}}
+	changes := []models.EndpointStatus{{EndpointID: 1, Status: models.StatusDown, PreviousStatus: models.StatusUp}}
+
+	messages, err := outboxMessages(results, statuses, changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sinks := make(map[string]outbox.Sink)
+	for _, sink := range s.sinks() {
+		sinks[sink.Name()] = sink
+	}
+
+	resultKey := messages[0].Key
+	delivered := make(map[string]bool)
+	for _, msg := range messages {
+		sink, ok := sinks[msg.Sink]
+		if !ok {
+			t.Fatalf("no sink registered for %s", msg.Sink)
+		}
+
+		err := sink.Deliver(context.Background(), msg)
+		switch msg.Sink {
+		case SinkStream, SinkStatusStream:
+			// Redis is down; the relay must retry these
+			if err == nil || outbox.IsPermanent(err) {
+				t.Errorf("%s: expected a retryable error, got %v", msg.Sink, err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("%s: %v", msg.Sink, err)
+			}
+		}
+		delivered[msg.Sink] = true
+
+		if msg.Sink != SinkStatusStream && msg.Key != resultKey {
+			t.Errorf("%s: key %q differs from the result's %q", msg.Sink, msg.Key, resultKey)
+		}
+	}
+
+	for _, name := range []string{SinkStream, SinkSymfony, SinkStatusStream} {
+		if !delivered[name] {
+			t.Errorf("expected a delivery to %s", name)
+		}
+	}
+	if len(symfonyKeys) != 1 || symfonyKeys[0] != resultKey {
+		t.Errorf("expected Symfony to receive key %q once, got %v", resultKey, symfonyKeys)
+	}
+}
+
+// TestSinksRejectUndecodablePayload tests that a payload no sink can decode
+// is dead on arrival instead of retried forever
+func TestSinksRejectUndecodablePayload(t *testing.T) {
+	s := newTestService()
+	s.hub = websocket.NewHub()
+
+	for _, sink := range s.sinks() {
+		err := sink.Deliver(context.Background(), outbox.Message{Sink: sink.Name(), Key: "key", Payload: []byte("not json")})
+		if !outbox.IsPermanent(err) {
+			t.Errorf("%s: expected a permanent error, got %v", sink.Name(), err)
+		}
+	}
+}
+
+// newTestService returns a Service with what checks and deliveries need
+func newTestService() *Service {
+	log := logger.New()
+	return &Service{
+		client:  &http.Client{Timeout: 5 * time.Second},
+		checker: NewEndpointChecker(log),
+		symfony: resilience.Policy(),
+		events:  events.DefaultStreamConfig(),
+		log:     log,
+	}
+}
+
+// getTestRedis returns a client for a local Redis, skipping the test when
+// there is none
+func getTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{
+		Addr: "localhost:6379",
+	})
+	t.Cleanup(func() { rdb.Close() })
+
+	if _, err := rdb.Ping(context.Background()).Result(); err != nil {
+		t.Skip("Redis not available, skipping test")
+	}
+	return rdb
+}
+
+// unavailableRedis returns a client for an address nothing listens on
+func unavailableRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: closedAddr(t), MaxRetries: -1})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// closedAddr returns a local address nothing listens on
+func closedAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to create listener: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// assertLatestEvent checks that the newest check event in the stream has
+// the given ID
+func assertLatestEvent(t *testing.T, rdb *redis.Client, eventID string) {
+	t.Helper()
+	entries, err := rdb.XRevRangeN(context.Background(), MetricsStream, "+", "-", 1).Result()
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+	envelope, err := events.FromStreamValues(entries[0].Values)
+	if err != nil {
+		t.Fatalf("failed to decode event: %v", err)
+	}
+	if envelope.ID != eventID {
+		t.Errorf("event ID: got %q, want %q", envelope.ID, eventID)
+	}
 }
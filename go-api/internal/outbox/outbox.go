@@ -0,0 +1,112 @@
+// Package outbox implements a transactional outbox: side effects are
+// written to the database in the same transaction as the data they belong
+// to, and a relay delivers them to their sinks at least once.
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Status is a message's delivery status
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	// StatusDead messages failed permanently or ran out of attempts; they
+	// are kept for inspection and not retried
+	StatusDead Status = "dead"
+)
+
+// Message is one pending delivery to one sink
+type Message struct {
+	ID   int64
+	Sink string
+
+	// Key identifies the message across retries; sinks pass it on so
+	// receivers can drop duplicates
+	Key string
+
+	Payload   json.RawMessage
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// Sink delivers messages to one destination. Deliver must be safe to call
+// again for a message it already delivered.
+type Sink interface {
+	Name() string
+	Deliver(ctx context.Context, msg Message) error
+}
+
+// SinkFunc adapts a function to a Sink
+type SinkFunc struct {
+	SinkName string
+	Fn       func(ctx context.Context, msg Message) error
+}
+
+// Name returns the sink name
+func (s SinkFunc) Name() string { return s.SinkName }
+
+// Deliver calls Fn
+func (s SinkFunc) Deliver(ctx context.Context, msg Message) error { return s.Fn(ctx, msg) }
+
+// permanentError marks a failure retrying can't fix
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps a delivery error so the message is marked dead instead of
+// retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was wrapped with Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Backlog counts a sink's undelivered messages
+type Backlog struct {
+	Sink    string `json:"sink"`
+	Pending int64  `json:"pending"`
+	Dead    int64  `json:"dead"`
+
+	// OldestPending is when the oldest pending message was written
+	OldestPending time.Time `json:"oldest_pending,omitempty"`
+}
+
+// Store persists outbox messages
+type Store interface {
+	// Enqueue writes messages as part of tx, so they are committed or
+	// rolled back with the data they describe
+	Enqueue(ctx context.Context, tx *sql.Tx, messages []Message) error
+
+	// Claim returns up to limit pending messages that are due and hides
+	// them from other claims for lease. A message whose relay dies before
+	// it is marked becomes due again when the lease expires.
+	Claim(ctx context.Context, limit int, lease time.Duration) ([]Message, error)
+
+	// MarkDelivered records a successful delivery
+	MarkDelivered(ctx context.Context, id int64) error
+
+	// MarkFailed records a failed attempt; the message is retried after
+	// retryIn unless dead is set
+	MarkFailed(ctx context.Context, id int64, reason string, retryIn time.Duration, dead bool) error
+
+	// Backlog counts undelivered messages per sink
+	Backlog(ctx context.Context) ([]Backlog, error)
+
+	// PruneDelivered deletes messages delivered before before
+	PruneDelivered(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,367 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"api-monitor-go/internal/logger"
+)
+
+// RelayConfig holds outbox relay configuration
+type RelayConfig struct {
+	// BatchSize is the most messages claimed at once; Concurrency of them
+	// are delivered in parallel
+	BatchSize   int
+	Concurrency int
+
+	// PollInterval is how often the outbox is checked when nobody calls
+	// Notify
+	PollInterval time.Duration
+
+	// Lease hides a claimed message from other relays; it must be longer
+	// than DeliveryTimeout
+	Lease           time.Duration
+	DeliveryTimeout time.Duration
+
+	// Failed deliveries are retried after InitialBackoff, doubling up to
+	// MaxBackoff, until MaxAttempts attempts have been made
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// Delivered messages are deleted after Retention, checked every
+	// PruneInterval
+	Retention     time.Duration
+	PruneInterval time.Duration
+}
+
+// DefaultRelayConfig returns default relay configuration
+func DefaultRelayConfig() RelayConfig {
+	return RelayConfig{
+		BatchSize:       100,
+		Concurrency:     10,
+		PollInterval:    time.Second,
+		Lease:           time.Minute,
+		DeliveryTimeout: 10 * time.Second,
+		MaxAttempts:     10,
+		InitialBackoff:  time.Second,
+		MaxBackoff:      5 * time.Minute,
+		Retention:       24 * time.Hour,
+		PruneInterval:   10 * time.Minute,
+	}
+}
+
+// SinkStats describes deliveries to one sink. Pending, Dead and
+// OldestPendingAge come from the store and cover every relay; the counters
+// are this relay's since it started.
+type SinkStats struct {
+	Sink             string        `json:"sink"`
+	Pending          int64         `json:"pending"`
+	Dead             int64         `json:"dead"`
+	OldestPendingAge time.Duration `json:"oldest_pending_age"`
+
+	Delivered       int64     `json:"delivered"`
+	Failed          int64     `json:"failed"`
+	DeadLettered    int64     `json:"dead_lettered"`
+	LastDeliveredAt time.Time `json:"last_delivered_at,omitempty"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// Relay delivers outbox messages to their sinks at least once
+type Relay struct {
+	store  Store
+	config RelayConfig
+	sinks  map[string]Sink
+	log    *logger.Logger
+
+	wake chan struct{}
+
+	mu    sync.Mutex
+	stats map[string]*SinkStats
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// NewRelay creates a relay for sinks; call Start to run it
+func NewRelay(store Store, config RelayConfig, sinks ...Sink) *Relay {
+	defaults := DefaultRelayConfig()
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaults.Concurrency
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaults.PollInterval
+	}
+	if config.DeliveryTimeout <= 0 {
+		config.DeliveryTimeout = defaults.DeliveryTimeout
+	}
+	if config.Lease <= config.DeliveryTimeout {
+		config.Lease = 2 * config.DeliveryTimeout
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaults.MaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = defaults.InitialBackoff
+	}
+	if config.MaxBackoff < config.InitialBackoff {
+		config.MaxBackoff = defaults.MaxBackoff
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaults.Retention
+	}
+	if config.PruneInterval <= 0 {
+		config.PruneInterval = defaults.PruneInterval
+	}
+
+	r := &Relay{
+		store:  store,
+		config: config,
+		sinks:  make(map[string]Sink, len(sinks)),
+		log:    logger.New().WithField("component", "outbox-relay"),
+		wake:   make(chan struct{}, 1),
+		stats:  make(map[string]*SinkStats),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	for _, sink := range sinks {
+		r.sinks[sink.Name()] = sink
+		r.stats[sink.Name()] = &SinkStats{Sink: sink.Name()}
+	}
+	return r
+}
+
+// Start runs the relay in the background until Stop is called
+func (r *Relay) Start() {
+	r.startOnce.Do(func() {
+		go r.loop()
+	})
+}
+
+// Stop stops the relay and waits for in-flight deliveries to be marked.
+// Messages it didn't get to stay pending.
+func (r *Relay) Stop(ctx context.Context) error {
+	r.stopOnce.Do(func() {
+		close(r.quit)
+	})
+
+	// If Start was never called there is no loop to wait for
+	r.startOnce.Do(func() {
+		close(r.done)
+	})
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Notify wakes the relay after messages were committed, so they are
+// delivered without waiting for the next poll
+func (r *Relay) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *Relay) loop() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	ctx := context.Background()
+	var lastPrune time.Time
+
+	for {
+		// Keep going while full batches come back
+		for {
+			n, err := r.RunOnce(ctx)
+			if err != nil {
+				r.log.Warnf("outbox relay run failed: %v", err)
+			}
+			if err != nil || n < r.config.BatchSize || r.stopping() {
+				break
+			}
+		}
+
+		if time.Since(lastPrune) >= r.config.PruneInterval {
+			if n, err := r.store.PruneDelivered(ctx, time.Now().Add(-r.config.Retention)); err != nil {
+				r.log.Warnf("failed to prune delivered outbox messages: %v", err)
+			} else if n > 0 {
+				r.log.Debugf("pruned %d delivered outbox messages", n)
+			}
+			lastPrune = time.Now()
+		}
+
+		select {
+		case <-r.quit:
+			return
+		case <-r.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Relay) stopping() bool {
+	select {
+	case <-r.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunOnce claims one batch of due messages and delivers them. It returns
+// how many were claimed.
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	messages, err := r.store.Claim(ctx, r.config.BatchSize, r.config.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	slots := make(chan struct{}, r.config.Concurrency)
+	var wg sync.WaitGroup
+	for _, msg := range messages {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(msg Message) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			r.deliver(ctx, msg)
+		}(msg)
+	}
+	wg.Wait()
+
+	return len(messages), nil
+}
+
+// deliver sends one message and records the outcome
+func (r *Relay) deliver(ctx context.Context, msg Message) {
+	err := r.send(ctx, msg)
+	if err == nil {
+		if err := r.store.MarkDelivered(ctx, msg.ID); err != nil {
+			// The lease expires and the message is delivered again
+			r.log.Errorf("failed to mark outbox message %d delivered: %v", msg.ID, err)
+			return
+		}
+		r.record(msg.Sink, func(s *SinkStats) {
+			s.Delivered++
+			s.LastDeliveredAt = time.Now()
+		})
+		return
+	}
+
+	dead := IsPermanent(err) || msg.Attempts >= r.config.MaxAttempts
+	retryIn := r.backoff(msg.Attempts)
+	if err := r.store.MarkFailed(ctx, msg.ID, err.Error(), retryIn, dead); err != nil {
+		r.log.Errorf("failed to mark outbox message %d failed: %v", msg.ID, err)
+	}
+
+	r.record(msg.Sink, func(s *SinkStats) {
+		s.Failed++
+		s.LastError = err.Error()
+		if dead {
+			s.DeadLettered++
+		}
+	})
+
+	log := r.log.WithFields(map[string]interface{}{
+		"sink":     msg.Sink,
+		"key":      msg.Key,
+		"attempts": msg.Attempts,
+	})
+	if dead {
+		log.Errorf("outbox delivery failed permanently: %v", err)
+	} else {
+		log.Warnf("outbox delivery failed, retrying in %v: %v", retryIn, err)
+	}
+}
+
+// send calls the message's sink with the delivery timeout
+func (r *Relay) send(ctx context.Context, msg Message) (err error) {
+	sink, ok := r.sinks[msg.Sink]
+	if !ok {
+		return Permanent(fmt.Errorf("unknown sink '%s'", msg.Sink))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.DeliveryTimeout)
+	defer cancel()
+
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("sink panicked: %v", rec)
+		}
+	}()
+	return sink.Deliver(ctx, msg)
+}
+
+// backoff returns the delay before the attempt after attempts
+func (r *Relay) backoff(attempts int) time.Duration {
+	delay := r.config.InitialBackoff
+	for i := 1; i < attempts && delay < r.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.config.MaxBackoff {
+		delay = r.config.MaxBackoff
+	}
+	return delay
+}
+
+func (r *Relay) record(sink string, update func(*SinkStats)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats, ok := r.stats[sink]
+	if !ok {
+		stats = &SinkStats{Sink: sink}
+		r.stats[sink] = stats
+	}
+	update(stats)
+}
+
+// Stats returns the backlog and delivery counters of every sink, sorted
+// by sink name
+func (r *Relay) Stats(ctx context.Context) ([]SinkStats, error) {
+	backlog, err := r.store.Backlog(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	merged := make(map[string]SinkStats, len(r.stats))
+	for name, stats := range r.stats {
+		merged[name] = *stats
+	}
+	r.mu.Unlock()
+
+	now := time.Now()
+	for _, b := range backlog {
+		stats := merged[b.Sink]
+		stats.Sink = b.Sink
+		stats.Pending = b.Pending
+		stats.Dead = b.Dead
+		if !b.OldestPending.IsZero() {
+			stats.OldestPendingAge = now.Sub(b.OldestPending)
+		}
+		merged[b.Sink] = stats
+	}
+
+	result := make([]SinkStats, 0, len(merged))
+	for _, stats := range merged {
+		result = append(result, stats)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Sink < result[j].Sink })
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresStore keeps messages in the outbox_messages table. Claims use
+// FOR UPDATE SKIP LOCKED, so several relays can share the table.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a new PostgreSQL outbox store
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// Enqueue copies messages into the outbox within tx
+func (p *PostgresStore) Enqueue(ctx context.Context, tx *sql.Tx, messages []Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("outbox_messages", "sink", "idempotency_key", "payload"))
+	if err != nil {
+		return fmt.Errorf("failed to prepare outbox copy: %w", err)
+	}
+
+	for _, msg := range messages {
+		if _, err := stmt.ExecContext(ctx, msg.Sink, msg.Key, string(msg.Payload)); err != nil {
+			stmt.Close()
+			return fmt.Errorf("failed to copy outbox message: %w", err)
+		}
+	}
+
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return fmt.Errorf("failed to flush outbox copy: %w", err)
+	}
+
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to close outbox copy: %w", err)
+	}
+	return nil
+}
+
+// Claim leases due messages, oldest first, counting the attempt
+func (p *PostgresStore) Claim(ctx context.Context, limit int, lease time.Duration) ([]Message, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		UPDATE outbox_messages o
+		SET attempts = o.attempts + 1,
+		    next_attempt_at = NOW() + make_interval(secs => $2)
+		FROM (
+			SELECT id FROM outbox_messages
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		) due
+		WHERE o.id = due.id
+		RETURNING o.id, o.sink, o.idempotency_key, o.payload, o.attempts, o.created_at
+	`, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox messages: %w", err)
+	}
+	defer rows.Close()
+
+	var messages []Message
+	for rows.Next() {
+		var msg Message
+		if err := rows.Scan(&msg.ID, &msg.Sink, &msg.Key, &msg.Payload, &msg.Attempts, &msg.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+// MarkDelivered records a successful delivery
+func (p *PostgresStore) MarkDelivered(ctx context.Context, id int64) error {
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE outbox_messages
+		SET status = 'delivered', delivered_at = NOW(), last_error = NULL
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message delivered: %w", err)
+	}
+	return nil
+}
+
+// MarkFailed records a failed attempt and when to retry it
+func (p *PostgresStore) MarkFailed(ctx context.Context, id int64, reason string, retryIn time.Duration, dead bool) error {
+	status := StatusPending
+	if dead {
+		status = StatusDead
+	}
+
+	_, err := p.db.ExecContext(ctx, `
+		UPDATE outbox_messages
+		SET status = $2, last_error = $3, next_attempt_at = NOW() + make_interval(secs => $4)
+		WHERE id = $1
+	`, id, string(status), reason, retryIn.Seconds())
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox message failed: %w", err)
+	}
+	return nil
+}
+
+// Backlog counts pending and dead messages per sink
+func (p *PostgresStore) Backlog(ctx context.Context) ([]Backlog, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT sink,
+		       COUNT(*) FILTER (WHERE status = 'pending'),
+		       COUNT(*) FILTER (WHERE status = 'dead'),
+		       MIN(created_at) FILTER (WHERE status = 'pending')
+		FROM outbox_messages
+		WHERE status <> 'delivered'
+		GROUP BY sink
+		ORDER BY sink
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox backlog: %w", err)
+	}
+	defer rows.Close()
+
+	var backlog []Backlog
+	for rows.Next() {
+		var b Backlog
+		var oldest sql.NullTime
+		if err := rows.Scan(&b.Sink, &b.Pending, &b.Dead, &oldest); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox backlog: %w", err)
+		}
+		b.OldestPending = oldest.Time
+		backlog = append(backlog, b)
+	}
+	return backlog, rows.Err()
+}
+
+// PruneDelivered deletes messages delivered before before
+func (p *PostgresStore) PruneDelivered(ctx context.Context, before time.Time) (int64, error) {
+	result, err := p.db.ExecContext(ctx,
+		`DELETE FROM outbox_messages WHERE status = 'delivered' AND delivered_at < $1`, before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune outbox messages: %w", err)
+	}
+	return result.RowsAffected()
+}
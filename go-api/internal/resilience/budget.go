@@ -0,0 +1,128 @@
+package resilience
+
+import (
+	"sync"
+	"time"
+)
+
+// RetryBudgetConfig holds retry budget configuration
+type RetryBudgetConfig struct {
+	// Ratio is the most retries allowed per call, e.g. 0.2 lets retries add
+	// at most 20% load on top of first attempts
+	Ratio float64
+
+	// MinRetries are allowed per Window regardless of Ratio, so rarely
+	// called dependencies can still retry
+	MinRetries int
+
+	// Window is how far back calls and retries are counted
+	Window time.Duration
+}
+
+// DefaultRetryBudgetConfig returns default retry budget configuration
+func DefaultRetryBudgetConfig() RetryBudgetConfig {
+	return RetryBudgetConfig{
+		Ratio:      0.2,
+		MinRetries: 10,
+		Window:     10 * time.Second,
+	}
+}
+
+// budgetBuckets is how many slices Window is counted in
+const budgetBuckets = 10
+
+type budgetBucket struct {
+	start   int64
+	calls   int
+	retries int
+}
+
+// RetryBudget caps retries as a share of calls across every caller sharing
+// it. When a dependency is down, callers stop retrying instead of
+// multiplying the load on it.
+type RetryBudget struct {
+	config RetryBudgetConfig
+	width  int64
+	clock  Clock
+
+	mu      sync.Mutex
+	buckets [budgetBuckets]budgetBucket
+}
+
+// NewRetryBudget creates a retry budget
+func NewRetryBudget(config RetryBudgetConfig) *RetryBudget {
+	defaults := DefaultRetryBudgetConfig()
+	if config.Ratio < 0 {
+		config.Ratio = 0
+	}
+	if config.MinRetries < 0 {
+		config.MinRetries = 0
+	}
+	if config.Window <= 0 {
+		config.Window = defaults.Window
+	}
+
+	width := int64(config.Window) / budgetBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &RetryBudget{config: config, width: width, clock: realClock{}}
+}
+
+// bucket returns the current bucket, clearing it if it is from an earlier
+// window. The caller holds mu.
+func (b *RetryBudget) bucket() *budgetBucket {
+	start := b.clock.Now().UnixNano() / b.width
+	bucket := &b.buckets[start%budgetBuckets]
+	if bucket.start != start {
+		*bucket = budgetBucket{start: start}
+	}
+	return bucket
+}
+
+// totals sums the buckets within the window. The caller holds mu.
+func (b *RetryBudget) totals() (calls, retries int) {
+	oldest := b.clock.Now().UnixNano()/b.width - budgetBuckets + 1
+	for _, bucket := range b.buckets {
+		if bucket.start >= oldest {
+			calls += bucket.calls
+			retries += bucket.retries
+		}
+	}
+	return calls, retries
+}
+
+// RecordCall counts a call's first attempt
+func (b *RetryBudget) RecordCall() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.bucket().calls++
+}
+
+// TryRetry spends one retry if the budget has room and reports whether it
+// did
+func (b *RetryBudget) TryRetry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	calls, retries := b.totals()
+	if float64(retries+1) > float64(b.config.MinRetries)+b.config.Ratio*float64(calls) {
+		return false
+	}
+	b.bucket().retries++
+	return true
+}
+
+// RetryBudgetStats counts calls and retries within the window
+type RetryBudgetStats struct {
+	Calls   int `json:"calls"`
+	Retries int `json:"retries"`
+}
+
+// Stats returns the calls and retries within the window
+func (b *RetryBudget) Stats() RetryBudgetStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	calls, retries := b.totals()
+	return RetryBudgetStats{Calls: calls, Retries: retries}
+}
@@ -0,0 +1,169 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Causes of a BulkheadError
+var (
+	ErrBulkheadFull    = errors.New("bulkhead is full")
+	ErrBulkheadTimeout = errors.New("timed out waiting for the bulkhead")
+)
+
+// BulkheadError is returned when the bulkhead rejects a call
+type BulkheadError struct {
+	name  string
+	cause error
+}
+
+func (e *BulkheadError) Error() string {
+	return fmt.Sprintf("bulkhead '%s' rejected the call: %v", e.name, e.cause)
+}
+
+// Unwrap returns ErrBulkheadFull or ErrBulkheadTimeout
+func (e *BulkheadError) Unwrap() error {
+	return e.cause
+}
+
+// BulkheadConfig holds bulkhead configuration
+type BulkheadConfig struct {
+	Name string
+
+	// MaxConcurrent calls run at once
+	MaxConcurrent int
+
+	// MaxQueue calls wait for a slot, each for at most QueueTimeout; calls
+	// beyond that are rejected at once. Zero QueueTimeout waits until the
+	// caller's context is done.
+	MaxQueue     int
+	QueueTimeout time.Duration
+}
+
+// DefaultBulkheadConfig returns default bulkhead configuration
+func DefaultBulkheadConfig() BulkheadConfig {
+	return BulkheadConfig{
+		MaxConcurrent: 10,
+		MaxQueue:      10,
+		QueueTimeout:  time.Second,
+	}
+}
+
+// BulkheadStats is a snapshot of a bulkhead
+type BulkheadStats struct {
+	Name          string `json:"name"`
+	MaxConcurrent int    `json:"max_concurrent"`
+	InFlight      int    `json:"in_flight"`
+	Queued        int    `json:"queued"`
+	Rejected      int64  `json:"rejected"`
+	TimedOut      int64  `json:"timed_out"`
+}
+
+// Bulkhead caps the concurrent calls to one dependency, so a slow
+// dependency can't take every goroutine and connection with it
+type Bulkhead struct {
+	config BulkheadConfig
+	clock  Clock
+	slots  chan struct{}
+
+	mu       sync.Mutex
+	queued   int
+	rejected int64
+	timedOut int64
+}
+
+// NewBulkhead creates a bulkhead
+func NewBulkhead(config BulkheadConfig) *Bulkhead {
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = DefaultBulkheadConfig().MaxConcurrent
+	}
+	if config.MaxQueue < 0 {
+		config.MaxQueue = 0
+	}
+
+	return &Bulkhead{
+		config: config,
+		clock:  realClock{},
+		slots:  make(chan struct{}, config.MaxConcurrent),
+	}
+}
+
+// Acquire waits for a slot and returns the function that gives it back
+func (b *Bulkhead) Acquire(ctx context.Context) (func(), error) {
+	select {
+	case b.slots <- struct{}{}:
+		return b.releaser(), nil
+	default:
+	}
+
+	b.mu.Lock()
+	if b.queued >= b.config.MaxQueue {
+		b.rejected++
+		b.mu.Unlock()
+		return nil, &BulkheadError{name: b.config.Name, cause: ErrBulkheadFull}
+	}
+	b.queued++
+	b.mu.Unlock()
+
+	defer func() {
+		b.mu.Lock()
+		b.queued--
+		b.mu.Unlock()
+	}()
+
+	var timeout <-chan time.Time
+	if b.config.QueueTimeout > 0 {
+		var stop func()
+		timeout, stop = b.clock.Timer(b.config.QueueTimeout)
+		defer stop()
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+		return b.releaser(), nil
+	case <-timeout:
+		b.mu.Lock()
+		b.timedOut++
+		b.mu.Unlock()
+		return nil, &BulkheadError{name: b.config.Name, cause: ErrBulkheadTimeout}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releaser returns a function that gives a slot back once, however often
+// it is called
+func (b *Bulkhead) releaser() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-b.slots })
+	}
+}
+
+// Execute runs fn once a slot is free
+func (b *Bulkhead) Execute(ctx context.Context, fn func(context.Context) error) error {
+	release, err := b.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return fn(ctx)
+}
+
+// Stats returns a snapshot of the bulkhead
+func (b *Bulkhead) Stats() BulkheadStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return BulkheadStats{
+		Name:          b.config.Name,
+		MaxConcurrent: b.config.MaxConcurrent,
+		InFlight:      len(b.slots),
+		Queued:        b.queued,
+		Rejected:      b.rejected,
+		TimedOut:      b.timedOut,
+	}
+}
@@ -0,0 +1,96 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestBulkhead(config BulkheadConfig) (*Bulkhead, *fakeClock) {
+	clock := newFakeClock()
+	b := NewBulkhead(config)
+	b.clock = clock
+	return b, clock
+}
+
+func TestBulkheadRejectsWhenQueueIsFull(t *testing.T) {
+	b, _ := newTestBulkhead(BulkheadConfig{Name: "symfony", MaxConcurrent: 2, MaxQueue: 0})
+
+	release1, err1 := b.Acquire(context.Background())
+	release2, err2 := b.Acquire(context.Background())
+	if err1 != nil || err2 != nil {
+		t.Fatalf("expected two slots, got %v, %v", err1, err2)
+	}
+
+	if _, err := b.Acquire(context.Background()); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("expected the third call to be rejected, got %v", err)
+	}
+
+	// Releasing twice gives back one slot only
+	release1()
+	release1()
+	if stats := b.Stats(); stats.InFlight != 1 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	release2()
+}
+
+func TestBulkheadQueueTimeout(t *testing.T) {
+	b, clock := newTestBulkhead(BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	release, _ := b.Acquire(context.Background())
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := b.Acquire(context.Background())
+		result <- err
+	}()
+	waitForTimers(t, clock, 1)
+
+	// A second waiter doesn't fit in the queue
+	if _, err := b.Acquire(context.Background()); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("expected a full queue, got %v", err)
+	}
+
+	clock.Advance(time.Second)
+	if err := <-result; !errors.Is(err, ErrBulkheadTimeout) {
+		t.Fatalf("expected the queued call to time out, got %v", err)
+	}
+	if stats := b.Stats(); stats.TimedOut != 1 || stats.Queued != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	release()
+}
+
+func TestBulkheadQueuedCallGetsReleasedSlot(t *testing.T) {
+	b, clock := newTestBulkhead(BulkheadConfig{MaxConcurrent: 1, MaxQueue: 1, QueueTimeout: time.Second})
+
+	release, _ := b.Acquire(context.Background())
+
+	result := make(chan error, 1)
+	go func() {
+		result <- b.Execute(context.Background(), func(ctx context.Context) error { return nil })
+	}()
+	waitForTimers(t, clock, 1)
+
+	release()
+	if err := <-result; err != nil {
+		t.Fatalf("expected the queued call to run, got %v", err)
+	}
+	if clock.Timers() != 0 {
+		t.Error("expected the queue timer to be stopped")
+	}
+}
+
+// waitForTimers waits until n goroutines are waiting on the fake clock
+func waitForTimers(t *testing.T, clock *fakeClock, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for clock.Timers() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d timers, got %d", n, clock.Timers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -17,7 +17,13 @@ const (
 	StateHalfOpen CircuitBreakerState = "half-open"
 )
 
-// CircuitBreakerError is returned when circuit breaker is open
+// Causes of a CircuitBreakerError
+var (
+	ErrCircuitOpen   = errors.New("circuit breaker is open")
+	ErrTooManyProbes = errors.New("circuit breaker is half-open and all probe calls are in flight")
+)
+
+// CircuitBreakerError is returned when the circuit breaker rejects a call
 type CircuitBreakerError struct {
 	name  string
 	cause error
@@ -27,161 +33,463 @@ func (e *CircuitBreakerError) Error() string {
 	return fmt.Sprintf("circuit breaker '%s' is open: %v", e.name, e.cause)
 }
 
+// Unwrap returns ErrCircuitOpen or ErrTooManyProbes
+func (e *CircuitBreakerError) Unwrap() error {
+	return e.cause
+}
+
+// WindowType selects how the sliding window is measured
+type WindowType string
+
+const (
+	// WindowCount keeps the outcomes of the last WindowSize calls
+	WindowCount WindowType = "count"
+	// WindowTime keeps the outcomes of the calls made within WindowDuration
+	WindowTime WindowType = "time"
+)
+
 // CircuitBreakerConfig holds circuit breaker configuration
 type CircuitBreakerConfig struct {
-	Name          string
-	MaxFailures   int
-	Timeout       time.Duration
+	Name string
+
+	// MaxFailures consecutive failures open the breaker whatever the rates
+	MaxFailures int
+
+	// Timeout bounds each call made with ExecuteWithContext; zero leaves
+	// calls unbounded
+	Timeout time.Duration
+
+	// ResetInterval is how long the breaker stays open before probing
 	ResetInterval time.Duration
+
+	// The rates are computed over a sliding window and only once it holds
+	// MinimumCalls calls
+	WindowType     WindowType
+	WindowSize     int
+	WindowDuration time.Duration
+	MinimumCalls   int
+
+	// FailureRateThreshold opens the breaker once this share of calls
+	// failed; zero disables it
+	FailureRateThreshold float64
+
+	// Calls taking SlowCallThreshold or longer are slow, and
+	// SlowCallRateThreshold opens the breaker once this share of calls were
+	// slow; zero disables either
+	SlowCallThreshold     time.Duration
+	SlowCallRateThreshold float64
+
+	// HalfOpenProbes calls are let through while half-open. The breaker
+	// closes once they all succeed and opens again on the first failure.
+	HalfOpenProbes int
+
+	// OnStateChange is called after every transition, outside the lock
+	OnStateChange func(name string, from, to CircuitBreakerState)
+}
+
+// DefaultCircuitBreakerConfig returns default circuit breaker configuration
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		MaxFailures:          5,
+		ResetInterval:        60 * time.Second,
+		WindowType:           WindowCount,
+		WindowSize:           20,
+		WindowDuration:       time.Minute,
+		MinimumCalls:         10,
+		FailureRateThreshold: 0.5,
+		HalfOpenProbes:       2,
+	}
+}
+
+// CircuitBreakerStats is a snapshot of a circuit breaker. The call counts
+// and rates cover the sliding window.
+type CircuitBreakerStats struct {
+	Name                string              `json:"name"`
+	State               CircuitBreakerState `json:"state"`
+	Calls               int                 `json:"calls"`
+	Failures            int                 `json:"failures"`
+	SlowCalls           int                 `json:"slow_calls"`
+	FailureRate         float64             `json:"failure_rate"`
+	SlowCallRate        float64             `json:"slow_call_rate"`
+	ConsecutiveFailures int                 `json:"consecutive_failures"`
+	Rejected            int64               `json:"rejected"`
+	StateChangedAt      time.Time           `json:"state_changed_at,omitempty"`
 }
 
 // CircuitBreaker implements the circuit breaker pattern
 type CircuitBreaker struct {
-	config       CircuitBreakerConfig
-	state        CircuitBreakerState
-	failures      int
-	lastFailTime  time.Time
-	successCount  int
-	mu            sync.RWMutex
+	config CircuitBreakerConfig
+	clock  Clock
+
+	mu             sync.Mutex
+	state          CircuitBreakerState
+	generation     uint64
+	window         slidingWindow
+	consecutive    int
+	openedAt       time.Time
+	stateChangedAt time.Time
+	probes         int
+	probeSuccesses int
+	rejected       int64
+}
+
+// stateChange is a transition waiting to be reported
+type stateChange struct {
+	from, to CircuitBreakerState
 }
 
 // NewCircuitBreaker creates a new circuit breaker
 func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	defaults := DefaultCircuitBreakerConfig()
 	if config.MaxFailures <= 0 {
-		config.MaxFailures = 5
-	}
-	if config.Timeout <= 0 {
-		config.Timeout = 30 * time.Second
+		config.MaxFailures = defaults.MaxFailures
 	}
 	if config.ResetInterval <= 0 {
-		config.ResetInterval = 60 * time.Second
+		config.ResetInterval = defaults.ResetInterval
+	}
+	if config.WindowType == "" {
+		config.WindowType = defaults.WindowType
+	}
+	if config.WindowSize <= 0 {
+		config.WindowSize = defaults.WindowSize
+	}
+	if config.WindowDuration <= 0 {
+		config.WindowDuration = defaults.WindowDuration
+	}
+	if config.MinimumCalls <= 0 {
+		config.MinimumCalls = defaults.MinimumCalls
+	}
+	if config.HalfOpenProbes <= 0 {
+		config.HalfOpenProbes = defaults.HalfOpenProbes
 	}
 
-	return &CircuitBreaker{
+	cb := &CircuitBreaker{
 		config: config,
+		clock:  realClock{},
 		state:  StateClosed,
 	}
+	if config.WindowType == WindowTime {
+		cb.window = newTimeWindow(config.WindowDuration)
+	} else {
+		cb.window = newCountWindow(config.WindowSize)
+	}
+	return cb
 }
 
-// Execute runs the given function with circuit breaker protection
+// Execute runs the given function with circuit breaker protection. fn
+// can't be canceled, so Timeout doesn't apply.
 func (cb *CircuitBreaker) Execute(fn func() error) error {
-	cb.mu.RLock()
-	state := cb.state
-	cb.mu.RUnlock()
-
-	// If open, check if we should try half-open
-	if state == StateOpen {
-		cb.mu.RLock()
-		timeSinceLastFail := time.Since(cb.lastFailTime)
-		cb.mu.RUnlock()
-
-		if timeSinceLastFail < cb.config.ResetInterval {
-			return &CircuitBreakerError{
-				name:  cb.config.Name,
-				cause: errors.New("circuit breaker is open"),
-			}
-		}
-
-		cb.mu.Lock()
-		cb.state = StateHalfOpen
-		cb.mu.Unlock()
-	}
+	return cb.execute(context.Background(), false, func(context.Context) error {
+		return fn()
+	})
+}
 
-	// Execute the function
-	err := fn()
+// ExecuteWithContext runs the function with context and circuit breaker protection
+func (cb *CircuitBreaker) ExecuteWithContext(ctx context.Context, fn func(context.Context) error) error {
+	return cb.execute(ctx, true, fn)
+}
 
+func (cb *CircuitBreaker) execute(ctx context.Context, bounded bool, fn func(context.Context) error) (err error) {
+	generation, err := cb.allow()
 	if err != nil {
-		cb.recordFailure()
 		return err
 	}
 
-	cb.recordSuccess()
-	return nil
+	if bounded && cb.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cb.config.Timeout)
+		defer cancel()
+	}
+
+	start := cb.clock.Now()
+	defer func() {
+		// A panicking call still gives its probe slot back
+		if r := recover(); r != nil {
+			cb.done(generation, fmt.Errorf("panic: %v", r), cb.clock.Now().Sub(start))
+			panic(r)
+		}
+	}()
+
+	err = fn(ctx)
+	cb.done(generation, err, cb.clock.Now().Sub(start))
+	return err
 }
 
-// recordFailure records a failure and updates circuit breaker state
-func (cb *CircuitBreaker) recordFailure() {
+// allow admits a call, moving an open breaker to half-open once
+// ResetInterval has passed. It returns the generation the call belongs to.
+func (cb *CircuitBreaker) allow() (uint64, error) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	now := cb.clock.Now()
+
+	var change *stateChange
+	if cb.state == StateOpen && now.Sub(cb.openedAt) >= cb.config.ResetInterval {
+		change = cb.transition(StateHalfOpen, now)
+	}
 
-	cb.failures++
-	cb.lastFailTime = time.Now()
-	cb.successCount = 0
+	var cause error
+	switch cb.state {
+	case StateOpen:
+		cause = ErrCircuitOpen
+	case StateHalfOpen:
+		if cb.probes >= cb.config.HalfOpenProbes {
+			cause = ErrTooManyProbes
+		} else {
+			cb.probes++
+		}
+	}
+	if cause != nil {
+		cb.rejected++
+	}
+	generation := cb.generation
+	cb.mu.Unlock()
 
-	if cb.state == StateHalfOpen || cb.failures >= cb.config.MaxFailures {
-		cb.state = StateOpen
+	cb.notify(change)
+	if cause != nil {
+		return generation, &CircuitBreakerError{name: cb.config.Name, cause: cause}
 	}
+	return generation, nil
 }
 
-// recordSuccess records a success and resets circuit breaker if in half-open state
-func (cb *CircuitBreaker) recordSuccess() {
+// done records the outcome of a call admitted in generation. Calls that
+// finish after the state changed don't count towards the new state.
+func (cb *CircuitBreaker) done(generation uint64, err error, duration time.Duration) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	if generation != cb.generation {
+		cb.mu.Unlock()
+		return
+	}
 
-	cb.successCount++
+	now := cb.clock.Now()
+	failed := err != nil
+	slow := cb.config.SlowCallThreshold > 0 && duration >= cb.config.SlowCallThreshold
+
+	var change *stateChange
+	switch cb.state {
+	case StateClosed:
+		cb.window.record(now, failed, slow)
+		if failed {
+			cb.consecutive++
+		} else {
+			cb.consecutive = 0
+		}
+		if cb.tripped(now) {
+			change = cb.transition(StateOpen, now)
+		}
 
-	if cb.state == StateHalfOpen {
-		// Reset circuit breaker after successful half-open test
-		if cb.successCount >= 2 {
-			cb.failures = 0
-			cb.state = StateClosed
-			cb.successCount = 0
+	case StateHalfOpen:
+		if failed || (slow && cb.config.SlowCallRateThreshold > 0) {
+			change = cb.transition(StateOpen, now)
+		} else if cb.probeSuccesses++; cb.probeSuccesses >= cb.config.HalfOpenProbes {
+			change = cb.transition(StateClosed, now)
 		}
-	} else if cb.state == StateClosed {
-		cb.failures = 0
+	}
+	cb.mu.Unlock()
+
+	cb.notify(change)
+}
+
+// tripped reports whether a closed breaker should open. The caller holds mu.
+func (cb *CircuitBreaker) tripped(now time.Time) bool {
+	if cb.consecutive >= cb.config.MaxFailures {
+		return true
+	}
+
+	totals := cb.window.totals(now)
+	if totals.calls < cb.config.MinimumCalls {
+		return false
+	}
+	if cb.config.FailureRateThreshold > 0 && totals.failureRate() >= cb.config.FailureRateThreshold {
+		return true
+	}
+	return cb.config.SlowCallRateThreshold > 0 && totals.slowCallRate() >= cb.config.SlowCallRateThreshold
+}
+
+// transition moves to state to. The caller holds mu and reports the
+// returned change once it has released it.
+func (cb *CircuitBreaker) transition(to CircuitBreakerState, now time.Time) *stateChange {
+	from := cb.state
+	cb.state = to
+	cb.generation++
+	cb.stateChangedAt = now
+
+	switch to {
+	case StateOpen:
+		cb.openedAt = now
+	case StateHalfOpen:
+		cb.probes = 0
+		cb.probeSuccesses = 0
+	case StateClosed:
+		cb.window.reset()
+		cb.consecutive = 0
+	}
+
+	if from == to {
+		return nil
+	}
+	return &stateChange{from: from, to: to}
+}
+
+func (cb *CircuitBreaker) notify(change *stateChange) {
+	if change != nil && cb.config.OnStateChange != nil {
+		cb.config.OnStateChange(cb.config.Name, change.from, change.to)
 	}
 }
 
 // GetState returns current circuit breaker state
 func (cb *CircuitBreaker) GetState() CircuitBreakerState {
-	cb.mu.RLock()
-	defer cb.mu.RUnlock()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
 	return cb.state
 }
 
+// Stats returns a snapshot of the circuit breaker
+func (cb *CircuitBreaker) Stats() CircuitBreakerStats {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	totals := cb.window.totals(cb.clock.Now())
+	return CircuitBreakerStats{
+		Name:                cb.config.Name,
+		State:               cb.state,
+		Calls:               totals.calls,
+		Failures:            totals.failures,
+		SlowCalls:           totals.slow,
+		FailureRate:         totals.failureRate(),
+		SlowCallRate:        totals.slowCallRate(),
+		ConsecutiveFailures: cb.consecutive,
+		Rejected:            cb.rejected,
+		StateChangedAt:      cb.stateChangedAt,
+	}
+}
+
 // Reset manually resets the circuit breaker
 func (cb *CircuitBreaker) Reset() {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	change := cb.transition(StateClosed, cb.clock.Now())
+	cb.openedAt = time.Time{}
+	cb.mu.Unlock()
 
-	cb.state = StateClosed
-	cb.failures = 0
-	cb.successCount = 0
-	cb.lastFailTime = time.Time{}
+	cb.notify(change)
 }
 
-// ExecuteWithContext runs the function with context and circuit breaker protection
-func (cb *CircuitBreaker) ExecuteWithContext(ctx context.Context, fn func(context.Context) error) error {
-	cb.mu.RLock()
-	state := cb.state
-	cb.mu.RUnlock()
-
-	// If open, check if we should try half-open
-	if state == StateOpen {
-		cb.mu.RLock()
-		timeSinceLastFail := time.Since(cb.lastFailTime)
-		cb.mu.RUnlock()
-
-		if timeSinceLastFail < cb.config.ResetInterval {
-			return &CircuitBreakerError{
-				name:  cb.config.Name,
-				cause: errors.New("circuit breaker is open"),
-			}
-		}
+// windowTotals counts the calls in a sliding window
+type windowTotals struct {
+	calls    int
+	failures int
+	slow     int
+}
 
-		cb.mu.Lock()
-		cb.state = StateHalfOpen
-		cb.mu.Unlock()
+func (t windowTotals) failureRate() float64 {
+	if t.calls == 0 {
+		return 0
+	}
+	return float64(t.failures) / float64(t.calls)
+}
+
+func (t windowTotals) slowCallRate() float64 {
+	if t.calls == 0 {
+		return 0
 	}
+	return float64(t.slow) / float64(t.calls)
+}
 
-	// Execute the function
-	err := fn(ctx)
+// slidingWindow records call outcomes; it is guarded by the breaker's lock
+type slidingWindow interface {
+	record(now time.Time, failed, slow bool)
+	totals(now time.Time) windowTotals
+	reset()
+}
 
-	if err != nil {
-		cb.recordFailure()
-		return err
+type callOutcome struct {
+	failed bool
+	slow   bool
+}
+
+// countWindow keeps the last len(outcomes) outcomes in a ring
+type countWindow struct {
+	outcomes []callOutcome
+	next     int
+	filled   int
+	sum      windowTotals
+}
+
+func newCountWindow(size int) *countWindow {
+	return &countWindow{outcomes: make([]callOutcome, size)}
+}
+
+func (w *countWindow) record(now time.Time, failed, slow bool) {
+	if w.filled == len(w.outcomes) {
+		w.sum.add(w.outcomes[w.next], -1)
+	} else {
+		w.filled++
+	}
+	w.outcomes[w.next] = callOutcome{failed: failed, slow: slow}
+	w.sum.add(w.outcomes[w.next], 1)
+	w.next = (w.next + 1) % len(w.outcomes)
+}
+
+func (w *countWindow) totals(now time.Time) windowTotals {
+	return w.sum
+}
+
+func (w *countWindow) reset() {
+	w.next, w.filled, w.sum = 0, 0, windowTotals{}
+}
+
+func (t *windowTotals) add(outcome callOutcome, n int) {
+	t.calls += n
+	if outcome.failed {
+		t.failures += n
+	}
+	if outcome.slow {
+		t.slow += n
+	}
+}
+
+// timeWindowBuckets is how many slices a time window is counted in
+const timeWindowBuckets = 10
+
+type timeBucket struct {
+	start int64
+	sum   windowTotals
+}
+
+// timeWindow counts outcomes in buckets covering the last duration
+type timeWindow struct {
+	width   int64
+	buckets [timeWindowBuckets]timeBucket
+}
+
+func newTimeWindow(duration time.Duration) *timeWindow {
+	width := int64(duration) / timeWindowBuckets
+	if width <= 0 {
+		width = 1
+	}
+	return &timeWindow{width: width}
+}
+
+func (w *timeWindow) record(now time.Time, failed, slow bool) {
+	start := now.UnixNano() / w.width
+	bucket := &w.buckets[start%timeWindowBuckets]
+	if bucket.start != start {
+		*bucket = timeBucket{start: start}
 	}
+	bucket.sum.add(callOutcome{failed: failed, slow: slow}, 1)
+}
+
+func (w *timeWindow) totals(now time.Time) windowTotals {
+	oldest := now.UnixNano()/w.width - timeWindowBuckets + 1
+	var totals windowTotals
+	for _, bucket := range w.buckets {
+		if bucket.start >= oldest {
+			totals.calls += bucket.sum.calls
+			totals.failures += bucket.sum.failures
+			totals.slow += bucket.sum.slow
+		}
+	}
+	return totals
+}
 
-	cb.recordSuccess()
-	return nil
+func (w *timeWindow) reset() {
+	w.buckets = [timeWindowBuckets]timeBucket{}
 }
@@ -0,0 +1,237 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTest = errors.New("test failure")
+
+func newTestBreaker(config CircuitBreakerConfig) (*CircuitBreaker, *fakeClock) {
+	clock := newFakeClock()
+	cb := NewCircuitBreaker(config)
+	cb.clock = clock
+	return cb, clock
+}
+
+func succeed() error { return nil }
+func fail() error    { return errTest }
+
+func TestCircuitBreakerOpensOnConsecutiveFailures(t *testing.T) {
+	cb, _ := newTestBreaker(CircuitBreakerConfig{Name: "test", MaxFailures: 3})
+
+	for i := 0; i < 3; i++ {
+		if err := cb.Execute(fail); err != errTest {
+			t.Fatalf("call %d: expected the call's error, got %v", i+1, err)
+		}
+	}
+
+	err := cb.Execute(succeed)
+	var breakerErr *CircuitBreakerError
+	if !errors.As(err, &breakerErr) || !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the breaker to reject the call, got %v", err)
+	}
+	if stats := cb.Stats(); stats.State != StateOpen || stats.Rejected != 1 || stats.ConsecutiveFailures != 3 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCircuitBreakerFailureRateOverCountWindow(t *testing.T) {
+	cb, _ := newTestBreaker(CircuitBreakerConfig{
+		MaxFailures:          100,
+		WindowType:           WindowCount,
+		WindowSize:           10,
+		MinimumCalls:         10,
+		FailureRateThreshold: 0.5,
+	})
+
+	// 4 failures in 10 calls stay below 50%
+	for i := 0; i < 10; i++ {
+		if i%2 == 1 && i < 9 {
+			cb.Execute(fail)
+		} else {
+			cb.Execute(succeed)
+		}
+	}
+	if stats := cb.Stats(); stats.State != StateClosed || stats.Calls != 10 || stats.Failures != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	// The window slides: the oldest success drops out, a failure comes in
+	cb.Execute(fail)
+	if stats := cb.Stats(); stats.State != StateOpen || stats.FailureRate != 0.5 {
+		t.Errorf("expected the breaker to open at 50%% failures, got %+v", stats)
+	}
+}
+
+func TestCircuitBreakerTimeWindowForgetsOldCalls(t *testing.T) {
+	cb, clock := newTestBreaker(CircuitBreakerConfig{
+		MaxFailures:          100,
+		WindowType:           WindowTime,
+		WindowDuration:       10 * time.Second,
+		MinimumCalls:         4,
+		FailureRateThreshold: 0.5,
+	})
+
+	cb.Execute(fail)
+	cb.Execute(fail)
+	cb.Execute(succeed)
+	clock.Advance(11 * time.Second)
+
+	// The earlier failures are outside the window
+	cb.Execute(fail)
+	cb.Execute(succeed)
+	cb.Execute(succeed)
+	cb.Execute(succeed)
+	if stats := cb.Stats(); stats.State != StateClosed || stats.Calls != 4 || stats.Failures != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCircuitBreakerSlowCallRate(t *testing.T) {
+	cb, clock := newTestBreaker(CircuitBreakerConfig{
+		MaxFailures:           100,
+		MinimumCalls:          2,
+		SlowCallThreshold:     time.Second,
+		SlowCallRateThreshold: 1,
+	})
+
+	slow := func() error {
+		clock.Advance(2 * time.Second)
+		return nil
+	}
+	cb.Execute(slow)
+	cb.Execute(slow)
+
+	if stats := cb.Stats(); stats.State != StateOpen || stats.SlowCallRate != 1 || stats.Failures != 0 {
+		t.Errorf("expected slow calls to open the breaker, got %+v", stats)
+	}
+}
+
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	var changes []string
+	cb, clock := newTestBreaker(CircuitBreakerConfig{
+		Name:           "probe",
+		MaxFailures:    1,
+		ResetInterval:  time.Minute,
+		HalfOpenProbes: 2,
+		OnStateChange: func(name string, from, to CircuitBreakerState) {
+			changes = append(changes, name+":"+string(from)+"->"+string(to))
+		},
+	})
+
+	cb.Execute(fail)
+	clock.Advance(time.Minute)
+
+	// Two probes are let through at once; a third call is rejected
+	release := make(chan struct{})
+	started := make(chan struct{}, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cb.Execute(func() error {
+				started <- struct{}{}
+				<-release
+				return nil
+			})
+		}()
+	}
+	<-started
+	<-started
+
+	if err := cb.Execute(succeed); !errors.Is(err, ErrTooManyProbes) {
+		t.Fatalf("expected the third call to be rejected, got %v", err)
+	}
+	if cb.GetState() != StateHalfOpen {
+		t.Fatalf("expected half-open, got %s", cb.GetState())
+	}
+
+	close(release)
+	wg.Wait()
+
+	want := []string{"probe:closed->open", "probe:open->half-open", "probe:half-open->closed"}
+	if len(changes) != len(want) {
+		t.Fatalf("expected transitions %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("transition %d: got %s, want %s", i, changes[i], want[i])
+		}
+	}
+}
+
+func TestCircuitBreakerReopensOnFailedProbe(t *testing.T) {
+	cb, clock := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1, ResetInterval: time.Minute})
+
+	cb.Execute(fail)
+	clock.Advance(time.Minute)
+	cb.Execute(fail)
+
+	if cb.GetState() != StateOpen {
+		t.Fatalf("expected a failed probe to reopen the breaker, got %s", cb.GetState())
+	}
+	if err := cb.Execute(succeed); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected the reopened breaker to wait ResetInterval again, got %v", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresCallsFromEarlierStates(t *testing.T) {
+	cb, _ := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1})
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		cb.Execute(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+		close(done)
+	}()
+
+	// Open the breaker while the slow call is in flight
+	<-started
+	cb.Execute(fail)
+	close(release)
+	<-done
+
+	// The slow call's success was admitted while closed; it must not
+	// count as a probe
+	if cb.GetState() != StateOpen {
+		t.Errorf("expected the breaker to stay open, got %s", cb.GetState())
+	}
+}
+
+func TestCircuitBreakerTimeout(t *testing.T) {
+	cb := NewCircuitBreaker(CircuitBreakerConfig{Timeout: 10 * time.Millisecond})
+
+	err := cb.ExecuteWithContext(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the call to time out, got %v", err)
+	}
+	if stats := cb.Stats(); stats.Failures != 1 {
+		t.Errorf("expected the timeout to count as a failure, got %+v", stats)
+	}
+}
+
+func TestCircuitBreakerReset(t *testing.T) {
+	cb, _ := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1})
+	cb.Execute(fail)
+	cb.Reset()
+
+	if stats := cb.Stats(); stats.State != StateClosed || stats.Calls != 0 || stats.ConsecutiveFailures != 0 {
+		t.Errorf("unexpected stats after reset %+v", stats)
+	}
+	if err := cb.Execute(succeed); err != nil {
+		t.Errorf("expected calls to pass after reset, got %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Decision is a classifier's verdict on an error
+type Decision int
+
+const (
+	// DecisionDefer leaves the error to the next classifier
+	DecisionDefer Decision = iota
+	DecisionRetry
+	DecisionStop
+)
+
+// Classification is the result of classifying an error. RetryAfter is the
+// minimum delay the callee asked for, if any.
+type Classification struct {
+	Decision   Decision
+	RetryAfter time.Duration
+}
+
+// Classifier decides whether an error is worth retrying
+type Classifier interface {
+	Classify(err error) Classification
+}
+
+// ClassifierFunc adapts a function to a Classifier
+type ClassifierFunc func(err error) Classification
+
+// Classify calls f
+func (f ClassifierFunc) Classify(err error) Classification { return f(err) }
+
+// Classifiers chains classifiers; the first one that doesn't defer decides.
+// Errors nobody recognizes are not retried.
+func Classifiers(classifiers ...Classifier) Classifier {
+	return ClassifierFunc(func(err error) Classification {
+		for _, c := range classifiers {
+			if result := c.Classify(err); result.Decision != DecisionDefer {
+				return result
+			}
+		}
+		return Classification{Decision: DecisionStop}
+	})
+}
+
+// DefaultClassifier retries transient HTTP responses and network errors
+func DefaultClassifier() Classifier {
+	return Classifiers(HTTPClassifier(), NetworkClassifier())
+}
+
+// retryableErrnos are connection-level failures another attempt may not hit
+var retryableErrnos = []error{
+	syscall.ECONNREFUSED,
+	syscall.ECONNRESET,
+	syscall.ECONNABORTED,
+	syscall.EPIPE,
+	syscall.ENETUNREACH,
+	syscall.EHOSTUNREACH,
+	syscall.ETIMEDOUT,
+}
+
+// NetworkClassifier retries timeouts, temporary DNS failures and broken
+// connections. Cancellation is never retried; anything else is deferred.
+func NetworkClassifier() Classifier {
+	return ClassifierFunc(func(err error) Classification {
+		if errors.Is(err, context.Canceled) {
+			return Classification{Decision: DecisionStop}
+		}
+
+		for _, errno := range retryableErrnos {
+			if errors.Is(err, errno) {
+				return Classification{Decision: DecisionRetry}
+			}
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return Classification{Decision: DecisionRetry}
+		}
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && errors.Is(urlErr.Err, io.EOF) {
+			// The server closed the connection before responding
+			return Classification{Decision: DecisionRetry}
+		}
+
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			if dnsErr.IsTimeout || dnsErr.IsTemporary {
+				return Classification{Decision: DecisionRetry}
+			}
+			return Classification{Decision: DecisionStop}
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return Classification{Decision: DecisionRetry}
+		}
+
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return Classification{Decision: DecisionRetry}
+		}
+
+		return Classification{Decision: DecisionDefer}
+	})
+}
+
+// RetryableStatusCodes are the responses HTTPClassifier retries by default
+var RetryableStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// HTTPError is a response whose status code signals failure
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter is the delay from the Retry-After header, zero if absent
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("unexpected status %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// NewHTTPError describes resp as an error, reading its Retry-After header
+func NewHTTPError(resp *http.Response) *HTTPError {
+	retryAfter, _ := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	return &HTTPError{StatusCode: resp.StatusCode, RetryAfter: retryAfter}
+}
+
+// ParseRetryAfter parses a Retry-After header given as seconds or as an
+// HTTP date relative to now
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if delay := at.Sub(now); delay > 0 {
+		return delay, true
+	}
+	return 0, true
+}
+
+// HTTPClassifier retries HTTPErrors with one of statusCodes, or
+// RetryableStatusCodes when none are given, and asks for their Retry-After
+// delay. Other HTTPErrors are not retried; other errors are deferred.
+func HTTPClassifier(statusCodes ...int) Classifier {
+	if len(statusCodes) == 0 {
+		statusCodes = RetryableStatusCodes
+	}
+	retryable := make(map[int]bool, len(statusCodes))
+	for _, code := range statusCodes {
+		retryable[code] = true
+	}
+
+	return ClassifierFunc(func(err error) Classification {
+		var httpErr *HTTPError
+		if !errors.As(err, &httpErr) {
+			return Classification{Decision: DecisionDefer}
+		}
+		if !retryable[httpErr.StatusCode] {
+			return Classification{Decision: DecisionStop}
+		}
+		return Classification{Decision: DecisionRetry, RetryAfter: httpErr.RetryAfter}
+	})
+}
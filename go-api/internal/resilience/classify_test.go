@@ -0,0 +1,131 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNetworkClassifier(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Decision
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, DecisionRetry},
+		{"wrapped reset", fmt.Errorf("failed to read: %w", syscall.ECONNRESET), DecisionRetry},
+		{"client timeout", &url.Error{Op: "Get", URL: "http://x", Err: &net.DNSError{IsTimeout: true}}, DecisionRetry},
+		{"unknown host", &net.DNSError{Err: "no such host", Name: "nope.invalid", IsNotFound: true}, DecisionStop},
+		{"server closed connection", &url.Error{Op: "Get", URL: "http://x", Err: io.EOF}, DecisionRetry},
+		{"truncated body", fmt.Errorf("failed to decode: %w", io.ErrUnexpectedEOF), DecisionRetry},
+		{"canceled", fmt.Errorf("failed: %w", context.Canceled), DecisionStop},
+		{"bad scheme", &url.Error{Op: "Get", URL: "ftp://x", Err: errors.New("unsupported protocol scheme")}, DecisionDefer},
+		{"text only", errors.New("connection refused"), DecisionDefer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NetworkClassifier().Classify(tt.err).Decision; got != tt.want {
+				t.Errorf("Classify(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPClassifier(t *testing.T) {
+	tests := []struct {
+		err       error
+		want      Decision
+		wantAfter time.Duration
+	}{
+		{&HTTPError{StatusCode: 503, RetryAfter: 3 * time.Second}, DecisionRetry, 3 * time.Second},
+		{fmt.Errorf("collect: %w", &HTTPError{StatusCode: 429}), DecisionRetry, 0},
+		{&HTTPError{StatusCode: 502}, DecisionRetry, 0},
+		{&HTTPError{StatusCode: 504}, DecisionRetry, 0},
+		{&HTTPError{StatusCode: 500}, DecisionStop, 0},
+		{&HTTPError{StatusCode: 404}, DecisionStop, 0},
+		{errors.New("connection refused"), DecisionDefer, 0},
+	}
+
+	for _, tt := range tests {
+		got := HTTPClassifier().Classify(tt.err)
+		if got.Decision != tt.want || got.RetryAfter != tt.wantAfter {
+			t.Errorf("Classify(%v) = %+v, want %v after %v", tt.err, got, tt.want, tt.wantAfter)
+		}
+	}
+
+	if HTTPClassifier(500).Classify(&HTTPError{StatusCode: 500}).Decision != DecisionRetry {
+		t.Error("expected custom status codes to be retried")
+	}
+}
+
+func TestDefaultClassifierStopsUnknownErrors(t *testing.T) {
+	if got := DefaultClassifier().Classify(errors.New("invalid endpoint URL")).Decision; got != DecisionStop {
+		t.Errorf("expected unknown errors to stop, got %v", got)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 5, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"120", 2 * time.Minute, true},
+		{" 0 ", 0, true},
+		{"Tue, 05 Mar 2024 10:00:30 GMT", 30 * time.Second, true},
+		{"Tue, 05 Mar 2024 09:00:00 GMT", 0, true},
+		{"-5", 0, false},
+		{"soon", 0, false},
+		{"", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseRetryAfter(tt.value, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("ParseRetryAfter(%q) = %v, %v, want %v, %v", tt.value, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	resp := &http.Response{StatusCode: 503, Header: http.Header{"Retry-After": []string{"7"}}}
+	if err := NewHTTPError(resp); err.StatusCode != 503 || err.RetryAfter != 7*time.Second {
+		t.Errorf("unexpected error %+v", err)
+	}
+}
+
+func TestRetryBudgetWindow(t *testing.T) {
+	clock := newFakeClock()
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 0.1, MinRetries: 1, Window: 10 * time.Second})
+	budget.clock = clock
+
+	for i := 0; i < 10; i++ {
+		budget.RecordCall()
+	}
+
+	// One retry from MinRetries plus one from 10% of 10 calls
+	if !budget.TryRetry() || !budget.TryRetry() {
+		t.Fatal("expected two retries to be allowed")
+	}
+	if budget.TryRetry() {
+		t.Fatal("expected the third retry to be refused")
+	}
+
+	// Once the window has passed the budget is refilled
+	clock.Advance(11 * time.Second)
+	if stats := budget.Stats(); stats.Calls != 0 || stats.Retries != 0 {
+		t.Errorf("expected an empty window, got %+v", stats)
+	}
+	if !budget.TryRetry() {
+		t.Error("expected MinRetries to allow a retry")
+	}
+}
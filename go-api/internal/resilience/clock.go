@@ -0,0 +1,20 @@
+package resilience
+
+import "time"
+
+// Clock tells the time and starts timers; tests substitute a fake one
+type Clock interface {
+	Now() time.Time
+	// Timer returns a channel that receives once d has passed and a
+	// function that stops it
+	Timer(d time.Duration) (<-chan time.Time, func())
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) Timer(d time.Duration) (<-chan time.Time, func()) {
+	t := time.NewTimer(d)
+	return t.C, func() { t.Stop() }
+}
@@ -0,0 +1,263 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrLimitExceeded is the cause of a LimitError
+var ErrLimitExceeded = errors.New("concurrency limit reached")
+
+// errCallPanicked is what a panicking call is recorded as
+var errCallPanicked = errors.New("call panicked")
+
+// LimitError is returned when the adaptive limiter rejects a call
+type LimitError struct {
+	name  string
+	limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limiter '%s' rejected the call: %v (%d)", e.name, ErrLimitExceeded, e.limit)
+}
+
+// Unwrap returns ErrLimitExceeded
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// LimitAlgorithm selects how the adaptive limiter moves its limit
+type LimitAlgorithm string
+
+const (
+	// LimitAIMD adds one per successful call while the limit is in use and
+	// multiplies the limit by BackoffRatio on a failed or slow call
+	LimitAIMD LimitAlgorithm = "aimd"
+	// LimitGradient follows the ratio of the long-term to the recent
+	// latency, shrinking the limit once calls slow down
+	LimitGradient LimitAlgorithm = "gradient"
+)
+
+// AdaptiveLimiterConfig holds adaptive limiter configuration
+type AdaptiveLimiterConfig struct {
+	Name      string
+	Algorithm LimitAlgorithm
+
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+
+	// BackoffRatio multiplies the limit when a call fails, and for AIMD
+	// when a call takes LatencyThreshold or longer; zero LatencyThreshold
+	// only backs off on failures
+	BackoffRatio     float64
+	LatencyThreshold time.Duration
+
+	// Tolerance is how much slower than the long-term latency calls may get
+	// before the gradient shrinks the limit, and Smoothing how much of each
+	// new limit is taken
+	Tolerance float64
+	Smoothing float64
+}
+
+// DefaultAdaptiveLimiterConfig returns default adaptive limiter configuration
+func DefaultAdaptiveLimiterConfig() AdaptiveLimiterConfig {
+	return AdaptiveLimiterConfig{
+		Algorithm:    LimitAIMD,
+		InitialLimit: 20,
+		MinLimit:     1,
+		MaxLimit:     200,
+		BackoffRatio: 0.9,
+		Tolerance:    1.5,
+		Smoothing:    0.2,
+	}
+}
+
+// longRTTWeight is the weight of a sample in the long-term latency average
+const longRTTWeight = 0.01
+
+// AdaptiveLimiterStats is a snapshot of an adaptive limiter
+type AdaptiveLimiterStats struct {
+	Name     string        `json:"name"`
+	Limit    int           `json:"limit"`
+	InFlight int           `json:"in_flight"`
+	Rejected int64         `json:"rejected"`
+	LongRTT  time.Duration `json:"long_rtt"`
+	LastRTT  time.Duration `json:"last_rtt"`
+}
+
+// AdaptiveLimiter caps concurrent calls at a limit it learns from their
+// latency and failures. Calls over the limit are rejected rather than
+// queued, so a slowing dependency sheds load instead of piling it up.
+type AdaptiveLimiter struct {
+	config AdaptiveLimiterConfig
+	clock  Clock
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	rejected int64
+	longRTT  float64
+	lastRTT  time.Duration
+}
+
+// NewAdaptiveLimiter creates an adaptive limiter
+func NewAdaptiveLimiter(config AdaptiveLimiterConfig) *AdaptiveLimiter {
+	defaults := DefaultAdaptiveLimiterConfig()
+	if config.Algorithm == "" {
+		config.Algorithm = defaults.Algorithm
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = defaults.MinLimit
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = defaults.MaxLimit
+	}
+	if config.MaxLimit < config.MinLimit {
+		config.MaxLimit = config.MinLimit
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = defaults.InitialLimit
+	}
+	if config.BackoffRatio <= 0 || config.BackoffRatio >= 1 {
+		config.BackoffRatio = defaults.BackoffRatio
+	}
+	if config.Tolerance < 1 {
+		config.Tolerance = defaults.Tolerance
+	}
+	if config.Smoothing <= 0 || config.Smoothing > 1 {
+		config.Smoothing = defaults.Smoothing
+	}
+
+	l := &AdaptiveLimiter{config: config, clock: realClock{}}
+	l.limit = l.clamp(float64(config.InitialLimit))
+	return l
+}
+
+// Acquire admits a call if the limit allows it. The returned function must
+// be called with the call's error once it is done.
+func (l *AdaptiveLimiter) Acquire() (func(err error), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight >= int(l.limit) {
+		l.rejected++
+		return nil, &LimitError{name: l.config.Name, limit: int(l.limit)}
+	}
+	l.inFlight++
+
+	start := l.clock.Now()
+	var once sync.Once
+	return func(err error) {
+		once.Do(func() { l.done(start, err) })
+	}, nil
+}
+
+// Execute runs fn if the limit allows it. A call that panics is released
+// as a failure and the panic carries on.
+func (l *AdaptiveLimiter) Execute(ctx context.Context, fn func(context.Context) error) (err error) {
+	done, err := l.Acquire()
+	if err != nil {
+		return err
+	}
+
+	returned := false
+	defer func() {
+		if !returned {
+			err = errCallPanicked
+		}
+		done(err)
+	}()
+
+	err = fn(ctx)
+	returned = true
+	return err
+}
+
+// done records a finished call and moves the limit
+func (l *AdaptiveLimiter) done(start time.Time, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+
+	rtt := l.clock.Now().Sub(start)
+	l.lastRTT = rtt
+
+	if err != nil {
+		l.limit = l.clamp(l.limit * l.config.BackoffRatio)
+		return
+	}
+
+	// A limit that isn't being used says nothing about the dependency
+	used := float64(inFlight) >= l.limit/2
+
+	switch l.config.Algorithm {
+	case LimitGradient:
+		l.gradient(rtt, used)
+	default:
+		if l.config.LatencyThreshold > 0 && rtt >= l.config.LatencyThreshold {
+			l.limit = l.clamp(l.limit * l.config.BackoffRatio)
+		} else if used {
+			l.limit = l.clamp(l.limit + 1)
+		}
+	}
+}
+
+// gradient moves the limit by the ratio of the long-term to the recent
+// latency. The caller holds mu.
+func (l *AdaptiveLimiter) gradient(rtt time.Duration, used bool) {
+	sample := float64(rtt)
+	if sample <= 0 {
+		sample = 1
+	}
+
+	if l.longRTT == 0 {
+		l.longRTT = sample
+	} else {
+		l.longRTT = l.longRTT*(1-longRTTWeight) + sample*longRTTWeight
+	}
+
+	// Let the long-term average recover quickly after a slow period
+	if l.longRTT/sample > 2 {
+		l.longRTT *= 0.95
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.config.Tolerance*l.longRTT/sample))
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	if newLimit > l.limit && !used {
+		return
+	}
+	l.limit = l.clamp(l.limit*(1-l.config.Smoothing) + newLimit*l.config.Smoothing)
+}
+
+func (l *AdaptiveLimiter) clamp(limit float64) float64 {
+	return math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), limit))
+}
+
+// Limit returns the current limit
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int(l.limit)
+}
+
+// Stats returns a snapshot of the limiter
+func (l *AdaptiveLimiter) Stats() AdaptiveLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return AdaptiveLimiterStats{
+		Name:     l.config.Name,
+		Limit:    int(l.limit),
+		InFlight: l.inFlight,
+		Rejected: l.rejected,
+		LongRTT:  time.Duration(l.longRTT),
+		LastRTT:  l.lastRTT,
+	}
+}
@@ -0,0 +1,156 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestLimiter(config AdaptiveLimiterConfig) (*AdaptiveLimiter, *fakeClock) {
+	clock := newFakeClock()
+	l := NewAdaptiveLimiter(config)
+	l.clock = clock
+	return l, clock
+}
+
+// call runs one call through l that takes d on the fake clock
+func call(l *AdaptiveLimiter, clock *fakeClock, d time.Duration, err error) error {
+	return l.Execute(context.Background(), func(ctx context.Context) error {
+		clock.Advance(d)
+		return err
+	})
+}
+
+func TestAdaptiveLimiterRejectsOverLimit(t *testing.T) {
+	l, _ := newTestLimiter(AdaptiveLimiterConfig{Name: "stripe", InitialLimit: 2})
+
+	done1, _ := l.Acquire()
+	done2, _ := l.Acquire()
+	if _, err := l.Acquire(); !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected the third call to be rejected, got %v", err)
+	}
+
+	done1(nil)
+	done2(nil)
+	if stats := l.Stats(); stats.InFlight != 0 || stats.Rejected != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAdaptiveLimiterReleasesPanickingCall(t *testing.T) {
+	l, _ := newTestLimiter(AdaptiveLimiterConfig{InitialLimit: 4, BackoffRatio: 0.5})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to carry on")
+			}
+		}()
+		l.Execute(context.Background(), func(ctx context.Context) error {
+			panic("boom")
+		})
+	}()
+
+	// The slot is released and the panic counts as a failure
+	if stats := l.Stats(); stats.InFlight != 0 || stats.Limit != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestAdaptiveLimiterAIMD(t *testing.T) {
+	l, clock := newTestLimiter(AdaptiveLimiterConfig{
+		Algorithm:        LimitAIMD,
+		InitialLimit:     2,
+		MaxLimit:         10,
+		BackoffRatio:     0.5,
+		LatencyThreshold: time.Second,
+	})
+
+	// Fully used, fast calls grow the limit by one each
+	for i := 0; i < 3; i++ {
+		done, _ := l.Acquire()
+		other, _ := l.Acquire()
+		clock.Advance(10 * time.Millisecond)
+		done(nil)
+		other(nil)
+	}
+	if limit := l.Limit(); limit < 5 {
+		t.Fatalf("expected the limit to grow, got %d", limit)
+	}
+	grown := l.Limit()
+
+	// A slow call and a failed one each halve it
+	call(l, clock, 2*time.Second, nil)
+	if limit := l.Limit(); limit != grown/2 {
+		t.Fatalf("expected a slow call to halve the limit to %d, got %d", grown/2, limit)
+	}
+	call(l, clock, 10*time.Millisecond, errors.New("503"))
+	if limit := l.Limit(); limit != grown/4 && limit != 1 {
+		t.Fatalf("expected a failure to halve the limit again, got %d", limit)
+	}
+
+	// Never below MinLimit
+	for i := 0; i < 10; i++ {
+		call(l, clock, 10*time.Millisecond, errors.New("503"))
+	}
+	if limit := l.Limit(); limit != 1 {
+		t.Errorf("expected the limit to stop at 1, got %d", limit)
+	}
+}
+
+func TestAdaptiveLimiterIgnoresUnusedLimit(t *testing.T) {
+	l, clock := newTestLimiter(AdaptiveLimiterConfig{InitialLimit: 10})
+
+	// One call at a time never uses half of 10
+	for i := 0; i < 20; i++ {
+		call(l, clock, 10*time.Millisecond, nil)
+	}
+	if limit := l.Limit(); limit != 10 {
+		t.Errorf("expected the limit to stay at 10, got %d", limit)
+	}
+}
+
+func TestAdaptiveLimiterGradient(t *testing.T) {
+	l, clock := newTestLimiter(AdaptiveLimiterConfig{
+		Algorithm:    LimitGradient,
+		InitialLimit: 4,
+		MaxLimit:     50,
+		Tolerance:    1.5,
+		Smoothing:    0.5,
+	})
+
+	// run makes n concurrent calls, each taking d
+	run := func(n int, d time.Duration) {
+		dones := make([]func(error), 0, n)
+		for i := 0; i < n; i++ {
+			done, err := l.Acquire()
+			if err != nil {
+				break
+			}
+			dones = append(dones, done)
+		}
+		clock.Advance(d)
+		for _, done := range dones {
+			done(nil)
+		}
+	}
+
+	// Steady latency lets the limit grow while it is used
+	for i := 0; i < 10; i++ {
+		run(l.Limit(), 100*time.Millisecond)
+	}
+	grown := l.Limit()
+	if grown <= 4 {
+		t.Fatalf("expected the limit to grow at steady latency, got %d", grown)
+	}
+
+	// Latency well above the long-term average shrinks it
+	run(l.Limit(), time.Second)
+	if limit := l.Limit(); limit >= grown {
+		t.Errorf("expected the limit to shrink when calls slow down, got %d (was %d)", limit, grown)
+	}
+	if stats := l.Stats(); stats.LongRTT <= 100*time.Millisecond || stats.LastRTT != time.Second {
+		t.Errorf("unexpected latencies %+v", stats)
+	}
+}
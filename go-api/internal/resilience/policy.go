@@ -0,0 +1,88 @@
+package resilience
+
+import (
+	"context"
+	"time"
+)
+
+// Step wraps a call with one resilience mechanism; it calls fn at most as
+// often as the mechanism allows
+type Step func(ctx context.Context, fn func(context.Context) error) error
+
+// WithTimeout bounds the rest of the pipeline, retries included, by d
+func WithTimeout(d time.Duration) Step {
+	return func(ctx context.Context, fn func(context.Context) error) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+		return fn(ctx)
+	}
+}
+
+// WithRetry retries the rest of the pipeline
+func WithRetry(r *Retrier) Step {
+	return r.DoWithContext
+}
+
+// WithCircuitBreaker guards the rest of the pipeline with a breaker
+func WithCircuitBreaker(cb *CircuitBreaker) Step {
+	return cb.ExecuteWithContext
+}
+
+// WithBulkhead runs the rest of the pipeline in a bulkhead slot
+func WithBulkhead(b *Bulkhead) Step {
+	return b.Execute
+}
+
+// WithLimiter runs the rest of the pipeline within an adaptive limit
+func WithLimiter(l *AdaptiveLimiter) Step {
+	return l.Execute
+}
+
+// Pipeline runs calls through a sequence of steps, the first outermost.
+// A typical order is timeout, retry, bulkhead, limiter, circuit breaker:
+// every retry then waits for a slot, and only calls that actually reach
+// the dependency count towards the breaker.
+type Pipeline struct {
+	steps []Step
+}
+
+// Policy creates a pipeline of steps
+func Policy(steps ...Step) *Pipeline {
+	return &Pipeline{steps: steps}
+}
+
+// Then returns a pipeline with step added innermost
+func (p *Pipeline) Then(step Step) *Pipeline {
+	steps := make([]Step, len(p.steps), len(p.steps)+1)
+	copy(steps, p.steps)
+	return &Pipeline{steps: append(steps, step)}
+}
+
+// Timeout adds WithTimeout innermost
+func (p *Pipeline) Timeout(d time.Duration) *Pipeline { return p.Then(WithTimeout(d)) }
+
+// Retry adds WithRetry innermost
+func (p *Pipeline) Retry(r *Retrier) *Pipeline { return p.Then(WithRetry(r)) }
+
+// CircuitBreaker adds WithCircuitBreaker innermost
+func (p *Pipeline) CircuitBreaker(cb *CircuitBreaker) *Pipeline {
+	return p.Then(WithCircuitBreaker(cb))
+}
+
+// Bulkhead adds WithBulkhead innermost
+func (p *Pipeline) Bulkhead(b *Bulkhead) *Pipeline { return p.Then(WithBulkhead(b)) }
+
+// Limit adds WithLimiter innermost
+func (p *Pipeline) Limit(l *AdaptiveLimiter) *Pipeline { return p.Then(WithLimiter(l)) }
+
+// Execute runs fn through every step
+func (p *Pipeline) Execute(ctx context.Context, fn func(context.Context) error) error {
+	call := fn
+	for i := len(p.steps) - 1; i >= 0; i-- {
+		step, next := p.steps[i], call
+		call = func(ctx context.Context) error {
+			return step(ctx, next)
+		}
+	}
+	return call(ctx)
+}
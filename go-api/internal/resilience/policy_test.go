@@ -0,0 +1,92 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPolicyRunsStepsOutermostFirst(t *testing.T) {
+	var trace []string
+	step := func(name string) Step {
+		return func(ctx context.Context, fn func(context.Context) error) error {
+			trace = append(trace, name+">")
+			err := fn(ctx)
+			trace = append(trace, "<"+name)
+			return err
+		}
+	}
+
+	p := Policy(step("a"), step("b")).Then(step("c"))
+	p.Execute(context.Background(), func(ctx context.Context) error {
+		trace = append(trace, "fn")
+		return nil
+	})
+
+	if got := strings.Join(trace, " "); got != "a> b> c> fn <c <b <a" {
+		t.Errorf("unexpected order: %s", got)
+	}
+}
+
+func TestPolicyTimeoutCoversRetries(t *testing.T) {
+	retrier := NewRetrier(RetryConfig{MaxAttempts: 100, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond})
+
+	calls := 0
+	err := Policy().Timeout(20*time.Millisecond).Retry(retrier).Execute(context.Background(), func(ctx context.Context) error {
+		calls++
+		return errors.New("temporary error")
+	})
+
+	if err == nil || calls >= 100 {
+		t.Errorf("expected the timeout to cut retries short, got %d calls, %v", calls, err)
+	}
+}
+
+func TestPolicyComposesResilienceMechanisms(t *testing.T) {
+	clock := newFakeClock()
+
+	retryConfig := DefaultRetryConfig()
+	retryConfig.Classifier = DefaultClassifier()
+	retrier := NewRetrier(retryConfig)
+	noSleep(retrier)
+
+	breaker := NewCircuitBreaker(CircuitBreakerConfig{Name: "symfony", MaxFailures: 2, ResetInterval: time.Minute})
+	breaker.clock = clock
+
+	bulkhead := NewBulkhead(BulkheadConfig{MaxConcurrent: 1})
+	bulkhead.clock = clock
+
+	limiter := NewAdaptiveLimiter(AdaptiveLimiterConfig{InitialLimit: 5})
+	limiter.clock = clock
+
+	policy := Policy(WithRetry(retrier)).Bulkhead(bulkhead).Limit(limiter).CircuitBreaker(breaker)
+
+	calls := 0
+	unavailable := func(ctx context.Context) error {
+		calls++
+		clock.Advance(100 * time.Millisecond)
+		return &HTTPError{StatusCode: 503}
+	}
+
+	// Two failed attempts open the breaker; the third is rejected by it,
+	// and the retrier doesn't retry the rejection
+	err := policy.Execute(context.Background(), unavailable)
+	if !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Fatalf("expected the breaker to stop the retries after 2 calls, got %d calls, %v", calls, err)
+	}
+	if stats := bulkhead.Stats(); stats.InFlight != 0 {
+		t.Errorf("expected every bulkhead slot back, got %+v", stats)
+	}
+	if limit := limiter.Limit(); limit >= 5 {
+		t.Errorf("expected failures to lower the limit, got %d", limit)
+	}
+
+	// Once ResetInterval has passed, probes go through again
+	clock.Advance(time.Minute)
+	err = policy.Execute(context.Background(), func(ctx context.Context) error { return nil })
+	if err != nil {
+		t.Errorf("expected the probe to succeed, got %v", err)
+	}
+}
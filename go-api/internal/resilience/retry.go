@@ -11,14 +11,29 @@ import (
 
 // RetryConfig holds retry configuration
 type RetryConfig struct {
-	MaxAttempts int
+	MaxAttempts  int
 	InitialDelay time.Duration
 	MaxDelay     time.Duration
 	Multiplier   float64
 	Jitter       bool
+
+	// Classifier decides which errors are retried; nil retries every error
+	Classifier Classifier
+
+	// MaxElapsed caps the time one call spends across attempts and delays;
+	// zero leaves only the context deadline
+	MaxElapsed time.Duration
+
+	// Budget, when set, is shared by the calls to one dependency and caps
+	// their overall retry ratio
+	Budget *RetryBudget
+
+	// OnAttempt is called after every attempt, for logging and metrics
+	OnAttempt func(Attempt)
 }
 
-// DefaultRetryConfig returns default retry configuration
+// DefaultRetryConfig returns default retry configuration. It retries every
+// error; set Classifier to DefaultClassifier() to retry only transient ones.
 func DefaultRetryConfig() RetryConfig {
 	return RetryConfig{
 		MaxAttempts:  3,
@@ -29,9 +44,35 @@ func DefaultRetryConfig() RetryConfig {
 	}
 }
 
+// Outcome describes what followed an attempt
+type Outcome string
+
+const (
+	OutcomeSuccess      Outcome = "success"
+	OutcomeRetry        Outcome = "retry"
+	OutcomeNotRetryable Outcome = "not_retryable"
+	OutcomeExhausted    Outcome = "exhausted"
+	// OutcomeOverBudget means the next attempt wouldn't fit in MaxElapsed
+	// or the context deadline
+	OutcomeOverBudget Outcome = "over_budget"
+	// OutcomeThrottled means the shared retry budget was spent
+	OutcomeThrottled Outcome = "throttled"
+)
+
+// Attempt reports one attempt of a call
+type Attempt struct {
+	Number   int // 1 for the first attempt
+	Err      error
+	Duration time.Duration
+	Outcome  Outcome
+	// Delay is the wait before the next attempt when Outcome is OutcomeRetry
+	Delay time.Duration
+}
+
 // Retrier handles retry logic with exponential backoff
 type Retrier struct {
 	config RetryConfig
+	sleep  func(ctx context.Context, d time.Duration) error
 }
 
 // NewRetrier creates a new retrier with custom config
@@ -49,7 +90,7 @@ func NewRetrier(config RetryConfig) *Retrier {
 		config.Multiplier = 2.0
 	}
 
-	return &Retrier{config: config}
+	return &Retrier{config: config, sleep: sleepContext}
 }
 
 // DefaultRetrier creates a retrier with default configuration
@@ -59,60 +100,112 @@ func DefaultRetrier() *Retrier {
 
 // Do executes the function with retry logic
 func (r *Retrier) Do(fn func() error) error {
-	var lastErr error
+	return r.DoWithContext(context.Background(), func(context.Context) error {
+		return fn()
+	})
+}
+
+// DoWithContext executes the function with retry logic and context. Errors
+// the classifier doesn't retry are returned as they are.
+func (r *Retrier) DoWithContext(ctx context.Context, fn func(context.Context) error) error {
+	start := time.Now()
+	if r.config.Budget != nil {
+		r.config.Budget.RecordCall()
+	}
 
+	var lastErr error
 	for attempt := 0; attempt < r.config.MaxAttempts; attempt++ {
-		err := fn()
+		// Check context before each attempt
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		attemptStart := time.Now()
+		err := fn(ctx)
+		report := Attempt{Number: attempt + 1, Err: err, Duration: time.Since(attemptStart)}
 		if err == nil {
+			report.Outcome = OutcomeSuccess
+			r.report(report)
 			return nil
 		}
-
 		lastErr = err
 
-		// Don't wait after last attempt
-		if attempt < r.config.MaxAttempts-1 {
-			delay := r.calculateDelay(attempt)
-			time.Sleep(delay)
+		report.Outcome, report.Delay = r.next(ctx, attempt, err, start)
+		r.report(report)
+
+		switch report.Outcome {
+		case OutcomeNotRetryable:
+			return err
+		case OutcomeOverBudget:
+			return fmt.Errorf("retry time budget exceeded after %d attempts: %w", attempt+1, err)
+		case OutcomeThrottled:
+			return fmt.Errorf("retry budget exhausted after %d attempts: %w", attempt+1, err)
+		case OutcomeRetry:
+			if err := r.sleep(ctx, report.Delay); err != nil {
+				return err
+			}
 		}
 	}
 
 	return fmt.Errorf("max retries (%d) exceeded: %w", r.config.MaxAttempts, lastErr)
 }
 
-// DoWithContext executes the function with retry logic and context
-func (r *Retrier) DoWithContext(ctx context.Context, fn func(context.Context) error) error {
-	var lastErr error
-
-	for attempt := 0; attempt < r.config.MaxAttempts; attempt++ {
-		// Check context before each attempt
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+// next decides whether a failed attempt is retried and after how long
+func (r *Retrier) next(ctx context.Context, attempt int, err error, start time.Time) (Outcome, time.Duration) {
+	var retryAfter time.Duration
+	if r.config.Classifier != nil {
+		classification := r.config.Classifier.Classify(err)
+		if classification.Decision != DecisionRetry {
+			return OutcomeNotRetryable, 0
 		}
+		retryAfter = classification.RetryAfter
+	}
 
-		err := fn(ctx)
-		if err == nil {
-			return nil
+	if attempt >= r.config.MaxAttempts-1 {
+		return OutcomeExhausted, 0
+	}
+
+	delay := r.calculateDelay(attempt)
+	if retryAfter > delay {
+		// A callee asking for longer than we'd ever wait gets MaxDelay
+		delay = retryAfter
+		if delay > r.config.MaxDelay {
+			delay = r.config.MaxDelay
 		}
+	}
 
-		lastErr = err
+	now := time.Now()
+	if r.config.MaxElapsed > 0 && now.Add(delay).Sub(start) >= r.config.MaxElapsed {
+		return OutcomeOverBudget, 0
+	}
+	if deadline, ok := ctx.Deadline(); ok && !now.Add(delay).Before(deadline) {
+		return OutcomeOverBudget, 0
+	}
 
-		// Don't wait after last attempt
-		if attempt < r.config.MaxAttempts-1 {
-			delay := r.calculateDelay(attempt)
+	// Spend the shared budget last, once the retry is otherwise certain
+	if r.config.Budget != nil && !r.config.Budget.TryRetry() {
+		return OutcomeThrottled, 0
+	}
+	return OutcomeRetry, delay
+}
 
-			// Use context-aware sleep
-			select {
-			case <-time.After(delay):
-				// Continue to next attempt
-			case <-ctx.Done():
-				return ctx.Err()
-			}
-		}
+func (r *Retrier) report(attempt Attempt) {
+	if r.config.OnAttempt != nil {
+		r.config.OnAttempt(attempt)
 	}
+}
 
-	return fmt.Errorf("max retries (%d) exceeded: %w", r.config.MaxAttempts, lastErr)
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
 // calculateDelay calculates exponential backoff with optional jitter
@@ -137,13 +230,21 @@ func (r *Retrier) calculateDelay(attempt int) time.Duration {
 	return delay
 }
 
-// IsRetryableError determines if an error should trigger a retry
-// This is a basic implementation - can be extended for specific error types
+// IsRetryableError determines if an error should trigger a retry. Typed
+// network errors are classified by NetworkClassifier; errors that lost
+// their type are matched on their text. Retriers should use a Classifier.
 func IsRetryableError(err error) bool {
 	if err == nil {
 		return false
 	}
 
+	switch NetworkClassifier().Classify(err).Decision {
+	case DecisionRetry:
+		return true
+	case DecisionStop:
+		return false
+	}
+
 	// Define retryable error patterns
 	errStr := strings.ToLower(err.Error())
 
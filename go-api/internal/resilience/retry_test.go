@@ -124,7 +124,7 @@ func TestRetryerDo(t *testing.T) {
 				Multiplier:   2.0,
 				Jitter:       false,
 			},
-			fn: func() error {
+			fn: func() func() error {
 				var callCount int
 				return func() error {
 					callCount++
@@ -132,10 +132,10 @@ func TestRetryerDo(t *testing.T) {
 						return errors.New("temporary error")
 					}
 					return nil
-				}()
-			},
+				}
+			}(),
 			expectedError: false,
-			expectedCalls: 1,
+			expectedCalls: 3,
 		},
 		{
 			name: "fails after max retries",
@@ -157,11 +157,18 @@ func TestRetryerDo(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			retrier := NewRetrier(tt.config)
-			err := retrier.Do(tt.fn)
+			calls := 0
+			err := retrier.Do(func() error {
+				calls++
+				return tt.fn()
+			})
 
 			if (err != nil) != tt.expectedError {
 				t.Errorf("Retrier.Do() error = %v, wantErr %v", err, tt.expectedError)
 			}
+			if calls != tt.expectedCalls {
+				t.Errorf("Retrier.Do() calls = %d, want %d", calls, tt.expectedCalls)
+			}
 		})
 	}
 }
@@ -306,3 +313,144 @@ func TestCalculateDelayWithJitter(t *testing.T) {
 		t.Errorf("calculateDelay with jitter produced only %d unique values, expected at least 2", len(results))
 	}
 }
+
+// noSleep records delays instead of waiting
+func noSleep(retrier *Retrier) *[]time.Duration {
+	var delays []time.Duration
+	retrier.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return &delays
+}
+
+func TestRetrierStopsOnNonRetryableErrors(t *testing.T) {
+	config := DefaultRetryConfig()
+	config.Jitter = false
+	config.Classifier = DefaultClassifier()
+	retrier := NewRetrier(config)
+	delays := noSleep(retrier)
+
+	badRequest := &HTTPError{StatusCode: 400}
+	calls := 0
+	err := retrier.Do(func() error {
+		calls++
+		return badRequest
+	})
+
+	if err != badRequest || calls != 1 || len(*delays) != 0 {
+		t.Errorf("expected one call returning the error, got %d calls, %v", calls, err)
+	}
+}
+
+func TestRetrierHonorsRetryAfter(t *testing.T) {
+	config := DefaultRetryConfig()
+	config.Jitter = false
+	config.Classifier = DefaultClassifier()
+	retrier := NewRetrier(config)
+	delays := noSleep(retrier)
+
+	calls := 0
+	err := retrier.Do(func() error {
+		calls++
+		if calls == 1 {
+			return &HTTPError{StatusCode: 503, RetryAfter: 2 * time.Second}
+		}
+		return nil
+	})
+
+	if err != nil || calls != 2 {
+		t.Fatalf("expected success on the second call, got %d calls, %v", calls, err)
+	}
+	if len(*delays) != 1 || (*delays)[0] != 2*time.Second {
+		t.Errorf("expected to wait the Retry-After delay, waited %v", *delays)
+	}
+
+	// Waits beyond MaxDelay are capped at MaxDelay
+	calls = 0
+	*delays = nil
+	err = retrier.Do(func() error {
+		calls++
+		if calls < 3 {
+			return &HTTPError{StatusCode: 429, RetryAfter: time.Minute}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success on the third call, got %d calls, %v", calls, err)
+	}
+	for _, d := range *delays {
+		if d != config.MaxDelay {
+			t.Errorf("expected Retry-After to be capped at %v, waited %v", config.MaxDelay, *delays)
+			break
+		}
+	}
+}
+
+func TestRetrierMaxElapsed(t *testing.T) {
+	retrier := NewRetrier(RetryConfig{
+		MaxAttempts:  10,
+		InitialDelay: 40 * time.Millisecond,
+		MaxDelay:     time.Second,
+		MaxElapsed:   100 * time.Millisecond,
+	})
+
+	var outcomes []Outcome
+	retrier.config.OnAttempt = func(a Attempt) { outcomes = append(outcomes, a.Outcome) }
+
+	err := retrier.Do(func() error { return errors.New("temporary error") })
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+
+	// 40ms and 80ms delays don't both fit in 100ms
+	want := []Outcome{OutcomeRetry, OutcomeOverBudget}
+	if len(outcomes) != len(want) || outcomes[0] != want[0] || outcomes[1] != want[1] {
+		t.Errorf("expected outcomes %v, got %v", want, outcomes)
+	}
+}
+
+func TestRetrierReportsAttempts(t *testing.T) {
+	config := DefaultRetryConfig()
+	config.Jitter = false
+	config.Classifier = DefaultClassifier()
+	var attempts []Attempt
+	config.OnAttempt = func(a Attempt) { attempts = append(attempts, a) }
+	retrier := NewRetrier(config)
+	noSleep(retrier)
+
+	retrier.Do(func() error { return &HTTPError{StatusCode: 502} })
+
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	for i, outcome := range []Outcome{OutcomeRetry, OutcomeRetry, OutcomeExhausted} {
+		if attempts[i].Number != i+1 || attempts[i].Outcome != outcome || attempts[i].Err == nil {
+			t.Errorf("attempt %d: unexpected report %+v", i+1, attempts[i])
+		}
+	}
+	if attempts[0].Delay != 100*time.Millisecond || attempts[1].Delay != 200*time.Millisecond {
+		t.Errorf("unexpected delays %v, %v", attempts[0].Delay, attempts[1].Delay)
+	}
+}
+
+func TestRetrierThrottledByBudget(t *testing.T) {
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 0.5, MinRetries: 0, Window: time.Minute})
+	config := DefaultRetryConfig()
+	config.Budget = budget
+	retrier := NewRetrier(config)
+	noSleep(retrier)
+
+	calls := 0
+	for i := 0; i < 4; i++ {
+		retrier.Do(func() error {
+			calls++
+			return errors.New("temporary error")
+		})
+	}
+
+	// 4 first attempts allow 2 retries in total
+	if stats := budget.Stats(); stats.Calls != 4 || stats.Retries != 2 || calls != 6 {
+		t.Errorf("expected 6 calls and 2 retries, got %d calls, %+v", calls, stats)
+	}
+}
@@ -0,0 +1,179 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pruneResults deletes raw monitoring results that are older than the
+// owner's plan retention and already covered by the 1m rollup.
+func (s *Service) pruneResults(ctx context.Context) error {
+	job := "prune:monitoring_results"
+
+	rolledUp, ok, err := readWatermark(ctx, s.db, jobName(&resultsSource{}, Resolutions[0].Name), false)
+	if err != nil || !ok {
+		s.record(job, time.Time{}, 0, err == nil, err)
+		return err
+	}
+
+	// Symfony owns the users table; without it every endpoint gets the
+	// default plan.
+	var hasUsers bool
+	if err := s.db.QueryRowContext(ctx, `SELECT to_regclass('users') IS NOT NULL`).Scan(&hasUsers); err != nil {
+		err = fmt.Errorf("failed to look up users table: %w", err)
+		s.record(job, time.Time{}, 0, false, err)
+		return err
+	}
+
+	plans := make([]string, 0, len(s.config.PlanRetention))
+	for plan := range s.config.PlanRetention {
+		plans = append(plans, plan)
+	}
+	sort.Strings(plans)
+
+	now := time.Now().UTC()
+	var total int64
+	for _, plan := range plans {
+		if !hasUsers && plan != s.config.DefaultPlan {
+			continue
+		}
+
+		cutoff := earlier(now.Add(-s.config.PlanRetention[plan]), rolledUp)
+
+		var deleted int64
+		if hasUsers {
+			deleted, err = s.deleteBatches(ctx, `
+				DELETE FROM monitoring_results WHERE id IN (
+					SELECT mr.id
+					FROM monitoring_results mr
+					LEFT JOIN api_endpoints e ON e.id = mr.endpoint_id
+					LEFT JOIN users u ON u.id::text = e.user_id::text
+					WHERE mr.checked_at < $1
+					  AND CASE WHEN u.subscription_tier = ANY($3) THEN u.subscription_tier ELSE $4 END = $5
+					LIMIT $2
+				)`, cutoff, pq.Array(plans), s.config.DefaultPlan, plan)
+		} else {
+			deleted, err = s.deleteBatches(ctx, `
+				DELETE FROM monitoring_results WHERE id IN (
+					SELECT id FROM monitoring_results WHERE checked_at < $1 LIMIT $2
+				)`, cutoff)
+		}
+		total += deleted
+		if err != nil {
+			err = fmt.Errorf("failed to prune %s plan results: %w", plan, err)
+			s.record(job, time.Time{}, total, false, err)
+			return err
+		}
+	}
+
+	s.record(job, rolledUp, total, true, nil)
+	return nil
+}
+
+// pruneMetrics deletes raw system metrics past MetricsRetention that are
+// already covered by the 1m rollup.
+func (s *Service) pruneMetrics(ctx context.Context) error {
+	job := "prune:system_metrics"
+
+	rolledUp, ok, err := readWatermark(ctx, s.db, jobName(&metricsSource{}, Resolutions[0].Name), false)
+	if err != nil || !ok {
+		s.record(job, time.Time{}, 0, err == nil, err)
+		return err
+	}
+
+	cutoff := earlier(time.Now().UTC().Add(-s.config.MetricsRetention), rolledUp)
+
+	deleted, err := s.deleteBatches(ctx, `
+		DELETE FROM system_metrics WHERE id IN (
+			SELECT id FROM system_metrics WHERE timestamp < $1 LIMIT $2
+		)`, cutoff)
+	if err != nil {
+		err = fmt.Errorf("failed to prune system metrics: %w", err)
+	}
+
+	s.record(job, cutoff, deleted, err == nil, err)
+	return err
+}
+
+// pruneRollups deletes rollups past their resolution's retention once the
+// next coarser resolution has absorbed them.
+func (s *Service) pruneRollups(ctx context.Context, src source) error {
+	table := src.rollupTable()
+	job := "prune:" + table
+
+	now := time.Now().UTC()
+	var total int64
+	for i, res := range Resolutions {
+		retention := s.config.RollupRetention[res.Name]
+		if retention <= 0 {
+			continue
+		}
+
+		cutoff := now.Add(-retention)
+		if i+1 < len(Resolutions) {
+			absorbed, ok, err := readWatermark(ctx, s.db, jobName(src, Resolutions[i+1].Name), false)
+			if err != nil {
+				s.record(job, time.Time{}, total, false, err)
+				return err
+			}
+			if !ok {
+				continue
+			}
+			cutoff = earlier(cutoff, absorbed)
+		}
+
+		deleted, err := s.deleteBatches(ctx, `
+			DELETE FROM `+table+` WHERE ctid IN (
+				SELECT ctid FROM `+table+` WHERE bucket_start < $1 AND resolution = $3 LIMIT $2
+			)`, cutoff, res.Name)
+		total += deleted
+		if err != nil {
+			err = fmt.Errorf("failed to prune %s rollups from %s: %w", res.Name, table, err)
+			s.record(job, time.Time{}, total, false, err)
+			return err
+		}
+	}
+
+	s.record(job, time.Time{}, total, true, nil)
+	return nil
+}
+
+// deleteBatches runs a DELETE whose first two parameters are the cutoff and
+// the batch size until it removes fewer rows than a full batch. Small
+// batches keep locks and WAL bursts short on large tables.
+func (s *Service) deleteBatches(ctx context.Context, query string, cutoff time.Time, args ...interface{}) (int64, error) {
+	params := append([]interface{}{cutoff, s.config.DeleteBatchSize}, args...)
+
+	var total int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+
+		result, err := s.db.ExecContext(ctx, query, params...)
+		if err != nil {
+			return total, err
+		}
+
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += affected
+
+		if affected < int64(s.config.DeleteBatchSize) {
+			return total, nil
+		}
+	}
+}
+
+func earlier(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
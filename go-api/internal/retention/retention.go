@@ -0,0 +1,322 @@
+// Package retention rolls raw monitoring results and metrics up into
+// 1-minute, 1-hour and 1-day aggregates and prunes raw rows once they are
+// both rolled up and older than the retention period.
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"api-monitor-go/internal/sketch"
+)
+
+// Resolution is a rollup granularity
+type Resolution struct {
+	Name string
+	Step time.Duration
+}
+
+// Resolutions are rolled up in order, each from the one before it
+var Resolutions = []Resolution{
+	{Name: "1m", Step: time.Minute},
+	{Name: "1h", Step: time.Hour},
+	{Name: "1d", Step: 24 * time.Hour},
+}
+
+// Source names, as used in watermark job names
+const (
+	SourceMonitoringResults = "monitoring_results"
+	SourceSystemMetrics     = "system_metrics"
+)
+
+// JobName returns the watermark job name for a source at a resolution
+func JobName(source, resolution string) string {
+	return source + ":" + resolution
+}
+
+// Watermarks returns how far each resolution of source has been rolled up,
+// keyed by resolution name. Everything before a watermark is covered by
+// that resolution's rollups. Resolutions that haven't started are missing.
+func Watermarks(ctx context.Context, db *sql.DB, source string) (map[string]time.Time, error) {
+	var exists bool
+	if err := db.QueryRowContext(ctx, `SELECT to_regclass('retention_watermarks') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("failed to look up retention_watermarks: %w", err)
+	}
+
+	watermarks := make(map[string]time.Time)
+	if !exists {
+		return watermarks, nil
+	}
+
+	for _, res := range Resolutions {
+		watermark, ok, err := readWatermark(ctx, db, JobName(source, res.Name), false)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			watermarks[res.Name] = watermark
+		}
+	}
+
+	return watermarks, nil
+}
+
+// Config holds retention configuration
+type Config struct {
+	Interval  time.Duration // time between background runs
+	MaxWindow time.Duration // raw time range aggregated per transaction
+	MaxSteps  int           // windows processed per job per run
+
+	// Lateness is how long to wait for late rows before closing a bucket.
+	// Buckets are never rolled up again, and raw rows are pruned once
+	// their bucket is closed, so a row committed after its bucket closed
+	// is lost. Lateness must cover the longest delay between a check and
+	// its row being written: check agents buffer results while
+	// disconnected, and batched writes retry through short outages.
+	Lateness time.Duration
+
+	// PlanRetention is how long raw monitoring results are kept per
+	// subscription tier; users on unknown tiers get DefaultPlan.
+	PlanRetention map[string]time.Duration
+	DefaultPlan   string
+
+	// MetricsRetention is how long raw system_metrics rows are kept
+	MetricsRetention time.Duration
+
+	// RollupRetention is how long rollups are kept per resolution name;
+	// zero or missing keeps them forever.
+	RollupRetention map[string]time.Duration
+
+	DeleteBatchSize int
+	SketchAccuracy  float64
+}
+
+// DefaultConfig returns default retention configuration
+func DefaultConfig() Config {
+	return Config{
+		Interval:  time.Minute,
+		Lateness:  20 * time.Minute,
+		MaxWindow: 15 * time.Minute,
+		MaxSteps:  100,
+		PlanRetention: map[string]time.Duration{
+			"free":       7 * 24 * time.Hour,
+			"pro":        30 * 24 * time.Hour,
+			"enterprise": 90 * 24 * time.Hour,
+		},
+		DefaultPlan:      "free",
+		MetricsRetention: 7 * 24 * time.Hour,
+		RollupRetention: map[string]time.Duration{
+			"1m": 7 * 24 * time.Hour,
+			"1h": 90 * 24 * time.Hour,
+		},
+		DeleteBatchSize: 10000,
+		SketchAccuracy:  sketch.DefaultRelativeAccuracy,
+	}
+}
+
+// JobProgress reports how far a rollup or prune job has got
+type JobProgress struct {
+	Job       string        `json:"job"`
+	Watermark time.Time     `json:"watermark,omitempty"`
+	Lag       time.Duration `json:"lag"`
+	Rows      int64         `json:"rows"`
+	LastRunAt time.Time     `json:"last_run_at"`
+	LastError string        `json:"last_error,omitempty"`
+	CaughtUp  bool          `json:"caught_up"`
+}
+
+// Service runs rollups and pruning in the background. Progress is stored
+// in retention_watermarks, so work resumes where it stopped after a restart
+// and several replicas can run side by side.
+type Service struct {
+	db      *sql.DB
+	config  Config
+	sources []source
+	log     *logger.Logger
+
+	mu       sync.Mutex
+	progress map[string]*JobProgress
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	quit      chan struct{}
+	done      chan struct{}
+}
+
+// NewService creates a retention service
+func NewService(db *sql.DB, config Config) *Service {
+	defaults := DefaultConfig()
+	if config.Interval <= 0 {
+		config.Interval = defaults.Interval
+	}
+	if config.Lateness < 0 {
+		config.Lateness = defaults.Lateness
+	}
+	if config.MaxWindow <= 0 {
+		config.MaxWindow = defaults.MaxWindow
+	}
+	if config.MaxSteps <= 0 {
+		config.MaxSteps = defaults.MaxSteps
+	}
+	if config.PlanRetention == nil {
+		config.PlanRetention = defaults.PlanRetention
+	}
+	if config.DefaultPlan == "" {
+		config.DefaultPlan = defaults.DefaultPlan
+	}
+	if config.MetricsRetention <= 0 {
+		config.MetricsRetention = defaults.MetricsRetention
+	}
+	if config.RollupRetention == nil {
+		config.RollupRetention = defaults.RollupRetention
+	}
+	if config.DeleteBatchSize <= 0 {
+		config.DeleteBatchSize = defaults.DeleteBatchSize
+	}
+	if config.SketchAccuracy <= 0 {
+		config.SketchAccuracy = defaults.SketchAccuracy
+	}
+
+	return &Service{
+		db:     db,
+		config: config,
+		sources: []source{
+			&resultsSource{accuracy: config.SketchAccuracy},
+			&metricsSource{accuracy: config.SketchAccuracy},
+		},
+		log:      logger.New().WithField("component", "retention"),
+		progress: make(map[string]*JobProgress),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start runs retention in the background until Stop is called
+func (s *Service) Start() {
+	s.startOnce.Do(func() {
+		go s.loop()
+	})
+}
+
+// Stop stops the background loop and waits for the current run to finish
+func (s *Service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		close(s.quit)
+	})
+
+	// If Start was never called there is no loop to wait for
+	s.startOnce.Do(func() {
+		close(s.done)
+	})
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Progress returns a snapshot of every job's progress, sorted by job name
+func (s *Service) Progress() []JobProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	progress := make([]JobProgress, 0, len(s.progress))
+	for _, p := range s.progress {
+		snapshot := *p
+		if !snapshot.Watermark.IsZero() {
+			snapshot.Lag = now.Sub(snapshot.Watermark)
+		}
+		progress = append(progress, snapshot)
+	}
+
+	sort.Slice(progress, func(i, j int) bool {
+		return progress[i].Job < progress[j].Job
+	})
+
+	return progress
+}
+
+// RunOnce advances every rollup as far as allowed and then prunes
+func (s *Service) RunOnce(ctx context.Context) error {
+	var firstErr error
+	keep := func(err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, src := range s.sources {
+		for i := range Resolutions {
+			keep(s.rollUp(ctx, src, i))
+		}
+	}
+
+	keep(s.pruneResults(ctx))
+	keep(s.pruneMetrics(ctx))
+	for _, src := range s.sources {
+		keep(s.pruneRollups(ctx, src))
+	}
+
+	return firstErr
+}
+
+func (s *Service) loop() {
+	defer close(s.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-s.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			s.log.Errorf("retention run failed: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// record updates the progress entry for a job
+func (s *Service) record(job string, watermark time.Time, rows int64, caughtUp bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.progress[job]
+	if !ok {
+		p = &JobProgress{Job: job}
+		s.progress[job] = p
+	}
+
+	if !watermark.IsZero() {
+		p.Watermark = watermark
+	}
+	p.Rows += rows
+	p.LastRunAt = time.Now().UTC()
+	p.CaughtUp = caughtUp
+	p.LastError = ""
+	if err != nil {
+		p.LastError = err.Error()
+	}
+}
@@ -0,0 +1,323 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"api-monitor-go/internal/sketch"
+)
+
+// maxBindParams is PostgreSQL's limit on parameters per statement
+const maxBindParams = 65535
+
+// bucketKey identifies one series in one time bucket
+type bucketKey struct {
+	series string // endpoint ID or metric name
+	tags   string // canonical tags JSON, empty for results
+	start  time.Time
+}
+
+// bucket accumulates the aggregate for one bucketKey
+type bucket struct {
+	count  int64
+	errors int64
+	sketch *sketch.Sketch
+}
+
+// buckets groups aggregates by series and bucket start
+type buckets map[bucketKey]*bucket
+
+func (b buckets) get(key bucketKey, accuracy float64) *bucket {
+	agg, ok := b[key]
+	if !ok {
+		agg = &bucket{sketch: sketch.New(accuracy)}
+		b[key] = agg
+	}
+	return agg
+}
+
+// source is a raw table that can be rolled up
+type source interface {
+	// name identifies the source in watermarks and progress
+	name() string
+
+	// earliest returns the oldest raw timestamp, or the oldest bucket at
+	// the given resolution when resolution is not empty
+	earliest(ctx context.Context, tx *sql.Tx, resolution string) (time.Time, bool, error)
+
+	// loadRaw aggregates raw rows in [from, to) into buckets of size step
+	loadRaw(ctx context.Context, tx *sql.Tx, from, to time.Time, step time.Duration) (buckets, int64, error)
+
+	// loadRollups merges rollups of a finer resolution in [from, to)
+	loadRollups(ctx context.Context, tx *sql.Tx, resolution string, from, to time.Time, step time.Duration) (buckets, int64, error)
+
+	// store upserts aggregated buckets at the given resolution
+	store(ctx context.Context, tx *sql.Tx, resolution string, agg buckets) error
+
+	// rollupTable is the table store writes to
+	rollupTable() string
+}
+
+// jobName returns the watermark key for a source at a resolution
+func jobName(src source, resolution string) string {
+	return JobName(src.name(), resolution)
+}
+
+// windowEnd returns where the next window starting at watermark should end,
+// never going past limit.
+func windowEnd(watermark, limit time.Time, step, maxWindow time.Duration) time.Time {
+	window := maxWindow - maxWindow%step
+	if window < step {
+		window = step
+	}
+
+	end := watermark.Add(window)
+	if end.After(limit) {
+		end = limit
+	}
+	return end
+}
+
+// rollUp advances one source at one resolution until it catches up or
+// MaxSteps windows have been processed.
+func (s *Service) rollUp(ctx context.Context, src source, resIdx int) error {
+	job := jobName(src, Resolutions[resIdx].Name)
+
+	for step := 0; step < s.config.MaxSteps; step++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		watermark, rows, advanced, err := s.advance(ctx, src, resIdx)
+		if err != nil {
+			s.record(job, time.Time{}, 0, false, err)
+			return fmt.Errorf("%s rollup failed: %w", job, err)
+		}
+
+		s.record(job, watermark, rows, !advanced, nil)
+		if !advanced {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// advance aggregates the next window after the watermark in a single
+// transaction. It reports false once there is nothing more to do.
+func (s *Service) advance(ctx context.Context, src source, resIdx int) (time.Time, int64, bool, error) {
+	res := Resolutions[resIdx]
+	job := jobName(src, res.Name)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, 0, false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	lowerRes := ""
+	limit := time.Now().UTC().Add(-s.config.Lateness).Truncate(res.Step)
+	if resIdx > 0 {
+		// A coarser rollup can only cover what the finer one has finished
+		lowerRes = Resolutions[resIdx-1].Name
+		lowerWatermark, ok, err := readWatermark(ctx, tx, jobName(src, lowerRes), false)
+		if err != nil || !ok {
+			return time.Time{}, 0, false, err
+		}
+		if lw := lowerWatermark.Truncate(res.Step); lw.Before(limit) {
+			limit = lw
+		}
+	}
+
+	watermark, ok, err := readWatermark(ctx, tx, job, true)
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+	if !ok {
+		earliest, found, err := src.earliest(ctx, tx, lowerRes)
+		if err != nil || !found {
+			return time.Time{}, 0, false, err
+		}
+		if err := initWatermark(ctx, tx, job, earliest.UTC().Truncate(res.Step)); err != nil {
+			return time.Time{}, 0, false, err
+		}
+		// Re-read under lock in case another replica initialised it first
+		if watermark, _, err = readWatermark(ctx, tx, job, true); err != nil {
+			return time.Time{}, 0, false, err
+		}
+	}
+
+	if !watermark.Before(limit) {
+		return watermark, 0, false, tx.Commit()
+	}
+
+	end := windowEnd(watermark, limit, res.Step, s.config.MaxWindow)
+
+	var agg buckets
+	var rows int64
+	if resIdx == 0 {
+		agg, rows, err = src.loadRaw(ctx, tx, watermark, end, res.Step)
+	} else {
+		agg, rows, err = src.loadRollups(ctx, tx, lowerRes, watermark, end, res.Step)
+	}
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+
+	if err := src.store(ctx, tx, res.Name, agg); err != nil {
+		return time.Time{}, 0, false, err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE retention_watermarks SET watermark = $2, updated_at = $3 WHERE job = $1`,
+		job, end, time.Now().UTC()); err != nil {
+		return time.Time{}, 0, false, fmt.Errorf("failed to update watermark: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return time.Time{}, 0, false, fmt.Errorf("failed to commit rollup: %w", err)
+	}
+
+	return end, rows, true, nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// readWatermark returns the watermark for job, optionally locking the row
+func readWatermark(ctx context.Context, q queryer, job string, forUpdate bool) (time.Time, bool, error) {
+	query := `SELECT watermark FROM retention_watermarks WHERE job = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var watermark time.Time
+	err := q.QueryRowContext(ctx, query, job).Scan(&watermark)
+	if err == sql.ErrNoRows {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to read watermark for %s: %w", job, err)
+	}
+
+	return watermark.UTC(), true, nil
+}
+
+func initWatermark(ctx context.Context, tx *sql.Tx, job string, watermark time.Time) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO retention_watermarks (job, watermark) VALUES ($1, $2) ON CONFLICT (job) DO NOTHING`,
+		job, watermark)
+	if err != nil {
+		return fmt.Errorf("failed to initialise watermark for %s: %w", job, err)
+	}
+	return nil
+}
+
+// queryEarliest runs a MIN() query and reports whether a value was found
+func queryEarliest(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (time.Time, bool, error) {
+	var earliest sql.NullTime
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&earliest); err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to find earliest row: %w", err)
+	}
+	if !earliest.Valid {
+		return time.Time{}, false, nil
+	}
+	return earliest.Time.UTC(), true, nil
+}
+
+// upsertRows writes rows with a multi-row INSERT, chunked to stay below the
+// bind parameter limit. prefix ends in VALUES and suffix is the ON CONFLICT clause.
+func upsertRows(ctx context.Context, tx *sql.Tx, prefix, suffix string, rows [][]interface{}) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	cols := len(rows[0])
+	chunk := maxBindParams / cols
+
+	for start := 0; start < len(rows); start += chunk {
+		end := start + chunk
+		if end > len(rows) {
+			end = len(rows)
+		}
+
+		var sb strings.Builder
+		sb.WriteString(prefix)
+		args := make([]interface{}, 0, (end-start)*cols)
+		for i, row := range rows[start:end] {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteString("(")
+			for j := range row {
+				if j > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString("$" + strconv.Itoa(len(args)+j+1))
+			}
+			sb.WriteString(")")
+			args = append(args, row...)
+		}
+		sb.WriteString(" ")
+		sb.WriteString(suffix)
+
+		if _, err := tx.ExecContext(ctx, sb.String(), args...); err != nil {
+			return fmt.Errorf("failed to upsert %d rollups: %w", end-start, err)
+		}
+	}
+
+	return nil
+}
+
+// sketchStats returns nullable min/max and the encoded sketch of a bucket
+func sketchStats(agg *bucket) (sql.NullFloat64, sql.NullFloat64, []byte, error) {
+	var min, max sql.NullFloat64
+	if agg.sketch.Count() > 0 {
+		min = sql.NullFloat64{Float64: agg.sketch.Min(), Valid: true}
+		max = sql.NullFloat64{Float64: agg.sketch.Max(), Valid: true}
+	}
+
+	encoded, err := json.Marshal(agg.sketch)
+	if err != nil {
+		return min, max, nil, fmt.Errorf("failed to encode sketch: %w", err)
+	}
+
+	return min, max, encoded, nil
+}
+
+// decodeSketch parses a stored sketch, tolerating NULL
+func decodeSketch(data []byte, accuracy float64) (*sketch.Sketch, error) {
+	if len(data) == 0 {
+		return sketch.New(accuracy), nil
+	}
+
+	var sk sketch.Sketch
+	if err := json.Unmarshal(data, &sk); err != nil {
+		return nil, err
+	}
+	return &sk, nil
+}
+
+// canonicalTags re-encodes a tags document with sorted keys so identical
+// tag sets always map to the same series
+func canonicalTags(raw []byte) (string, error) {
+	tags := map[string]string{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &tags); err != nil {
+			return "", fmt.Errorf("failed to decode tags: %w", err)
+		}
+	}
+
+	encoded, err := json.Marshal(tags)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
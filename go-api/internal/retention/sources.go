@@ -0,0 +1,290 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// resultsSource rolls up monitoring_results per endpoint. A result counts
+// as an error when the check got no response or a 4xx/5xx status.
+type resultsSource struct {
+	accuracy float64
+}
+
+func (r *resultsSource) name() string {
+	return SourceMonitoringResults
+}
+
+func (r *resultsSource) rollupTable() string {
+	return "monitoring_result_rollups"
+}
+
+func (r *resultsSource) earliest(ctx context.Context, tx *sql.Tx, resolution string) (time.Time, bool, error) {
+	if resolution == "" {
+		return queryEarliest(ctx, tx, `SELECT MIN(checked_at) FROM monitoring_results`)
+	}
+	return queryEarliest(ctx, tx,
+		`SELECT MIN(bucket_start) FROM monitoring_result_rollups WHERE resolution = $1`, resolution)
+}
+
+func (r *resultsSource) loadRaw(ctx context.Context, tx *sql.Tx, from, to time.Time, step time.Duration) (buckets, int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT endpoint_id, checked_at, response_time, status_code
+		FROM monitoring_results
+		WHERE checked_at >= $1 AND checked_at < $2
+	`, from, to)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query results: %w", err)
+	}
+	defer rows.Close()
+
+	agg := make(buckets)
+	var count int64
+	for rows.Next() {
+		var endpointID int
+		var checkedAt time.Time
+		var responseTime, statusCode sql.NullInt64
+
+		if err := rows.Scan(&endpointID, &checkedAt, &responseTime, &statusCode); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan result: %w", err)
+		}
+
+		b := agg.get(bucketKey{
+			series: strconv.Itoa(endpointID),
+			start:  checkedAt.UTC().Truncate(step),
+		}, r.accuracy)
+
+		b.count++
+		if !statusCode.Valid || statusCode.Int64 >= 400 {
+			b.errors++
+		}
+		if responseTime.Valid {
+			b.sketch.Add(float64(responseTime.Int64))
+		}
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating result rows: %w", err)
+	}
+
+	return agg, count, nil
+}
+
+func (r *resultsSource) loadRollups(ctx context.Context, tx *sql.Tx, resolution string, from, to time.Time, step time.Duration) (buckets, int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT endpoint_id, bucket_start, count, error_count, sketch
+		FROM monitoring_result_rollups
+		WHERE resolution = $1 AND bucket_start >= $2 AND bucket_start < $3
+	`, resolution, from, to)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query result rollups: %w", err)
+	}
+	defer rows.Close()
+
+	agg := make(buckets)
+	var count int64
+	for rows.Next() {
+		var endpointID int
+		var bucketStart time.Time
+		var n, errs int64
+		var sketchJSON []byte
+
+		if err := rows.Scan(&endpointID, &bucketStart, &n, &errs, &sketchJSON); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan result rollup: %w", err)
+		}
+
+		sk, err := decodeSketch(sketchJSON, r.accuracy)
+		if err != nil {
+			return nil, 0, fmt.Errorf("endpoint %d at %s: %w", endpointID, bucketStart, err)
+		}
+
+		b := agg.get(bucketKey{
+			series: strconv.Itoa(endpointID),
+			start:  bucketStart.UTC().Truncate(step),
+		}, r.accuracy)
+
+		b.count += n
+		b.errors += errs
+		if err := b.sketch.Merge(sk); err != nil {
+			return nil, 0, err
+		}
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating result rollup rows: %w", err)
+	}
+
+	return agg, count, nil
+}
+
+func (r *resultsSource) store(ctx context.Context, tx *sql.Tx, resolution string, agg buckets) error {
+	rows := make([][]interface{}, 0, len(agg))
+	for key, b := range agg {
+		endpointID, err := strconv.Atoi(key.series)
+		if err != nil {
+			return fmt.Errorf("invalid endpoint series %q: %w", key.series, err)
+		}
+
+		min, max, encoded, err := sketchStats(b)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, []interface{}{
+			endpointID, resolution, key.start, b.count, b.errors, min, max, b.sketch.Sum(), encoded,
+		})
+	}
+
+	return upsertRows(ctx, tx, `
+		INSERT INTO monitoring_result_rollups
+			(endpoint_id, resolution, bucket_start, count, error_count,
+			 min_response_time, max_response_time, sum_response_time, sketch)
+		VALUES `, `
+		ON CONFLICT (endpoint_id, resolution, bucket_start) DO UPDATE SET
+			count = EXCLUDED.count,
+			error_count = EXCLUDED.error_count,
+			min_response_time = EXCLUDED.min_response_time,
+			max_response_time = EXCLUDED.max_response_time,
+			sum_response_time = EXCLUDED.sum_response_time,
+			sketch = EXCLUDED.sketch`, rows)
+}
+
+// metricsSource rolls up system_metrics per metric name and tag set
+type metricsSource struct {
+	accuracy float64
+}
+
+func (m *metricsSource) name() string {
+	return SourceSystemMetrics
+}
+
+func (m *metricsSource) rollupTable() string {
+	return "system_metric_rollups"
+}
+
+func (m *metricsSource) earliest(ctx context.Context, tx *sql.Tx, resolution string) (time.Time, bool, error) {
+	if resolution == "" {
+		return queryEarliest(ctx, tx, `SELECT MIN(timestamp) FROM system_metrics`)
+	}
+	return queryEarliest(ctx, tx,
+		`SELECT MIN(bucket_start) FROM system_metric_rollups WHERE resolution = $1`, resolution)
+}
+
+func (m *metricsSource) loadRaw(ctx context.Context, tx *sql.Tx, from, to time.Time, step time.Duration) (buckets, int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT name, tags, value, timestamp
+		FROM system_metrics
+		WHERE timestamp >= $1 AND timestamp < $2
+	`, from, to)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query metrics: %w", err)
+	}
+	defer rows.Close()
+
+	agg := make(buckets)
+	var count int64
+	for rows.Next() {
+		var name string
+		var tagsJSON []byte
+		var value float64
+		var timestamp time.Time
+
+		if err := rows.Scan(&name, &tagsJSON, &value, &timestamp); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan metric: %w", err)
+		}
+
+		tags, err := canonicalTags(tagsJSON)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		b := agg.get(bucketKey{series: name, tags: tags, start: timestamp.UTC().Truncate(step)}, m.accuracy)
+		b.count++
+		b.sketch.Add(value)
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating metric rows: %w", err)
+	}
+
+	return agg, count, nil
+}
+
+func (m *metricsSource) loadRollups(ctx context.Context, tx *sql.Tx, resolution string, from, to time.Time, step time.Duration) (buckets, int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT name, tags, bucket_start, count, sketch
+		FROM system_metric_rollups
+		WHERE resolution = $1 AND bucket_start >= $2 AND bucket_start < $3
+	`, resolution, from, to)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query metric rollups: %w", err)
+	}
+	defer rows.Close()
+
+	agg := make(buckets)
+	var count int64
+	for rows.Next() {
+		var name string
+		var tagsJSON, sketchJSON []byte
+		var bucketStart time.Time
+		var n int64
+
+		if err := rows.Scan(&name, &tagsJSON, &bucketStart, &n, &sketchJSON); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan metric rollup: %w", err)
+		}
+
+		tags, err := canonicalTags(tagsJSON)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		sk, err := decodeSketch(sketchJSON, m.accuracy)
+		if err != nil {
+			return nil, 0, fmt.Errorf("metric %s at %s: %w", name, bucketStart, err)
+		}
+
+		b := agg.get(bucketKey{series: name, tags: tags, start: bucketStart.UTC().Truncate(step)}, m.accuracy)
+		b.count += n
+		if err := b.sketch.Merge(sk); err != nil {
+			return nil, 0, err
+		}
+		count++
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("error iterating metric rollup rows: %w", err)
+	}
+
+	return agg, count, nil
+}
+
+func (m *metricsSource) store(ctx context.Context, tx *sql.Tx, resolution string, agg buckets) error {
+	rows := make([][]interface{}, 0, len(agg))
+	for key, b := range agg {
+		min, max, encoded, err := sketchStats(b)
+		if err != nil {
+			return err
+		}
+
+		rows = append(rows, []interface{}{
+			key.series, key.tags, resolution, key.start, b.count, min, max, b.sketch.Sum(), encoded,
+		})
+	}
+
+	return upsertRows(ctx, tx, `
+		INSERT INTO system_metric_rollups
+			(name, tags, resolution, bucket_start, count, min_value, max_value, sum_value, sketch)
+		VALUES `, `
+		ON CONFLICT (name, tags, resolution, bucket_start) DO UPDATE SET
+			count = EXCLUDED.count,
+			min_value = EXCLUDED.min_value,
+			max_value = EXCLUDED.max_value,
+			sum_value = EXCLUDED.sum_value,
+			sketch = EXCLUDED.sketch`, rows)
+}
@@ -0,0 +1,313 @@
+// Package sketch implements a mergeable quantile sketch with bounded
+// relative error, in the style of DDSketch. Values are counted in
+// logarithmically sized bins, so two sketches built with the same accuracy
+// can be merged exactly and quantiles over long ranges can be computed from
+// stored rollups without rereading raw data.
+package sketch
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultRelativeAccuracy keeps quantile estimates within 1% of the true value
+const DefaultRelativeAccuracy = 0.01
+
+// minIndexableValue is the smallest magnitude tracked in log bins; smaller
+// values are counted as zero.
+const minIndexableValue = 1e-9
+
+// Sketch is a mergeable quantile sketch. The zero value is not usable; call New.
+type Sketch struct {
+	alpha     float64
+	gamma     float64
+	logGamma  float64
+	positive  map[int]uint64
+	negative  map[int]uint64
+	zeroCount uint64
+	count     uint64
+	sum       float64
+	sumSq     float64
+	min       float64
+	max       float64
+}
+
+// New creates an empty sketch whose quantiles are accurate to within the
+// given relative error (e.g. 0.01 for 1%).
+func New(relativeAccuracy float64) *Sketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = DefaultRelativeAccuracy
+	}
+
+	gamma := (1 + relativeAccuracy) / (1 - relativeAccuracy)
+	return &Sketch{
+		alpha:    relativeAccuracy,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int]uint64),
+		negative: make(map[int]uint64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}
+}
+
+// Add records a single value
+func (s *Sketch) Add(value float64) {
+	s.AddN(value, 1)
+}
+
+// AddN records a value n times
+func (s *Sketch) AddN(value float64, n uint64) {
+	if n == 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+
+	switch {
+	case value > minIndexableValue:
+		s.positive[s.index(value)] += n
+	case value < -minIndexableValue:
+		s.negative[s.index(-value)] += n
+	default:
+		s.zeroCount += n
+	}
+
+	s.count += n
+	s.sum += value * float64(n)
+	s.sumSq += value * value * float64(n)
+	if value < s.min {
+		s.min = value
+	}
+	if value > s.max {
+		s.max = value
+	}
+}
+
+// Merge folds other into s. Both sketches must use the same accuracy.
+func (s *Sketch) Merge(other *Sketch) error {
+	if other == nil || other.count == 0 {
+		return nil
+	}
+	if other.alpha != s.alpha {
+		return fmt.Errorf("cannot merge sketches with accuracy %g and %g", s.alpha, other.alpha)
+	}
+
+	for k, c := range other.positive {
+		s.positive[k] += c
+	}
+	for k, c := range other.negative {
+		s.negative[k] += c
+	}
+	s.zeroCount += other.zeroCount
+	s.count += other.count
+	s.sum += other.sum
+	s.sumSq += other.sumSq
+	if other.min < s.min {
+		s.min = other.min
+	}
+	if other.max > s.max {
+		s.max = other.max
+	}
+
+	return nil
+}
+
+// Count returns the number of recorded values
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Sum returns the sum of recorded values
+func (s *Sketch) Sum() float64 {
+	return s.sum
+}
+
+// StdDev returns the sample standard deviation of recorded values, or 0
+// if fewer than two values were recorded.
+func (s *Sketch) StdDev() float64 {
+	if s.count < 2 {
+		return 0
+	}
+
+	n := float64(s.count)
+	variance := (s.sumSq - s.sum*s.sum/n) / (n - 1)
+	if variance <= 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+// Min returns the smallest recorded value, or 0 if empty
+func (s *Sketch) Min() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.min
+}
+
+// Max returns the largest recorded value, or 0 if empty
+func (s *Sketch) Max() float64 {
+	if s.count == 0 {
+		return 0
+	}
+	return s.max
+}
+
+// Quantile returns the estimated value at quantile q (0 <= q <= 1), or 0
+// if the sketch is empty.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 || q < 0 || q > 1 {
+		return 0
+	}
+	if q == 0 {
+		return s.min
+	}
+	if q == 1 {
+		return s.max
+	}
+
+	rank := uint64(q * float64(s.count-1))
+	var seen uint64
+
+	// Negative values from most to least negative
+	negKeys := sortedKeys(s.negative)
+	for i := len(negKeys) - 1; i >= 0; i-- {
+		seen += s.negative[negKeys[i]]
+		if seen > rank {
+			return s.clamp(-s.value(negKeys[i]))
+		}
+	}
+
+	seen += s.zeroCount
+	if seen > rank {
+		return s.clamp(0)
+	}
+
+	for _, k := range sortedKeys(s.positive) {
+		seen += s.positive[k]
+		if seen > rank {
+			return s.clamp(s.value(k))
+		}
+	}
+
+	return s.max
+}
+
+// CountAtOrBelow estimates how many recorded values are <= x. Values in
+// the same bin as x are counted as below it, so the estimate is exact
+// except for values within the relative accuracy of x.
+func (s *Sketch) CountAtOrBelow(x float64) uint64 {
+	if s.count == 0 || x < s.min {
+		return 0
+	}
+	if x >= s.max {
+		return s.count
+	}
+
+	var n uint64
+	switch {
+	case x > minIndexableValue:
+		limit := s.index(x)
+		for _, c := range s.negative {
+			n += c
+		}
+		n += s.zeroCount
+		for k, c := range s.positive {
+			if k <= limit {
+				n += c
+			}
+		}
+	case x < -minIndexableValue:
+		limit := s.index(-x)
+		for k, c := range s.negative {
+			if k >= limit {
+				n += c
+			}
+		}
+	default:
+		for _, c := range s.negative {
+			n += c
+		}
+		n += s.zeroCount
+	}
+
+	return n
+}
+
+// index maps a positive value to its bin
+func (s *Sketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// value returns the representative value of a bin
+func (s *Sketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+func (s *Sketch) clamp(v float64) float64 {
+	return math.Max(s.min, math.Min(s.max, v))
+}
+
+func sortedKeys(m map[int]uint64) []int {
+	keys := make([]int, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// encoded is the serialized form stored in rollup tables
+type encoded struct {
+	Alpha    float64        `json:"alpha"`
+	Count    uint64         `json:"count"`
+	Zero     uint64         `json:"zero,omitempty"`
+	Sum      float64        `json:"sum"`
+	SumSq    float64        `json:"sum_sq,omitempty"`
+	Min      float64        `json:"min"`
+	Max      float64        `json:"max"`
+	Positive map[int]uint64 `json:"pos,omitempty"`
+	Negative map[int]uint64 `json:"neg,omitempty"`
+}
+
+// MarshalJSON encodes the sketch for storage
+func (s *Sketch) MarshalJSON() ([]byte, error) {
+	return json.Marshal(encoded{
+		Alpha:    s.alpha,
+		Count:    s.count,
+		Zero:     s.zeroCount,
+		Sum:      s.sum,
+		SumSq:    s.sumSq,
+		Min:      s.Min(),
+		Max:      s.Max(),
+		Positive: s.positive,
+		Negative: s.negative,
+	})
+}
+
+// UnmarshalJSON decodes a sketch produced by MarshalJSON
+func (s *Sketch) UnmarshalJSON(data []byte) error {
+	var e encoded
+	if err := json.Unmarshal(data, &e); err != nil {
+		return fmt.Errorf("failed to decode sketch: %w", err)
+	}
+
+	*s = *New(e.Alpha)
+	for k, c := range e.Positive {
+		s.positive[k] = c
+	}
+	for k, c := range e.Negative {
+		s.negative[k] = c
+	}
+	s.zeroCount = e.Zero
+	s.count = e.Count
+	s.sum = e.Sum
+	s.sumSq = e.SumSq
+	if e.Count > 0 {
+		s.min = e.Min
+		s.max = e.Max
+	}
+
+	return nil
+}
@@ -0,0 +1,155 @@
+package sketch
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestQuantileWithinRelativeError(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := New(0.01)
+
+	values := make([]float64, 10000)
+	for i := range values {
+		values[i] = rng.ExpFloat64() * 200
+		s.Add(values[i])
+	}
+	sort.Float64s(values)
+
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		want := values[int(q*float64(len(values)-1))]
+		got := s.Quantile(q)
+		if math.Abs(got-want)/want > 0.02 {
+			t.Errorf("Quantile(%v) = %v, want %v within 2%%", q, got, want)
+		}
+	}
+
+	if s.Count() != uint64(len(values)) {
+		t.Errorf("Count() = %d, want %d", s.Count(), len(values))
+	}
+	if s.Min() != values[0] || s.Max() != values[len(values)-1] {
+		t.Errorf("Min/Max = %v/%v, want %v/%v", s.Min(), s.Max(), values[0], values[len(values)-1])
+	}
+}
+
+func TestMergeEqualsCombined(t *testing.T) {
+	a, b, combined := New(0.01), New(0.01), New(0.01)
+	for i := 1; i <= 1000; i++ {
+		v := float64(i)
+		if i%2 == 0 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+		combined.Add(v)
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	for _, q := range []float64{0, 0.25, 0.5, 0.99, 1} {
+		if a.Quantile(q) != combined.Quantile(q) {
+			t.Errorf("Quantile(%v): merged %v, combined %v", q, a.Quantile(q), combined.Quantile(q))
+		}
+	}
+}
+
+func TestMergeRejectsDifferentAccuracy(t *testing.T) {
+	a, b := New(0.01), New(0.05)
+	b.Add(1)
+
+	if err := a.Merge(b); err == nil {
+		t.Fatal("expected error merging sketches with different accuracy")
+	}
+}
+
+func TestNegativeAndZeroValues(t *testing.T) {
+	s := New(0.01)
+	for _, v := range []float64{-100, -10, 0, 0, 10, 100} {
+		s.Add(v)
+	}
+
+	if got := s.Quantile(0); got != -100 {
+		t.Errorf("Quantile(0) = %v, want -100", got)
+	}
+	if got := s.Quantile(0.5); got != 0 {
+		t.Errorf("Quantile(0.5) = %v, want 0", got)
+	}
+	if got := s.Quantile(1); got != 100 {
+		t.Errorf("Quantile(1) = %v, want 100", got)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	s := New(0.02)
+	for i := 0; i < 500; i++ {
+		s.Add(float64(i % 97))
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	var decoded Sketch
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+
+	if decoded.Count() != s.Count() || decoded.Sum() != s.Sum() {
+		t.Errorf("decoded count/sum = %d/%v, want %d/%v", decoded.Count(), decoded.Sum(), s.Count(), s.Sum())
+	}
+	for _, q := range []float64{0.1, 0.5, 0.9} {
+		if decoded.Quantile(q) != s.Quantile(q) {
+			t.Errorf("Quantile(%v) after round trip = %v, want %v", q, decoded.Quantile(q), s.Quantile(q))
+		}
+	}
+}
+
+func TestEmptySketch(t *testing.T) {
+	s := New(0.01)
+	if s.Quantile(0.5) != 0 || s.Min() != 0 || s.Max() != 0 {
+		t.Error("empty sketch should report zeros")
+	}
+}
+
+func TestStdDev(t *testing.T) {
+	s := New(0.01)
+	for _, v := range []float64{2, 4, 4, 4, 5, 5, 7, 9} {
+		s.Add(v)
+	}
+
+	// Sample standard deviation of the values above
+	want := math.Sqrt(32.0 / 7.0)
+	if got := s.StdDev(); math.Abs(got-want) > 1e-9 {
+		t.Errorf("StdDev() = %v, want %v", got, want)
+	}
+}
+
+func TestCountAtOrBelow(t *testing.T) {
+	s := New(0.01)
+	for i := 1; i <= 100; i++ {
+		s.Add(float64(i))
+	}
+
+	tests := []struct {
+		x    float64
+		want uint64
+	}{
+		{0, 0},
+		{10, 10},
+		{50, 50},
+		{100, 100},
+		{1000, 100},
+	}
+
+	for _, tt := range tests {
+		if got := s.CountAtOrBelow(tt.x); got != tt.want {
+			t.Errorf("CountAtOrBelow(%v) = %d, want %d", tt.x, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"api-monitor-go/internal/logger"
+	"github.com/redis/go-redis/v9"
+)
+
+// Fields added to dead-lettered entries next to the original values
+const (
+	DeadLetterFieldStream     = "dlq_stream"
+	DeadLetterFieldGroup      = "dlq_group"
+	DeadLetterFieldID         = "dlq_id"
+	DeadLetterFieldDeliveries = "dlq_deliveries"
+)
+
+// Message is a stream entry delivered to a Handler
+type Message struct {
+	ID     string
+	Stream string
+	Values map[string]interface{}
+
+	// Deliveries counts this delivery; it is above 1 for messages
+	// reclaimed from a consumer that didn't acknowledge them
+	Deliveries int64
+}
+
+// Handler processes a message. Returning nil acknowledges it; an error
+// leaves it pending so it is redelivered once it has been idle for
+// MinIdle.
+type Handler func(ctx context.Context, msg Message) error
+
+// ConsumerConfig holds consumer group configuration
+type ConsumerConfig struct {
+	Stream string
+	Group  string
+
+	// Consumer names this reader within the group; defaults to
+	// hostname-pid
+	Consumer string
+
+	// StartID is where a newly created group starts reading: "$" for new
+	// entries only (the default) or "0" for the whole stream
+	StartID string
+
+	// BatchSize is the most entries read or reclaimed at once
+	BatchSize int64
+
+	// Block is how long a read waits for new entries
+	Block time.Duration
+
+	// Entries pending for longer than MinIdle are reclaimed from their
+	// consumer every ClaimInterval
+	MinIdle       time.Duration
+	ClaimInterval time.Duration
+
+	// Entries delivered more than MaxDeliveries times are moved to
+	// DeadLetterStream (default Stream + ":dead") and acknowledged
+	MaxDeliveries    int64
+	DeadLetterStream string
+	DeadLetterTrim   TrimConfig
+}
+
+// DefaultConsumerConfig returns default consumer configuration
+func DefaultConsumerConfig() ConsumerConfig {
+	return ConsumerConfig{
+		StartID:        "$",
+		BatchSize:      10,
+		Block:          5 * time.Second,
+		MinIdle:        time.Minute,
+		ClaimInterval:  30 * time.Second,
+		MaxDeliveries:  5,
+		DeadLetterTrim: TrimConfig{MaxAge: 7 * 24 * time.Hour},
+	}
+}
+
+// ConsumerStats counts what a consumer has done
+type ConsumerStats struct {
+	Processed    int64 `json:"processed"`
+	Failed       int64 `json:"failed"`
+	Reclaimed    int64 `json:"reclaimed"`
+	DeadLettered int64 `json:"dead_lettered"`
+}
+
+// Consumer reads a stream as a member of a consumer group
+type Consumer struct {
+	rdb     *redis.Client
+	config  ConsumerConfig
+	handler Handler
+	log     *logger.Logger
+
+	// claimStart is where the next XAUTOCLAIM scan resumes
+	claimStart string
+
+	processed    atomic.Int64
+	failed       atomic.Int64
+	reclaimed    atomic.Int64
+	deadLettered atomic.Int64
+}
+
+// NewConsumer creates a consumer; call Run to start reading
+func NewConsumer(rdb *redis.Client, config ConsumerConfig, handler Handler) (*Consumer, error) {
+	if rdb == nil {
+		return nil, fmt.Errorf("redis client cannot be nil")
+	}
+	if config.Stream == "" || config.Group == "" {
+		return nil, fmt.Errorf("stream and group are required")
+	}
+	if handler == nil {
+		return nil, fmt.Errorf("handler cannot be nil")
+	}
+
+	defaults := DefaultConsumerConfig()
+	if config.Consumer == "" {
+		hostname, _ := os.Hostname()
+		config.Consumer = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	if config.StartID == "" {
+		config.StartID = defaults.StartID
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaults.BatchSize
+	}
+	if config.Block <= 0 {
+		config.Block = defaults.Block
+	}
+	if config.MinIdle <= 0 {
+		config.MinIdle = defaults.MinIdle
+	}
+	if config.ClaimInterval <= 0 {
+		config.ClaimInterval = defaults.ClaimInterval
+	}
+	if config.MaxDeliveries <= 0 {
+		config.MaxDeliveries = defaults.MaxDeliveries
+	}
+	if config.DeadLetterStream == "" {
+		config.DeadLetterStream = config.Stream + ":dead"
+	}
+
+	return &Consumer{
+		rdb:        rdb,
+		config:     config,
+		handler:    handler,
+		claimStart: "0-0",
+		log: logger.New().WithFields(map[string]interface{}{
+			"component": "stream-consumer",
+			"stream":    config.Stream,
+			"group":     config.Group,
+			"consumer":  config.Consumer,
+		}),
+	}, nil
+}
+
+// Stats returns the consumer's counters
+func (c *Consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Processed:    c.processed.Load(),
+		Failed:       c.failed.Load(),
+		Reclaimed:    c.reclaimed.Load(),
+		DeadLettered: c.deadLettered.Load(),
+	}
+}
+
+// EnsureGroup creates the stream and group if they don't exist
+func (c *Consumer) EnsureGroup(ctx context.Context) error {
+	err := c.rdb.XGroupCreateMkStream(ctx, c.config.Stream, c.config.Group, c.config.StartID).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+	return nil
+}
+
+// Run reads and reclaims messages until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) error {
+	if err := c.EnsureGroup(ctx); err != nil {
+		return err
+	}
+
+	c.log.Infof("consuming, dead-lettering after %d deliveries to %s", c.config.MaxDeliveries, c.config.DeadLetterStream)
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.config.ClaimInterval {
+			if _, err := c.Reclaim(ctx); err != nil && ctx.Err() == nil {
+				c.log.Warnf("reclaim failed: %v", err)
+			}
+			lastClaim = time.Now()
+		}
+
+		if _, err := c.ReadOnce(ctx); err != nil && ctx.Err() == nil {
+			c.log.Warnf("read failed: %v", err)
+
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	return nil
+}
+
+// ReadOnce reads one batch of new messages, blocking up to Block, and
+// handles them. It returns how many were read.
+func (c *Consumer) ReadOnce(ctx context.Context) (int, error) {
+	streams, err := c.rdb.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.config.Group,
+		Consumer: c.config.Consumer,
+		Streams:  []string{c.config.Stream, ">"},
+		Count:    c.config.BatchSize,
+		Block:    c.config.Block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from group: %w", err)
+	}
+
+	count := 0
+	for _, stream := range streams {
+		for _, entry := range stream.Messages {
+			c.handle(ctx, Message{ID: entry.ID, Stream: c.config.Stream, Values: entry.Values, Deliveries: 1})
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Reclaim claims one batch of messages that have been pending longer than
+// MinIdle, dead-letters those delivered more than MaxDeliveries times and
+// handles the rest. It returns how many were claimed.
+func (c *Consumer) Reclaim(ctx context.Context) (int, error) {
+	messages, next, err := c.rdb.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+		Stream:   c.config.Stream,
+		Group:    c.config.Group,
+		Consumer: c.config.Consumer,
+		MinIdle:  c.config.MinIdle,
+		Start:    c.claimStart,
+		Count:    c.config.BatchSize,
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to autoclaim: %w", err)
+	}
+	c.claimStart = next
+	if len(messages) == 0 {
+		return 0, nil
+	}
+
+	// XAUTOCLAIM doesn't return delivery counts, so read them back from the
+	// pending entries that are now ours
+	pending, err := c.rdb.XPendingExt(ctx, &redis.XPendingExtArgs{
+		Stream:   c.config.Stream,
+		Group:    c.config.Group,
+		Consumer: c.config.Consumer,
+		Start:    messages[0].ID,
+		End:      messages[len(messages)-1].ID,
+		Count:    int64(len(messages)),
+	}).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read delivery counts: %w", err)
+	}
+	deliveries := make(map[string]int64, len(pending))
+	for _, p := range pending {
+		deliveries[p.ID] = p.RetryCount
+	}
+
+	c.reclaimed.Add(int64(len(messages)))
+
+	for _, entry := range messages {
+		msg := Message{ID: entry.ID, Stream: c.config.Stream, Values: entry.Values, Deliveries: deliveries[entry.ID]}
+
+		if msg.Deliveries > c.config.MaxDeliveries {
+			if err := c.deadLetter(ctx, msg); err != nil {
+				c.log.Errorf("failed to dead-letter %s: %v", msg.ID, err)
+			}
+			continue
+		}
+		c.handle(ctx, msg)
+	}
+
+	return len(messages), nil
+}
+
+// handle runs the handler and acknowledges the message if it succeeds
+func (c *Consumer) handle(ctx context.Context, msg Message) {
+	if err := c.safeHandle(ctx, msg); err != nil {
+		c.failed.Add(1)
+		c.log.Warnf("handler failed for %s (delivery %d): %v", msg.ID, msg.Deliveries, err)
+		return
+	}
+
+	if err := c.rdb.XAck(ctx, c.config.Stream, c.config.Group, msg.ID).Err(); err != nil {
+		c.log.Errorf("failed to acknowledge %s: %v", msg.ID, err)
+		return
+	}
+	c.processed.Add(1)
+}
+
+// safeHandle turns handler panics into errors
+func (c *Consumer) safeHandle(ctx context.Context, msg Message) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked: %v", r)
+		}
+	}()
+	return c.handler(ctx, msg)
+}
+
+// deadLetter copies the message to the dead-letter stream and acknowledges
+// it in one transaction
+func (c *Consumer) deadLetter(ctx context.Context, msg Message) error {
+	values := make(map[string]interface{}, len(msg.Values)+4)
+	for k, v := range msg.Values {
+		values[k] = v
+	}
+	values[DeadLetterFieldStream] = msg.Stream
+	values[DeadLetterFieldGroup] = c.config.Group
+	values[DeadLetterFieldID] = msg.ID
+	values[DeadLetterFieldDeliveries] = strconv.FormatInt(msg.Deliveries, 10)
+
+	args := &redis.XAddArgs{Stream: c.config.DeadLetterStream, Values: values}
+	c.config.DeadLetterTrim.Apply(args, time.Now())
+
+	_, err := c.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, args)
+		pipe.XAck(ctx, c.config.Stream, c.config.Group, msg.ID)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	c.deadLettered.Add(1)
+	c.log.Warnf("dead-lettered %s after %d deliveries", msg.ID, msg.Deliveries)
+	return nil
+}
@@ -0,0 +1,81 @@
+// Package streams provides trimming, consumer groups with reclaim and
+// dead-lettering, and lag inspection for Redis streams.
+package streams
+
+import (
+	"fmt"
+	"time"
+
+	"api-monitor-go/internal/config"
+	"github.com/redis/go-redis/v9"
+)
+
+// TrimConfig bounds a stream's size as entries are added
+type TrimConfig struct {
+	// MaxLen keeps roughly the newest MaxLen entries; 0 disables it
+	MaxLen int64
+
+	// MaxAge drops entries older than MaxAge by MINID; 0 disables it.
+	// When both are set, MaxAge is used.
+	MaxAge time.Duration
+
+	// Exact trims to the exact bound. By default trimming is approximate
+	// ("~"), which lets Redis drop whole nodes and is much cheaper.
+	Exact bool
+}
+
+// DefaultTrimConfig returns default trim configuration
+func DefaultTrimConfig() TrimConfig {
+	return TrimConfig{MaxLen: 100000}
+}
+
+// TrimFromConfig returns the trim bounds set by STREAM_MAXLEN and
+// STREAM_MAX_AGE
+func TrimFromConfig(cfg *config.Config) TrimConfig {
+	return TrimConfig{MaxLen: cfg.StreamMaxLen, MaxAge: cfg.StreamMaxAge}
+}
+
+// Apply sets the trim arguments of an XADD issued at now
+func (t TrimConfig) Apply(args *redis.XAddArgs, now time.Time) {
+	args.MaxLen = 0
+	args.MinID = ""
+
+	switch {
+	case t.MaxAge > 0:
+		args.MinID = MinID(now.Add(-t.MaxAge))
+	case t.MaxLen > 0:
+		args.MaxLen = t.MaxLen
+	default:
+		return
+	}
+	args.Approx = !t.Exact
+}
+
+// String describes the config for logs
+func (t TrimConfig) String() string {
+	mode := "~"
+	if t.Exact {
+		mode = "="
+	}
+	switch {
+	case t.MaxAge > 0:
+		return fmt.Sprintf("MINID %s now-%v", mode, t.MaxAge)
+	case t.MaxLen > 0:
+		return fmt.Sprintf("MAXLEN %s %d", mode, t.MaxLen)
+	}
+	return "none"
+}
+
+// MinID returns the smallest stream ID that can be added at t
+func MinID(t time.Time) string {
+	return fmt.Sprintf("%d-0", t.UnixMilli())
+}
+
+// IDTime returns the time encoded in a stream ID
+func IDTime(id string) (time.Time, error) {
+	var ms, seq int64
+	if _, err := fmt.Sscanf(id, "%d-%d", &ms, &seq); err != nil {
+		return time.Time{}, fmt.Errorf("invalid stream ID '%s': %w", id, err)
+	}
+	return time.UnixMilli(ms), nil
+}
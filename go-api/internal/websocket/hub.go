@@ -1,6 +1,7 @@
 package websocket
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -11,8 +12,11 @@ import (
 
 // Hub manages WebSocket clients and broadcasts monitoring results to subscribers.
 type Hub struct {
-	clients    map[*websocket.Conn]bool
+	// clients maps each connection to the lock its writers take, since a
+	// connection supports only one writer at a time
+	clients    map[*websocket.Conn]*sync.Mutex
 	broadcast  chan models.MonitoringResult
+	statuses   chan models.EndpointStatus
 	register   chan *websocket.Conn
 	unregister chan *websocket.Conn
 	mu         sync.Mutex
@@ -22,8 +26,9 @@ type Hub struct {
 
 func NewHub() *Hub {
 	return &Hub{
-		clients:    make(map[*websocket.Conn]bool),
+		clients:    make(map[*websocket.Conn]*sync.Mutex),
 		broadcast:  make(chan models.MonitoringResult, 100), // Buffered channel
+		statuses:   make(chan models.EndpointStatus, 100),
 		register:   make(chan *websocket.Conn),
 		unregister: make(chan *websocket.Conn),
 		log:        logger.New(),
@@ -49,6 +54,9 @@ func (h *Hub) Run() {
 		case result := <-h.broadcast:
 			h.broadcastToClients(result)
 
+		case status := <-h.statuses:
+			h.broadcastStatusToClients(status)
+
 		case <-ticker.C:
 			h.sendPings()
 
@@ -64,7 +72,7 @@ func (h *Hub) registerClient(client *websocket.Conn) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
-	h.clients[client] = true
+	h.clients[client] = &sync.Mutex{}
 	clientCount := len(h.clients)
 	h.log.WithFields(map[string]interface{}{
 		"connected_clients": clientCount,
@@ -97,31 +105,52 @@ func (h *Hub) Broadcast(result models.MonitoringResult) {
 	}
 }
 
-// broadcastToClients sends the result to all connected clients asynchronously
-func (h *Hub) broadcastToClients(result models.MonitoringResult) {
-	h.mu.Lock()
-	clients := make([]*websocket.Conn, 0, len(h.clients))
-	for client := range h.clients {
-		clients = append(clients, client)
+// BroadcastContext queues a monitoring result like Broadcast, but waits for
+// room in the queue instead of dropping the result
+func (h *Hub) BroadcastContext(ctx context.Context, result models.MonitoringResult) error {
+	select {
+	case h.broadcast <- result:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	h.mu.Unlock()
+}
 
-	failedClients := make([]*websocket.Conn, 0)
+// snapshot returns the connected clients and their write locks
+func (h *Hub) snapshot() map[*websocket.Conn]*sync.Mutex {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
-	for _, client := range clients {
-		// Send in goroutine to avoid blocking on slow clients
-		go func(c *websocket.Conn) {
-			if err := c.WriteJSON(result); err != nil {
-				h.log.WithField("error", err.Error()).Debug("failed to write to client")
-				failedClients = append(failedClients, c)
-			}
-		}(client)
+	clients := make(map[*websocket.Conn]*sync.Mutex, len(h.clients))
+	for client, writeMu := range h.clients {
+		clients[client] = writeMu
 	}
+	return clients
+}
+
+// send writes a message to a client, unregistering it if the write fails.
+// It runs outside Run, which is what receives the unregistration.
+func (h *Hub) send(client *websocket.Conn, writeMu *sync.Mutex, write func(*websocket.Conn) error) {
+	writeMu.Lock()
+	err := write(client)
+	writeMu.Unlock()
 
-	// Unregister failed clients
-	for _, client := range failedClients {
+	if err != nil {
+		h.log.WithField("error", err.Error()).Debug("failed to write to client")
 		h.Unregister(client)
 	}
+}
+
+// broadcastToClients sends the result to all connected clients asynchronously
+func (h *Hub) broadcastToClients(result models.MonitoringResult) {
+	clients := h.snapshot()
+
+	for client, writeMu := range clients {
+		// Send in goroutine to avoid blocking on slow clients
+		go h.send(client, writeMu, func(c *websocket.Conn) error {
+			return c.WriteJSON(result)
+		})
+	}
 
 	h.log.WithFields(map[string]interface{}{
 		"endpoint_id":   result.EndpointID,
@@ -130,20 +159,49 @@ func (h *Hub) broadcastToClients(result models.MonitoringResult) {
 	}).Debug("broadcast completed")
 }
 
-// sendPings sends ping messages to all connected clients
-func (h *Hub) sendPings() {
-	h.mu.Lock()
-	clients := make([]*websocket.Conn, 0, len(h.clients))
-	for client := range h.clients {
-		clients = append(clients, client)
+// statusMessage is how endpoint status changes are sent to clients, told
+// apart from results by their type
+type statusMessage struct {
+	Type string `json:"type"`
+	models.EndpointStatus
+}
+
+// BroadcastStatus queues an endpoint status change to be sent to all
+// connected clients, waiting for room in the queue
+func (h *Hub) BroadcastStatus(ctx context.Context, status models.EndpointStatus) error {
+	select {
+	case h.statuses <- status:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	h.mu.Unlock()
+}
 
-	for _, client := range clients {
-		if err := client.WriteMessage(websocket.PingMessage, nil); err != nil {
-			h.log.WithField("error", err.Error()).Debug("failed to send ping")
-			h.Unregister(client)
-		}
+// broadcastStatusToClients sends a status change to all connected clients
+// asynchronously
+func (h *Hub) broadcastStatusToClients(status models.EndpointStatus) {
+	clients := h.snapshot()
+
+	msg := statusMessage{Type: "endpoint_status", EndpointStatus: status}
+	for client, writeMu := range clients {
+		go h.send(client, writeMu, func(c *websocket.Conn) error {
+			return c.WriteJSON(msg)
+		})
+	}
+
+	h.log.WithFields(map[string]interface{}{
+		"endpoint_id":   status.EndpointID,
+		"status":        status.Status,
+		"clients_count": len(clients),
+	}).Debug("status broadcast completed")
+}
+
+// sendPings sends ping messages to all connected clients
+func (h *Hub) sendPings() {
+	for client, writeMu := range h.snapshot() {
+		go h.send(client, writeMu, func(c *websocket.Conn) error {
+			return c.WriteMessage(websocket.PingMessage, nil)
+		})
 	}
 }
 
@@ -177,6 +235,6 @@ func (h *Hub) shutdown() {
 	for client := range h.clients {
 		client.Close()
 	}
-	h.clients = make(map[*websocket.Conn]bool)
+	h.clients = make(map[*websocket.Conn]*sync.Mutex)
 	h.log.Info("WebSocket hub shutdown")
 }
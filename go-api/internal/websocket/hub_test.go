@@ -1,8 +1,11 @@
 package websocket
 
 import (
+	"context"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -10,41 +13,38 @@ import (
 	"github.com/gorilla/websocket"
 )
 
-type fakeConn struct{ websocket.Conn }
-
 func TestHubRegisterUnregister(t *testing.T) {
 	h := NewHub()
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	// Use a mock connection instead of nil
-	c1 := &websocket.Conn{}
-	c2 := &websocket.Conn{}
-	
+	c1 := newTestConn(t)
+	c2 := newTestConn(t)
+
 	h.Register(c1)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 1 {
-		t.Fatalf("expected 1 client, got %d", len(h.clients))
+	if h.GetClientCount() != 1 {
+		t.Fatalf("expected 1 client, got %d", h.GetClientCount())
 	}
 
 	h.Register(c2)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 2 {
-		t.Fatalf("expected 2 clients, got %d", len(h.clients))
+	if h.GetClientCount() != 2 {
+		t.Fatalf("expected 2 clients, got %d", h.GetClientCount())
 	}
 
 	h.Unregister(c1)
 	time.Sleep(10 * time.Millisecond)
-	if len(h.clients) != 1 {
-		t.Fatalf("expected 1 client, got %d", len(h.clients))
+	if h.GetClientCount() != 1 {
+		t.Fatalf("expected 1 client, got %d", h.GetClientCount())
 	}
 
 	h.Unregister(c2)
 	time.Sleep(10 * time.Millisecond)
-	if len(h.clients) != 0 {
-		t.Fatalf("expected 0 clients, got %d", len(h.clients))
+	if h.GetClientCount() != 0 {
+		t.Fatalf("expected 0 clients, got %d", h.GetClientCount())
 	}
 }
 
@@ -66,7 +66,7 @@ func TestNewHub(t *testing.T) {
 		t.Fatalf("expected hub instance")
 	}
 
-	if len(h.clients) != 0 {
+	if h.GetClientCount() != 0 {
 		t.Fatalf("expected 0 clients initially")
 	}
 
@@ -84,14 +84,14 @@ func TestHubMultipleRegistrations(t *testing.T) {
 	conns := make([]*websocket.Conn, numClients)
 
 	for i := 0; i < numClients; i++ {
-		conns[i] = &websocket.Conn{}
+		conns[i] = newTestConn(t)
 		h.Register(conns[i])
 	}
 
 	time.Sleep(50 * time.Millisecond)
 
-	if len(h.clients) != numClients {
-		t.Fatalf("expected %d clients, got %d", numClients, len(h.clients))
+	if h.GetClientCount() != numClients {
+		t.Fatalf("expected %d clients, got %d", numClients, h.GetClientCount())
 	}
 }
 
@@ -108,7 +108,7 @@ func TestHubBroadcastWithNoClients(t *testing.T) {
 	h.Broadcast(result)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 0 {
+	if h.GetClientCount() != 0 {
 		t.Fatalf("expected 0 clients")
 	}
 }
@@ -118,7 +118,7 @@ func TestHubBroadcastMultiple(t *testing.T) {
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	c1 := &websocket.Conn{}
+	c1 := newTestConn(t)
 	h.Register(c1)
 	time.Sleep(10 * time.Millisecond)
 
@@ -138,28 +138,28 @@ func TestHubRegisterAfterUnregister(t *testing.T) {
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	c1 := &websocket.Conn{}
+	c1 := newTestConn(t)
 
 	h.Register(c1)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 1 {
+	if h.GetClientCount() != 1 {
 		t.Fatalf("expected 1 client after register")
 	}
 
 	h.Unregister(c1)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 0 {
+	if h.GetClientCount() != 0 {
 		t.Fatalf("expected 0 clients after unregister")
 	}
 
 	// Register again
-	c2 := &websocket.Conn{}
+	c2 := newTestConn(t)
 	h.Register(c2)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 1 {
+	if h.GetClientCount() != 1 {
 		t.Fatalf("expected 1 client after re-register")
 	}
 }
@@ -169,11 +169,11 @@ func TestHubUnregisterNonexistentClient(t *testing.T) {
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	c1 := &websocket.Conn{}
+	c1 := newTestConn(t)
 	h.Unregister(c1)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 0 {
+	if h.GetClientCount() != 0 {
 		t.Fatalf("expected 0 clients")
 	}
 }
@@ -199,10 +199,14 @@ func TestHubConcurrentOperations(t *testing.T) {
 
 	done := make(chan bool)
 
+	conns := make([]*websocket.Conn, 5)
+	for i := range conns {
+		conns[i] = newTestConn(t)
+	}
+
 	// Goroutine 1: Register clients
 	go func() {
-		for i := 0; i < 5; i++ {
-			c := &websocket.Conn{}
+		for _, c := range conns {
 			h.Register(c)
 			time.Sleep(5 * time.Millisecond)
 		}
@@ -286,7 +290,7 @@ func TestHubBroadcastWithDifferentResults(t *testing.T) {
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	c1 := &websocket.Conn{}
+	c1 := newTestConn(t)
 	h.Register(c1)
 	time.Sleep(10 * time.Millisecond)
 
@@ -320,17 +324,25 @@ func TestHubClientCleanupOnWriteError(t *testing.T) {
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	// Create a mock connection that fails on write
-	c := &websocket.Conn{}
+	c := newTestConn(t)
 	h.Register(c)
 	time.Sleep(10 * time.Millisecond)
 
-	if len(h.clients) != 1 {
+	if h.GetClientCount() != 1 {
 		t.Fatalf("expected 1 client")
 	}
 
+	// A closed connection fails on write
+	c.Close()
 	h.Broadcast(models.MonitoringResult{EndpointID: 1})
-	time.Sleep(10 * time.Millisecond)
+
+	deadline := time.Now().Add(time.Second)
+	for h.GetClientCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failed client to be unregistered")
+		}
+		time.Sleep(time.Millisecond)
+	}
 }
 
 func TestHubLargePayload(t *testing.T) {
@@ -338,7 +350,7 @@ func TestHubLargePayload(t *testing.T) {
 	go h.Run()
 	time.Sleep(10 * time.Millisecond)
 
-	c1 := &websocket.Conn{}
+	c1 := newTestConn(t)
 	h.Register(c1)
 	time.Sleep(10 * time.Millisecond)
 
@@ -355,6 +367,31 @@ func TestHubLargePayload(t *testing.T) {
 	time.Sleep(100 * time.Millisecond)
 }
 
+// newTestConn returns the server side of a WebSocket connection to a
+// client that reads nothing
+func newTestConn(t *testing.T) *websocket.Conn {
+	t.Helper()
+
+	conns := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conns <- ws
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return <-conns
+}
+
 // Helper functions
 func intPtr(i int) *int {
 	return &i
@@ -363,3 +400,50 @@ func intPtr(i int) *int {
 func strPtr(s string) *string {
 	return &s
 }
+
+func TestHubBroadcastStatus(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Stop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+			ws, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			h.Register(ws)
+		}),
+	}
+	go server.Serve(listener)
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws://"+listener.Addr().String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for h.GetClientCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	status := models.EndpointStatus{EndpointID: 4, Status: models.StatusDown, PreviousStatus: models.StatusUp, FailingLocations: []string{"eu", "us"}}
+	if err := h.BroadcastStatus(context.Background(), status); err != nil {
+		t.Fatal(err)
+	}
+
+	var msg map[string]interface{}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if err := client.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["type"] != "endpoint_status" || msg["status"] != models.StatusDown || msg["endpoint_id"] != float64(4) {
+		t.Errorf("unexpected status message %v", msg)
+	}
+}
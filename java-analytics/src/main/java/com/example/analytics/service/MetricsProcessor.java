@@ -1,6 +1,7 @@
 package com.example.analytics.service;
 
 import com.example.analytics.model.ApiMetricEvent;
+import com.fasterxml.jackson.databind.JsonNode;
 import com.fasterxml.jackson.databind.ObjectMapper;
 import java.nio.charset.StandardCharsets;
 import jakarta.annotation.PostConstruct;
@@ -76,7 +77,7 @@ public class MetricsProcessor {
 
     private void processMetricMap(String recordId, Map<String, String> value) {
         try {
-            if (!value.containsKey("endpoint_id")) {
+            if (!value.containsKey("endpoint_id") && !value.containsKey("event")) {
                 log.debug("Skipping non-metric record: {}", value);
                 return;
             }
@@ -113,6 +114,13 @@ public class MetricsProcessor {
     
     private ApiMetricEvent convertToMetricEvent(Map<String, String> value) {
         try {
+            // Versioned envelope (schemas/events); the flat fields are only
+            // written while EVENT_LEGACY_FIELDS is on
+            String envelope = value.get("event");
+            if (envelope != null && "application/json".equals(value.get("content_type"))) {
+                return convertEnvelope(envelope);
+            }
+
             ApiMetricEvent event = new ApiMetricEvent();
             event.setEndpointId(value.get("endpoint_id"));
 
@@ -141,6 +149,29 @@ public class MetricsProcessor {
         }
     }
 
+    private ApiMetricEvent convertEnvelope(String json) throws java.io.IOException {
+        JsonNode envelope = objectMapper.readTree(json);
+        if (!"api.check.completed".equals(envelope.path("type").asText())) {
+            return null;
+        }
+
+        JsonNode payload = envelope.path("payload");
+        ApiMetricEvent event = new ApiMetricEvent();
+        event.setEndpointId(payload.path("endpoint_id").asText(null));
+        if (payload.hasNonNull("response_time")) {
+            event.setResponseTime(payload.get("response_time").asDouble());
+        }
+        if (payload.hasNonNull("status_code")) {
+            event.setStatusCode(payload.get("status_code").asInt());
+        }
+        if (payload.hasNonNull("checked_at")) {
+            event.setTimestamp(Instant.parse(payload.get("checked_at").asText()));
+        }
+        event.setMethod(payload.path("method").asText(null));
+        event.setPath(payload.path("path").asText(null));
+        return event;
+    }
+
     // Helper to convert various runtime key/value shapes to String
     private String convertObjectToString(Object obj) {
         if (obj == null) return null;